@@ -2,19 +2,28 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 
 	"citadel/highway17/internal/app"
+	"citadel/highway17/internal/audit"
+	"citadel/highway17/internal/auth"
 	"citadel/highway17/internal/config"
 	"citadel/highway17/internal/database"
 	"citadel/highway17/internal/logger"
 )
 
 func main() {
-	ctx := context.Background()
+	// Cancelled on SIGINT/SIGTERM to stop background workers and the server
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	// Load .env file (ignore error if file doesn't exist in production)
 	_ = godotenv.Load()
@@ -42,22 +51,41 @@ func main() {
 	defer db.Close()
 
 	// Run migrations
-	if err := db.Migrate(ctx); err != nil {
+	applied, err := db.Migrate(ctx)
+	if err != nil {
 		log.Sugar().Fatalf("Failed to run migrations: %v", err)
 	}
+	for _, m := range applied {
+		log.Sugar().Infow("applied migration", "version", m.Version, "name", m.Name)
+	}
 
 	log.Sugar().Info("Database migrations completed successfully")
 
 	// Create and start application
-	echoApp, err := app.New(cfg, db, log)
+	echoApp, err := app.New(ctx, cfg, db, log)
 	if err != nil {
 		log.Sugar().Fatalf("Failed to create application: %v", err)
 	}
 
+	// Background workers
+	go auth.RunSessionJanitor(ctx, db, log, time.Duration(cfg.SessionPurgeInterval)*time.Second)
+	go audit.RunRetention(ctx, db, log, time.Duration(cfg.AuditRetentionDays)*24*time.Hour, time.Hour)
+
 	listenAddr := fmt.Sprintf(":%d", cfg.AppPort)
 	log.Sugar().Infof("Starting Highway 17 Dashboard on %s", listenAddr)
 
-	if err := echoApp.Start(listenAddr); err != nil {
-		log.Sugar().Fatalf("Server error: %v", err)
+	go func() {
+		if err := echoApp.Start(listenAddr); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Sugar().Fatalf("Server error: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Sugar().Info("Shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := echoApp.Shutdown(shutdownCtx); err != nil {
+		log.Sugar().Errorf("Server shutdown error: %v", err)
 	}
 }
@@ -0,0 +1,259 @@
+// Command h17ctl manages dashboard accounts, sessions, API tokens and schema
+// migrations directly in the database, for recovering from lockouts and for
+// scripting.
+//
+//	h17ctl [--json] <user|session|token|migrate> <action> [flags] [args]
+//
+// Exit codes: 0 success, 1 error, 2 usage, 3 not found, 4 refused (conflict).
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"os/user"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/joho/godotenv"
+
+	"citadel/highway17/internal/audit"
+	"citadel/highway17/internal/config"
+	"citadel/highway17/internal/database"
+	"citadel/highway17/internal/logger"
+)
+
+const (
+	exitOK       = 0
+	exitError    = 1
+	exitUsage    = 2
+	exitNotFound = 3
+	exitConflict = 4
+)
+
+// cliError carries the process exit code for a failed command
+type cliError struct {
+	code int
+	err  error
+}
+
+func (e *cliError) Error() string { return e.err.Error() }
+
+func usageErrorf(format string, args ...any) error {
+	return &cliError{exitUsage, fmt.Errorf(format, args...)}
+}
+
+func notFoundf(format string, args ...any) error {
+	return &cliError{exitNotFound, fmt.Errorf(format, args...)}
+}
+
+func conflictf(format string, args ...any) error {
+	return &cliError{exitConflict, fmt.Errorf(format, args...)}
+}
+
+// action is one "<group> <name>" subcommand
+type action struct {
+	usage string
+	run   func(ctx context.Context, c *cli, args []string) error
+}
+
+var groups = map[string]map[string]action{
+	"user":    userActions,
+	"session": sessionActions,
+	"token":   tokenActions,
+	"migrate": migrateActions,
+}
+
+// cli is the state shared by every action
+type cli struct {
+	db     *database.DB
+	audit  *audit.Recorder
+	json   bool
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	c := &cli{stdin: os.Stdin, stdout: os.Stdout, stderr: os.Stderr}
+
+	global := flag.NewFlagSet("h17ctl", flag.ContinueOnError)
+	global.SetOutput(io.Discard)
+	global.BoolVar(&c.json, "json", false, "print results as JSON")
+	if err := global.Parse(args); err != nil {
+		return c.fail(usageErrorf("%v", err))
+	}
+	args = global.Args()
+
+	if len(args) == 0 || args[0] == "help" {
+		printUsage(c.stdout)
+		if len(args) == 0 {
+			return exitUsage
+		}
+		return exitOK
+	}
+	if len(args) < 2 {
+		return c.fail(usageErrorf("missing action for %q, see h17ctl help", args[0]))
+	}
+
+	actions, ok := groups[args[0]]
+	if !ok {
+		return c.fail(usageErrorf("unknown command %q, see h17ctl help", args[0]))
+	}
+	act, ok := actions[args[1]]
+	if !ok {
+		return c.fail(usageErrorf("unknown action %q for %s, see h17ctl help", args[1], args[0]))
+	}
+
+	// Errors before the action parses its flags still honour a trailing --json
+	for _, arg := range args[2:] {
+		if arg == "--json" || arg == "-json" {
+			c.json = true
+		}
+	}
+
+	// Same environment as the dashboard itself
+	_ = godotenv.Load()
+	cfg, err := config.Load()
+	if err != nil {
+		return c.fail(fmt.Errorf("failed to load config: %w", err))
+	}
+
+	db, err := database.New(ctx, cfg.DatabaseURL)
+	if err != nil {
+		return c.fail(err)
+	}
+	defer db.Close()
+	c.db = db
+
+	// Logs go to stderr so they never mix with --json output
+	log, err := logger.NewLogger("warn")
+	if err != nil {
+		return c.fail(err)
+	}
+	defer log.Sync()
+	c.audit = audit.NewRecorder(db, log)
+
+	if err := act.run(ctx, c, args[2:]); err != nil {
+		return c.fail(err)
+	}
+	return exitOK
+}
+
+// fail reports an error on stderr and returns its exit code
+func (c *cli) fail(err error) int {
+	code := exitError
+	var ce *cliError
+	if errors.As(err, &ce) {
+		code = ce.code
+	}
+
+	if c.json {
+		json.NewEncoder(c.stderr).Encode(map[string]any{"error": err.Error(), "exit_code": code})
+	} else {
+		fmt.Fprintf(c.stderr, "h17ctl: %v\n", err)
+	}
+	return code
+}
+
+// print writes v as JSON with --json, otherwise calls text with a tabwriter
+func (c *cli) print(v any, text func(w io.Writer)) {
+	if c.json {
+		enc := json.NewEncoder(c.stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(v)
+		return
+	}
+	tw := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	text(tw)
+	tw.Flush()
+}
+
+// flags returns a flag set for an action; --json is accepted there too
+func (c *cli) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.BoolVar(&c.json, "json", c.json, "print results as JSON")
+	return fs
+}
+
+// parse parses flags that may appear before, between or after positional
+// arguments and checks the number of positionals
+func parse(fs *flag.FlagSet, args []string, want int, names string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, usageErrorf("%s: %v", fs.Name(), err)
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+	if len(positional) != want {
+		return nil, usageErrorf("usage: h17ctl %s %s", fs.Name(), names)
+	}
+	return positional, nil
+}
+
+// record writes an audit event attributed to the operating system user
+// running h17ctl
+func (c *cli) record(ctx context.Context, ev audit.Event) {
+	ev.ActorName = "h17ctl"
+	if u, err := user.Current(); err == nil {
+		ev.ActorName += ":" + u.Username
+	}
+	ev.Details = map[string]any{"via": "cli"}
+	c.audit.Record(ctx, ev)
+}
+
+// readPassword reads a password piped in on stdin, minus the trailing newline
+func (c *cli) readPassword() (string, error) {
+	b, err := io.ReadAll(io.LimitReader(c.stdin, 4096))
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: h17ctl [--json] <command> <action> [flags] [args]")
+	fmt.Fprintln(w)
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		actions := make([]string, 0, len(groups[name]))
+		for act := range groups[name] {
+			actions = append(actions, act)
+		}
+		sort.Strings(actions)
+		for _, act := range actions {
+			fmt.Fprintln(w, strings.TrimRight(fmt.Sprintf("  h17ctl %s %s %s", name, act, groups[name][act].usage), " "))
+		}
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Reads DATABASE_URL from the environment or .env, like the dashboard.")
+	fmt.Fprintln(w, "Exit codes: 0 success, 1 error, 2 usage, 3 not found, 4 refused.")
+}
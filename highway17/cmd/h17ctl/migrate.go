@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"citadel/highway17/internal/database"
+)
+
+var migrateActions = map[string]action{
+	"status": {"", migrateStatus},
+	"up":     {"", migrateUp},
+	"down":   {"[--steps 1]", migrateDown},
+}
+
+// migrationResult reports a migration that was applied or reverted
+type migrationResult struct {
+	Version int64  `json:"version"`
+	Name    string `json:"name"`
+}
+
+func migrationResults(list []database.Migration) []migrationResult {
+	results := make([]migrationResult, 0, len(list))
+	for _, m := range list {
+		results = append(results, migrationResult{Version: m.Version, Name: m.Name})
+	}
+	return results
+}
+
+func migrateStatus(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("migrate status")
+	if _, err := parse(fs, args, 0, ""); err != nil {
+		return err
+	}
+
+	statuses, err := c.db.MigrationStatus(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read migration status: %w", err)
+	}
+
+	c.print(statuses, func(w io.Writer) {
+		fmt.Fprintln(w, "VERSION\tNAME\tSTATE\tAPPLIED")
+		for _, s := range statuses {
+			fmt.Fprintf(w, "%03d\t%s\t%s\t%s\n", s.Version, s.Name, s.State, formatTime(s.AppliedAt, "-"))
+		}
+	})
+	return nil
+}
+
+func migrateUp(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("migrate up")
+	if _, err := parse(fs, args, 0, ""); err != nil {
+		return err
+	}
+
+	applied, err := c.db.Migrate(ctx)
+	if err != nil {
+		return err
+	}
+
+	c.print(migrationResults(applied), func(w io.Writer) {
+		if len(applied) == 0 {
+			fmt.Fprintln(w, "database is up to date")
+		}
+		for _, m := range applied {
+			fmt.Fprintf(w, "applied %03d_%s\n", m.Version, m.Name)
+		}
+	})
+	return nil
+}
+
+func migrateDown(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("migrate down")
+	steps := fs.Int("steps", 1, "number of migrations to revert")
+	if _, err := parse(fs, args, 0, ""); err != nil {
+		return err
+	}
+	if *steps < 1 {
+		return usageErrorf("--steps must be at least 1")
+	}
+
+	reverted, err := c.db.MigrateDown(ctx, *steps)
+	if err != nil {
+		return err
+	}
+
+	c.print(migrationResults(reverted), func(w io.Writer) {
+		if len(reverted) == 0 {
+			fmt.Fprintln(w, "no migrations to revert")
+		}
+		for _, m := range reverted {
+			fmt.Fprintf(w, "reverted %03d_%s\n", m.Version, m.Name)
+		}
+	})
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+
+	"citadel/highway17/internal/audit"
+)
+
+var sessionActions = map[string]action{
+	"list":   {"<username>", sessionList},
+	"revoke": {"<username> <session-id|all>", sessionRevoke},
+}
+
+func sessionList(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("session list")
+	pos, err := parse(fs, args, 1, "<username>")
+	if err != nil {
+		return err
+	}
+
+	user, err := c.lookupUser(ctx, pos[0])
+	if err != nil {
+		return err
+	}
+	sessions, err := c.db.ListSessions(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	c.print(sessions, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tCREATED\tLAST SEEN\tEXPIRES\tIP\tUSER AGENT")
+		for _, s := range sessions {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n",
+				s.ID, s.CreatedAt.Format("2006-01-02 15:04"), s.LastSeenAt.Format("2006-01-02 15:04"),
+				s.ExpiresAt.Format("2006-01-02 15:04"), s.IPAddress, truncate(s.UserAgent, 60))
+		}
+	})
+	return nil
+}
+
+func sessionRevoke(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("session revoke")
+	pos, err := parse(fs, args, 2, "<username> <session-id|all>")
+	if err != nil {
+		return err
+	}
+
+	user, err := c.lookupUser(ctx, pos[0])
+	if err != nil {
+		return err
+	}
+
+	if pos[1] == "all" {
+		revoked, err := c.db.DeleteUserSessions(ctx, user.ID, "")
+		if err != nil {
+			return fmt.Errorf("failed to revoke sessions: %w", err)
+		}
+		c.record(ctx, audit.Event{Action: audit.ActionSessionRevoke, TargetType: "session", TargetID: user.Username + "/all"})
+		c.print(map[string]any{"username": user.Username, "revoked": revoked}, func(w io.Writer) {
+			fmt.Fprintf(w, "revoked %d sessions of %q\n", revoked, user.Username)
+		})
+		return nil
+	}
+
+	id, err := strconv.Atoi(pos[1])
+	if err != nil {
+		return usageErrorf("invalid session id %q", pos[1])
+	}
+	found, err := c.db.DeleteUserSession(ctx, user.ID, id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	if !found {
+		return notFoundf("session %d of %q not found", id, user.Username)
+	}
+
+	c.record(ctx, audit.Event{Action: audit.ActionSessionRevoke, TargetType: "session", TargetID: strconv.Itoa(id)})
+	c.print(map[string]any{"username": user.Username, "id": id, "revoked": 1}, func(w io.Writer) {
+		fmt.Fprintf(w, "revoked session %d of %q\n", id, user.Username)
+	})
+	return nil
+}
+
+// truncate shortens s to n runes for table output
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
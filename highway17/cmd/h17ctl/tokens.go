@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"citadel/highway17/internal/audit"
+	"citadel/highway17/internal/auth"
+)
+
+var tokenActions = map[string]action{
+	"list":   {"<username>", tokenList},
+	"create": {"<username> <name> [--scopes widgets:read] [--expires-days 0]", tokenCreate},
+	"revoke": {"<username> <token-id>", tokenRevoke},
+}
+
+func tokenList(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("token list")
+	pos, err := parse(fs, args, 1, "<username>")
+	if err != nil {
+		return err
+	}
+
+	user, err := c.lookupUser(ctx, pos[0])
+	if err != nil {
+		return err
+	}
+	tokens, err := c.db.ListAPITokens(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("failed to list tokens: %w", err)
+	}
+
+	c.print(tokens, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tNAME\tPREFIX\tSCOPES\tEXPIRES\tLAST USED")
+		for _, t := range tokens {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n",
+				t.ID, t.Name, t.Prefix, strings.Join(t.Scopes, ","), formatTime(t.ExpiresAt, "never"), formatTime(t.LastUsedAt, "never"))
+		}
+	})
+	return nil
+}
+
+func tokenCreate(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("token create")
+	scopeList := fs.String("scopes", string(auth.PermWidgetsRead), "comma-separated scopes")
+	expiresDays := fs.Int("expires-days", 0, "days until the token expires (0 = never)")
+	pos, err := parse(fs, args, 2, "<username> <name>")
+	if err != nil {
+		return err
+	}
+
+	name := strings.TrimSpace(pos[1])
+	if name == "" || len(name) > 100 {
+		return usageErrorf("name must be 1-100 characters")
+	}
+	if *expiresDays < 0 {
+		return usageErrorf("--expires-days must not be negative")
+	}
+
+	user, err := c.lookupUser(ctx, pos[0])
+	if err != nil {
+		return err
+	}
+	scopes, err := auth.ParseScopes(user, strings.Split(*scopeList, ","))
+	if err != nil {
+		return usageErrorf("%v", err)
+	}
+
+	secret, hash, prefix := auth.GenerateAPIToken()
+	ttl := time.Duration(*expiresDays) * 24 * time.Hour
+	token, err := c.db.CreateAPIToken(ctx, user.ID, name, hash, prefix, scopes, ttl)
+	if err != nil {
+		return fmt.Errorf("failed to create token: %w", err)
+	}
+
+	c.record(ctx, audit.Event{
+		Action:     audit.ActionTokenCreate,
+		TargetType: "api_token",
+		TargetID:   strconv.Itoa(token.ID),
+		After:      map[string]any{"owner": user.Username, "name": token.Name, "prefix": token.Prefix, "scopes": token.Scopes, "expires_at": token.ExpiresAt},
+	})
+
+	c.print(map[string]any{
+		"id":         token.ID,
+		"username":   user.Username,
+		"name":       token.Name,
+		"prefix":     token.Prefix,
+		"scopes":     token.Scopes,
+		"expires_at": token.ExpiresAt,
+		"token":      secret,
+	}, func(w io.Writer) {
+		fmt.Fprintf(w, "created token %d %q for %q\n", token.ID, token.Name, user.Username)
+		fmt.Fprintf(w, "token: %s\n", secret)
+	})
+	return nil
+}
+
+func tokenRevoke(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("token revoke")
+	pos, err := parse(fs, args, 2, "<username> <token-id>")
+	if err != nil {
+		return err
+	}
+
+	id, err := strconv.Atoi(pos[1])
+	if err != nil {
+		return usageErrorf("invalid token id %q", pos[1])
+	}
+	user, err := c.lookupUser(ctx, pos[0])
+	if err != nil {
+		return err
+	}
+
+	found, err := c.db.DeleteAPIToken(ctx, user.ID, id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	if !found {
+		return notFoundf("token %d of %q not found", id, user.Username)
+	}
+
+	c.record(ctx, audit.Event{Action: audit.ActionTokenRevoke, TargetType: "api_token", TargetID: strconv.Itoa(id)})
+	c.print(map[string]any{"username": user.Username, "id": id, "revoked": true}, func(w io.Writer) {
+		fmt.Fprintf(w, "revoked token %d of %q\n", id, user.Username)
+	})
+	return nil
+}
+
+// formatTime renders an optional timestamp for table output
+func formatTime(t *time.Time, zero string) string {
+	if t == nil {
+		return zero
+	}
+	return t.Format("2006-01-02 15:04")
+}
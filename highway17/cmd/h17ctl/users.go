@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"citadel/highway17/internal/audit"
+	"citadel/highway17/internal/auth"
+	"citadel/highway17/internal/database"
+	"citadel/highway17/internal/models"
+)
+
+var userActions = map[string]action{
+	"list":           {"", userList},
+	"add":            {"<username> [--role viewer] [--password-stdin] [--must-change=true]", userAdd},
+	"delete":         {"<username>", userDelete},
+	"reset-password": {"<username> [--password-stdin] [--must-change=true]", userResetPassword},
+	"set-role":       {"<username> <admin|operator|viewer>", userSetRole},
+}
+
+// passwordResult reports a new password; Password is only set when h17ctl
+// generated it
+type passwordResult struct {
+	ID                 int    `json:"id"`
+	Username           string `json:"username"`
+	Role               string `json:"role"`
+	Password           string `json:"password,omitempty"`
+	MustChangePassword bool   `json:"must_change_password"`
+	SessionsRevoked    int64  `json:"sessions_revoked,omitempty"`
+}
+
+// lookupUser maps a missing username to a not-found exit code
+func (c *cli) lookupUser(ctx context.Context, username string) (*models.User, error) {
+	user, err := c.db.GetUserByUsername(ctx, username)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return nil, notFoundf("user %q not found", username)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user: %w", err)
+	}
+	return user, nil
+}
+
+// choosePassword reads a password from stdin with --password-stdin, or
+// generates one to print back
+func (c *cli) choosePassword(fromStdin bool) (password string, generated bool, err error) {
+	if !fromStdin {
+		return auth.GenerateToken()[:20], true, nil
+	}
+	password, err = c.readPassword()
+	if err != nil {
+		return "", false, err
+	}
+	if err := auth.ValidatePassword(password); err != nil {
+		return "", false, usageErrorf("%v", err)
+	}
+	return password, false, nil
+}
+
+// adminCount counts admins, to refuse removing the last one
+func (c *cli) adminCount(ctx context.Context) (int, error) {
+	users, err := c.db.ListUsers(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list users: %w", err)
+	}
+	admins := 0
+	for _, u := range users {
+		if u.Role == string(auth.RoleAdmin) {
+			admins++
+		}
+	}
+	return admins, nil
+}
+
+func userList(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("user list")
+	if _, err := parse(fs, args, 0, ""); err != nil {
+		return err
+	}
+
+	users, err := c.db.ListUsers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list users: %w", err)
+	}
+
+	c.print(users, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tUSERNAME\tROLE\tLOGIN\t2FA\tMUST CHANGE\tCREATED")
+		for _, u := range users {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%t\t%t\t%s\n",
+				u.ID, u.Username, u.Role, loginMethod(u), u.TOTPEnabled, u.MustChangePassword,
+				u.CreatedAt.Format("2006-01-02 15:04"))
+		}
+	})
+	return nil
+}
+
+// loginMethod names how an account signs in
+func loginMethod(u models.User) string {
+	switch {
+	case u.PasswordHash != "":
+		return "password"
+	case u.OIDCSubject != "":
+		return "oidc"
+	case u.TailscaleLogin != "" || u.TailscaleIP != "":
+		return "tailscale"
+	default:
+		return "directory"
+	}
+}
+
+func userAdd(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("user add")
+	roleName := fs.String("role", string(auth.RoleViewer), "admin, operator or viewer")
+	fromStdin := fs.Bool("password-stdin", false, "read the password from stdin instead of generating one")
+	mustChange := fs.Bool("must-change", true, "require a password change at first login")
+	pos, err := parse(fs, args, 1, "<username>")
+	if err != nil {
+		return err
+	}
+	username := pos[0]
+
+	if err := auth.ValidateUsername(username); err != nil {
+		return usageErrorf("%v", err)
+	}
+	role, err := auth.ParseRole(*roleName)
+	if err != nil {
+		return usageErrorf("%v", err)
+	}
+
+	if _, err := c.db.GetUserByUsername(ctx, username); err == nil {
+		return conflictf("user %q already exists", username)
+	} else if !errors.Is(err, database.ErrUserNotFound) {
+		return fmt.Errorf("failed to look up user: %w", err)
+	}
+
+	password, generated, err := c.choosePassword(*fromStdin)
+	if err != nil {
+		return err
+	}
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	id, err := c.db.CreateUser(ctx, username, hash, string(role), *mustChange)
+	if err != nil {
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+
+	// An account created here means the web setup wizard is no longer needed
+	if err := c.db.MarkSetupComplete(ctx); err != nil {
+		return fmt.Errorf("failed to close setup wizard: %w", err)
+	}
+
+	c.record(ctx, audit.Event{
+		Action:     audit.ActionUserCreate,
+		TargetType: "user",
+		TargetID:   username,
+		After:      map[string]any{"role": role, "must_change_password": *mustChange},
+	})
+
+	res := passwordResult{ID: id, Username: username, Role: string(role), MustChangePassword: *mustChange}
+	if generated {
+		res.Password = password
+	}
+	c.print(res, func(w io.Writer) {
+		fmt.Fprintf(w, "created %s user %q (id %d)\n", role, username, id)
+		if generated {
+			fmt.Fprintf(w, "password: %s\n", password)
+		}
+	})
+	return nil
+}
+
+func userDelete(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("user delete")
+	pos, err := parse(fs, args, 1, "<username>")
+	if err != nil {
+		return err
+	}
+
+	user, err := c.lookupUser(ctx, pos[0])
+	if err != nil {
+		return err
+	}
+	if user.Role == string(auth.RoleAdmin) {
+		admins, err := c.adminCount(ctx)
+		if err != nil {
+			return err
+		}
+		if admins <= 1 {
+			return conflictf("refusing to delete the last admin %q", user.Username)
+		}
+	}
+
+	found, err := c.db.DeleteUser(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	if !found {
+		return notFoundf("user %q not found", user.Username)
+	}
+
+	c.record(ctx, audit.Event{
+		Action:     audit.ActionUserDelete,
+		TargetType: "user",
+		TargetID:   user.Username,
+		Before:     map[string]any{"id": user.ID, "role": user.Role},
+	})
+
+	c.print(map[string]any{"id": user.ID, "username": user.Username, "deleted": true}, func(w io.Writer) {
+		fmt.Fprintf(w, "deleted user %q\n", user.Username)
+	})
+	return nil
+}
+
+func userResetPassword(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("user reset-password")
+	fromStdin := fs.Bool("password-stdin", false, "read the password from stdin instead of generating one")
+	mustChange := fs.Bool("must-change", true, "require a password change at next login")
+	pos, err := parse(fs, args, 1, "<username>")
+	if err != nil {
+		return err
+	}
+
+	user, err := c.lookupUser(ctx, pos[0])
+	if err != nil {
+		return err
+	}
+
+	password, generated, err := c.choosePassword(*fromStdin)
+	if err != nil {
+		return err
+	}
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+	if err := c.db.SetPassword(ctx, user.ID, hash, *mustChange); err != nil {
+		return fmt.Errorf("failed to set password: %w", err)
+	}
+
+	// Whoever had the old password is signed out and any lockout is lifted
+	revoked, err := c.db.DeleteUserSessions(ctx, user.ID, "")
+	if err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	if err := c.db.ClearLoginFailures(ctx, auth.LockoutScopeUsername, user.Username); err != nil {
+		return fmt.Errorf("failed to clear lockout: %w", err)
+	}
+
+	c.record(ctx, audit.Event{
+		Action:     audit.ActionUserPassword,
+		TargetType: "user",
+		TargetID:   user.Username,
+		After:      map[string]any{"must_change_password": *mustChange, "sessions_revoked": revoked},
+	})
+
+	res := passwordResult{ID: user.ID, Username: user.Username, Role: user.Role, MustChangePassword: *mustChange, SessionsRevoked: revoked}
+	if generated {
+		res.Password = password
+	}
+	c.print(res, func(w io.Writer) {
+		fmt.Fprintf(w, "reset password for %q, %d sessions revoked\n", user.Username, revoked)
+		if generated {
+			fmt.Fprintf(w, "password: %s\n", password)
+		}
+	})
+	return nil
+}
+
+func userSetRole(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("user set-role")
+	pos, err := parse(fs, args, 2, "<username> <role>")
+	if err != nil {
+		return err
+	}
+
+	role, err := auth.ParseRole(pos[1])
+	if err != nil {
+		return usageErrorf("%v", err)
+	}
+	user, err := c.lookupUser(ctx, pos[0])
+	if err != nil {
+		return err
+	}
+
+	if user.Role == string(auth.RoleAdmin) && role != auth.RoleAdmin {
+		admins, err := c.adminCount(ctx)
+		if err != nil {
+			return err
+		}
+		if admins <= 1 {
+			return conflictf("refusing to demote the last admin %q", user.Username)
+		}
+	}
+
+	if _, err := c.db.SetUserRole(ctx, user.ID, string(role)); err != nil {
+		return fmt.Errorf("failed to set role: %w", err)
+	}
+
+	c.record(ctx, audit.Event{
+		Action:     audit.ActionUserRole,
+		TargetType: "user",
+		TargetID:   user.Username,
+		Before:     map[string]string{"role": user.Role},
+		After:      map[string]string{"role": string(role)},
+	})
+
+	c.print(map[string]any{"id": user.ID, "username": user.Username, "role": role}, func(w io.Writer) {
+		fmt.Fprintf(w, "%q is now %s\n", user.Username, role)
+	})
+	return nil
+}
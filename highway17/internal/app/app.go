@@ -1,11 +1,18 @@
 package app
 
 import (
+	"context"
+	"fmt"
+	"net/http"
 	"time"
 
+	"citadel/highway17/internal/assets"
+	"citadel/highway17/internal/audit"
+	"citadel/highway17/internal/auth"
 	"citadel/highway17/internal/config"
 	"citadel/highway17/internal/database"
 	"citadel/highway17/internal/handlers"
+	"citadel/highway17/internal/metrics"
 	"citadel/highway17/internal/middleware"
 	"citadel/highway17/internal/services"
 
@@ -14,37 +21,128 @@ import (
 	"go.uber.org/zap"
 )
 
-func New(cfg *config.Config, db *database.DB, log *zap.Logger) (*echo.Echo, error) {
+// New builds the application. Background work it starts, such as metrics
+// recording, stops when ctx is cancelled.
+func New(ctx context.Context, cfg *config.Config, db *database.DB, log *zap.Logger) (*echo.Echo, error) {
 	e := echo.New()
 
+	networkPolicy, err := middleware.NewNetworkPolicy(cfg, log)
+	if err != nil {
+		return nil, err
+	}
+	e.IPExtractor = networkPolicy.ClientIP
+
 	// Middleware
 	e.Use(echomiddleware.RequestLoggerWithConfig(echomiddleware.RequestLoggerConfig{
-		LogURI:    true,
-		LogStatus: true,
-		LogError:  true,
+		LogURI:      true,
+		LogStatus:   true,
+		LogError:    true,
+		LogRemoteIP: true,
 		LogValuesFunc: func(c echo.Context, values echomiddleware.RequestLoggerValues) error {
 			log.Sugar().Infow("request",
 				"uri", values.URI,
 				"status", values.Status,
 				"method", values.Method,
+				"remote_ip", values.RemoteIP,
 			)
 			return nil
 		},
 	}))
 	e.Use(echomiddleware.Recover())
-	e.Use(echomiddleware.CORS())
+
+	// Same-origin only unless origins are allow-listed. Cross-origin callers
+	// authenticate with API tokens, so cookies are never shared.
+	if len(cfg.CORSAllowedOrigins) > 0 {
+		e.Use(echomiddleware.CORSWithConfig(echomiddleware.CORSConfig{
+			AllowOrigins: cfg.CORSAllowedOrigins,
+			AllowMethods: []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete},
+			AllowHeaders: []string{echo.HeaderAuthorization, echo.HeaderContentType},
+		}))
+	}
+
+	// Network allow/deny rules run before authentication
+	e.Use(networkPolicy.Enforce)
+
+	// State-changing browser requests must echo the CSRF token
+	e.Use(middleware.NewCSRF(cfg, log).Protect)
+
+	sessions := auth.NewSessions(cfg, db)
+	auditor := audit.NewRecorder(db, log)
+
+	forwardPolicy, err := auth.ParseForwardRules(cfg.ForwardAuthRules)
+	if err != nil {
+		return nil, fmt.Errorf("invalid FORWARD_AUTH_RULES: %w", err)
+	}
 
 	// Custom middleware for authentication
-	authMW := middleware.NewAuthMiddleware(cfg, db, log)
+	authMW := middleware.NewAuthMiddleware(cfg, db, log, auditor, sessions, networkPolicy)
 	e.Use(authMW.CheckAuth)
 
+	forwardAuth, err := middleware.NewForwardAuth(cfg, log, authMW, forwardPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	staticAssets, err := assets.Default()
+	if err != nil {
+		return nil, err
+	}
+
 	// Initialize services
 	weatherService := services.NewWeatherService(cfg, log)
 	systemStatsService := services.NewSystemStatsService(log, time.Duration(cfg.StatsPollInterval)*time.Second)
+	storageMounts, err := services.ParseMountSpecs(cfg.StorageMounts, cfg.StorageWarnPercent)
+	if err != nil {
+		return nil, fmt.Errorf("invalid STORAGE_MOUNTS: %w", err)
+	}
+	systemStatsService.Register(services.NewStorageCollector(storageMounts, cfg.StorageWarnPercent))
+	if cfg.SystemStatsEnabled {
+		systemStatsService.Start(ctx)
+	}
+
+	metricsRecorder := metrics.NewRecorder(db, log, metrics.Retention{
+		Raw:    time.Duration(cfg.MetricsRawHours) * time.Hour,
+		Minute: time.Duration(cfg.MetricsMinuteDays) * 24 * time.Hour,
+		Hour:   time.Duration(cfg.MetricsHourDays) * 24 * time.Hour,
+	})
+	if cfg.MetricsEnabled && cfg.SystemStatsEnabled {
+		go metricsRecorder.Run(ctx, systemStatsService, time.Duration(cfg.StatsPollInterval)*time.Second)
+	}
+
+	lockout := auth.NewLockout(cfg, db, log)
+	twoFactor := auth.NewTwoFactor(cfg, db)
+	backends, err := auth.NewBackends(cfg, db, log)
+	if err != nil {
+		return nil, err
+	}
+	passkeys, err := auth.NewPasskeys(cfg, db)
+	if err != nil {
+		return nil, err
+	}
+
+	var oidcHandler *handlers.OIDCHandler
+	if cfg.OIDCIssuerURL != "" {
+		oidc, err := auth.NewOIDC(cfg, db)
+		if err != nil {
+			return nil, err
+		}
+		oidcHandler = handlers.NewOIDCHandler(cfg, log, auditor, sessions, oidc, forwardPolicy)
+	}
 
 	// Initialize handlers
-	authHandler := handlers.NewAuthHandler(cfg, db, log)
-	dashboardHandler := handlers.NewDashboardHandler(cfg, db, log, weatherService, systemStatsService)
+	setup := auth.NewSetup(db)
+	setupHandler := handlers.NewSetupHandler(cfg, db, log, auditor, sessions, setup)
+	authHandler := handlers.NewAuthHandler(cfg, db, log, auditor, sessions, lockout, twoFactor, backends, setup, forwardPolicy)
+	passwordHandler := handlers.NewPasswordHandler(cfg, db, log, auditor)
+	twoFactorHandler := handlers.NewTwoFactorHandler(cfg, db, log, auditor, twoFactor)
+	passkeyHandler := handlers.NewPasskeyHandler(cfg, db, log, auditor, sessions, passkeys, forwardPolicy)
+	sessionHandler := handlers.NewSessionHandler(cfg, db, log, auditor, sessions)
+	tokenHandler := handlers.NewTokenHandler(cfg, db, log, auditor)
+	adminHandler := handlers.NewAdminHandler(cfg, db, log, auditor)
+	auditHandler := handlers.NewAuditHandler(cfg, db, log)
+	settingsHandler := handlers.NewSettingsHandler(cfg, db, log, auditor)
+	dashboardHandler := handlers.NewDashboardHandler(cfg, db, log, auditor, weatherService, systemStatsService, metricsRecorder)
+	metricsHandler := handlers.NewMetricsHandler(cfg, log, metricsRecorder)
 
 	// Routes
 	// Health check
@@ -52,22 +150,97 @@ func New(cfg *config.Config, db *database.DB, log *zap.Logger) (*echo.Echo, erro
 		return c.JSON(200, map[string]string{"status": "ok"})
 	})
 
+	// Embedded CSS and htmx, public via AUTH_PUBLIC_PATHS
+	e.GET(assets.Prefix+"*", staticAssets.Handler)
+
 	// Auth routes
+	// First-run setup, disabled once the first admin exists
+	e.GET("/setup", setupHandler.Page)
+	e.POST("/api/setup", setupHandler.Complete)
+
+	e.GET("/login", authHandler.LoginPage)
 	e.POST("/api/login", authHandler.Login)
+	e.POST("/api/login/2fa", authHandler.LoginTwoFactor)
 	e.POST("/api/logout", authHandler.Logout)
 
+	// Forward auth for services behind the reverse proxy; answers for itself
+	// rather than going through CheckAuth
+	e.GET(middleware.ForwardAuthPath, forwardAuth.Check)
+
+	// Every authenticated route below declares the permission it needs;
+	// see auth.rolePermissions for what each role is granted
+	require := authMW.Require
+
+	// Password change routes
+	e.GET("/account/password", passwordHandler.Page, require(auth.PermAccount))
+	e.POST("/api/account/password", passwordHandler.Change, require(auth.PermAccount))
+
+	// Session management routes
+	e.GET("/account/sessions", sessionHandler.Page, require(auth.PermAccount))
+	e.GET("/api/sessions", sessionHandler.List, require(auth.PermAccount))
+	e.DELETE("/api/sessions", sessionHandler.RevokeAll, require(auth.PermAccount))
+	e.DELETE("/api/sessions/:id", sessionHandler.Revoke, require(auth.PermAccount))
+
+	// Single sign-on routes
+	if oidcHandler != nil {
+		e.GET("/auth/oidc/login", oidcHandler.Login)
+		e.GET("/auth/oidc/callback", oidcHandler.Callback)
+		e.GET("/account/oidc/link", oidcHandler.Link, require(auth.PermAccount))
+	}
+
+	// API token routes
+	e.GET("/account/tokens", tokenHandler.Page, require(auth.PermAccount))
+	e.GET("/api/tokens", tokenHandler.List, require(auth.PermAccount))
+	e.POST("/api/tokens", tokenHandler.Create, require(auth.PermAccount))
+	e.DELETE("/api/tokens/:id", tokenHandler.Revoke, require(auth.PermAccount))
+
+	// Passkey routes
+	e.POST("/api/passkeys/login/begin", passkeyHandler.BeginLogin)
+	e.POST("/api/passkeys/login/finish", passkeyHandler.FinishLogin)
+	e.GET("/account/passkeys", passkeyHandler.Page, require(auth.PermAccount))
+	e.GET("/api/passkeys", passkeyHandler.List, require(auth.PermAccount))
+	e.POST("/api/passkeys/register/begin", passkeyHandler.BeginRegistration, require(auth.PermAccount))
+	e.POST("/api/passkeys/register/finish", passkeyHandler.FinishRegistration, require(auth.PermAccount))
+	e.PATCH("/api/passkeys/:id", passkeyHandler.Rename, require(auth.PermAccount))
+	e.DELETE("/api/passkeys/:id", passkeyHandler.Delete, require(auth.PermAccount))
+
+	// Two-factor enrollment routes
+	e.GET("/account/2fa", twoFactorHandler.Page, require(auth.PermAccount))
+	e.POST("/api/2fa/enroll", twoFactorHandler.Enroll, require(auth.PermAccount))
+	e.POST("/api/2fa/verify", twoFactorHandler.Verify, require(auth.PermAccount))
+	e.POST("/api/2fa/disable", twoFactorHandler.Disable, require(auth.PermAccount))
+
 	// Dashboard routes
-	e.GET("/", dashboardHandler.Dashboard)
-	e.GET("/dashboard", dashboardHandler.Dashboard)
+	e.GET("/", dashboardHandler.Dashboard, require(auth.PermDashboardView))
+	e.GET("/dashboard", dashboardHandler.Dashboard, require(auth.PermDashboardView))
 
 	// Widget API routes
-	e.GET("/api/widgets/weather", dashboardHandler.GetWeatherWidget)
-	e.GET("/api/widgets/system", dashboardHandler.GetSystemStatsWidget)
-	e.GET("/api/widgets/uptime", dashboardHandler.GetUptimeWidget)
+	e.GET("/api/widgets/weather", dashboardHandler.GetWeatherWidget, require(auth.PermWidgetsRead))
+	e.GET("/api/widgets/system", dashboardHandler.GetSystemStatsWidget, require(auth.PermWidgetsRead))
+	e.GET("/api/widgets/uptime", dashboardHandler.GetUptimeWidget, require(auth.PermWidgetsRead))
+
+	// Metrics history routes
+	e.GET("/metrics", metricsHandler.Page, require(auth.PermWidgetsRead))
+	e.GET("/api/metrics", metricsHandler.List, require(auth.PermWidgetsRead))
+	e.GET("/api/metrics/series", metricsHandler.Series, require(auth.PermWidgetsRead))
 
 	// Widget data routes
-	e.POST("/api/widgets/save", dashboardHandler.SaveWidgetData)
-	e.GET("/api/widgets/data", dashboardHandler.GetWidgetData)
+	e.POST("/api/widgets/save", dashboardHandler.SaveWidgetData, require(auth.PermWidgetsWrite))
+	e.GET("/api/widgets/data", dashboardHandler.GetWidgetData, require(auth.PermWidgetsRead))
+
+	// Settings routes
+	e.GET("/api/settings/:key", settingsHandler.Get, require(auth.PermDashboardView))
+	e.PUT("/api/settings/:key", settingsHandler.Save, require(auth.PermSettingsWrite))
+
+	// Admin routes
+	e.GET("/api/admin/users", adminHandler.ListUsers, require(auth.PermAdmin))
+	e.POST("/api/admin/users", adminHandler.CreateUser, require(auth.PermAdmin))
+	e.PUT("/api/admin/users/:id/role", adminHandler.SetUserRole, require(auth.PermAdmin))
+	e.GET("/api/admin/lockouts", adminHandler.ListLockouts, require(auth.PermAdmin))
+	e.DELETE("/api/admin/lockouts", adminHandler.ClearAllLockouts, require(auth.PermAdmin))
+	e.DELETE("/api/admin/lockouts/:id", adminHandler.ClearLockout, require(auth.PermAdmin))
+	e.GET("/admin/audit", auditHandler.Page, require(auth.PermAdmin))
+	e.GET("/api/admin/audit", auditHandler.List, require(auth.PermAdmin))
 
 	return e, nil
 }
@@ -0,0 +1,234 @@
+// Package assets serves the embedded static files under /static. Each file
+// gets a content-hashed URL that is cached for a year, plus gzip and brotli
+// variants compressed once at startup.
+package assets
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"citadel/highway17/web/static"
+
+	"github.com/andybalholm/brotli"
+	"github.com/labstack/echo/v4"
+)
+
+// Prefix is the URL path assets are served under
+const Prefix = "/static/"
+
+const (
+	// immutableCache is sent for hashed URLs, whose content never changes
+	immutableCache = "public, max-age=31536000, immutable"
+
+	// revalidateCache is sent for plain URLs, which browsers check by ETag
+	revalidateCache = "no-cache"
+
+	hashLength = 12
+)
+
+// asset is one file with its precompressed variants; gzip and br are nil
+// when compression would not make the file smaller
+type asset struct {
+	name        string // logical path, e.g. css/style.css
+	hashedName  string // e.g. css/style.1a2b3c4d5e6f.css
+	hash        string
+	contentType string
+	raw         []byte
+	gzip        []byte
+	br          []byte
+}
+
+// Set is a collection of assets addressable by logical or hashed name
+type Set struct {
+	byName   map[string]*asset
+	byHashed map[string]*asset
+}
+
+var (
+	defaultSet  *Set
+	defaultErr  error
+	defaultOnce sync.Once
+)
+
+// Default returns the set built from the embedded web/static files
+func Default() (*Set, error) {
+	defaultOnce.Do(func() {
+		defaultSet, defaultErr = New(static.FS)
+	})
+	return defaultSet, defaultErr
+}
+
+// Path returns the cache-busting URL for a logical asset name such as
+// "js/htmx.min.js". Unknown names fall back to the plain URL.
+func Path(name string) string {
+	set, err := Default()
+	if err != nil {
+		return Prefix + name
+	}
+	return set.Path(name)
+}
+
+// New hashes and compresses every file in fsys
+func New(fsys fs.FS) (*Set, error) {
+	set := &Set{byName: make(map[string]*asset), byHashed: make(map[string]*asset)}
+
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		raw, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		a, err := newAsset(name, raw)
+		if err != nil {
+			return fmt.Errorf("asset %s: %w", name, err)
+		}
+		set.byName[a.name] = a
+		set.byHashed[a.hashedName] = a
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load static assets: %w", err)
+	}
+
+	return set, nil
+}
+
+func newAsset(name string, raw []byte) (*asset, error) {
+	sum := sha256.Sum256(raw)
+	hash := hex.EncodeToString(sum[:])[:hashLength]
+	ext := path.Ext(name)
+
+	contentType := mime.TypeByExtension(ext)
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	a := &asset{
+		name:        name,
+		hashedName:  strings.TrimSuffix(name, ext) + "." + hash + ext,
+		hash:        hash,
+		contentType: contentType,
+		raw:         raw,
+	}
+
+	var buf bytes.Buffer
+	gz, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if _, err := gz.Write(raw); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	if buf.Len() < len(raw) {
+		a.gzip = bytes.Clone(buf.Bytes())
+	}
+
+	buf.Reset()
+	br := brotli.NewWriterLevel(&buf, brotli.BestCompression)
+	if _, err := br.Write(raw); err != nil {
+		return nil, err
+	}
+	if err := br.Close(); err != nil {
+		return nil, err
+	}
+	if buf.Len() < len(raw) {
+		a.br = bytes.Clone(buf.Bytes())
+	}
+
+	return a, nil
+}
+
+// Path returns the hashed URL for a logical asset name
+func (s *Set) Path(name string) string {
+	if a, ok := s.byName[name]; ok {
+		return Prefix + a.hashedName
+	}
+	return Prefix + name
+}
+
+// Handler serves /static/*. Hashed URLs are cached for a year; plain URLs
+// must be revalidated with If-None-Match. Both pick brotli or gzip from
+// Accept-Encoding when a smaller variant exists.
+func (s *Set) Handler(c echo.Context) error {
+	name := strings.TrimPrefix(c.Request().URL.Path, Prefix)
+
+	cacheControl := immutableCache
+	a, ok := s.byHashed[name]
+	if !ok {
+		cacheControl = revalidateCache
+		if a, ok = s.byName[name]; !ok {
+			return echo.NewHTTPError(http.StatusNotFound)
+		}
+	}
+
+	body, encoding := a.raw, ""
+	accepted := acceptedEncodings(c.Request().Header.Get(echo.HeaderAcceptEncoding))
+	switch {
+	case a.br != nil && accepted["br"]:
+		body, encoding = a.br, "br"
+	case a.gzip != nil && accepted["gzip"]:
+		body, encoding = a.gzip, "gzip"
+	}
+
+	// Each encoding is a different representation, so it gets its own ETag
+	etag := `"` + a.hash + `"`
+	if encoding != "" {
+		etag = `"` + a.hash + "-" + encoding + `"`
+	}
+
+	h := c.Response().Header()
+	h.Set(echo.HeaderCacheControl, cacheControl)
+	h.Set("ETag", etag)
+	h.Add(echo.HeaderVary, echo.HeaderAcceptEncoding)
+
+	if etagMatches(c.Request().Header.Get("If-None-Match"), etag) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	if encoding != "" {
+		h.Set(echo.HeaderContentEncoding, encoding)
+	}
+	h.Set(echo.HeaderContentLength, strconv.Itoa(len(body)))
+	return c.Blob(http.StatusOK, a.contentType, body)
+}
+
+// acceptedEncodings parses Accept-Encoding, dropping codings with q=0
+func acceptedEncodings(header string) map[string]bool {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if q, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				continue
+			}
+		}
+		if coding != "" {
+			accepted[strings.ToLower(coding)] = true
+		}
+	}
+	return accepted
+}
+
+// etagMatches reports whether an If-None-Match header lists etag
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+// Package audit records security-relevant and state-changing actions in the
+// audit_events table.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"citadel/highway17/internal/database"
+	"citadel/highway17/internal/models"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// Actions. Names are "<area>.<verb>" so a filter on "auth." finds every
+// authentication event.
+const (
+	ActionLogin          = "auth.login"
+	ActionLoginFailed    = "auth.login_failed"
+	ActionLoginLocked    = "auth.login_locked"
+	ActionLogout         = "auth.logout"
+	ActionSetup          = "auth.setup"
+	ActionPasswordChange = "auth.password_change"
+	ActionTwoFactorOn    = "auth.2fa_enable"
+	ActionTwoFactorOff   = "auth.2fa_disable"
+	ActionPasskeyAdd     = "auth.passkey_add"
+	ActionPasskeyDelete  = "auth.passkey_delete"
+	ActionOIDCLink       = "auth.oidc_link"
+	ActionSessionRevoke  = "session.revoke"
+	ActionTokenCreate    = "token.create"
+	ActionTokenRevoke    = "token.revoke"
+	ActionWidgetSave     = "widget.save"
+	ActionSettingSave    = "setting.save"
+	ActionUserCreate     = "admin.user_create"
+	ActionUserDelete     = "admin.user_delete"
+	ActionUserRole       = "admin.user_role"
+	ActionUserPassword   = "admin.user_password"
+	ActionLockoutClear   = "admin.lockout_clear"
+)
+
+// Actions lists every action, for filter suggestions
+var Actions = []string{
+	ActionLogin, ActionLoginFailed, ActionLoginLocked, ActionLogout, ActionSetup,
+	ActionPasswordChange, ActionTwoFactorOn, ActionTwoFactorOff, ActionPasskeyAdd,
+	ActionPasskeyDelete, ActionOIDCLink, ActionSessionRevoke, ActionTokenCreate,
+	ActionTokenRevoke, ActionWidgetSave, ActionSettingSave, ActionUserCreate,
+	ActionUserDelete, ActionUserRole, ActionUserPassword, ActionLockoutClear,
+}
+
+// Outcomes
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	OutcomeDenied  = "denied"
+)
+
+// Event is an action to record. Before, After and Details are marshalled
+// to JSON; leave them nil when there is nothing to show.
+type Event struct {
+	Action     string
+	Outcome    string // defaults to OutcomeSuccess
+	ActorID    *int
+	ActorName  string
+	ClientIP   string
+	TargetType string
+	TargetID   string
+	Before     any
+	After      any
+	Details    map[string]any
+}
+
+// Recorder writes audit events. Failures are logged and never fail the
+// action being audited.
+type Recorder struct {
+	db  database.AuditStore
+	log *zap.Logger
+}
+
+func NewRecorder(db database.AuditStore, log *zap.Logger) *Recorder {
+	return &Recorder{db: db, log: log}
+}
+
+// Record stores an event as given
+func (r *Recorder) Record(ctx context.Context, ev Event) {
+	if ev.Outcome == "" {
+		ev.Outcome = OutcomeSuccess
+	}
+
+	row := &models.AuditEvent{
+		Action:     ev.Action,
+		Outcome:    ev.Outcome,
+		ActorID:    ev.ActorID,
+		ActorName:  ev.ActorName,
+		ClientIP:   ev.ClientIP,
+		TargetType: ev.TargetType,
+		TargetID:   ev.TargetID,
+		Before:     r.marshal(ev.Action, ev.Before),
+		After:      r.marshal(ev.Action, ev.After),
+	}
+	if len(ev.Details) > 0 {
+		row.Details = r.marshal(ev.Action, ev.Details)
+	}
+
+	if err := r.db.InsertAuditEvent(ctx, row); err != nil {
+		r.log.Sugar().Errorw("failed to record audit event", "action", ev.Action, "actor", ev.ActorName, "error", err)
+	}
+}
+
+// Request records an event for an HTTP request, taking the actor from the
+// authenticated user (unless ActorName is already set) and the client IP
+// from the request
+func (r *Recorder) Request(c echo.Context, ev Event) {
+	if user, ok := c.Get("user").(*models.User); ok && ev.ActorID == nil && ev.ActorName == "" {
+		ev.ActorID = &user.ID
+		ev.ActorName = user.Username
+	}
+	if ev.ClientIP == "" {
+		ev.ClientIP = c.RealIP()
+	}
+	r.Record(context.Background(), ev)
+}
+
+func (r *Recorder) marshal(action string, v any) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	if raw, ok := v.(json.RawMessage); ok {
+		return raw
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		r.log.Sugar().Warnw("failed to encode audit value", "action", action, "error", err)
+		return nil
+	}
+	return b
+}
+
+// RunRetention deletes events older than maxAge every interval until ctx is
+// cancelled. A zero maxAge keeps events forever.
+func RunRetention(ctx context.Context, db database.AuditStore, log *zap.Logger, maxAge, interval time.Duration) {
+	if maxAge <= 0 || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := db.PurgeAuditEvents(ctx, maxAge)
+		if err != nil {
+			log.Sugar().Errorw("failed to purge audit events", "error", err)
+		} else if purged > 0 {
+			log.Sugar().Infow("purged old audit events", "count", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
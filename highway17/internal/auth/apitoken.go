@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+
+	"citadel/highway17/internal/models"
+)
+
+// APITokenPrefix marks personal API tokens so they can be told apart from
+// session tokens in an Authorization header
+const APITokenPrefix = "h17_"
+
+// TokenScopes are the permissions an API token may carry
+var TokenScopes = []Permission{PermWidgetsRead, PermWidgetsWrite, PermProxyAccess, PermAdmin}
+
+// GenerateAPIToken returns a new token, its hash for storage and a short
+// prefix that identifies it in listings
+func GenerateAPIToken() (token, hash, prefix string) {
+	b := make([]byte, 32)
+	rand.Read(b)
+	token = APITokenPrefix + hex.EncodeToString(b)
+	return token, HashAPIToken(token), token[:len(APITokenPrefix)+8]
+}
+
+// HashAPIToken hashes a token for lookup. Tokens carry 256 bits of entropy
+// so a fast hash is sufficient.
+func HashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsAPIToken reports whether a bearer credential is a personal API token
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, APITokenPrefix)
+}
+
+// ParseScopes validates requested scopes against TokenScopes and the
+// owner's role; a token can never exceed the user who created it
+func ParseScopes(user *models.User, requested []string) ([]string, error) {
+	var scopes []string
+	for _, raw := range requested {
+		scope := Permission(strings.TrimSpace(raw))
+		if scope == "" || slices.Contains(scopes, string(scope)) {
+			continue
+		}
+		if !slices.Contains(TokenScopes, scope) {
+			return nil, fmt.Errorf("unknown scope %q", scope)
+		}
+		if !UserCan(user, scope) {
+			return nil, fmt.Errorf("your role does not grant scope %q", scope)
+		}
+		scopes = append(scopes, string(scope))
+	}
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("at least one scope is required")
+	}
+	return scopes, nil
+}
+
+// TokenAllows reports whether an API token's scopes cover perm. Reading
+// widgets includes viewing the dashboard, and admin covers everything
+// except managing the owner's own credentials.
+func TokenAllows(token *models.APIToken, perm Permission) bool {
+	for _, scope := range token.Scopes {
+		switch Permission(scope) {
+		case perm:
+			return true
+		case PermAdmin:
+			if perm != PermAccount {
+				return true
+			}
+		case PermWidgetsRead:
+			if perm == PermDashboardView {
+				return true
+			}
+		}
+	}
+	return false
+}
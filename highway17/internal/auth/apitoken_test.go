@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"slices"
+	"testing"
+
+	"citadel/highway17/internal/models"
+)
+
+func TestTokenAllows(t *testing.T) {
+	tests := []struct {
+		scopes []string
+		perm   Permission
+		want   bool
+	}{
+		{[]string{"widgets:read"}, PermWidgetsRead, true},
+		{[]string{"widgets:read"}, PermDashboardView, true},
+		{[]string{"widgets:read"}, PermWidgetsWrite, false},
+		{[]string{"widgets:read"}, PermProxyAccess, false},
+		{[]string{"widgets:write"}, PermWidgetsRead, false},
+		{[]string{"widgets:read", "widgets:write"}, PermWidgetsWrite, true},
+		{[]string{"proxy:access"}, PermProxyAccess, true},
+		{[]string{"proxy:access"}, PermDashboardView, false},
+		{[]string{"admin"}, PermSettingsWrite, true},
+		{[]string{"admin"}, PermAdmin, true},
+		// Tokens never manage their owner's credentials
+		{[]string{"admin"}, PermAccount, false},
+		{nil, PermDashboardView, false},
+	}
+
+	for _, tt := range tests {
+		if got := TokenAllows(&models.APIToken{Scopes: tt.scopes}, tt.perm); got != tt.want {
+			t.Errorf("TokenAllows(%v, %s) = %v, want %v", tt.scopes, tt.perm, got, tt.want)
+		}
+	}
+}
+
+func TestParseScopes(t *testing.T) {
+	viewer := &models.User{Role: string(RoleViewer)}
+	admin := &models.User{Role: string(RoleAdmin)}
+
+	tests := []struct {
+		name      string
+		user      *models.User
+		requested []string
+		want      []string
+		wantErr   bool
+	}{
+		{name: "trimmed and deduplicated", user: viewer, requested: []string{" widgets:read", "widgets:read", ""}, want: []string{"widgets:read"}},
+		{name: "admin takes any scope", user: admin, requested: []string{"admin", "proxy:access"}, want: []string{"admin", "proxy:access"}},
+		{name: "beyond the owner's role", user: viewer, requested: []string{"widgets:write"}, wantErr: true},
+		{name: "admin scope for a viewer", user: viewer, requested: []string{"admin"}, wantErr: true},
+		{name: "not a token scope", user: admin, requested: []string{"account:self"}, wantErr: true},
+		{name: "unknown scope", user: admin, requested: []string{"widgets:*"}, wantErr: true},
+		{name: "none", user: admin, requested: []string{" "}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseScopes(tt.user, tt.requested)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("scopes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateAPIToken(t *testing.T) {
+	token, hash, prefix := GenerateAPIToken()
+	if !IsAPIToken(token) || len(token) != len(APITokenPrefix)+64 {
+		t.Errorf("token = %q", token)
+	}
+	if hash != HashAPIToken(token) || hash == token {
+		t.Errorf("hash = %q", hash)
+	}
+	if prefix != token[:len(APITokenPrefix)+8] {
+		t.Errorf("prefix = %q", prefix)
+	}
+	if other, _, _ := GenerateAPIToken(); other == token {
+		t.Error("two tokens are equal")
+	}
+}
@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"citadel/highway17/internal/config"
+	"citadel/highway17/internal/database"
+	"citadel/highway17/internal/models"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrUnknownUser means a backend has no account for the username, so
+	// the next backend in the chain gets a turn
+	ErrUnknownUser = errors.New("unknown user")
+
+	// ErrInvalidCredentials means a backend owns the account and rejected
+	// the password; the chain stops there
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Backend verifies a username and password against one identity store and
+// returns the matching local users row
+type Backend interface {
+	Name() string
+	Authenticate(ctx context.Context, username, password string) (*models.User, error)
+}
+
+// Backends tries each configured backend in order
+type Backends struct {
+	chain []Backend
+	log   *zap.Logger
+}
+
+// NewBackends builds the chain named by AUTH_BACKENDS
+func NewBackends(cfg *config.Config, db database.UserStore, log *zap.Logger) (*Backends, error) {
+	b := &Backends{log: log}
+
+	for i, name := range cfg.AuthBackends {
+		switch name {
+		case "local":
+			b.chain = append(b.chain, NewLocalBackend(db))
+		case "ldap":
+			// A templated bind fails the same way for unknown users and
+			// wrong passwords, so nothing after it would ever be tried
+			if cfg.LDAPUserDNTemplate != "" && i < len(cfg.AuthBackends)-1 {
+				return nil, fmt.Errorf("invalid AUTH_BACKENDS: ldap must be the last backend when LDAP_USER_DN_TEMPLATE is set (use LDAP_BASE_DN to search for users instead)")
+			}
+			ldap, err := NewLDAPBackend(cfg, db)
+			if err != nil {
+				return nil, err
+			}
+			b.chain = append(b.chain, ldap)
+		default:
+			return nil, fmt.Errorf("invalid AUTH_BACKENDS: unknown backend %q (want local or ldap)", name)
+		}
+	}
+
+	if len(b.chain) == 0 {
+		return nil, fmt.Errorf("invalid AUTH_BACKENDS: at least one backend is required")
+	}
+	return b, nil
+}
+
+// Authenticate returns the user and the name of the backend that accepted
+// them. A backend that is unreachable is skipped so a directory outage
+// does not lock out local accounts; its error is returned only if no other
+// backend settled the login.
+func (b *Backends) Authenticate(ctx context.Context, username, password string) (*models.User, string, error) {
+	if password == "" {
+		return nil, "", ErrInvalidCredentials
+	}
+
+	var backendErr error
+	for _, backend := range b.chain {
+		user, err := backend.Authenticate(ctx, username, password)
+		switch {
+		case err == nil:
+			return user, backend.Name(), nil
+		case errors.Is(err, ErrUnknownUser):
+			continue
+		case errors.Is(err, ErrInvalidCredentials):
+			return nil, backend.Name(), err
+		default:
+			b.log.Sugar().Errorw("auth backend failed", "backend", backend.Name(), "username", username, "error", err)
+			backendErr = fmt.Errorf("%s backend: %w", backend.Name(), err)
+		}
+	}
+
+	if backendErr != nil {
+		return nil, "", backendErr
+	}
+	return nil, "", ErrUnknownUser
+}
+
+// LocalBackend checks bcrypt hashes in users.password_hash. Accounts without
+// a hash (Tailscale, OIDC, directory users) are left to other backends.
+type LocalBackend struct {
+	db database.UserStore
+}
+
+func NewLocalBackend(db database.UserStore) *LocalBackend {
+	return &LocalBackend{db: db}
+}
+
+func (lb *LocalBackend) Name() string { return "local" }
+
+func (lb *LocalBackend) Authenticate(ctx context.Context, username, password string) (*models.User, error) {
+	user, err := lb.db.GetUserByUsername(ctx, username)
+	if err != nil {
+		if errors.Is(err, database.ErrUserNotFound) {
+			return nil, ErrUnknownUser
+		}
+		return nil, err
+	}
+
+	if user.PasswordHash == "" {
+		return nil, ErrUnknownUser
+	}
+	if !VerifyPassword(user.PasswordHash, password) {
+		return nil, ErrInvalidCredentials
+	}
+	return user, nil
+}
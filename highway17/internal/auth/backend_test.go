@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"citadel/highway17/internal/config"
+	"citadel/highway17/internal/database/databasetest"
+	"citadel/highway17/internal/models"
+
+	"go.uber.org/zap"
+)
+
+var errOutage = errors.New("connection refused")
+
+// fakeBackend answers every login with the same user or error and counts
+// how often it was asked
+type fakeBackend struct {
+	name  string
+	user  *models.User
+	err   error
+	calls int
+}
+
+func (f *fakeBackend) Name() string { return f.name }
+
+func (f *fakeBackend) Authenticate(ctx context.Context, username, password string) (*models.User, error) {
+	f.calls++
+	return f.user, f.err
+}
+
+func TestBackendsAuthenticate(t *testing.T) {
+	alyx := &models.User{ID: 1, Username: "alyx"}
+
+	tests := []struct {
+		name        string
+		results     []error // one backend per entry; nil accepts the login
+		password    string
+		wantBackend string
+		wantErr     error
+		wantCalls   []int
+	}{
+		{name: "first backend accepts", results: []error{nil, nil}, wantBackend: "a", wantCalls: []int{1, 0}},
+		{name: "unknown user falls through", results: []error{ErrUnknownUser, nil}, wantBackend: "b", wantCalls: []int{1, 1}},
+		{name: "invalid credentials stop the chain", results: []error{ErrInvalidCredentials, nil}, wantBackend: "a", wantErr: ErrInvalidCredentials, wantCalls: []int{1, 0}},
+		{name: "outage falls back", results: []error{errOutage, nil}, wantBackend: "b", wantCalls: []int{1, 1}},
+		{name: "outage then invalid credentials", results: []error{errOutage, ErrInvalidCredentials}, wantBackend: "b", wantErr: ErrInvalidCredentials, wantCalls: []int{1, 1}},
+		{name: "outage then unknown user", results: []error{errOutage, ErrUnknownUser}, wantErr: errOutage, wantCalls: []int{1, 1}},
+		{name: "nobody knows the user", results: []error{ErrUnknownUser, ErrUnknownUser}, wantErr: ErrUnknownUser, wantCalls: []int{1, 1}},
+		{name: "empty password", results: []error{nil}, password: "-", wantErr: ErrInvalidCredentials, wantCalls: []int{0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &Backends{log: zap.NewNop()}
+			var fakes []*fakeBackend
+			for i, err := range tt.results {
+				f := &fakeBackend{name: string(rune('a' + i)), err: err}
+				if err == nil {
+					f.user = alyx
+				}
+				fakes = append(fakes, f)
+				b.chain = append(b.chain, f)
+			}
+
+			password := "whiteforest"
+			if tt.password == "-" {
+				password = ""
+			}
+			user, backend, err := b.Authenticate(context.Background(), "alyx", password)
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if backend != tt.wantBackend {
+				t.Errorf("backend = %q, want %q", backend, tt.wantBackend)
+			}
+			if (user != nil) != (tt.wantErr == nil) {
+				t.Errorf("user = %v with err %v", user, err)
+			}
+			for i, f := range fakes {
+				if f.calls != tt.wantCalls[i] {
+					t.Errorf("backend %s called %d times, want %d", f.name, f.calls, tt.wantCalls[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLocalBackend(t *testing.T) {
+	ctx := context.Background()
+	store := databasetest.NewStore()
+	hash, err := HashPassword("whiteforest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreateUser(ctx, "alyx", hash, "admin", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreateTailscaleUser(ctx, "barney", "barney@github"); err != nil {
+		t.Fatal(err)
+	}
+	lb := NewLocalBackend(store)
+
+	tests := []struct {
+		username, password string
+		wantErr            error
+	}{
+		{"alyx", "whiteforest", nil},
+		{"alyx", "blackmesa", ErrInvalidCredentials},
+		// Password-less accounts belong to other backends
+		{"barney", "whiteforest", ErrUnknownUser},
+		{"gordon", "whiteforest", ErrUnknownUser},
+	}
+	for _, tt := range tests {
+		user, err := lb.Authenticate(ctx, tt.username, tt.password)
+		if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && (err != nil || user.Username != tt.username)) {
+			t.Errorf("Authenticate(%s, %s) = %v, %v; want %v", tt.username, tt.password, user, err, tt.wantErr)
+		}
+	}
+}
+
+func TestNewBackends(t *testing.T) {
+	tests := []struct {
+		name     string
+		backends []string
+		template string
+		wantErr  string
+	}{
+		{name: "local only", backends: []string{"local"}},
+		{name: "search before local", backends: []string{"ldap", "local"}},
+		{name: "template last", backends: []string{"local", "ldap"}, template: "uid={username},ou=people,dc=city17,dc=lan"},
+		// An unknown user would fail the templated bind and never reach local
+		{name: "template before local", backends: []string{"ldap", "local"}, template: "uid={username},ou=people,dc=city17,dc=lan", wantErr: "must be the last backend"},
+		{name: "unknown backend", backends: []string{"local", "radius"}, wantErr: `unknown backend "radius"`},
+		{name: "no backends", wantErr: "at least one backend"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				AuthBackends:       tt.backends,
+				LDAPURL:            "ldap://127.0.0.1:1",
+				LDAPBaseDN:         "dc=city17,dc=lan",
+				LDAPUserDNTemplate: tt.template,
+			}
+			_, err := NewBackends(cfg, databasetest.NewStore(), zap.NewNop())
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// A directory that cannot be reached must not lock out local accounts
+func TestBackendsLDAPOutage(t *testing.T) {
+	ctx := context.Background()
+	store := databasetest.NewStore()
+	hash, err := HashPassword("whiteforest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreateUser(ctx, "alyx", hash, "admin", false); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := NewBackends(&config.Config{
+		AuthBackends:   []string{"ldap", "local"},
+		LDAPURL:        "ldap://127.0.0.1:1",
+		LDAPBaseDN:     "dc=city17,dc=lan",
+		LDAPUserFilter: "(uid={username})",
+	}, store, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, backend, err := b.Authenticate(ctx, "alyx", "whiteforest")
+	if err != nil || backend != "local" || user.Username != "alyx" {
+		t.Fatalf("Authenticate(alyx) = %v, %q, %v; want alyx from local", user, backend, err)
+	}
+	if _, _, err := b.Authenticate(ctx, "alyx", "blackmesa"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("wrong password = %v, want ErrInvalidCredentials", err)
+	}
+
+	// A user only the directory could know about surfaces the outage
+	_, _, err = b.Authenticate(ctx, "gordon", "crowbar")
+	if err == nil || errors.Is(err, ErrUnknownUser) || !strings.HasPrefix(err.Error(), "ldap backend:") {
+		t.Errorf("directory user during an outage = %v, want the ldap error", err)
+	}
+}
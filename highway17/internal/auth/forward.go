@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+
+	"citadel/highway17/internal/models"
+)
+
+// ForwardRule grants signed-in users access to the services behind a reverse
+// proxy host. Hosts are exact names or "*.domain" wildcards; subjects are role
+// names, "user:<username>" or "*" for any signed-in user.
+type ForwardRule struct {
+	Host     string
+	Subjects []string
+	raw      string
+}
+
+func (r ForwardRule) String() string {
+	return r.raw
+}
+
+// ForwardPolicy decides which users a forward-auth request lets through.
+// The first rule whose host matches decides; hosts without a rule are denied.
+type ForwardPolicy struct {
+	rules []ForwardRule
+}
+
+// ParseForwardRules parses rules of the form "jellyfin.city17.lan viewer,operator,admin"
+// separated by ";"
+func ParseForwardRules(spec string) (*ForwardPolicy, error) {
+	policy := &ForwardPolicy{}
+
+	for _, raw := range strings.Split(spec, ";") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+
+		fields := strings.Fields(raw)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("rule %q: want \"<host> <role|user:name|*>[,...]\"", raw)
+		}
+
+		host := strings.ToLower(fields[0])
+		if name := strings.TrimPrefix(host, "*."); name == "" || strings.Contains(name, "*") {
+			return nil, fmt.Errorf("rule %q: wildcards are only allowed as a leading \"*.\"", raw)
+		}
+
+		rule := ForwardRule{Host: host, raw: raw}
+		for _, subject := range strings.Split(fields[1], ",") {
+			subject = strings.TrimSpace(subject)
+			switch {
+			case subject == "":
+				continue
+			case subject == "*", strings.HasPrefix(subject, "user:") && len(subject) > len("user:"):
+			default:
+				if _, err := ParseRole(subject); err != nil {
+					return nil, fmt.Errorf("rule %q: %w", raw, err)
+				}
+			}
+			rule.Subjects = append(rule.Subjects, subject)
+		}
+		if len(rule.Subjects) == 0 {
+			return nil, fmt.Errorf("rule %q: no roles or users", raw)
+		}
+
+		policy.rules = append(policy.rules, rule)
+	}
+
+	return policy, nil
+}
+
+// Rule returns the rule that governs host, or nil if no rule covers it
+func (p *ForwardPolicy) Rule(host string) *ForwardRule {
+	host = normalizeHost(host)
+	if host == "" {
+		return nil
+	}
+	for i, rule := range p.rules {
+		if matchHost(rule.Host, host) {
+			return &p.rules[i]
+		}
+	}
+	return nil
+}
+
+// Allows reports whether the rule lets user through
+func (r *ForwardRule) Allows(user *models.User) bool {
+	if r == nil || user == nil {
+		return false
+	}
+	return slices.ContainsFunc(r.Subjects, func(subject string) bool {
+		if name, ok := strings.CutPrefix(subject, "user:"); ok {
+			return strings.EqualFold(name, user.Username)
+		}
+		return subject == "*" || subject == user.Role
+	})
+}
+
+// ReturnURL validates a post-login destination on a protected host. Only
+// http(s) URLs whose host has a rule are accepted, so the login page cannot
+// be used as an open redirect.
+func (p *ForwardPolicy) ReturnURL(raw string) (string, bool) {
+	if raw == "" || len(raw) > 2048 {
+		return "", false
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.User != nil || p.Rule(u.Host) == nil {
+		return "", false
+	}
+	return u.String(), true
+}
+
+// normalizeHost lower-cases a host and strips any port
+func normalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(host, ".")
+}
+
+// matchHost matches "*.domain" against any subdomain, everything else exactly
+func matchHost(pattern, host string) bool {
+	if domain, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+domain)
+	}
+	return host == pattern
+}
@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"citadel/highway17/internal/config"
+	"citadel/highway17/internal/database"
+	"citadel/highway17/internal/models"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ldapTimeout bounds every directory round trip during a login
+const ldapTimeout = 10 * time.Second
+
+// LDAPBackend authenticates against a directory. With LDAP_USER_DN_TEMPLATE
+// it binds straight to the templated DN (simple bind); otherwise it looks the
+// user up under LDAP_BASE_DN, as LDAP_BIND_DN or anonymously, and then binds
+// as the entry it found (search-then-bind). On success the local users row
+// is created or refreshed.
+type LDAPBackend struct {
+	db           database.UserStore
+	url          string
+	startTLS     bool
+	tlsConfig    *tls.Config
+	bindDN       string
+	bindPassword string
+	userTemplate string
+	baseDN       string
+	userFilter   string
+	groupFilter  string
+	groupAttr    string
+	roleMapping  map[string]Role
+}
+
+func NewLDAPBackend(cfg *config.Config, db database.UserStore) (*LDAPBackend, error) {
+	if cfg.LDAPURL == "" {
+		return nil, fmt.Errorf("LDAP_URL is required for the ldap auth backend")
+	}
+	if cfg.LDAPUserDNTemplate == "" && cfg.LDAPBaseDN == "" {
+		return nil, fmt.Errorf("LDAP_USER_DN_TEMPLATE or LDAP_BASE_DN is required for the ldap auth backend")
+	}
+	if cfg.LDAPGroupFilter != "" && cfg.LDAPBaseDN == "" {
+		return nil, fmt.Errorf("LDAP_BASE_DN is required when LDAP_GROUP_FILTER is set")
+	}
+
+	mapping, err := ParseRoleMapping(cfg.LDAPRoleMapping)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP_ROLE_MAPPING: %w", err)
+	}
+
+	return &LDAPBackend{
+		db:           db,
+		url:          cfg.LDAPURL,
+		startTLS:     cfg.LDAPStartTLS,
+		tlsConfig:    &tls.Config{InsecureSkipVerify: cfg.LDAPInsecureSkipVerify},
+		bindDN:       cfg.LDAPBindDN,
+		bindPassword: cfg.LDAPBindPassword,
+		userTemplate: cfg.LDAPUserDNTemplate,
+		baseDN:       cfg.LDAPBaseDN,
+		userFilter:   cfg.LDAPUserFilter,
+		groupFilter:  cfg.LDAPGroupFilter,
+		groupAttr:    cfg.LDAPGroupAttribute,
+		roleMapping:  mapping,
+	}, nil
+}
+
+func (lb *LDAPBackend) Name() string { return models.AuthBackendLDAP }
+
+func (lb *LDAPBackend) Authenticate(ctx context.Context, username, password string) (*models.User, error) {
+	// An empty password would be an unauthenticated bind, which many
+	// servers report as success
+	if password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := lb.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	userDN, err := lb.findUserDN(conn, username)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.Bind(userDN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("user bind failed: %w", err)
+	}
+
+	groups, err := lb.userGroups(conn, username, userDN)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := lb.db.UpsertDirectoryUser(ctx, models.AuthBackendLDAP, username, string(lb.groupRole(groups)), len(lb.roleMapping) > 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sync local user: %w", err)
+	}
+	return user, nil
+}
+
+// groupRole maps the user's groups through LDAP_ROLE_MAPPING; users in no
+// mapped group are viewers
+func (lb *LDAPBackend) groupRole(groups []string) Role {
+	role, mapped := MapGroupsToRole(lb.roleMapping, groups)
+	if !mapped {
+		return RoleViewer
+	}
+	return role
+}
+
+func (lb *LDAPBackend) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(lb.url,
+		ldap.DialWithTLSConfig(lb.tlsConfig),
+		ldap.DialWithDialer(&net.Dialer{Timeout: ldapTimeout}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", lb.url, err)
+	}
+	conn.SetTimeout(ldapTimeout)
+
+	if lb.startTLS {
+		if err := conn.StartTLS(lb.tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("starttls failed: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// findUserDN resolves the DN to bind as
+func (lb *LDAPBackend) findUserDN(conn *ldap.Conn, username string) (string, error) {
+	if lb.userTemplate != "" {
+		return lb.templateDN(username), nil
+	}
+
+	if err := lb.serviceBind(conn); err != nil {
+		return "", err
+	}
+
+	filter := lb.userSearchFilter(username)
+	result, err := conn.Search(ldap.NewSearchRequest(
+		lb.baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, int(ldapTimeout.Seconds()), false,
+		filter, []string{"dn"}, nil,
+	))
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return "", fmt.Errorf("user filter %q matched more than one entry", filter)
+	}
+	if err != nil {
+		return "", fmt.Errorf("user search failed: %w", err)
+	}
+
+	switch len(result.Entries) {
+	case 0:
+		return "", ErrUnknownUser
+	case 1:
+		return result.Entries[0].DN, nil
+	default:
+		return "", fmt.Errorf("user filter %q matched more than one entry", filter)
+	}
+}
+
+// userGroups lists the names of the groups matching LDAP_GROUP_FILTER,
+// where {dn} is the user's DN and {username} the login name
+func (lb *LDAPBackend) userGroups(conn *ldap.Conn, username, userDN string) ([]string, error) {
+	if lb.groupFilter == "" {
+		return nil, nil
+	}
+
+	// Group lookups run as the service account when there is one, otherwise
+	// as the user who just bound
+	if lb.bindDN != "" {
+		if err := lb.serviceBind(conn); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		lb.baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		0, int(ldapTimeout.Seconds()), false,
+		lb.groupSearchFilter(username, userDN), []string{lb.groupAttr}, nil,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("group search failed: %w", err)
+	}
+
+	var groups []string
+	for _, entry := range result.Entries {
+		if name := entry.GetAttributeValue(lb.groupAttr); name != "" {
+			groups = append(groups, name)
+		}
+	}
+	return groups, nil
+}
+
+// templateDN fills LDAP_USER_DN_TEMPLATE, escaping the username as a DN
+// attribute value
+func (lb *LDAPBackend) templateDN(username string) string {
+	return strings.ReplaceAll(lb.userTemplate, "{username}", ldap.EscapeDN(username))
+}
+
+// userSearchFilter fills LDAP_USER_FILTER with the escaped username
+func (lb *LDAPBackend) userSearchFilter(username string) string {
+	return strings.ReplaceAll(lb.userFilter, "{username}", ldap.EscapeFilter(username))
+}
+
+// groupSearchFilter fills LDAP_GROUP_FILTER with the escaped DN and username
+func (lb *LDAPBackend) groupSearchFilter(username, userDN string) string {
+	return strings.NewReplacer(
+		"{dn}", ldap.EscapeFilter(userDN),
+		"{username}", ldap.EscapeFilter(username),
+	).Replace(lb.groupFilter)
+}
+
+// serviceBind authenticates as LDAP_BIND_DN, or stays anonymous without one
+func (lb *LDAPBackend) serviceBind(conn *ldap.Conn) error {
+	if lb.bindDN == "" {
+		return nil
+	}
+	if err := conn.Bind(lb.bindDN, lb.bindPassword); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return fmt.Errorf("service account bind rejected, check LDAP_BIND_DN and LDAP_BIND_PASSWORD")
+		}
+		return fmt.Errorf("service account bind failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"testing"
+
+	"citadel/highway17/internal/config"
+	"citadel/highway17/internal/database/databasetest"
+)
+
+func newTestLDAP(t *testing.T, cfg *config.Config) *LDAPBackend {
+	t.Helper()
+	cfg.LDAPURL = "ldap://127.0.0.1:1"
+	lb, err := NewLDAPBackend(cfg, databasetest.NewStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lb
+}
+
+func TestLDAPEscaping(t *testing.T) {
+	lb := newTestLDAP(t, &config.Config{
+		LDAPUserDNTemplate: "uid={username},ou=people,dc=city17,dc=lan",
+		LDAPBaseDN:         "dc=city17,dc=lan",
+		LDAPUserFilter:     "(&(objectClass=person)(uid={username}))",
+		LDAPGroupFilter:    "(&(objectClass=groupOfNames)(|(member={dn})(memberUid={username})))",
+	})
+
+	tests := []struct {
+		username   string
+		userDN     string
+		wantDN     string
+		wantFilter string
+		wantGroups string
+	}{
+		{
+			username:   "alyx",
+			userDN:     "uid=alyx,ou=people,dc=city17,dc=lan",
+			wantDN:     "uid=alyx,ou=people,dc=city17,dc=lan",
+			wantFilter: "(&(objectClass=person)(uid=alyx))",
+			wantGroups: "(&(objectClass=groupOfNames)(|(member=uid=alyx,ou=people,dc=city17,dc=lan)(memberUid=alyx)))",
+		},
+		// A wildcard username must not match every entry
+		{
+			username:   "*",
+			userDN:     "uid=\\*,ou=people,dc=city17,dc=lan",
+			wantDN:     "uid=*,ou=people,dc=city17,dc=lan",
+			wantFilter: "(&(objectClass=person)(uid=\\2a))",
+			wantGroups: "(&(objectClass=groupOfNames)(|(member=uid=\\5c\\2a,ou=people,dc=city17,dc=lan)(memberUid=\\2a)))",
+		},
+		// Nor close the filter and add its own clause
+		{
+			username:   "x)(uid=*",
+			userDN:     "uid=x)(uid=*,ou=people,dc=city17,dc=lan",
+			wantDN:     "uid=x)(uid=*,ou=people,dc=city17,dc=lan",
+			wantFilter: "(&(objectClass=person)(uid=x\\29\\28uid=\\2a))",
+			wantGroups: "(&(objectClass=groupOfNames)(|(member=uid=x\\29\\28uid=\\2a,ou=people,dc=city17,dc=lan)(memberUid=x\\29\\28uid=\\2a)))",
+		},
+		// Nor step outside the templated RDN
+		{
+			username:   "alyx,ou=admins",
+			userDN:     "cn=Vance (Alyx),ou=people,dc=city17,dc=lan",
+			wantDN:     "uid=alyx\\,ou=admins,ou=people,dc=city17,dc=lan",
+			wantFilter: "(&(objectClass=person)(uid=alyx,ou=admins))",
+			wantGroups: "(&(objectClass=groupOfNames)(|(member=cn=Vance \\28Alyx\\29,ou=people,dc=city17,dc=lan)(memberUid=alyx,ou=admins)))",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.username, func(t *testing.T) {
+			if got := lb.templateDN(tt.username); got != tt.wantDN {
+				t.Errorf("templateDN = %q, want %q", got, tt.wantDN)
+			}
+			if got := lb.userSearchFilter(tt.username); got != tt.wantFilter {
+				t.Errorf("userSearchFilter = %q, want %q", got, tt.wantFilter)
+			}
+			if got := lb.groupSearchFilter(tt.username, tt.userDN); got != tt.wantGroups {
+				t.Errorf("groupSearchFilter = %q, want %q", got, tt.wantGroups)
+			}
+		})
+	}
+}
+
+func TestLDAPGroupRole(t *testing.T) {
+	lb := newTestLDAP(t, &config.Config{
+		LDAPBaseDN:      "dc=city17,dc=lan",
+		LDAPRoleMapping: []string{"lab-admins=admin", " lab = operator", "resistance=viewer"},
+	})
+
+	tests := []struct {
+		groups []string
+		want   Role
+	}{
+		{nil, RoleViewer},
+		{[]string{"citizens"}, RoleViewer},
+		{[]string{"lab"}, RoleOperator},
+		{[]string{"resistance"}, RoleViewer},
+		// The most privileged mapped group wins, in any order
+		{[]string{"lab", "lab-admins"}, RoleAdmin},
+		{[]string{"lab-admins", "resistance", "lab"}, RoleAdmin},
+		// Group names are matched exactly
+		{[]string{"Lab-Admins"}, RoleViewer},
+	}
+	for _, tt := range tests {
+		if got := lb.groupRole(tt.groups); got != tt.want {
+			t.Errorf("groupRole(%v) = %s, want %s", tt.groups, got, tt.want)
+		}
+	}
+
+	if _, err := NewLDAPBackend(&config.Config{
+		LDAPURL:         "ldap://127.0.0.1:1",
+		LDAPBaseDN:      "dc=city17,dc=lan",
+		LDAPRoleMapping: []string{"lab=superuser"},
+	}, databasetest.NewStore()); err == nil {
+		t.Error("LDAP_ROLE_MAPPING with an unknown role accepted")
+	}
+}
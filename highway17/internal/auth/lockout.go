@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"citadel/highway17/internal/config"
+	"citadel/highway17/internal/database"
+
+	"go.uber.org/zap"
+)
+
+const (
+	LockoutScopeUsername = "username"
+	LockoutScopeIP       = "ip"
+)
+
+// LockedOutError is returned when a username or source IP is locked out
+type LockedOutError struct {
+	Scope      string
+	RetryAfter time.Duration
+}
+
+func (e *LockedOutError) Error() string {
+	return fmt.Sprintf("%s locked out for %s", e.Scope, e.RetryAfter.Round(time.Second))
+}
+
+// Lockout tracks failed logins per username and per source IP. Once a
+// subject reaches MaxAttempts failures inside FailureWindow it is locked
+// for BaseLockout, doubling with every further failure up to MaxLockout.
+// State lives in the login_lockouts table so it survives restarts.
+type Lockout struct {
+	db  database.LockoutStore
+	log *zap.Logger
+
+	maxAttempts   int
+	baseLockout   time.Duration
+	maxLockout    time.Duration
+	failureWindow time.Duration
+}
+
+func NewLockout(cfg *config.Config, db database.LockoutStore, log *zap.Logger) *Lockout {
+	return &Lockout{
+		db:            db,
+		log:           log,
+		maxAttempts:   cfg.LoginMaxAttempts,
+		baseLockout:   time.Duration(cfg.LoginLockoutBase) * time.Second,
+		maxLockout:    time.Duration(cfg.LoginLockoutMax) * time.Second,
+		failureWindow: time.Duration(cfg.LoginFailureWindow) * time.Second,
+	}
+}
+
+// Check returns a *LockedOutError if either the username or the IP is locked
+func (l *Lockout) Check(ctx context.Context, username, ip string) error {
+	for _, subject := range l.subjects(username, ip) {
+		remaining, err := l.db.LoginLockedFor(ctx, subject.scope, subject.value)
+		if err != nil {
+			return fmt.Errorf("failed to check lockout: %w", err)
+		}
+		if remaining > 0 {
+			return &LockedOutError{Scope: subject.scope, RetryAfter: remaining}
+		}
+	}
+	return nil
+}
+
+// RecordFailure counts a failed login and locks subjects that crossed the threshold
+func (l *Lockout) RecordFailure(ctx context.Context, username, ip string) {
+	for _, subject := range l.subjects(username, ip) {
+		failures, err := l.db.RecordLoginFailure(ctx, subject.scope, subject.value, l.failureWindow)
+		if err != nil {
+			l.log.Sugar().Errorw("failed to record login failure", "scope", subject.scope, "error", err)
+			continue
+		}
+
+		duration := l.lockoutDuration(failures)
+		if duration == 0 {
+			continue
+		}
+
+		if err := l.db.LockLogin(ctx, subject.scope, subject.value, duration); err != nil {
+			l.log.Sugar().Errorw("failed to lock login", "scope", subject.scope, "error", err)
+			continue
+		}
+
+		// Stable keys so fail2ban-style filters can match on event and client_ip
+		l.log.Warn("login lockout",
+			zap.String("event", "login_lockout"),
+			zap.String("scope", subject.scope),
+			zap.String("subject", subject.value),
+			zap.String("username", username),
+			zap.String("client_ip", ip),
+			zap.Int("failures", failures),
+			zap.Duration("lockout", duration),
+			zap.Time("locked_until", time.Now().Add(duration)),
+		)
+	}
+}
+
+// RecordSuccess clears the username's failure history after a successful
+// login. The source IP's failures are left to expire with the window:
+// clearing them would let anyone with one valid account reset their
+// per-IP counter between guesses at other usernames.
+func (l *Lockout) RecordSuccess(ctx context.Context, username string) {
+	if username == "" {
+		return
+	}
+	if err := l.db.ClearLoginFailures(ctx, LockoutScopeUsername, username); err != nil {
+		l.log.Sugar().Errorw("failed to clear login failures", "scope", LockoutScopeUsername, "error", err)
+	}
+}
+
+// lockoutDuration is zero below the threshold, then doubles per failure
+func (l *Lockout) lockoutDuration(failures int) time.Duration {
+	if l.maxAttempts <= 0 || failures < l.maxAttempts {
+		return 0
+	}
+
+	duration := l.baseLockout
+	for i := l.maxAttempts; i < failures; i++ {
+		duration *= 2
+		if duration >= l.maxLockout {
+			return l.maxLockout
+		}
+	}
+	return min(duration, l.maxLockout)
+}
+
+type lockoutSubject struct {
+	scope string
+	value string
+}
+
+func (l *Lockout) subjects(username, ip string) []lockoutSubject {
+	var subjects []lockoutSubject
+	if username != "" {
+		subjects = append(subjects, lockoutSubject{LockoutScopeUsername, username})
+	}
+	if ip != "" {
+		subjects = append(subjects, lockoutSubject{LockoutScopeIP, ip})
+	}
+	return subjects
+}
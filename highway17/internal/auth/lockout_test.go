@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"citadel/highway17/internal/config"
+	"citadel/highway17/internal/database/databasetest"
+
+	"go.uber.org/zap"
+)
+
+// newTestLockout locks after 3 failures within 5 minutes, for 60s doubling
+// up to 10 minutes, on a store whose clock the test moves
+func newTestLockout() (*Lockout, *databasetest.Store, *time.Time) {
+	store := databasetest.NewStore()
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	store.Now = func() time.Time { return now }
+
+	cfg := &config.Config{
+		LoginMaxAttempts:   3,
+		LoginLockoutBase:   60,
+		LoginLockoutMax:    600,
+		LoginFailureWindow: 300,
+	}
+	return NewLockout(cfg, store, zap.NewNop()), store, &now
+}
+
+// lockedScope returns the scope Check reports as locked, or "" if none
+func lockedScope(t *testing.T, l *Lockout, username, ip string) (string, time.Duration) {
+	t.Helper()
+	err := l.Check(context.Background(), username, ip)
+	if err == nil {
+		return "", 0
+	}
+	var locked *LockedOutError
+	if !errors.As(err, &locked) {
+		t.Fatalf("Check: %v", err)
+	}
+	return locked.Scope, locked.RetryAfter
+}
+
+func TestLockoutDuration(t *testing.T) {
+	l, _, _ := newTestLockout()
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, time.Minute},
+		{4, 2 * time.Minute},
+		{5, 4 * time.Minute},
+		{6, 8 * time.Minute},
+		{7, 10 * time.Minute},
+		{50, 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := l.lockoutDuration(tt.failures); got != tt.want {
+			t.Errorf("lockoutDuration(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+
+	// A cap below the base still caps
+	l.maxLockout = 30 * time.Second
+	if got := l.lockoutDuration(3); got != 30*time.Second {
+		t.Errorf("lockoutDuration(3) with a 30s cap = %s", got)
+	}
+
+	// Zero attempts turns lockout off
+	l.maxAttempts = 0
+	if got := l.lockoutDuration(50); got != 0 {
+		t.Errorf("lockoutDuration(50) when disabled = %s", got)
+	}
+}
+
+func TestLockoutScopes(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("username across addresses", func(t *testing.T) {
+		l, _, _ := newTestLockout()
+		for _, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+			l.RecordFailure(ctx, "alyx", ip)
+		}
+		if scope, retry := lockedScope(t, l, "alyx", "192.0.2.9"); scope != LockoutScopeUsername || retry != time.Minute {
+			t.Errorf("alyx = %q for %s, want username for 1m", scope, retry)
+		}
+		if scope, _ := lockedScope(t, l, "barney", "192.0.2.1"); scope != "" {
+			t.Errorf("barney from a failing address = %q, want unlocked", scope)
+		}
+	})
+
+	t.Run("address across usernames", func(t *testing.T) {
+		l, _, _ := newTestLockout()
+		for _, username := range []string{"alyx", "barney", "eli"} {
+			l.RecordFailure(ctx, username, "192.0.2.1")
+		}
+		if scope, _ := lockedScope(t, l, "kleiner", "192.0.2.1"); scope != LockoutScopeIP {
+			t.Errorf("new username from the address = %q, want ip", scope)
+		}
+		if scope, _ := lockedScope(t, l, "alyx", "192.0.2.2"); scope != "" {
+			t.Errorf("alyx from another address = %q, want unlocked", scope)
+		}
+	})
+
+	t.Run("lock doubles and expires", func(t *testing.T) {
+		l, _, now := newTestLockout()
+		for range 4 {
+			l.RecordFailure(ctx, "alyx", "")
+		}
+		if _, retry := lockedScope(t, l, "alyx", ""); retry != 2*time.Minute {
+			t.Errorf("after 4 failures locked for %s, want 2m", retry)
+		}
+		*now = now.Add(2*time.Minute + time.Second)
+		if scope, _ := lockedScope(t, l, "alyx", ""); scope != "" {
+			t.Errorf("after the lock expired = %q, want unlocked", scope)
+		}
+	})
+
+	t.Run("failures outside the window reset", func(t *testing.T) {
+		l, _, now := newTestLockout()
+		l.RecordFailure(ctx, "alyx", "192.0.2.1")
+		l.RecordFailure(ctx, "alyx", "192.0.2.1")
+		*now = now.Add(5*time.Minute + time.Second)
+		l.RecordFailure(ctx, "alyx", "192.0.2.1")
+		if scope, _ := lockedScope(t, l, "alyx", "192.0.2.1"); scope != "" {
+			t.Errorf("after a quiet window = %q, want unlocked", scope)
+		}
+	})
+}
+
+func TestLockoutRecordSuccess(t *testing.T) {
+	ctx := context.Background()
+	l, store, _ := newTestLockout()
+
+	l.RecordFailure(ctx, "alyx", "192.0.2.1")
+	l.RecordFailure(ctx, "alyx", "192.0.2.1")
+	l.RecordSuccess(ctx, "alyx")
+
+	// The username starts over, the address keeps counting
+	l.RecordFailure(ctx, "alyx", "192.0.2.1")
+	if scope, _ := lockedScope(t, l, "alyx", "192.0.2.2"); scope != "" {
+		t.Errorf("alyx from another address = %q, want the username cleared", scope)
+	}
+	if scope, _ := lockedScope(t, l, "barney", "192.0.2.1"); scope != LockoutScopeIP {
+		t.Errorf("the address = %q, want its failures kept across the success", scope)
+	}
+
+	lockouts, err := store.ListLoginLockouts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, lockout := range lockouts {
+		if lockout.Scope == LockoutScopeUsername && lockout.Failures != 1 {
+			t.Errorf("username failures = %d, want 1 after the success", lockout.Failures)
+		}
+	}
+
+	// An empty username clears nothing
+	l.RecordSuccess(ctx, "")
+	if scope, _ := lockedScope(t, l, "", "192.0.2.1"); scope != LockoutScopeIP {
+		t.Errorf("the address after an anonymous success = %q, want ip", scope)
+	}
+}
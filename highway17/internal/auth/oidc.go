@@ -0,0 +1,289 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"citadel/highway17/internal/config"
+	"citadel/highway17/internal/database"
+	"citadel/highway17/internal/models"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// OIDCStateTTL bounds how long the round trip through the identity provider may take
+const OIDCStateTTL = 10 * time.Minute
+
+var (
+	ErrOIDCStateExpired = errors.New("oidc login expired or unknown")
+	ErrOIDCUnknownUser  = errors.New("no local user is linked to this identity")
+)
+
+// OIDCLogin is the outcome of a completed authorization-code flow
+type OIDCLogin struct {
+	User     *models.User
+	Linked   bool // an existing account was linked rather than logged in
+	ReturnTo string
+}
+
+// OIDC runs the OpenID Connect authorization-code flow with PKCE. Provider
+// discovery happens on first use so the dashboard still starts while the
+// identity provider is down.
+type OIDC struct {
+	db            database.Store
+	issuerURL     string
+	clientID      string
+	clientSecret  string
+	redirectURL   string
+	scopes        []string
+	usernameClaim string
+	groupsClaim   string
+	roleMapping   map[string]Role
+	autoProvision bool
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+	verifier *oidc.IDTokenVerifier
+}
+
+func NewOIDC(cfg *config.Config, db database.Store) (*OIDC, error) {
+	if cfg.OIDCClientID == "" {
+		return nil, fmt.Errorf("OIDC_CLIENT_ID is required when OIDC_ISSUER_URL is set")
+	}
+
+	mapping, err := ParseRoleMapping(cfg.OIDCRoleMapping)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OIDC_ROLE_MAPPING: %w", err)
+	}
+
+	scopes := cfg.OIDCScopes
+	if !slices.Contains(scopes, oidc.ScopeOpenID) {
+		scopes = append([]string{oidc.ScopeOpenID}, scopes...)
+	}
+
+	return &OIDC{
+		db:            db,
+		issuerURL:     cfg.OIDCIssuerURL,
+		clientID:      cfg.OIDCClientID,
+		clientSecret:  cfg.OIDCClientSecret,
+		redirectURL:   cfg.OIDCRedirectURL,
+		scopes:        scopes,
+		usernameClaim: cfg.OIDCUsernameClaim,
+		groupsClaim:   cfg.OIDCGroupsClaim,
+		roleMapping:   mapping,
+		autoProvision: cfg.OIDCAutoProvision,
+	}, nil
+}
+
+// ParseRoleMapping parses "group=role" entries. A user in several mapped
+// groups gets the most privileged of their roles.
+func ParseRoleMapping(entries []string) (map[string]Role, error) {
+	mapping := make(map[string]Role, len(entries))
+	for _, entry := range entries {
+		group, name, ok := strings.Cut(entry, "=")
+		group = strings.TrimSpace(group)
+		if !ok || group == "" {
+			return nil, fmt.Errorf("entry %q: want group=role", entry)
+		}
+		role, err := ParseRole(strings.TrimSpace(name))
+		if err != nil {
+			return nil, fmt.Errorf("entry %q: %w", entry, err)
+		}
+		mapping[group] = role
+	}
+	return mapping, nil
+}
+
+// MapGroupsToRole returns the most privileged role granted by any group,
+// or false when none of the groups are mapped
+func MapGroupsToRole(mapping map[string]Role, groups []string) (Role, bool) {
+	for _, role := range Roles {
+		for _, group := range groups {
+			if mapping[group] == role {
+				return role, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Begin starts a login (or, with linkUserID, an account link) and returns
+// the identity provider URL to redirect the browser to, plus the state
+// value the caller should bind to the browser
+func (o *OIDC) Begin(ctx context.Context, linkUserID *int, returnTo string) (string, string, error) {
+	oauthCfg, _, err := o.discover(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	state := GenerateToken()
+	nonce := GenerateToken()
+	verifier := oauth2.GenerateVerifier()
+
+	if err := o.db.SaveOIDCState(ctx, state, nonce, verifier, linkUserID, returnTo, OIDCStateTTL); err != nil {
+		return "", "", fmt.Errorf("failed to save oidc state: %w", err)
+	}
+
+	url := oauthCfg.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+	return url, state, nil
+}
+
+// Finish redeems the authorization code, verifies the ID token and maps it
+// to a local user
+func (o *OIDC) Finish(ctx context.Context, state, code string) (*OIDCLogin, error) {
+	pending, err := o.db.TakeOIDCState(ctx, state)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, ErrOIDCStateExpired
+		}
+		return nil, fmt.Errorf("failed to load oidc state: %w", err)
+	}
+
+	oauthCfg, verifier, err := o.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := oauthCfg.Exchange(ctx, code, oauth2.VerifierOption(pending.CodeVerifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to redeem authorization code: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, fmt.Errorf("token response has no id_token")
+	}
+
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id_token: %w", err)
+	}
+	if idToken.Nonce != pending.Nonce {
+		return nil, fmt.Errorf("invalid id_token: nonce mismatch")
+	}
+
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("failed to decode id_token claims: %w", err)
+	}
+
+	if pending.UserID != nil {
+		if err := o.db.LinkOIDCIdentity(ctx, *pending.UserID, idToken.Issuer, idToken.Subject); err != nil {
+			return nil, fmt.Errorf("failed to link identity: %w", err)
+		}
+		user, err := o.db.GetUserByID(ctx, *pending.UserID)
+		if err != nil {
+			return nil, err
+		}
+		return &OIDCLogin{User: user, Linked: true, ReturnTo: pending.ReturnTo}, nil
+	}
+
+	user, err := o.resolveUser(ctx, idToken.Issuer, idToken.Subject, claims)
+	if err != nil {
+		return nil, err
+	}
+	return &OIDCLogin{User: user, ReturnTo: pending.ReturnTo}, nil
+}
+
+// resolveUser finds the user linked to an identity, provisioning one when
+// allowed, and keeps the role in step with the provider's groups
+func (o *OIDC) resolveUser(ctx context.Context, issuer, subject string, claims map[string]any) (*models.User, error) {
+	role, mapped := MapGroupsToRole(o.roleMapping, stringsClaim(claims, o.groupsClaim))
+	if !mapped && len(o.roleMapping) > 0 {
+		role, mapped = RoleViewer, true
+	}
+
+	user, err := o.db.GetUserByOIDCSubject(ctx, issuer, subject)
+	if err == nil {
+		if mapped && user.Role != string(role) {
+			if _, err := o.db.SetUserRole(ctx, user.ID, string(role)); err != nil {
+				return nil, fmt.Errorf("failed to update role: %w", err)
+			}
+			user.Role = string(role)
+		}
+		return user, nil
+	}
+	if !errors.Is(err, database.ErrUserNotFound) {
+		return nil, err
+	}
+
+	if !o.autoProvision {
+		return nil, fmt.Errorf("%w: sub=%q", ErrOIDCUnknownUser, subject)
+	}
+
+	username := stringClaim(claims, o.usernameClaim)
+	if username == "" {
+		username = stringClaim(claims, "email")
+	}
+	if username == "" {
+		username = subject
+	}
+	if !mapped {
+		role = RoleViewer
+	}
+
+	userID, err := o.db.CreateOIDCUser(ctx, username, issuer, subject, string(role))
+	if err != nil {
+		return nil, fmt.Errorf("failed to provision user %q: %w", username, err)
+	}
+	return o.db.GetUserByID(ctx, userID)
+}
+
+// discover fetches the provider metadata once and caches it; failures are
+// retried on the next login
+func (o *OIDC) discover(ctx context.Context) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.provider == nil {
+		provider, err := oidc.NewProvider(ctx, o.issuerURL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("oidc discovery failed: %w", err)
+		}
+		o.provider = provider
+		o.verifier = provider.Verifier(&oidc.Config{ClientID: o.clientID})
+	}
+
+	return &oauth2.Config{
+		ClientID:     o.clientID,
+		ClientSecret: o.clientSecret,
+		RedirectURL:  o.redirectURL,
+		Endpoint:     o.provider.Endpoint(),
+		Scopes:       o.scopes,
+	}, o.verifier, nil
+}
+
+func stringClaim(claims map[string]any, name string) string {
+	value, _ := claims[name].(string)
+	return strings.TrimSpace(value)
+}
+
+// stringsClaim reads a claim that providers send either as a JSON array or
+// as a single comma-separated string
+func stringsClaim(claims map[string]any, name string) []string {
+	switch value := claims[name].(type) {
+	case []any:
+		var out []string
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	case string:
+		var out []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				out = append(out, item)
+			}
+		}
+		return out
+	}
+	return nil
+}
@@ -0,0 +1,283 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"citadel/highway17/internal/auth/oidctest"
+	"citadel/highway17/internal/config"
+	"citadel/highway17/internal/database/databasetest"
+)
+
+const testRedirectURL = "http://dashboard.test/auth/oidc/callback"
+
+// newTestOIDC starts a fake issuer and an OIDC client for it, backed by the
+// in-memory store
+func newTestOIDC(t *testing.T, autoProvision bool, roleMapping ...string) (*OIDC, *oidctest.Server, *databasetest.Store) {
+	t.Helper()
+
+	issuer, err := oidctest.NewServer("127.0.0.1:0", "highway17", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { issuer.Close() })
+
+	db := databasetest.NewStore()
+	o, err := NewOIDC(&config.Config{
+		OIDCIssuerURL:     issuer.Issuer,
+		OIDCClientID:      issuer.ClientID,
+		OIDCClientSecret:  issuer.ClientSecret,
+		OIDCRedirectURL:   testRedirectURL,
+		OIDCScopes:        []string{"profile", "email", "groups"},
+		OIDCUsernameClaim: "preferred_username",
+		OIDCGroupsClaim:   "groups",
+		OIDCRoleMapping:   roleMapping,
+		OIDCAutoProvision: autoProvision,
+	}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o, issuer, db
+}
+
+// authorize follows an authorization URL, optionally edited first, and
+// returns the state and code the issuer redirects back with
+func authorize(t *testing.T, authURL string, edit func(q url.Values)) (state, code string) {
+	t.Helper()
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edit != nil {
+		q := u.Query()
+		edit(q)
+		u.RawQuery = q.Encode()
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	location, err := resp.Location()
+	if err != nil {
+		t.Fatalf("authorize: no redirect (status %d)", resp.StatusCode)
+	}
+	if !strings.HasPrefix(location.String(), testRedirectURL) {
+		t.Fatalf("authorize redirected to %s", location)
+	}
+	if e := location.Query().Get("error"); e != "" {
+		t.Fatalf("authorize: %s", e)
+	}
+	return location.Query().Get("state"), location.Query().Get("code")
+}
+
+func TestOIDCLogin(t *testing.T) {
+	ctx := context.Background()
+	o, issuer, _ := newTestOIDC(t, true)
+	issuer.SetIdentity(&oidctest.Identity{Subject: "sub-alyx", PreferredUsername: "alyx", Email: "alyx@example.com"})
+
+	authURL, state, err := o.Begin(ctx, nil, "https://jellyfin.city17.lan/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q, _ := url.Parse(authURL)
+	if got := q.Query(); got.Get("state") != state || got.Get("nonce") == "" || got.Get("code_challenge_method") != "S256" || got.Get("code_challenge") == "" {
+		t.Fatalf("authorization URL %s lacks state, nonce or an S256 challenge", authURL)
+	}
+	if scope := q.Query().Get("scope"); !strings.HasPrefix(scope, "openid ") {
+		t.Errorf("scope = %q, want openid first", scope)
+	}
+
+	gotState, code := authorize(t, authURL, nil)
+	if gotState != state {
+		t.Fatalf("state = %q, want %q", gotState, state)
+	}
+
+	login, err := o.Finish(ctx, state, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if login.User.Username != "alyx" || login.User.Role != string(RoleViewer) || login.Linked || login.ReturnTo != "https://jellyfin.city17.lan/" {
+		t.Errorf("login = %+v, user %+v", login, login.User)
+	}
+
+	// The state is single use
+	if _, err := o.Finish(ctx, state, code); !errors.Is(err, ErrOIDCStateExpired) {
+		t.Errorf("Finish with a spent state: error = %v, want ErrOIDCStateExpired", err)
+	}
+}
+
+func TestOIDCPKCE(t *testing.T) {
+	ctx := context.Background()
+	o, issuer, db := newTestOIDC(t, true)
+	issuer.SetIdentity(&oidctest.Identity{Subject: "sub-alyx", PreferredUsername: "alyx"})
+
+	authURL, state, err := o.Begin(ctx, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The challenge sent to the issuer is the S256 of the stored verifier
+	pending, err := db.TakeOIDCState(ctx, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(pending.CodeVerifier))
+	u, _ := url.Parse(authURL)
+	if got, want := u.Query().Get("code_challenge"), base64.RawURLEncoding.EncodeToString(sum[:]); got != want {
+		t.Errorf("code_challenge = %q, want S256(verifier) %q", got, want)
+	}
+
+	// A code issued for another challenge cannot be redeemed with ours
+	authURL, state, err = o.Begin(ctx, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, code := authorize(t, authURL, func(q url.Values) {
+		forged := sha256.Sum256([]byte("attacker verifier"))
+		q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(forged[:]))
+	})
+	if _, err := o.Finish(ctx, state, code); err == nil || !strings.Contains(err.Error(), "redeem authorization code") {
+		t.Errorf("Finish with a mismatched verifier: error = %v", err)
+	}
+}
+
+func TestOIDCNonceMismatch(t *testing.T) {
+	ctx := context.Background()
+	o, issuer, db := newTestOIDC(t, true)
+	issuer.SetIdentity(&oidctest.Identity{Subject: "sub-alyx", PreferredUsername: "alyx"})
+
+	authURL, state, err := o.Begin(ctx, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, code := authorize(t, authURL, func(q url.Values) { q.Set("nonce", "replayed") })
+
+	if _, err := o.Finish(ctx, state, code); err == nil || !strings.Contains(err.Error(), "nonce mismatch") {
+		t.Fatalf("Finish: error = %v, want a nonce mismatch", err)
+	}
+	if n, _ := db.CountUsers(ctx); n != 0 {
+		t.Errorf("users = %d, want none provisioned", n)
+	}
+}
+
+func TestOIDCLinkAccount(t *testing.T) {
+	ctx := context.Background()
+	o, issuer, db := newTestOIDC(t, false)
+	issuer.SetIdentity(&oidctest.Identity{Subject: "sub-alyx", PreferredUsername: "someone-else"})
+
+	userID, err := db.CreateUser(ctx, "alyx", "hash", "operator", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authURL, state, err := o.Begin(ctx, &userID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, code := authorize(t, authURL, nil)
+	login, err := o.Finish(ctx, state, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !login.Linked || login.User.ID != userID {
+		t.Fatalf("login = %+v, want alyx linked", login)
+	}
+
+	// Later logins find the linked account even without provisioning
+	authURL, state, err = o.Begin(ctx, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, code = authorize(t, authURL, nil)
+	login, err = o.Finish(ctx, state, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if login.Linked || login.User.ID != userID || login.User.Role != "operator" {
+		t.Errorf("login = %+v, user %+v; want alyx as operator", login, login.User)
+	}
+}
+
+func TestOIDCResolveUser(t *testing.T) {
+	ctx := context.Background()
+	const issuer = "https://idp.example"
+	mapping := []string{"admins=admin", "ops=operator", "staff=viewer"}
+
+	tests := []struct {
+		name          string
+		autoProvision bool
+		mapping       []string
+		existingRole  string // the subject is already linked to a user with this role
+		claims        map[string]any
+		wantUsername  string
+		wantRole      Role
+		wantErr       error
+	}{
+		{name: "provisioned from a group", autoProvision: true, mapping: mapping,
+			claims: map[string]any{"preferred_username": "alyx", "groups": []any{"ops"}}, wantUsername: "alyx", wantRole: RoleOperator},
+		{name: "most privileged group wins", autoProvision: true, mapping: mapping,
+			claims: map[string]any{"preferred_username": "alyx", "groups": []any{"staff", "admins", "ops"}}, wantUsername: "alyx", wantRole: RoleAdmin},
+		{name: "groups as a comma-separated string", autoProvision: true, mapping: mapping,
+			claims: map[string]any{"preferred_username": "alyx", "groups": "staff, ops"}, wantUsername: "alyx", wantRole: RoleOperator},
+		{name: "unmapped groups get viewer", autoProvision: true, mapping: mapping,
+			claims: map[string]any{"preferred_username": "alyx", "groups": []any{"visitors"}}, wantUsername: "alyx", wantRole: RoleViewer},
+		{name: "username falls back to email", autoProvision: true,
+			claims: map[string]any{"email": "alyx@example.com"}, wantUsername: "alyx@example.com", wantRole: RoleViewer},
+		{name: "username falls back to subject", autoProvision: true,
+			claims: map[string]any{}, wantUsername: "sub-1", wantRole: RoleViewer},
+		{name: "provisioning disabled", mapping: mapping,
+			claims: map[string]any{"preferred_username": "alyx", "groups": []any{"admins"}}, wantErr: ErrOIDCUnknownUser},
+		{name: "linked user promoted by groups", mapping: mapping, existingRole: "viewer",
+			claims: map[string]any{"groups": []any{"admins"}}, wantUsername: "existing", wantRole: RoleAdmin},
+		{name: "linked user demoted when groups go", mapping: mapping, existingRole: "admin",
+			claims: map[string]any{"groups": []any{}}, wantUsername: "existing", wantRole: RoleViewer},
+		{name: "linked user keeps role without a mapping", existingRole: "operator",
+			claims: map[string]any{"groups": []any{"admins"}}, wantUsername: "existing", wantRole: RoleOperator},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, _, db := newTestOIDC(t, tt.autoProvision, tt.mapping...)
+			if tt.existingRole != "" {
+				if _, err := db.CreateOIDCUser(ctx, "existing", issuer, "sub-1", tt.existingRole); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			user, err := o.resolveUser(ctx, issuer, "sub-1", tt.claims)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+				if n, _ := db.CountUsers(ctx); n != 0 {
+					t.Errorf("users = %d, want none provisioned", n)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.Username != tt.wantUsername || user.Role != string(tt.wantRole) {
+				t.Errorf("user = %s (%s), want %s (%s)", user.Username, user.Role, tt.wantUsername, tt.wantRole)
+			}
+
+			// The role change is stored, not just returned
+			stored, err := db.GetUserByOIDCSubject(ctx, issuer, "sub-1")
+			if err != nil || stored.Role != user.Role {
+				t.Errorf("stored user = %+v, %v; want role %s", stored, err, user.Role)
+			}
+		})
+	}
+}
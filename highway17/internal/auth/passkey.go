@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"citadel/highway17/internal/config"
+	"citadel/highway17/internal/database"
+	"citadel/highway17/internal/models"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// CeremonyTTL bounds how long a browser has to answer a WebAuthn prompt
+const CeremonyTTL = 5 * time.Minute
+
+var ErrCeremonyExpired = errors.New("passkey ceremony expired or unknown")
+
+// Passkeys runs WebAuthn registration and discoverable login ceremonies
+type Passkeys struct {
+	db database.Store
+	wa *webauthn.WebAuthn
+}
+
+func NewPasskeys(cfg *config.Config, db database.Store) (*Passkeys, error) {
+	wa, err := webauthn.New(&webauthn.Config{
+		RPID:          cfg.WebAuthnRPID,
+		RPDisplayName: cfg.WebAuthnRPName,
+		RPOrigins:     cfg.WebAuthnRPOrigins,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid WebAuthn configuration: %w", err)
+	}
+
+	return &Passkeys{db: db, wa: wa}, nil
+}
+
+// passkeyUser adapts a dashboard user to webauthn.User
+type passkeyUser struct {
+	user        *models.User
+	credentials []webauthn.Credential
+}
+
+func (u *passkeyUser) WebAuthnID() []byte                         { return userHandle(u.user.ID) }
+func (u *passkeyUser) WebAuthnName() string                       { return u.user.Username }
+func (u *passkeyUser) WebAuthnDisplayName() string                { return u.user.Username }
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential { return u.credentials }
+
+// BeginRegistration starts adding a passkey to the user's account
+func (p *Passkeys) BeginRegistration(ctx context.Context, user *models.User) (string, *protocol.CredentialCreation, error) {
+	pu, err := p.loadUser(ctx, user)
+	if err != nil {
+		return "", nil, err
+	}
+
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(pu.credentials))
+	for _, cred := range pu.credentials {
+		exclusions = append(exclusions, cred.Descriptor())
+	}
+
+	creation, session, err := p.wa.BeginRegistration(pu,
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+		webauthn.WithExclusions(exclusions),
+	)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to begin registration: %w", err)
+	}
+
+	token, err := p.saveCeremony(ctx, &user.ID, session)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, creation, nil
+}
+
+// FinishRegistration verifies the authenticator response and stores the credential
+func (p *Passkeys) FinishRegistration(ctx context.Context, user *models.User, token, name string, r *http.Request) error {
+	userID, session, err := p.takeCeremony(ctx, token)
+	if err != nil {
+		return err
+	}
+	if userID == nil || *userID != user.ID {
+		return ErrCeremonyExpired
+	}
+
+	pu, err := p.loadUser(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	credential, err := p.wa.FinishRegistration(pu, *session, r)
+	if err != nil {
+		return fmt.Errorf("failed to verify registration: %w", err)
+	}
+
+	credJSON, err := json.Marshal(credential)
+	if err != nil {
+		return fmt.Errorf("failed to encode credential: %w", err)
+	}
+
+	if name == "" {
+		name = fmt.Sprintf("Passkey %d", len(pu.credentials)+1)
+	}
+
+	return p.db.CreatePasskey(ctx, user.ID, name, credential.ID, string(credJSON))
+}
+
+// BeginLogin starts a username-less passkey login
+func (p *Passkeys) BeginLogin(ctx context.Context) (string, *protocol.CredentialAssertion, error) {
+	assertion, session, err := p.wa.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+	)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to begin login: %w", err)
+	}
+
+	token, err := p.saveCeremony(ctx, nil, session)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, assertion, nil
+}
+
+// FinishLogin verifies an assertion and returns the user it belongs to
+func (p *Passkeys) FinishLogin(ctx context.Context, token string, r *http.Request) (*models.User, error) {
+	_, session, err := p.takeCeremony(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	var matched *passkeyUser
+	handler := func(rawID, handle []byte) (webauthn.User, error) {
+		if len(handle) != 8 {
+			return nil, fmt.Errorf("unknown user handle")
+		}
+		user, err := p.db.GetUserByID(ctx, int(binary.BigEndian.Uint64(handle)))
+		if err != nil {
+			return nil, fmt.Errorf("unknown user handle: %w", err)
+		}
+		matched, err = p.loadUser(ctx, user)
+		return matched, err
+	}
+
+	credential, err := p.wa.FinishDiscoverableLogin(handler, *session, r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify assertion: %w", err)
+	}
+
+	credJSON, err := json.Marshal(credential)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode credential: %w", err)
+	}
+	if err := p.db.TouchPasskey(ctx, credential.ID, string(credJSON)); err != nil {
+		return nil, fmt.Errorf("failed to update credential: %w", err)
+	}
+
+	return matched.user, nil
+}
+
+func (p *Passkeys) loadUser(ctx context.Context, user *models.User) (*passkeyUser, error) {
+	passkeys, err := p.db.ListPasskeys(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load passkeys: %w", err)
+	}
+
+	pu := &passkeyUser{user: user}
+	for _, pk := range passkeys {
+		var cred webauthn.Credential
+		if err := json.Unmarshal([]byte(pk.CredentialJSON), &cred); err != nil {
+			return nil, fmt.Errorf("failed to decode passkey %d: %w", pk.ID, err)
+		}
+		pu.credentials = append(pu.credentials, cred)
+	}
+	return pu, nil
+}
+
+func (p *Passkeys) saveCeremony(ctx context.Context, userID *int, session *webauthn.SessionData) (string, error) {
+	sessionJSON, err := json.Marshal(session)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode ceremony: %w", err)
+	}
+
+	token := GenerateToken()
+	if err := p.db.SaveWebAuthnCeremony(ctx, token, userID, string(sessionJSON), CeremonyTTL); err != nil {
+		return "", fmt.Errorf("failed to save ceremony: %w", err)
+	}
+	return token, nil
+}
+
+func (p *Passkeys) takeCeremony(ctx context.Context, token string) (*int, *webauthn.SessionData, error) {
+	userID, sessionJSON, err := p.db.TakeWebAuthnCeremony(ctx, token)
+	if err != nil {
+		return nil, nil, ErrCeremonyExpired
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal([]byte(sessionJSON), &session); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode ceremony: %w", err)
+	}
+	return userID, &session, nil
+}
+
+// userHandle is the opaque WebAuthn user ID: the user's row ID, big-endian
+func userHandle(userID int) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(userID))
+	return b
+}
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"fmt"
+	"slices"
+
+	"citadel/highway17/internal/models"
+)
+
+// Role is a user's access level
+type Role string
+
+const (
+	RoleAdmin    Role = "admin"
+	RoleOperator Role = "operator"
+	RoleViewer   Role = "viewer"
+)
+
+// Roles lists every role from most to least privileged
+var Roles = []Role{RoleAdmin, RoleOperator, RoleViewer}
+
+// Permission is an action a route or widget requires
+type Permission string
+
+const (
+	PermDashboardView    Permission = "dashboard:view"
+	PermWidgetsRead      Permission = "widgets:read"
+	PermWidgetsWrite     Permission = "widgets:write"
+	PermSettingsWrite    Permission = "settings:write"
+	PermContainersManage Permission = "containers:manage"
+	PermAccount          Permission = "account:self"
+	PermProxyAccess      Permission = "proxy:access"
+	PermAdmin            Permission = "admin"
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleViewer: {
+		PermDashboardView, PermWidgetsRead, PermAccount, PermProxyAccess,
+	},
+	RoleOperator: {
+		PermDashboardView, PermWidgetsRead, PermAccount, PermProxyAccess,
+		PermWidgetsWrite, PermSettingsWrite, PermContainersManage,
+	},
+	RoleAdmin: {
+		PermDashboardView, PermWidgetsRead, PermAccount, PermProxyAccess,
+		PermWidgetsWrite, PermSettingsWrite, PermContainersManage,
+		PermAdmin,
+	},
+}
+
+// ParseRole validates a role name
+func ParseRole(name string) (Role, error) {
+	role := Role(name)
+	if _, ok := rolePermissions[role]; !ok {
+		return "", fmt.Errorf("unknown role %q (want admin, operator or viewer)", name)
+	}
+	return role, nil
+}
+
+// Can reports whether the role grants a permission
+func (r Role) Can(perm Permission) bool {
+	return slices.Contains(rolePermissions[r], perm)
+}
+
+// UserCan reports whether a user's role grants a permission
+func UserCan(user *models.User, perm Permission) bool {
+	return user != nil && Role(user.Role).Can(perm)
+}
@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"citadel/highway17/internal/config"
+	"citadel/highway17/internal/database"
+	"citadel/highway17/internal/models"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+const (
+	// SessionCookieName is the cookie that carries the session token
+	SessionCookieName = "session_token"
+
+	// touchInterval limits how often a session's last-seen time is written
+	touchInterval = time.Minute
+
+	maxUserAgentLength = 512
+)
+
+// Sessions issues and maintains login sessions. Each request slides the
+// expiry forward by the idle timeout, but never past the session's
+// absolute lifetime.
+type Sessions struct {
+	db          database.SessionStore
+	idleTimeout time.Duration
+	maxLifetime time.Duration
+	domain      string
+}
+
+func NewSessions(cfg *config.Config, db database.SessionStore) *Sessions {
+	return &Sessions{
+		db:          db,
+		idleTimeout: time.Duration(cfg.SessionIdleTimeout) * time.Second,
+		maxLifetime: time.Duration(cfg.SessionMaxLifetime) * time.Second,
+		domain:      cfg.SessionCookieDomain,
+	}
+}
+
+// Start creates a session for the user, recording the client's IP and user
+// agent, and sets the session cookie
+func (s *Sessions) Start(c echo.Context, userID int) (string, error) {
+	token := GenerateToken()
+
+	userAgent := c.Request().UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+
+	ctx := c.Request().Context()
+	if err := s.db.CreateSession(ctx, userID, token, c.RealIP(), userAgent, s.idleTimeout, s.maxLifetime); err != nil {
+		return "", fmt.Errorf("failed to save session: %w", err)
+	}
+
+	s.SetCookie(c, token)
+	return token, nil
+}
+
+// Touch records activity on a session and slides its expiry
+func (s *Sessions) Touch(ctx context.Context, session *models.Session, ip string) error {
+	if time.Since(session.LastSeenAt) < touchInterval && session.IPAddress == ip {
+		return nil
+	}
+	return s.db.TouchSession(ctx, session.ID, ip, s.idleTimeout)
+}
+
+// SetCookie sets the session token cookie. The browser keeps it for the
+// absolute lifetime; the server enforces the idle timeout. With a cookie
+// domain set, the cookie also reaches hosts protected by forward auth.
+func (s *Sessions) SetCookie(c echo.Context, token string) {
+	http.SetCookie(c.Response(), &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    token,
+		MaxAge:   int(s.maxLifetime.Seconds()),
+		Path:     "/",
+		Domain:   s.domain,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// ClearCookie expires the session token cookie
+func (s *Sessions) ClearCookie(c echo.Context) {
+	http.SetCookie(c.Response(), &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    "",
+		MaxAge:   -1,
+		Path:     "/",
+		Domain:   s.domain,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// RunSessionJanitor deletes expired sessions and abandoned login ceremonies
+// every interval until ctx is cancelled
+func RunSessionJanitor(ctx context.Context, db database.SessionStore, log *zap.Logger, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := db.PurgeExpiredSessions(ctx)
+		if err != nil {
+			log.Sugar().Errorw("failed to purge expired sessions", "error", err)
+		} else if purged > 0 {
+			log.Sugar().Infow("purged expired sessions", "count", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// GenerateToken creates a random 32-byte hex token
+func GenerateToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync/atomic"
+
+	"citadel/highway17/internal/database"
+)
+
+// MinPasswordLength applies to passwords chosen in the app
+const MinPasswordLength = 10
+
+// ErrSetupComplete is returned once the first admin exists
+var ErrSetupComplete = errors.New("setup has already been completed")
+
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,50}$`)
+
+// Setup guards the one-time creation of the first admin account. The wizard
+// is only offered while the users table is empty: an account created any
+// other way (h17ctl, LDAP, OIDC or Tailscale provisioning) closes it. Once
+// closed it never becomes pending again, even if every user is deleted.
+type Setup struct {
+	db   database.Store
+	done atomic.Bool
+}
+
+func NewSetup(db database.Store) *Setup {
+	return &Setup{db: db}
+}
+
+// Pending reports whether the setup wizard should still be offered
+func (s *Setup) Pending(ctx context.Context) (bool, error) {
+	if s.done.Load() {
+		return false, nil
+	}
+	done, err := s.db.IsSetupComplete(ctx)
+	if err != nil {
+		return false, err
+	}
+	if !done {
+		users, err := s.db.CountUsers(ctx)
+		if err != nil {
+			return false, err
+		}
+		if users > 0 {
+			if err := s.db.MarkSetupComplete(ctx); err != nil {
+				return false, err
+			}
+			done = true
+		}
+	}
+	if done {
+		s.done.Store(true)
+	}
+	return !done, nil
+}
+
+// Complete creates the first admin and closes the wizard for good
+func (s *Setup) Complete(ctx context.Context, username, password string) (int, error) {
+	if err := ValidateUsername(username); err != nil {
+		return 0, err
+	}
+	if err := ValidatePassword(password); err != nil {
+		return 0, err
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		return 0, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	id, created, err := s.db.CompleteSetup(ctx, username, hash)
+	if err != nil {
+		return 0, err
+	}
+	s.done.Store(true)
+	if !created {
+		return 0, ErrSetupComplete
+	}
+	return id, nil
+}
+
+// ValidateUsername checks a username chosen in the app
+func ValidateUsername(username string) error {
+	if !usernamePattern.MatchString(username) {
+		return fmt.Errorf("username must be 1-50 letters, digits, dots, dashes or underscores")
+	}
+	return nil
+}
+
+// ValidatePassword checks a password chosen in the app
+func ValidatePassword(password string) error {
+	if len(password) < MinPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image/png"
+	"strings"
+	"time"
+
+	"citadel/highway17/internal/config"
+	"citadel/highway17/internal/database"
+	"citadel/highway17/internal/models"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	// ChallengeTTL is how long a password-verified login may wait for its second factor
+	ChallengeTTL = 5 * time.Minute
+
+	// RecoveryCodeCount is how many one-time recovery codes enrollment issues
+	RecoveryCodeCount = 10
+
+	totpPeriod = 30
+	totpSkew   = 1
+)
+
+var (
+	ErrInvalidCode         = errors.New("invalid two-factor code")
+	ErrTwoFactorNotPending = errors.New("no two-factor enrollment in progress")
+)
+
+// Enrollment is what a user needs to add the account to an authenticator app
+type Enrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+	QRCode string `json:"qr_code"` // PNG data URI
+}
+
+// TwoFactor handles RFC 6238 TOTP enrollment and verification
+type TwoFactor struct {
+	db     database.TOTPStore
+	issuer string
+}
+
+func NewTwoFactor(cfg *config.Config, db database.TOTPStore) *TwoFactor {
+	return &TwoFactor{
+		db:     db,
+		issuer: cfg.TOTPIssuer,
+	}
+}
+
+// Begin generates a fresh secret and stores it as a pending enrollment
+func (tf *TwoFactor) Begin(ctx context.Context, user *models.User) (*Enrollment, error) {
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      tf.issuer,
+		AccountName: user.Username,
+		Period:      totpPeriod,
+		Digits:      otp.DigitsSix,
+		Algorithm:   otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate TOTP secret: %w", err)
+	}
+
+	img, err := key.Image(200, 200)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render QR code: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode QR code: %w", err)
+	}
+
+	if err := tf.db.SetPendingTOTPSecret(ctx, user.ID, key.Secret()); err != nil {
+		return nil, fmt.Errorf("failed to save TOTP secret: %w", err)
+	}
+
+	return &Enrollment{
+		Secret: key.Secret(),
+		URI:    key.URL(),
+		QRCode: "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()),
+	}, nil
+}
+
+// Confirm checks the first code against the pending secret, enables
+// two-factor and returns the plaintext recovery codes (shown only once)
+func (tf *TwoFactor) Confirm(ctx context.Context, user *models.User, code string) ([]string, error) {
+	if user.TOTPEnabled || user.TOTPSecret == "" {
+		return nil, ErrTwoFactorNotPending
+	}
+
+	step, ok := MatchTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	codes, hashes, err := GenerateRecoveryCodes(RecoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tf.db.EnableTOTP(ctx, user.ID, step, hashes); err != nil {
+		return nil, fmt.Errorf("failed to enable two-factor: %w", err)
+	}
+
+	return codes, nil
+}
+
+// Verify accepts a current TOTP code or an unused recovery code
+func (tf *TwoFactor) Verify(ctx context.Context, user *models.User, code string) error {
+	if !user.TOTPEnabled {
+		return ErrTwoFactorNotPending
+	}
+
+	if step, ok := MatchTOTP(user.TOTPSecret, code, time.Now()); ok {
+		// Each time step may only be used once
+		fresh, err := tf.db.AdvanceTOTPStep(ctx, user.ID, step)
+		if err != nil {
+			return fmt.Errorf("failed to record TOTP use: %w", err)
+		}
+		if !fresh {
+			return ErrInvalidCode
+		}
+		return nil
+	}
+
+	used, err := tf.db.UseRecoveryCode(ctx, user.ID, HashRecoveryCode(code))
+	if err != nil {
+		return fmt.Errorf("failed to check recovery code: %w", err)
+	}
+	if !used {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+// Disable turns two-factor off after checking a current code
+func (tf *TwoFactor) Disable(ctx context.Context, user *models.User, code string) error {
+	if err := tf.Verify(ctx, user, code); err != nil {
+		return err
+	}
+	return tf.db.DisableTOTP(ctx, user.ID)
+}
+
+// MatchTOTP checks a code against the current time step and one step either
+// side, returning the step it matched
+func MatchTOTP(secret, code string, now time.Time) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != int(otp.DigitsSix) || secret == "" {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for skew := int64(-totpSkew); skew <= totpSkew; skew++ {
+		step := current + skew
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(step*totpPeriod, 0), totp.ValidateOpts{
+			Period:    totpPeriod,
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// GenerateRecoveryCodes returns n codes like "abcde-fghij" and their hashes
+func GenerateRecoveryCodes(n int) ([]string, []string, error) {
+	codes := make([]string, 0, n)
+	hashes := make([]string, 0, n)
+
+	for range n {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		raw := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))[:10]
+		code := raw[:5] + "-" + raw[5:]
+
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode normalises a recovery code and returns its SHA-256 hex digest
+func HashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(code)))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
+
+	"citadel/highway17/internal/tailscale"
 )
 
 type Config struct {
@@ -16,11 +19,76 @@ type Config struct {
 	LogLevel string
 
 	// Tailscale
-	TailscaleEnabled bool
-	TailscaleAPIKey  string
+	TailscaleEnabled       bool
+	TailscaleAPIKey        string
+	TailscaleSocket        string
+	TailscaleAllowedUsers  []string
+	TailscaleAllowedTags   []string
+	TailscaleAutoProvision bool
 
 	// Auth
-	LoginPassword string
+	AuthPublicPaths []string
+	AuthBackends    []string
+	TOTPRequired    bool
+	TOTPIssuer      string
+
+	// Sessions
+	SessionIdleTimeout   int
+	SessionMaxLifetime   int
+	SessionPurgeInterval int
+	SessionCookieDomain  string
+
+	// Passkeys (WebAuthn)
+	WebAuthnRPID         string
+	WebAuthnRPName       string
+	WebAuthnRPOrigins    []string
+	PasswordLoginEnabled bool
+
+	// OpenID Connect single sign-on
+	OIDCIssuerURL     string
+	OIDCClientID      string
+	OIDCClientSecret  string
+	OIDCRedirectURL   string
+	OIDCScopes        []string
+	OIDCProviderName  string
+	OIDCUsernameClaim string
+	OIDCGroupsClaim   string
+	OIDCRoleMapping   []string
+	OIDCAutoProvision bool
+
+	// LDAP directory backend
+	LDAPURL                string
+	LDAPStartTLS           bool
+	LDAPInsecureSkipVerify bool
+	LDAPBindDN             string
+	LDAPBindPassword       string
+	LDAPUserDNTemplate     string
+	LDAPBaseDN             string
+	LDAPUserFilter         string
+	LDAPGroupFilter        string
+	LDAPGroupAttribute     string
+	LDAPRoleMapping        []string
+
+	// Login brute-force protection
+	LoginMaxAttempts   int
+	LoginLockoutBase   int
+	LoginLockoutMax    int
+	LoginFailureWindow int
+
+	// Network policy
+	NetworkPolicy        string
+	NetworkDefaultAction string
+	TrustedProxies       []string
+
+	// Cross-origin requests
+	CORSAllowedOrigins []string
+
+	// Audit log
+	AuditRetentionDays int
+
+	// Forward auth for services behind the reverse proxy
+	ForwardAuthRules    string
+	ForwardAuthLoginURL string
 
 	// Weather
 	WeatherLatitude  float64
@@ -34,29 +102,98 @@ type Config struct {
 	// Features
 	SystemStatsEnabled bool
 	UptimeEnabled      bool
+
+	// Storage mounts to report, as "path" or "path=warn%" entries; empty
+	// discovers every mounted block device
+	StorageMounts      []string
+	StorageWarnPercent float64
+
+	// System metrics history: how long raw samples, 1-minute rollups and
+	// hourly rollups are kept
+	MetricsEnabled    bool
+	MetricsRawHours   int
+	MetricsMinuteDays int
+	MetricsHourDays   int
 }
 
 func Load() (*Config, error) {
 	cfg := &Config{
-		DatabaseURL:         getEnv("DATABASE_URL", ""),
-		AppPort:             getEnvInt("APP_PORT", 8080),
-		AppEnv:              getEnv("APP_ENV", "development"),
-		LogLevel:            getEnv("LOG_LEVEL", "info"),
-		TailscaleEnabled:    getEnvBool("TAILSCALE_ENABLED", true),
-		TailscaleAPIKey:     getEnv("TAILSCALE_API_KEY", ""),
-		LoginPassword:       getEnv("LOGIN_PASSWORD", "checkpoint"),
-		WeatherLatitude:     getEnvFloat64("WEATHER_LATITUDE", 43.1629),   // Rochester, NY default
-		WeatherLongitude:    getEnvFloat64("WEATHER_LONGITUDE", -77.6099), // Rochester, NY default
-		WeatherCacheTTL:     getEnvInt("WEATHER_CACHE_TTL", 600),
-		StatsPollInterval:   getEnvInt("STATS_POLL_INTERVAL", 5),
-		WeatherPollInterval: getEnvInt("WEATHER_POLL_INTERVAL", 600),
-		SystemStatsEnabled:  getEnvBool("SYSTEM_STATS_ENABLED", true),
-		UptimeEnabled:       getEnvBool("UPTIME_ENABLED", true),
+		DatabaseURL:            getEnv("DATABASE_URL", ""),
+		AppPort:                getEnvInt("APP_PORT", 8080),
+		AppEnv:                 getEnv("APP_ENV", "development"),
+		LogLevel:               getEnv("LOG_LEVEL", "info"),
+		TailscaleEnabled:       getEnvBool("TAILSCALE_ENABLED", true),
+		TailscaleAPIKey:        getEnv("TAILSCALE_API_KEY", ""),
+		TailscaleSocket:        getEnv("TAILSCALE_SOCKET", tailscale.DefaultSocketPath),
+		TailscaleAllowedUsers:  getEnvList("TAILSCALE_ALLOWED_USERS", nil),
+		TailscaleAllowedTags:   getEnvList("TAILSCALE_ALLOWED_TAGS", nil),
+		TailscaleAutoProvision: getEnvBool("TAILSCALE_AUTO_PROVISION", false),
+		AuthPublicPaths:        getEnvList("AUTH_PUBLIC_PATHS", []string{"/health", "/setup", "/api/setup", "/login", "/api/login", "/api/login/2fa", "/api/passkeys/login/*", "/auth/oidc/*", "/static/*"}),
+		AuthBackends:           getEnvList("AUTH_BACKENDS", []string{"local"}),
+		SessionIdleTimeout:     getEnvInt("SESSION_IDLE_TIMEOUT", 86400),
+		SessionMaxLifetime:     getEnvInt("SESSION_MAX_LIFETIME", 30*86400),
+		SessionPurgeInterval:   getEnvInt("SESSION_PURGE_INTERVAL", 3600),
+		SessionCookieDomain:    getEnv("SESSION_COOKIE_DOMAIN", ""),
+		TOTPRequired:           getEnvBool("AUTH_REQUIRE_2FA", false),
+		TOTPIssuer:             getEnv("TOTP_ISSUER", "Highway 17"),
+		WebAuthnRPID:           getEnv("WEBAUTHN_RP_ID", "localhost"),
+		WebAuthnRPName:         getEnv("WEBAUTHN_RP_NAME", "Highway 17"),
+		WebAuthnRPOrigins:      getEnvList("WEBAUTHN_RP_ORIGINS", []string{"http://localhost:8080"}),
+		PasswordLoginEnabled:   getEnvBool("PASSWORD_LOGIN_ENABLED", true),
+		OIDCIssuerURL:          getEnv("OIDC_ISSUER_URL", ""),
+		OIDCClientID:           getEnv("OIDC_CLIENT_ID", ""),
+		OIDCClientSecret:       getEnv("OIDC_CLIENT_SECRET", ""),
+		OIDCRedirectURL:        getEnv("OIDC_REDIRECT_URL", "http://localhost:8080/auth/oidc/callback"),
+		OIDCScopes:             getEnvList("OIDC_SCOPES", []string{"openid", "profile", "email", "groups"}),
+		OIDCProviderName:       getEnv("OIDC_PROVIDER_NAME", "SSO"),
+		OIDCUsernameClaim:      getEnv("OIDC_USERNAME_CLAIM", "preferred_username"),
+		OIDCGroupsClaim:        getEnv("OIDC_GROUPS_CLAIM", "groups"),
+		OIDCRoleMapping:        getEnvList("OIDC_ROLE_MAPPING", nil),
+		OIDCAutoProvision:      getEnvBool("OIDC_AUTO_PROVISION", false),
+		LDAPURL:                getEnv("LDAP_URL", ""),
+		LDAPStartTLS:           getEnvBool("LDAP_START_TLS", false),
+		LDAPInsecureSkipVerify: getEnvBool("LDAP_INSECURE_SKIP_VERIFY", false),
+		LDAPBindDN:             getEnv("LDAP_BIND_DN", ""),
+		LDAPBindPassword:       getEnv("LDAP_BIND_PASSWORD", ""),
+		LDAPUserDNTemplate:     getEnv("LDAP_USER_DN_TEMPLATE", ""),
+		LDAPBaseDN:             getEnv("LDAP_BASE_DN", ""),
+		LDAPUserFilter:         getEnv("LDAP_USER_FILTER", "(&(objectClass=person)(uid={username}))"),
+		LDAPGroupFilter:        getEnv("LDAP_GROUP_FILTER", ""),
+		LDAPGroupAttribute:     getEnv("LDAP_GROUP_ATTRIBUTE", "cn"),
+		LDAPRoleMapping:        getEnvList("LDAP_ROLE_MAPPING", nil),
+		LoginMaxAttempts:       getEnvInt("LOGIN_MAX_ATTEMPTS", 5),
+		LoginLockoutBase:       getEnvInt("LOGIN_LOCKOUT_BASE", 60),
+		LoginLockoutMax:        getEnvInt("LOGIN_LOCKOUT_MAX", 3600),
+		LoginFailureWindow:     getEnvInt("LOGIN_FAILURE_WINDOW", 900),
+		NetworkPolicy:          getEnv("NETWORK_POLICY", ""),
+		NetworkDefaultAction:   getEnv("NETWORK_DEFAULT_ACTION", "allow"),
+		TrustedProxies:         getEnvList("TRUSTED_PROXIES", nil),
+		CORSAllowedOrigins:     getEnvList("CORS_ALLOWED_ORIGINS", nil),
+		AuditRetentionDays:     getEnvInt("AUDIT_RETENTION_DAYS", 365),
+		ForwardAuthRules:       getEnv("FORWARD_AUTH_RULES", ""),
+		ForwardAuthLoginURL:    getEnv("FORWARD_AUTH_LOGIN_URL", ""),
+		WeatherLatitude:        getEnvFloat64("WEATHER_LATITUDE", 43.1629),   // Rochester, NY default
+		WeatherLongitude:       getEnvFloat64("WEATHER_LONGITUDE", -77.6099), // Rochester, NY default
+		WeatherCacheTTL:        getEnvInt("WEATHER_CACHE_TTL", 600),
+		StatsPollInterval:      getEnvInt("STATS_POLL_INTERVAL", 5),
+		WeatherPollInterval:    getEnvInt("WEATHER_POLL_INTERVAL", 600),
+		SystemStatsEnabled:     getEnvBool("SYSTEM_STATS_ENABLED", true),
+		UptimeEnabled:          getEnvBool("UPTIME_ENABLED", true),
+		StorageMounts:          getEnvList("STORAGE_MOUNTS", nil),
+		StorageWarnPercent:     getEnvFloat64("STORAGE_WARN_PERCENT", 90),
+		MetricsEnabled:         getEnvBool("METRICS_ENABLED", true),
+		MetricsRawHours:        getEnvInt("METRICS_RAW_RETENTION_HOURS", 24),
+		MetricsMinuteDays:      getEnvInt("METRICS_MINUTE_RETENTION_DAYS", 30),
+		MetricsHourDays:        getEnvInt("METRICS_HOUR_RETENTION_DAYS", 365),
 	}
 
 	if cfg.DatabaseURL == "" {
 		return nil, fmt.Errorf("DATABASE_URL environment variable is required")
 	}
+	// Same range as a per-mount path=percent; written so NaN fails too
+	if !(cfg.StorageWarnPercent >= 1 && cfg.StorageWarnPercent <= 100) {
+		return nil, fmt.Errorf("STORAGE_WARN_PERCENT must be a percent from 1 to 100")
+	}
 
 	return cfg, nil
 }
@@ -91,3 +228,19 @@ func getEnvBool(key string, defaultVal bool) bool {
 	}
 	return valStr == "true" || valStr == "1" || valStr == "yes"
 }
+
+// getEnvList parses a comma-separated list, dropping empty entries
+func getEnvList(key string, defaultVal []string) []string {
+	valStr := getEnv(key, "")
+	if valStr == "" {
+		return defaultVal
+	}
+
+	var list []string
+	for _, item := range strings.Split(valStr, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,1151 @@
+// Package databasetest provides an in-memory implementation of the database
+// stores, so handlers and auth code can be exercised without Postgres.
+package databasetest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"citadel/highway17/internal/database"
+	"citadel/highway17/internal/models"
+)
+
+// defaultRole matches the users.role column default
+const defaultRole = "viewer"
+
+// Store keeps users, sessions, two-factor state, API tokens, passkeys,
+// WebAuthn and OIDC login state, login lockouts, widget data, settings,
+// audit events and metric history in maps.
+// It follows the Postgres implementation's semantics: unique usernames,
+// expiry, cascading deletes and not-found errors.
+type Store struct {
+	// Now is the clock used for timestamps and expiry; tests may replace it
+	Now func() time.Time
+
+	mu            sync.Mutex
+	nextID        int
+	users         map[int]*models.User
+	sessions      map[int]*models.Session
+	recoveryCodes map[int][]recoveryCode
+	challenges    map[string]loginChallenge
+	apiTokens     map[int]*apiToken
+	passkeys      map[int]*models.Passkey
+	ceremonies    map[string]ceremony
+	oidcStates    map[string]oidcState
+	lockouts      map[lockoutRef]*models.LoginLockout
+	setupComplete bool
+	widgets       map[widgetRef]string
+	settings      map[settingRef]string
+	auditEvents   []models.AuditEvent
+	samples       map[metricRef]models.MetricPoint
+	rollups       map[metricRef]models.MetricPoint
+}
+
+type recoveryCode struct {
+	hash string
+	used bool
+}
+
+type loginChallenge struct {
+	userID    int
+	expiresAt time.Time
+}
+
+// ceremony is a pending WebAuthn registration or login
+type ceremony struct {
+	userID      *int
+	sessionJSON string
+	expiresAt   time.Time
+}
+
+// oidcState is a pending authorization-code flow
+type oidcState struct {
+	models.OIDCState
+	expiresAt time.Time
+}
+
+// apiToken is a stored token with the hash it is looked up by
+type apiToken struct {
+	models.APIToken
+	hash string
+}
+
+type lockoutRef struct {
+	scope   string
+	subject string
+}
+
+type widgetRef struct {
+	userID int
+	name   string
+	key    string
+}
+
+type settingRef struct {
+	userID int
+	key    string
+}
+
+// metricRef keys a sample (resolution 0) or a rollup
+type metricRef struct {
+	resolution time.Duration
+	metric     string
+	at         time.Time
+}
+
+var _ database.Store = (*Store)(nil)
+
+// NewStore returns an empty store using the wall clock
+func NewStore() *Store {
+	return &Store{
+		Now:           time.Now,
+		users:         make(map[int]*models.User),
+		sessions:      make(map[int]*models.Session),
+		recoveryCodes: make(map[int][]recoveryCode),
+		challenges:    make(map[string]loginChallenge),
+		apiTokens:     make(map[int]*apiToken),
+		passkeys:      make(map[int]*models.Passkey),
+		ceremonies:    make(map[string]ceremony),
+		oidcStates:    make(map[string]oidcState),
+		lockouts:      make(map[lockoutRef]*models.LoginLockout),
+		widgets:       make(map[widgetRef]string),
+		settings:      make(map[settingRef]string),
+		samples:       make(map[metricRef]models.MetricPoint),
+		rollups:       make(map[metricRef]models.MetricPoint),
+	}
+}
+
+func (s *Store) id() int {
+	s.nextID++
+	return s.nextID
+}
+
+// User queries
+
+func (s *Store) findUser(match func(u *models.User) bool) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if match(u) {
+			user := *u
+			return &user, nil
+		}
+	}
+	return nil, database.ErrUserNotFound
+}
+
+func (s *Store) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	return s.findUser(func(u *models.User) bool { return u.Username == username })
+}
+
+func (s *Store) GetUserByID(ctx context.Context, id int) (*models.User, error) {
+	return s.findUser(func(u *models.User) bool { return u.ID == id })
+}
+
+func (s *Store) GetUserByTailscaleLogin(ctx context.Context, login string) (*models.User, error) {
+	return s.findUser(func(u *models.User) bool { return u.TailscaleLogin != "" && strings.EqualFold(u.TailscaleLogin, login) })
+}
+
+func (s *Store) GetUserByTailscaleIP(ctx context.Context, ip string) (*models.User, error) {
+	return s.findUser(func(u *models.User) bool { return u.TailscaleIP != "" && u.TailscaleIP == ip })
+}
+
+func (s *Store) GetUserByOIDCSubject(ctx context.Context, issuer, subject string) (*models.User, error) {
+	return s.findUser(func(u *models.User) bool {
+		return u.OIDCSubject != "" && u.OIDCIssuer == issuer && u.OIDCSubject == subject
+	})
+}
+
+// insertUser adds a user, enforcing the unique username constraint
+func (s *Store) insertUser(user models.User) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if u.Username == user.Username {
+			return nil, fmt.Errorf("duplicate key value violates unique constraint: username %q", user.Username)
+		}
+	}
+
+	now := s.Now()
+	user.ID = s.id()
+	user.CreatedAt, user.UpdatedAt = now, now
+	if user.Role == "" {
+		user.Role = defaultRole
+	}
+	s.users[user.ID] = &user
+
+	created := user
+	return &created, nil
+}
+
+func (s *Store) CreateUser(ctx context.Context, username, passwordHash, role string, mustChangePassword bool) (int, error) {
+	user, err := s.insertUser(models.User{Username: username, PasswordHash: passwordHash, Role: role, MustChangePassword: mustChangePassword, AuthBackend: models.AuthBackendLocal})
+	if err != nil {
+		return 0, err
+	}
+	return user.ID, nil
+}
+
+func (s *Store) CreateTailscaleUser(ctx context.Context, username, login string) (int, error) {
+	user, err := s.insertUser(models.User{Username: username, TailscaleLogin: login, AuthBackend: models.AuthBackendTailscale})
+	if err != nil {
+		return 0, err
+	}
+	return user.ID, nil
+}
+
+func (s *Store) CreateOIDCUser(ctx context.Context, username, issuer, subject, role string) (int, error) {
+	user, err := s.insertUser(models.User{Username: username, OIDCIssuer: issuer, OIDCSubject: subject, Role: role, AuthBackend: models.AuthBackendOIDC})
+	if err != nil {
+		return 0, err
+	}
+	return user.ID, nil
+}
+
+func (s *Store) UpsertDirectoryUser(ctx context.Context, backend, username, role string, syncRole bool) (*models.User, error) {
+	s.mu.Lock()
+	for _, u := range s.users {
+		if u.Username == username {
+			if u.AuthBackend != backend {
+				s.mu.Unlock()
+				return nil, database.ErrUserConflict
+			}
+			if syncRole {
+				u.Role = role
+			}
+			u.UpdatedAt = s.Now()
+			user := *u
+			s.mu.Unlock()
+			return &user, nil
+		}
+	}
+	s.mu.Unlock()
+
+	return s.insertUser(models.User{Username: username, Role: role, AuthBackend: backend})
+}
+
+// updateUser applies fn to a user, reporting whether it exists
+func (s *Store) updateUser(userID int, fn func(u *models.User)) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return false
+	}
+	fn(u)
+	u.UpdatedAt = s.Now()
+	return true
+}
+
+// SetTailscaleIP pins a user to a tailnet address. No query writes the
+// column; administrators set it by hand.
+func (s *Store) SetTailscaleIP(userID int, ip string) {
+	s.updateUser(userID, func(u *models.User) { u.TailscaleIP = ip })
+}
+
+func (s *Store) LinkOIDCIdentity(ctx context.Context, userID int, issuer, subject string) error {
+	s.updateUser(userID, func(u *models.User) { u.OIDCIssuer, u.OIDCSubject = issuer, subject })
+	return nil
+}
+
+func (s *Store) SetPassword(ctx context.Context, userID int, passwordHash string, mustChangePassword bool) error {
+	s.updateUser(userID, func(u *models.User) { u.PasswordHash, u.MustChangePassword = passwordHash, mustChangePassword })
+	return nil
+}
+
+func (s *Store) SetUserRole(ctx context.Context, userID int, role string) (bool, error) {
+	return s.updateUser(userID, func(u *models.User) { u.Role = role }), nil
+}
+
+// DeleteUser removes an account along with everything that references it
+func (s *Store) DeleteUser(ctx context.Context, userID int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[userID]; !ok {
+		return false, nil
+	}
+	delete(s.users, userID)
+	delete(s.recoveryCodes, userID)
+	for id, session := range s.sessions {
+		if session.UserID == userID {
+			delete(s.sessions, id)
+		}
+	}
+	for token, challenge := range s.challenges {
+		if challenge.userID == userID {
+			delete(s.challenges, token)
+		}
+	}
+	for id, token := range s.apiTokens {
+		if token.UserID == userID {
+			delete(s.apiTokens, id)
+		}
+	}
+	for id, p := range s.passkeys {
+		if p.UserID == userID {
+			delete(s.passkeys, id)
+		}
+	}
+	for token, c := range s.ceremonies {
+		if c.userID != nil && *c.userID == userID {
+			delete(s.ceremonies, token)
+		}
+	}
+	for state, st := range s.oidcStates {
+		if st.UserID != nil && *st.UserID == userID {
+			delete(s.oidcStates, state)
+		}
+	}
+	for k := range s.widgets {
+		if k.userID == userID {
+			delete(s.widgets, k)
+		}
+	}
+	for k := range s.settings {
+		if k.userID == userID {
+			delete(s.settings, k)
+		}
+	}
+	for i := range s.auditEvents {
+		if id := s.auditEvents[i].ActorID; id != nil && *id == userID {
+			s.auditEvents[i].ActorID = nil
+		}
+	}
+	return true, nil
+}
+
+func (s *Store) CountUsers(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.users), nil
+}
+
+func (s *Store) ListUsers(ctx context.Context) ([]models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := []models.User{}
+	for _, u := range s.users {
+		users = append(users, *u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+// Session queries
+
+func (s *Store) CreateSession(ctx context.Context, userID int, token, ip, userAgent string, idle, maxLifetime time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[userID]; !ok {
+		return fmt.Errorf("insert or update on table sessions violates foreign key constraint: user %d", userID)
+	}
+
+	now := s.Now()
+	absolute := now.Add(maxLifetime)
+	session := &models.Session{
+		ID:                s.id(),
+		UserID:            userID,
+		Token:             token,
+		ExpiresAt:         now.Add(min(idle, maxLifetime)),
+		AbsoluteExpiresAt: &absolute,
+		CreatedAt:         now,
+		LastSeenAt:        now,
+		IPAddress:         ip,
+		UserAgent:         userAgent,
+	}
+	s.sessions[session.ID] = session
+	return nil
+}
+
+func (s *Store) GetSessionByToken(ctx context.Context, token string) (*models.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.Now()
+	for _, session := range s.sessions {
+		if session.Token == token && session.ExpiresAt.After(now) {
+			found := *session
+			return &found, nil
+		}
+	}
+	return nil, database.ErrSessionNotFound
+}
+
+func (s *Store) TouchSession(ctx context.Context, id int, ip string, idle time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil
+	}
+	now := s.Now()
+	session.LastSeenAt = now
+	session.IPAddress = ip
+	session.ExpiresAt = now.Add(idle)
+	if session.AbsoluteExpiresAt != nil && session.AbsoluteExpiresAt.Before(session.ExpiresAt) {
+		session.ExpiresAt = *session.AbsoluteExpiresAt
+	}
+	return nil
+}
+
+func (s *Store) ListSessions(ctx context.Context, userID int) ([]models.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.Now()
+	sessions := []models.Session{}
+	for _, session := range s.sessions {
+		if session.UserID == userID && session.ExpiresAt.After(now) {
+			sessions = append(sessions, *session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt) })
+	return sessions, nil
+}
+
+func (s *Store) DeleteSession(ctx context.Context, token string) error {
+	s.deleteSessions(func(session *models.Session) bool { return session.Token == token })
+	return nil
+}
+
+func (s *Store) DeleteUserSession(ctx context.Context, userID, id int) (bool, error) {
+	n := s.deleteSessions(func(session *models.Session) bool { return session.ID == id && session.UserID == userID })
+	return n > 0, nil
+}
+
+func (s *Store) DeleteUserSessions(ctx context.Context, userID int, keepToken string) (int64, error) {
+	return s.deleteSessions(func(session *models.Session) bool { return session.UserID == userID && session.Token != keepToken }), nil
+}
+
+func (s *Store) PurgeExpiredSessions(ctx context.Context) (int64, error) {
+	now := s.Now()
+	purged := s.deleteSessions(func(session *models.Session) bool { return session.ExpiresAt.Before(now) })
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for token, challenge := range s.challenges {
+		if challenge.expiresAt.Before(now) {
+			delete(s.challenges, token)
+		}
+	}
+	for token, c := range s.ceremonies {
+		if c.expiresAt.Before(now) {
+			delete(s.ceremonies, token)
+		}
+	}
+	for state, st := range s.oidcStates {
+		if st.expiresAt.Before(now) {
+			delete(s.oidcStates, state)
+		}
+	}
+	return purged, nil
+}
+
+// deleteSessions removes every session match selects and returns how many
+func (s *Store) deleteSessions(match func(session *models.Session) bool) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for id, session := range s.sessions {
+		if match(session) {
+			delete(s.sessions, id)
+			n++
+		}
+	}
+	return n
+}
+
+// Two-factor queries
+
+func (s *Store) SetPendingTOTPSecret(ctx context.Context, userID int, secret string) error {
+	s.updateUser(userID, func(u *models.User) { u.TOTPSecret, u.TOTPEnabled, u.TOTPLastStep = secret, false, 0 })
+	return nil
+}
+
+func (s *Store) EnableTOTP(ctx context.Context, userID int, step int64, recoveryCodeHashes []string) error {
+	if !s.updateUser(userID, func(u *models.User) { u.TOTPEnabled, u.TOTPLastStep = true, step }) {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	codes := make([]recoveryCode, 0, len(recoveryCodeHashes))
+	for _, hash := range recoveryCodeHashes {
+		codes = append(codes, recoveryCode{hash: hash})
+	}
+	s.recoveryCodes[userID] = codes
+	return nil
+}
+
+func (s *Store) DisableTOTP(ctx context.Context, userID int) error {
+	s.updateUser(userID, func(u *models.User) { u.TOTPSecret, u.TOTPEnabled, u.TOTPLastStep = "", false, 0 })
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.recoveryCodes, userID)
+	return nil
+}
+
+func (s *Store) AdvanceTOTPStep(ctx context.Context, userID int, step int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[userID]
+	if !ok || u.TOTPLastStep >= step {
+		return false, nil
+	}
+	u.TOTPLastStep = step
+	return true, nil
+}
+
+func (s *Store) UseRecoveryCode(ctx context.Context, userID int, codeHash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	codes := s.recoveryCodes[userID]
+	for i := range codes {
+		if codes[i].hash == codeHash && !codes[i].used {
+			codes[i].used = true
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *Store) CountRecoveryCodes(ctx context.Context, userID int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var count int
+	for _, code := range s.recoveryCodes[userID] {
+		if !code.used {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *Store) CreateLoginChallenge(ctx context.Context, userID int, token string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.challenges[token]; ok {
+		return fmt.Errorf("duplicate key value violates unique constraint: login challenge")
+	}
+	s.challenges[token] = loginChallenge{userID: userID, expiresAt: s.Now().Add(ttl)}
+	return nil
+}
+
+func (s *Store) GetLoginChallenge(ctx context.Context, token string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	challenge, ok := s.challenges[token]
+	if !ok || !challenge.expiresAt.After(s.Now()) {
+		return 0, database.ErrNotFound
+	}
+	return challenge.userID, nil
+}
+
+// DeleteLoginChallenge removes a challenge along with any that expired
+func (s *Store) DeleteLoginChallenge(ctx context.Context, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.Now()
+	for t, challenge := range s.challenges {
+		if t == token || challenge.expiresAt.Before(now) {
+			delete(s.challenges, t)
+		}
+	}
+	return nil
+}
+
+// Passkey queries
+
+func (s *Store) ListPasskeys(ctx context.Context, userID int) ([]models.Passkey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	passkeys := []models.Passkey{}
+	for _, p := range s.passkeys {
+		if p.UserID == userID {
+			passkeys = append(passkeys, *p)
+		}
+	}
+	sort.Slice(passkeys, func(i, j int) bool {
+		if !passkeys[i].CreatedAt.Equal(passkeys[j].CreatedAt) {
+			return passkeys[i].CreatedAt.Before(passkeys[j].CreatedAt)
+		}
+		return passkeys[i].ID < passkeys[j].ID
+	})
+	return passkeys, nil
+}
+
+func (s *Store) CreatePasskey(ctx context.Context, userID int, name string, credentialID []byte, credentialJSON string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[userID]; !ok {
+		return fmt.Errorf("insert or update on table webauthn_credentials violates foreign key constraint: user %d", userID)
+	}
+	for _, p := range s.passkeys {
+		if bytes.Equal(p.CredentialID, credentialID) {
+			return fmt.Errorf("duplicate key value violates unique constraint: credential id")
+		}
+	}
+
+	p := &models.Passkey{
+		ID:             s.id(),
+		UserID:         userID,
+		Name:           name,
+		CredentialID:   bytes.Clone(credentialID),
+		CredentialJSON: credentialJSON,
+		CreatedAt:      s.Now(),
+	}
+	s.passkeys[p.ID] = p
+	return nil
+}
+
+func (s *Store) TouchPasskey(ctx context.Context, credentialID []byte, credentialJSON string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, p := range s.passkeys {
+		if bytes.Equal(p.CredentialID, credentialID) {
+			now := s.Now()
+			p.CredentialJSON, p.LastUsedAt = credentialJSON, &now
+		}
+	}
+	return nil
+}
+
+func (s *Store) RenamePasskey(ctx context.Context, userID, id int, name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.passkeys[id]
+	if !ok || p.UserID != userID {
+		return false, nil
+	}
+	p.Name = name
+	return true, nil
+}
+
+func (s *Store) DeletePasskey(ctx context.Context, userID, id int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.passkeys[id]
+	if !ok || p.UserID != userID {
+		return false, nil
+	}
+	delete(s.passkeys, id)
+	return true, nil
+}
+
+func (s *Store) SaveWebAuthnCeremony(ctx context.Context, token string, userID *int, sessionJSON string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.ceremonies[token]; ok {
+		return fmt.Errorf("duplicate key value violates unique constraint: webauthn ceremony")
+	}
+	s.ceremonies[token] = ceremony{userID: copyID(userID), sessionJSON: sessionJSON, expiresAt: s.Now().Add(ttl)}
+	return nil
+}
+
+func (s *Store) TakeWebAuthnCeremony(ctx context.Context, token string) (*int, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.ceremonies[token]
+	if !ok || !c.expiresAt.After(s.Now()) {
+		return nil, "", database.ErrNotFound
+	}
+	delete(s.ceremonies, token)
+	return copyID(c.userID), c.sessionJSON, nil
+}
+
+// OIDC queries
+
+func (s *Store) SaveOIDCState(ctx context.Context, state, nonce, codeVerifier string, userID *int, returnTo string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.oidcStates[state]; ok {
+		return fmt.Errorf("duplicate key value violates unique constraint: oidc state")
+	}
+	s.oidcStates[state] = oidcState{
+		OIDCState: models.OIDCState{Nonce: nonce, CodeVerifier: codeVerifier, UserID: copyID(userID), ReturnTo: returnTo},
+		expiresAt: s.Now().Add(ttl),
+	}
+	return nil
+}
+
+func (s *Store) TakeOIDCState(ctx context.Context, state string) (*models.OIDCState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.oidcStates[state]
+	if !ok || !st.expiresAt.After(s.Now()) {
+		return nil, database.ErrNotFound
+	}
+	delete(s.oidcStates, state)
+	pending := st.OIDCState
+	pending.UserID = copyID(st.UserID)
+	return &pending, nil
+}
+
+// copyID keeps callers from sharing a stored *int
+func copyID(id *int) *int {
+	if id == nil {
+		return nil
+	}
+	v := *id
+	return &v
+}
+
+// API token queries
+
+func (s *Store) CreateAPIToken(ctx context.Context, userID int, name, tokenHash, prefix string, scopes []string, ttl time.Duration) (*models.APIToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[userID]; !ok {
+		return nil, fmt.Errorf("insert or update on table api_tokens violates foreign key constraint: user %d", userID)
+	}
+	for _, t := range s.apiTokens {
+		if t.hash == tokenHash {
+			return nil, fmt.Errorf("duplicate key value violates unique constraint: api token hash")
+		}
+	}
+
+	now := s.Now()
+	token := &apiToken{
+		APIToken: models.APIToken{
+			ID:        s.id(),
+			UserID:    userID,
+			Name:      name,
+			Prefix:    prefix,
+			Scopes:    append([]string{}, scopes...),
+			CreatedAt: now,
+		},
+		hash: tokenHash,
+	}
+	if ttl > 0 {
+		expires := now.Add(ttl)
+		token.ExpiresAt = &expires
+	}
+	s.apiTokens[token.ID] = token
+
+	created := token.APIToken
+	return &created, nil
+}
+
+func (s *Store) GetAPITokenByHash(ctx context.Context, tokenHash string) (*models.APIToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.Now()
+	for _, t := range s.apiTokens {
+		if t.hash == tokenHash && (t.ExpiresAt == nil || t.ExpiresAt.After(now)) {
+			found := t.APIToken
+			return &found, nil
+		}
+	}
+	return nil, database.ErrAPITokenNotFound
+}
+
+// TouchAPIToken records use, writing at most once a minute per token
+func (s *Store) TouchAPIToken(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.apiTokens[id]
+	if !ok {
+		return nil
+	}
+	now := s.Now()
+	if t.LastUsedAt == nil || t.LastUsedAt.Before(now.Add(-time.Minute)) {
+		t.LastUsedAt = &now
+	}
+	return nil
+}
+
+func (s *Store) ListAPITokens(ctx context.Context, userID int) ([]models.APIToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens := []models.APIToken{}
+	for _, t := range s.apiTokens {
+		if t.UserID == userID {
+			tokens = append(tokens, t.APIToken)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		if !tokens[i].CreatedAt.Equal(tokens[j].CreatedAt) {
+			return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+		}
+		return tokens[i].ID < tokens[j].ID
+	})
+	return tokens, nil
+}
+
+func (s *Store) DeleteAPIToken(ctx context.Context, userID, id int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.apiTokens[id]
+	if !ok || t.UserID != userID {
+		return false, nil
+	}
+	delete(s.apiTokens, id)
+	return true, nil
+}
+
+// Login lockout queries
+
+// RecordLoginFailure bumps the failure counter, restarting it when the
+// previous failure is older than window
+func (s *Store) RecordLoginFailure(ctx context.Context, scope, subject string, window time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.Now()
+	ref := lockoutRef{scope, subject}
+	l, ok := s.lockouts[ref]
+	switch {
+	case !ok:
+		l = &models.LoginLockout{ID: s.id(), Scope: scope, Subject: subject, Failures: 1}
+		s.lockouts[ref] = l
+	case l.LastFailureAt.Before(now.Add(-window)):
+		l.Failures = 1
+	default:
+		l.Failures++
+	}
+	l.LastFailureAt = now
+	return l.Failures, nil
+}
+
+func (s *Store) LockLogin(ctx context.Context, scope, subject string, duration time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if l, ok := s.lockouts[lockoutRef{scope, subject}]; ok {
+		until := s.Now().Add(duration)
+		l.LockedUntil = &until
+	}
+	return nil
+}
+
+func (s *Store) LoginLockedFor(ctx context.Context, scope, subject string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l, ok := s.lockouts[lockoutRef{scope, subject}]
+	if !ok || l.LockedUntil == nil {
+		return 0, nil
+	}
+	return max(l.LockedUntil.Sub(s.Now()), 0), nil
+}
+
+func (s *Store) ClearLoginFailures(ctx context.Context, scope, subject string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.lockouts, lockoutRef{scope, subject})
+	return nil
+}
+
+func (s *Store) ListLoginLockouts(ctx context.Context) ([]models.LoginLockout, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.Now()
+	lockouts := []models.LoginLockout{}
+	for _, l := range s.lockouts {
+		lockout := *l
+		lockout.Locked = l.LockedUntil != nil && l.LockedUntil.After(now)
+		lockouts = append(lockouts, lockout)
+	}
+	sort.Slice(lockouts, func(i, j int) bool { return lockouts[i].LastFailureAt.After(lockouts[j].LastFailureAt) })
+	return lockouts, nil
+}
+
+func (s *Store) DeleteLoginLockout(ctx context.Context, id int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for ref, l := range s.lockouts {
+		if l.ID == id {
+			delete(s.lockouts, ref)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *Store) DeleteAllLoginLockouts(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := int64(len(s.lockouts))
+	clear(s.lockouts)
+	return n, nil
+}
+
+// Widget data queries
+
+func (s *Store) SaveWidgetData(ctx context.Context, userID int, widgetName, widgetKey, valueJSON string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.widgets[widgetRef{userID, widgetName, widgetKey}] = valueJSON
+	return nil
+}
+
+func (s *Store) GetWidgetData(ctx context.Context, userID int, widgetName, widgetKey string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, ok := s.widgets[widgetRef{userID, widgetName, widgetKey}]
+	if !ok {
+		return "", database.ErrWidgetDataNotFound
+	}
+	return value, nil
+}
+
+// Setup queries
+
+func (s *Store) IsSetupComplete(ctx context.Context) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.setupComplete, nil
+}
+
+func (s *Store) MarkSetupComplete(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setupComplete = true
+	return nil
+}
+
+// CompleteSetup marks setup as done and creates the first admin, unless
+// setup already ran or any user exists
+func (s *Store) CompleteSetup(ctx context.Context, username, passwordHash string) (int, bool, error) {
+	s.mu.Lock()
+	if s.setupComplete {
+		s.mu.Unlock()
+		return 0, false, nil
+	}
+	s.setupComplete = true
+	hasUsers := len(s.users) > 0
+	s.mu.Unlock()
+
+	if hasUsers {
+		return 0, false, nil
+	}
+	user, err := s.insertUser(models.User{Username: username, PasswordHash: passwordHash, Role: "admin", AuthBackend: models.AuthBackendLocal})
+	if err != nil {
+		// The insert failing rolls back the setup flag with it
+		s.mu.Lock()
+		s.setupComplete = false
+		s.mu.Unlock()
+		return 0, false, err
+	}
+	return user.ID, true, nil
+}
+
+// Settings queries
+
+func (s *Store) SaveSetting(ctx context.Context, userID int, key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.settings[settingRef{userID, key}] = value
+	return nil
+}
+
+func (s *Store) GetSetting(ctx context.Context, userID int, key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, ok := s.settings[settingRef{userID, key}]
+	if !ok {
+		return "", database.ErrSettingNotFound
+	}
+	return value, nil
+}
+
+// Audit queries
+
+func (s *Store) InsertAuditEvent(ctx context.Context, e *models.AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	event := *e
+	event.ID = int64(s.id())
+	event.OccurredAt = s.Now()
+	s.auditEvents = append(s.auditEvents, event)
+	return nil
+}
+
+// ListAuditEvents returns matching events, newest first
+func (s *Store) ListAuditEvents(ctx context.Context, f database.AuditFilter) ([]models.AuditEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events := []models.AuditEvent{}
+	for i := len(s.auditEvents) - 1; i >= 0 && len(events) < f.Limit; i-- {
+		e := s.auditEvents[i]
+		switch {
+		case f.Action != "" && !strings.HasPrefix(e.Action, f.Action),
+			f.Actor != "" && !strings.EqualFold(e.ActorName, f.Actor),
+			f.Outcome != "" && e.Outcome != f.Outcome,
+			f.TargetType != "" && e.TargetType != f.TargetType,
+			f.TargetID != "" && e.TargetID != f.TargetID,
+			f.ClientIP != "" && e.ClientIP != f.ClientIP,
+			!f.Since.IsZero() && e.OccurredAt.Before(f.Since),
+			!f.Until.IsZero() && !e.OccurredAt.Before(f.Until),
+			f.BeforeID > 0 && e.ID >= f.BeforeID:
+			continue
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+func (s *Store) PurgeAuditEvents(ctx context.Context, maxAge time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := s.Now().Add(-maxAge)
+	kept := s.auditEvents[:0]
+	for _, e := range s.auditEvents {
+		if !e.OccurredAt.Before(cutoff) {
+			kept = append(kept, e)
+		}
+	}
+	purged := int64(len(s.auditEvents) - len(kept))
+	s.auditEvents = kept
+	return purged, nil
+}
+
+// AuditEvents returns the recorded audit events, oldest first
+func (s *Store) AuditEvents() []models.AuditEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]models.AuditEvent(nil), s.auditEvents...)
+}
+
+// Metric queries
+
+func (s *Store) InsertMetricSamples(ctx context.Context, at time.Time, values map[string]float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	at = at.UTC().Truncate(time.Second)
+	for name, v := range values {
+		ref := metricRef{metric: name, at: at}
+		if _, ok := s.samples[ref]; !ok {
+			s.samples[ref] = models.MetricPoint{Metric: name, Time: at, Avg: v, Min: v, Max: v, Count: 1}
+		}
+	}
+	return nil
+}
+
+func (s *Store) UpsertMetricRollups(ctx context.Context, resolution time.Duration, points []models.MetricPoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, p := range points {
+		p.Time = p.Time.UTC()
+		s.rollups[metricRef{resolution, p.Metric, p.Time}] = p
+	}
+	return nil
+}
+
+func (s *Store) ListMetricPoints(ctx context.Context, resolution time.Duration, metric string, from, to time.Time) ([]models.MetricPoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var points []models.MetricPoint
+	for ref, p := range s.metricTier(resolution) {
+		if ref.resolution == resolution && (metric == "" || ref.metric == metric) && !p.Time.Before(from) && p.Time.Before(to) {
+			points = append(points, p)
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if !points[i].Time.Equal(points[j].Time) {
+			return points[i].Time.Before(points[j].Time)
+		}
+		return points[i].Metric < points[j].Metric
+	})
+	return points, nil
+}
+
+func (s *Store) LatestMetricRollup(ctx context.Context, resolution time.Duration) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var latest time.Time
+	for ref := range s.rollups {
+		if ref.resolution == resolution && ref.at.After(latest) {
+			latest = ref.at
+		}
+	}
+	return latest, nil
+}
+
+func (s *Store) PurgeMetricPoints(ctx context.Context, resolution time.Duration, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var purged int64
+	tier := s.metricTier(resolution)
+	for ref := range tier {
+		if ref.resolution == resolution && ref.at.Before(before) {
+			delete(tier, ref)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (s *Store) ListMetricNames(ctx context.Context) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seen := make(map[string]bool)
+	var names []string
+	for _, tier := range []map[metricRef]models.MetricPoint{s.samples, s.rollups} {
+		for ref := range tier {
+			if !seen[ref.metric] {
+				seen[ref.metric] = true
+				names = append(names, ref.metric)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// metricTier returns the map holding points at resolution
+func (s *Store) metricTier(resolution time.Duration) map[metricRef]models.MetricPoint {
+	if resolution == 0 {
+		return s.samples
+	}
+	return s.rollups
+}
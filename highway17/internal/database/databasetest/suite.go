@@ -0,0 +1,822 @@
+package databasetest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"citadel/highway17/internal/database"
+	"citadel/highway17/internal/models"
+)
+
+// TestStore checks that an empty store behaves like the Postgres reference:
+// the same suite runs against Postgres, SQLite and the in-memory Store. It
+// returns every mismatch found, or nil. The store is left with data in it.
+//
+//	db, err := database.New(ctx, "sqlite::memory:")
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	if _, err := db.Migrate(ctx); err != nil {
+//		t.Fatal(err)
+//	}
+//	if err := databasetest.TestStore(ctx, db); err != nil {
+//		t.Fatal(err)
+//	}
+func TestStore(ctx context.Context, store database.Store) error {
+	c := &checker{}
+	testSetup(ctx, c, store)
+	testUsers(ctx, c, store)
+	testSessions(ctx, c, store)
+	testTwoFactor(ctx, c, store)
+	testAPITokens(ctx, c, store)
+	testPasskeys(ctx, c, store)
+	testLoginState(ctx, c, store)
+	testLockouts(ctx, c, store)
+	testWidgets(ctx, c, store)
+	testSettings(ctx, c, store)
+	testAudit(ctx, c, store)
+	testMetrics(ctx, c, store)
+	testCascade(ctx, c, store)
+	return errors.Join(c.errs...)
+}
+
+type checker struct {
+	errs []error
+}
+
+func (c *checker) errorf(format string, args ...any) {
+	c.errs = append(c.errs, fmt.Errorf(format, args...))
+}
+
+// ok records an unexpected error and reports whether there was none
+func (c *checker) ok(what string, err error) bool {
+	if err != nil {
+		c.errorf("%s: %v", what, err)
+		return false
+	}
+	return true
+}
+
+func (c *checker) notFound(what string, err, want error) {
+	if !errors.Is(err, want) || !errors.Is(err, database.ErrNotFound) {
+		c.errorf("%s: got error %v, want %v", what, err, want)
+	}
+}
+
+// testSetup creates the first admin, then deletes it so the other checks
+// start from an empty users table
+func testSetup(ctx context.Context, c *checker, s database.Store) {
+	if done, err := s.IsSetupComplete(ctx); c.ok("IsSetupComplete", err) && done {
+		c.errorf("IsSetupComplete on an empty store = true")
+	}
+
+	id, created, err := s.CompleteSetup(ctx, "root", "hash-root")
+	if !c.ok("CompleteSetup", err) {
+		return
+	}
+	if !created || id == 0 {
+		c.errorf("CompleteSetup = %d, %v; want a new user", id, created)
+		return
+	}
+	if root, err := s.GetUserByID(ctx, id); c.ok("GetUserByID(root)", err) && (root.Username != "root" || root.Role != "admin" || root.PasswordHash != "hash-root") {
+		c.errorf("CompleteSetup created %+v", root)
+	}
+
+	if again, created, err := s.CompleteSetup(ctx, "root2", "hash"); c.ok("CompleteSetup(again)", err) && (created || again != 0) {
+		c.errorf("CompleteSetup ran twice: %d, %v", again, created)
+	}
+	c.ok("MarkSetupComplete", s.MarkSetupComplete(ctx))
+	if done, err := s.IsSetupComplete(ctx); c.ok("IsSetupComplete(done)", err) && !done {
+		c.errorf("IsSetupComplete after CompleteSetup = false")
+	}
+
+	c.ok("DeleteUser(root)", errOnly(s.DeleteUser(ctx, id)))
+	if done, err := s.IsSetupComplete(ctx); c.ok("IsSetupComplete(no users)", err) && !done {
+		c.errorf("IsSetupComplete reopened after the last user was deleted")
+	}
+}
+
+func testUsers(ctx context.Context, c *checker, s database.Store) {
+	id, err := s.CreateUser(ctx, "alice", "hash-1", "admin", true)
+	if !c.ok("CreateUser", err) {
+		return
+	}
+	if _, err := s.CreateUser(ctx, "alice", "hash-2", "viewer", false); err == nil {
+		c.errorf("CreateUser with a taken username succeeded")
+	}
+
+	user, err := s.GetUserByUsername(ctx, "alice")
+	if c.ok("GetUserByUsername", err) {
+		if user.ID != id || user.PasswordHash != "hash-1" || user.Role != "admin" || !user.MustChangePassword || user.AuthBackend != models.AuthBackendLocal {
+			c.errorf("GetUserByUsername = %+v", user)
+		}
+		// Columns that are NULL for a password user come back empty
+		if user.TailscaleIP != "" || user.TailscaleLogin != "" || user.OIDCIssuer != "" || user.TOTPSecret != "" || user.TOTPEnabled {
+			c.errorf("GetUserByUsername: NULL columns not empty: %+v", user)
+		}
+		if user.CreatedAt.IsZero() {
+			c.errorf("GetUserByUsername: created_at not set")
+		}
+	}
+
+	_, err = s.GetUserByUsername(ctx, "nobody")
+	c.notFound("GetUserByUsername(nobody)", err, database.ErrUserNotFound)
+	_, err = s.GetUserByID(ctx, id+1000)
+	c.notFound("GetUserByID(missing)", err, database.ErrUserNotFound)
+
+	bobID, err := s.CreateTailscaleUser(ctx, "bob", "Bob@github")
+	if c.ok("CreateTailscaleUser", err) {
+		bob, err := s.GetUserByTailscaleLogin(ctx, "bob@GitHub")
+		if c.ok("GetUserByTailscaleLogin", err) && (bob.ID != bobID || bob.Role != "viewer" || bob.PasswordHash != "" || bob.AuthBackend != models.AuthBackendTailscale) {
+			c.errorf("GetUserByTailscaleLogin = %+v", bob)
+		}
+	}
+	_, err = s.GetUserByTailscaleIP(ctx, "100.64.0.99")
+	c.notFound("GetUserByTailscaleIP(missing)", err, database.ErrUserNotFound)
+
+	carolID, err := s.CreateOIDCUser(ctx, "carol", "https://idp.example", "sub-1", "operator")
+	if c.ok("CreateOIDCUser", err) {
+		carol, err := s.GetUserByOIDCSubject(ctx, "https://idp.example", "sub-1")
+		if c.ok("GetUserByOIDCSubject", err) && (carol.ID != carolID || carol.Role != "operator" || carol.AuthBackend != models.AuthBackendOIDC) {
+			c.errorf("GetUserByOIDCSubject = %+v", carol)
+		}
+	}
+
+	c.ok("LinkOIDCIdentity", s.LinkOIDCIdentity(ctx, id, "https://idp.example", "sub-2"))
+	if linked, err := s.GetUserByOIDCSubject(ctx, "https://idp.example", "sub-2"); c.ok("GetUserByOIDCSubject(linked)", err) && linked.ID != id {
+		c.errorf("LinkOIDCIdentity linked user %d, want %d", linked.ID, id)
+	}
+
+	c.ok("SetPassword", s.SetPassword(ctx, id, "hash-3", false))
+	if user, err := s.GetUserByID(ctx, id); c.ok("GetUserByID", err) && (user.PasswordHash != "hash-3" || user.MustChangePassword) {
+		c.errorf("SetPassword not applied: %+v", user)
+	}
+
+	if found, err := s.SetUserRole(ctx, bobID, "operator"); c.ok("SetUserRole", err) && !found {
+		c.errorf("SetUserRole(bob) reported no user")
+	}
+	if found, err := s.SetUserRole(ctx, id+1000, "admin"); c.ok("SetUserRole(missing)", err) && found {
+		c.errorf("SetUserRole(missing) reported a user")
+	}
+
+	// Directory users keep a manually set role unless roles are synced
+	dave, err := s.UpsertDirectoryUser(ctx, models.AuthBackendLDAP, "dave", "operator", true)
+	if c.ok("UpsertDirectoryUser", err) && dave.Role != "operator" {
+		c.errorf("UpsertDirectoryUser role = %q, want operator", dave.Role)
+	}
+	if dave, err := s.UpsertDirectoryUser(ctx, models.AuthBackendLDAP, "dave", "admin", false); c.ok("UpsertDirectoryUser(no sync)", err) && dave.Role != "operator" {
+		c.errorf("UpsertDirectoryUser without sync changed role to %q", dave.Role)
+	}
+	if dave, err := s.UpsertDirectoryUser(ctx, models.AuthBackendLDAP, "dave", "admin", true); c.ok("UpsertDirectoryUser(sync)", err) && dave.Role != "admin" {
+		c.errorf("UpsertDirectoryUser with sync left role %q", dave.Role)
+	}
+	if dave != nil && dave.AuthBackend != models.AuthBackendLDAP {
+		c.errorf("UpsertDirectoryUser auth_backend = %q, want ldap", dave.AuthBackend)
+	}
+
+	// A directory login never adopts an account another backend created
+	for _, name := range []string{"alice", "bob", "carol"} {
+		if _, err := s.UpsertDirectoryUser(ctx, models.AuthBackendLDAP, name, "admin", true); !errors.Is(err, database.ErrUserConflict) {
+			c.errorf("UpsertDirectoryUser(%s) = %v, want ErrUserConflict", name, err)
+		}
+	}
+	if alice, err := s.GetUserByID(ctx, id); c.ok("GetUserByID(alice)", err) && (alice.AuthBackend != models.AuthBackendLocal || alice.Role != "admin") {
+		c.errorf("alice after a directory conflict = %+v", alice)
+	}
+
+	users, err := s.ListUsers(ctx)
+	if c.ok("ListUsers", err) {
+		var names []string
+		for _, u := range users {
+			names = append(names, u.Username)
+		}
+		if want := []string{"alice", "bob", "carol", "dave"}; !reflect.DeepEqual(names, want) {
+			c.errorf("ListUsers = %v, want %v", names, want)
+		}
+	}
+	if count, err := s.CountUsers(ctx); c.ok("CountUsers", err) && count != 4 {
+		c.errorf("CountUsers = %d, want 4", count)
+	}
+}
+
+func testSessions(ctx context.Context, c *checker, s database.Store) {
+	alice, err := s.GetUserByUsername(ctx, "alice")
+	if !c.ok("GetUserByUsername", err) {
+		return
+	}
+
+	c.ok("CreateSession", s.CreateSession(ctx, alice.ID, "tok-1", "192.0.2.1", "curl/8", time.Hour, 2*time.Hour))
+	session, err := s.GetSessionByToken(ctx, "tok-1")
+	if c.ok("GetSessionByToken", err) {
+		if session.UserID != alice.ID || session.IPAddress != "192.0.2.1" || session.UserAgent != "curl/8" {
+			c.errorf("GetSessionByToken = %+v", session)
+		}
+		if session.AbsoluteExpiresAt == nil || !session.AbsoluteExpiresAt.After(session.ExpiresAt) {
+			c.errorf("session absolute expiry %v not after idle expiry %v", session.AbsoluteExpiresAt, session.ExpiresAt)
+		}
+		c.ok("TouchSession", s.TouchSession(ctx, session.ID, "192.0.2.2", time.Hour))
+		if touched, err := s.GetSessionByToken(ctx, "tok-1"); c.ok("GetSessionByToken(touched)", err) && touched.IPAddress != "192.0.2.2" {
+			c.errorf("TouchSession did not record the new IP: %+v", touched)
+		}
+	}
+
+	// An idle timeout in the past yields a session that is already expired
+	c.ok("CreateSession(expired)", s.CreateSession(ctx, alice.ID, "tok-old", "", "", -time.Minute, time.Hour))
+	_, err = s.GetSessionByToken(ctx, "tok-old")
+	c.notFound("GetSessionByToken(expired)", err, database.ErrSessionNotFound)
+	_, err = s.GetSessionByToken(ctx, "tok-unknown")
+	c.notFound("GetSessionByToken(unknown)", err, database.ErrSessionNotFound)
+	if purged, err := s.PurgeExpiredSessions(ctx); c.ok("PurgeExpiredSessions", err) && purged != 1 {
+		c.errorf("PurgeExpiredSessions = %d, want 1", purged)
+	}
+
+	c.ok("CreateSession(2)", s.CreateSession(ctx, alice.ID, "tok-2", "", "", time.Hour, time.Hour))
+	if sessions, err := s.ListSessions(ctx, alice.ID); c.ok("ListSessions", err) && len(sessions) != 2 {
+		c.errorf("ListSessions returned %d sessions, want 2", len(sessions))
+	}
+	if revoked, err := s.DeleteUserSessions(ctx, alice.ID, "tok-1"); c.ok("DeleteUserSessions", err) && revoked != 1 {
+		c.errorf("DeleteUserSessions = %d, want 1", revoked)
+	}
+	if session != nil {
+		if found, err := s.DeleteUserSession(ctx, alice.ID+1000, session.ID); c.ok("DeleteUserSession(other user)", err) && found {
+			c.errorf("DeleteUserSession removed another user's session")
+		}
+	}
+	c.ok("DeleteSession", s.DeleteSession(ctx, "tok-1"))
+	_, err = s.GetSessionByToken(ctx, "tok-1")
+	c.notFound("GetSessionByToken(deleted)", err, database.ErrSessionNotFound)
+}
+
+func testTwoFactor(ctx context.Context, c *checker, s database.Store) {
+	alice, err := s.GetUserByUsername(ctx, "alice")
+	if !c.ok("GetUserByUsername", err) {
+		return
+	}
+
+	c.ok("SetPendingTOTPSecret", s.SetPendingTOTPSecret(ctx, alice.ID, "SECRET"))
+	if user, err := s.GetUserByID(ctx, alice.ID); c.ok("GetUserByID(pending)", err) && (user.TOTPSecret != "SECRET" || user.TOTPEnabled) {
+		c.errorf("SetPendingTOTPSecret: secret %q, enabled %v", user.TOTPSecret, user.TOTPEnabled)
+	}
+
+	c.ok("EnableTOTP", s.EnableTOTP(ctx, alice.ID, 100, []string{"code-a", "code-b"}))
+	if user, err := s.GetUserByID(ctx, alice.ID); c.ok("GetUserByID(enabled)", err) && (!user.TOTPEnabled || user.TOTPLastStep != 100) {
+		c.errorf("EnableTOTP: enabled %v, last step %d", user.TOTPEnabled, user.TOTPLastStep)
+	}
+	if n, err := s.CountRecoveryCodes(ctx, alice.ID); c.ok("CountRecoveryCodes", err) && n != 2 {
+		c.errorf("CountRecoveryCodes = %d, want 2", n)
+	}
+
+	// A step is accepted once, and never one at or before the last
+	for _, tc := range []struct {
+		step int64
+		want bool
+	}{{100, false}, {101, true}, {101, false}, {99, false}} {
+		if fresh, err := s.AdvanceTOTPStep(ctx, alice.ID, tc.step); c.ok("AdvanceTOTPStep", err) && fresh != tc.want {
+			c.errorf("AdvanceTOTPStep(%d) = %v, want %v", tc.step, fresh, tc.want)
+		}
+	}
+
+	if used, err := s.UseRecoveryCode(ctx, alice.ID, "code-a"); c.ok("UseRecoveryCode", err) && !used {
+		c.errorf("UseRecoveryCode(code-a) = false")
+	}
+	if used, err := s.UseRecoveryCode(ctx, alice.ID, "code-a"); c.ok("UseRecoveryCode(again)", err) && used {
+		c.errorf("UseRecoveryCode accepted a spent code")
+	}
+	if used, err := s.UseRecoveryCode(ctx, alice.ID, "code-z"); c.ok("UseRecoveryCode(unknown)", err) && used {
+		c.errorf("UseRecoveryCode accepted an unknown code")
+	}
+	if n, err := s.CountRecoveryCodes(ctx, alice.ID); c.ok("CountRecoveryCodes(after use)", err) && n != 1 {
+		c.errorf("CountRecoveryCodes after use = %d, want 1", n)
+	}
+
+	// Re-enrolling replaces the codes
+	c.ok("EnableTOTP(again)", s.EnableTOTP(ctx, alice.ID, 200, []string{"code-c"}))
+	if n, err := s.CountRecoveryCodes(ctx, alice.ID); c.ok("CountRecoveryCodes(re-enrolled)", err) && n != 1 {
+		c.errorf("CountRecoveryCodes after re-enrolling = %d, want 1", n)
+	}
+	if used, err := s.UseRecoveryCode(ctx, alice.ID, "code-b"); c.ok("UseRecoveryCode(replaced)", err) && used {
+		c.errorf("UseRecoveryCode accepted a replaced code")
+	}
+
+	c.ok("DisableTOTP", s.DisableTOTP(ctx, alice.ID))
+	if user, err := s.GetUserByID(ctx, alice.ID); c.ok("GetUserByID(disabled)", err) && (user.TOTPEnabled || user.TOTPSecret != "" || user.TOTPLastStep != 0) {
+		c.errorf("DisableTOTP left %+v", user)
+	}
+	if n, err := s.CountRecoveryCodes(ctx, alice.ID); c.ok("CountRecoveryCodes(disabled)", err) && n != 0 {
+		c.errorf("CountRecoveryCodes after DisableTOTP = %d, want 0", n)
+	}
+
+	c.ok("CreateLoginChallenge", s.CreateLoginChallenge(ctx, alice.ID, "chal-1", time.Minute))
+	if userID, err := s.GetLoginChallenge(ctx, "chal-1"); c.ok("GetLoginChallenge", err) && userID != alice.ID {
+		c.errorf("GetLoginChallenge = %d, want %d", userID, alice.ID)
+	}
+	c.ok("CreateLoginChallenge(expired)", s.CreateLoginChallenge(ctx, alice.ID, "chal-old", -time.Minute))
+	_, err = s.GetLoginChallenge(ctx, "chal-old")
+	c.notFound("GetLoginChallenge(expired)", err, database.ErrNotFound)
+	c.ok("DeleteLoginChallenge", s.DeleteLoginChallenge(ctx, "chal-1"))
+	_, err = s.GetLoginChallenge(ctx, "chal-1")
+	c.notFound("GetLoginChallenge(deleted)", err, database.ErrNotFound)
+
+	// Left for testCascade
+	c.ok("CreateLoginChallenge(2)", s.CreateLoginChallenge(ctx, alice.ID, "chal-2", time.Minute))
+}
+
+func testAPITokens(ctx context.Context, c *checker, s database.Store) {
+	alice, err := s.GetUserByUsername(ctx, "alice")
+	if !c.ok("GetUserByUsername", err) {
+		return
+	}
+	bob, err := s.GetUserByUsername(ctx, "bob")
+	if !c.ok("GetUserByUsername", err) {
+		return
+	}
+
+	forever, err := s.CreateAPIToken(ctx, alice.ID, "ci", "hash-ci", "h17_ci", []string{"read", "write"}, 0)
+	if !c.ok("CreateAPIToken", err) {
+		return
+	}
+	if forever.ID == 0 || forever.UserID != alice.ID || forever.Name != "ci" || forever.Prefix != "h17_ci" || forever.ExpiresAt != nil || forever.LastUsedAt != nil {
+		c.errorf("CreateAPIToken = %+v", forever)
+	}
+	if !reflect.DeepEqual(forever.Scopes, []string{"read", "write"}) {
+		c.errorf("CreateAPIToken scopes = %v", forever.Scopes)
+	}
+
+	expiring, err := s.CreateAPIToken(ctx, alice.ID, "backup", "hash-backup", "h17_bk", []string{"read"}, time.Hour)
+	if c.ok("CreateAPIToken(ttl)", err) && (expiring.ExpiresAt == nil || !expiring.ExpiresAt.After(expiring.CreatedAt)) {
+		c.errorf("CreateAPIToken with a ttl expires at %v", expiring.ExpiresAt)
+	}
+	if _, err := s.CreateAPIToken(ctx, bob.ID, "bob", "hash-ci", "h17_ci", nil, 0); err == nil {
+		c.errorf("CreateAPIToken with a taken hash succeeded")
+	}
+
+	token, err := s.GetAPITokenByHash(ctx, "hash-ci")
+	if c.ok("GetAPITokenByHash", err) && (token.ID != forever.ID || !reflect.DeepEqual(token.Scopes, forever.Scopes)) {
+		c.errorf("GetAPITokenByHash = %+v", token)
+	}
+	_, err = s.GetAPITokenByHash(ctx, "hash-unknown")
+	c.notFound("GetAPITokenByHash(unknown)", err, database.ErrAPITokenNotFound)
+
+	c.ok("TouchAPIToken", s.TouchAPIToken(ctx, forever.ID))
+	tokens, err := s.ListAPITokens(ctx, alice.ID)
+	if c.ok("ListAPITokens", err) {
+		used := map[string]bool{}
+		for _, t := range tokens {
+			used[t.Name] = t.LastUsedAt != nil
+		}
+		if want := map[string]bool{"ci": true, "backup": false}; !reflect.DeepEqual(used, want) {
+			c.errorf("ListAPITokens used = %v, want %v", used, want)
+		}
+	}
+	if tokens, err := s.ListAPITokens(ctx, bob.ID); c.ok("ListAPITokens(bob)", err) && len(tokens) != 0 {
+		c.errorf("ListAPITokens(bob) = %+v, want none", tokens)
+	}
+
+	if found, err := s.DeleteAPIToken(ctx, bob.ID, expiring.ID); c.ok("DeleteAPIToken(other user)", err) && found {
+		c.errorf("DeleteAPIToken removed another user's token")
+	}
+	if found, err := s.DeleteAPIToken(ctx, alice.ID, expiring.ID); c.ok("DeleteAPIToken", err) && !found {
+		c.errorf("DeleteAPIToken reported no token")
+	}
+	_, err = s.GetAPITokenByHash(ctx, "hash-backup")
+	c.notFound("GetAPITokenByHash(deleted)", err, database.ErrAPITokenNotFound)
+}
+
+func testPasskeys(ctx context.Context, c *checker, s database.Store) {
+	alice, err := s.GetUserByUsername(ctx, "alice")
+	if !c.ok("GetUserByUsername", err) {
+		return
+	}
+	bob, err := s.GetUserByUsername(ctx, "bob")
+	if !c.ok("GetUserByUsername", err) {
+		return
+	}
+
+	// Credential IDs are raw bytes, NULs and high bytes included
+	credID := []byte{0x00, 0x9f, 0xff, 0x27, 0x5c, 0x00}
+	c.ok("CreatePasskey", s.CreatePasskey(ctx, alice.ID, "YubiKey", credID, `{"id":"AJ__J1wA","signCount":1}`))
+	c.ok("CreatePasskey(2)", s.CreatePasskey(ctx, alice.ID, "Phone", []byte("phone"), `{"id":"cGhvbmU","signCount":0}`))
+	if err := s.CreatePasskey(ctx, bob.ID, "Copy", credID, `{}`); err == nil {
+		c.errorf("CreatePasskey with a taken credential ID succeeded")
+	}
+
+	passkeys, err := s.ListPasskeys(ctx, alice.ID)
+	if !c.ok("ListPasskeys", err) {
+		return
+	}
+	if len(passkeys) != 2 || passkeys[0].Name != "YubiKey" || passkeys[1].Name != "Phone" {
+		c.errorf("ListPasskeys = %+v, want YubiKey then Phone", passkeys)
+		return
+	}
+	key := passkeys[0]
+	if !bytes.Equal(key.CredentialID, credID) || key.UserID != alice.ID || key.LastUsedAt != nil || key.CreatedAt.IsZero() {
+		c.errorf("ListPasskeys[0] = %+v, credential ID %x", key, key.CredentialID)
+	}
+	if !sameJSON(key.CredentialJSON, `{"id":"AJ__J1wA","signCount":1}`) {
+		c.errorf("ListPasskeys credential JSON = %s", key.CredentialJSON)
+	}
+	if passkeys, err := s.ListPasskeys(ctx, bob.ID); c.ok("ListPasskeys(bob)", err) && (passkeys == nil || len(passkeys) != 0) {
+		c.errorf("ListPasskeys(bob) = %#v, want an empty list", passkeys)
+	}
+
+	// A login finds the credential by ID alone
+	c.ok("TouchPasskey", s.TouchPasskey(ctx, credID, `{"id":"AJ__J1wA","signCount":2}`))
+	if passkeys, err := s.ListPasskeys(ctx, alice.ID); c.ok("ListPasskeys(touched)", err) && len(passkeys) == 2 {
+		if passkeys[0].LastUsedAt == nil || !sameJSON(passkeys[0].CredentialJSON, `{"id":"AJ__J1wA","signCount":2}`) {
+			c.errorf("TouchPasskey not applied: %+v", passkeys[0])
+		}
+		if passkeys[1].LastUsedAt != nil {
+			c.errorf("TouchPasskey touched another credential: %+v", passkeys[1])
+		}
+	}
+
+	if found, err := s.RenamePasskey(ctx, bob.ID, key.ID, "Mine"); c.ok("RenamePasskey(other user)", err) && found {
+		c.errorf("RenamePasskey renamed another user's passkey")
+	}
+	if found, err := s.RenamePasskey(ctx, alice.ID, key.ID, "Keychain"); c.ok("RenamePasskey", err) && !found {
+		c.errorf("RenamePasskey reported no passkey")
+	}
+	if found, err := s.DeletePasskey(ctx, bob.ID, key.ID); c.ok("DeletePasskey(other user)", err) && found {
+		c.errorf("DeletePasskey removed another user's passkey")
+	}
+	if found, err := s.DeletePasskey(ctx, alice.ID, key.ID); c.ok("DeletePasskey", err) && !found {
+		c.errorf("DeletePasskey reported no passkey")
+	}
+	if passkeys, err := s.ListPasskeys(ctx, alice.ID); c.ok("ListPasskeys(deleted)", err) && (len(passkeys) != 1 || passkeys[0].Name != "Phone") {
+		c.errorf("ListPasskeys after DeletePasskey = %+v, want Phone", passkeys)
+	}
+}
+
+// testLoginState covers WebAuthn ceremonies and OIDC states, which are
+// taken with DELETE ... RETURNING so each can only be finished once
+func testLoginState(ctx context.Context, c *checker, s database.Store) {
+	alice, err := s.GetUserByUsername(ctx, "alice")
+	if !c.ok("GetUserByUsername", err) {
+		return
+	}
+
+	c.ok("SaveWebAuthnCeremony", s.SaveWebAuthnCeremony(ctx, "cer-1", &alice.ID, `{"challenge":"abc","user_id":"AQ"}`, time.Minute))
+	if userID, session, err := s.TakeWebAuthnCeremony(ctx, "cer-1"); c.ok("TakeWebAuthnCeremony", err) {
+		if userID == nil || *userID != alice.ID || !sameJSON(session, `{"challenge":"abc","user_id":"AQ"}`) {
+			c.errorf("TakeWebAuthnCeremony = %v, %s", userID, session)
+		}
+	}
+	_, _, err = s.TakeWebAuthnCeremony(ctx, "cer-1")
+	c.notFound("TakeWebAuthnCeremony(taken)", err, database.ErrNotFound)
+
+	// Discoverable logins start without a user
+	c.ok("SaveWebAuthnCeremony(discoverable)", s.SaveWebAuthnCeremony(ctx, "cer-2", nil, `{"challenge":"def"}`, time.Minute))
+	if userID, _, err := s.TakeWebAuthnCeremony(ctx, "cer-2"); c.ok("TakeWebAuthnCeremony(discoverable)", err) && userID != nil {
+		c.errorf("TakeWebAuthnCeremony(discoverable) user = %d, want nil", *userID)
+	}
+	c.ok("SaveWebAuthnCeremony(expired)", s.SaveWebAuthnCeremony(ctx, "cer-old", &alice.ID, `{}`, -time.Minute))
+	_, _, err = s.TakeWebAuthnCeremony(ctx, "cer-old")
+	c.notFound("TakeWebAuthnCeremony(expired)", err, database.ErrNotFound)
+	_, _, err = s.TakeWebAuthnCeremony(ctx, "cer-unknown")
+	c.notFound("TakeWebAuthnCeremony(unknown)", err, database.ErrNotFound)
+
+	c.ok("SaveOIDCState", s.SaveOIDCState(ctx, "st-1", "nonce-1", "verifier-1", nil, "/dashboard", time.Minute))
+	if pending, err := s.TakeOIDCState(ctx, "st-1"); c.ok("TakeOIDCState", err) {
+		want := models.OIDCState{Nonce: "nonce-1", CodeVerifier: "verifier-1", ReturnTo: "/dashboard"}
+		if !reflect.DeepEqual(*pending, want) {
+			c.errorf("TakeOIDCState = %+v, want %+v", *pending, want)
+		}
+	}
+	_, err = s.TakeOIDCState(ctx, "st-1")
+	c.notFound("TakeOIDCState(taken)", err, database.ErrNotFound)
+
+	c.ok("SaveOIDCState(link)", s.SaveOIDCState(ctx, "st-2", "nonce-2", "verifier-2", &alice.ID, "/account", time.Minute))
+	if pending, err := s.TakeOIDCState(ctx, "st-2"); c.ok("TakeOIDCState(link)", err) && (pending.UserID == nil || *pending.UserID != alice.ID) {
+		c.errorf("TakeOIDCState(link) user = %v, want %d", pending.UserID, alice.ID)
+	}
+	c.ok("SaveOIDCState(expired)", s.SaveOIDCState(ctx, "st-old", "n", "v", nil, "/", -time.Minute))
+	_, err = s.TakeOIDCState(ctx, "st-old")
+	c.notFound("TakeOIDCState(expired)", err, database.ErrNotFound)
+
+	// Left for testCascade
+	c.ok("SaveWebAuthnCeremony(pending)", s.SaveWebAuthnCeremony(ctx, "cer-3", &alice.ID, `{}`, time.Hour))
+	c.ok("SaveOIDCState(pending)", s.SaveOIDCState(ctx, "st-3", "n", "v", &alice.ID, "/", time.Hour))
+}
+
+func testLockouts(ctx context.Context, c *checker, s database.Store) {
+	const user, ip = "username", "ip"
+
+	for want := 1; want <= 2; want++ {
+		if n, err := s.RecordLoginFailure(ctx, user, "alice", time.Hour); c.ok("RecordLoginFailure", err) && n != want {
+			c.errorf("RecordLoginFailure = %d, want %d", n, want)
+		}
+	}
+	if n, err := s.RecordLoginFailure(ctx, ip, "192.0.2.1", time.Hour); c.ok("RecordLoginFailure(ip)", err) && n != 1 {
+		c.errorf("RecordLoginFailure(ip) = %d, want 1", n)
+	}
+	if d, err := s.LoginLockedFor(ctx, user, "alice"); c.ok("LoginLockedFor", err) && d != 0 {
+		c.errorf("LoginLockedFor before LockLogin = %v, want 0", d)
+	}
+
+	c.ok("LockLogin", s.LockLogin(ctx, user, "alice", time.Hour))
+	if d, err := s.LoginLockedFor(ctx, user, "alice"); c.ok("LoginLockedFor(locked)", err) && (d < 59*time.Minute || d > time.Hour+time.Second) {
+		c.errorf("LoginLockedFor = %v, want about 1h", d)
+	}
+	if d, err := s.LoginLockedFor(ctx, user, "bob"); c.ok("LoginLockedFor(unknown)", err) && d != 0 {
+		c.errorf("LoginLockedFor(unknown) = %v, want 0", d)
+	}
+
+	lockouts, err := s.ListLoginLockouts(ctx)
+	if c.ok("ListLoginLockouts", err) {
+		got := map[string]string{}
+		for _, l := range lockouts {
+			got[l.Scope+"/"+l.Subject] = fmt.Sprintf("%d %v %v", l.Failures, l.Locked, l.LockedUntil != nil)
+		}
+		want := map[string]string{"username/alice": "2 true true", "ip/192.0.2.1": "1 false false"}
+		if !reflect.DeepEqual(got, want) {
+			c.errorf("ListLoginLockouts = %v, want %v", got, want)
+		}
+	}
+
+	// A failure outside the window restarts the count
+	if n, err := s.RecordLoginFailure(ctx, user, "alice", -time.Minute); c.ok("RecordLoginFailure(window)", err) && n != 1 {
+		c.errorf("RecordLoginFailure after the window = %d, want 1", n)
+	}
+
+	// An expired lock no longer counts
+	c.ok("LockLogin(expired)", s.LockLogin(ctx, ip, "192.0.2.1", -time.Minute))
+	if d, err := s.LoginLockedFor(ctx, ip, "192.0.2.1"); c.ok("LoginLockedFor(expired)", err) && d != 0 {
+		c.errorf("LoginLockedFor after the lock expired = %v, want 0", d)
+	}
+
+	c.ok("ClearLoginFailures", s.ClearLoginFailures(ctx, user, "alice"))
+	if d, err := s.LoginLockedFor(ctx, user, "alice"); c.ok("LoginLockedFor(cleared)", err) && d != 0 {
+		c.errorf("LoginLockedFor after ClearLoginFailures = %v, want 0", d)
+	}
+
+	lockouts, err = s.ListLoginLockouts(ctx)
+	if c.ok("ListLoginLockouts(cleared)", err) {
+		if len(lockouts) != 1 {
+			c.errorf("ListLoginLockouts after clearing = %+v, want the ip row", lockouts)
+		} else {
+			if found, err := s.DeleteLoginLockout(ctx, lockouts[0].ID); c.ok("DeleteLoginLockout", err) && !found {
+				c.errorf("DeleteLoginLockout reported no row")
+			}
+			if found, err := s.DeleteLoginLockout(ctx, lockouts[0].ID); c.ok("DeleteLoginLockout(again)", err) && found {
+				c.errorf("DeleteLoginLockout removed the same row twice")
+			}
+		}
+	}
+
+	c.ok("RecordLoginFailure", errOnly(s.RecordLoginFailure(ctx, user, "carol", time.Hour)))
+	c.ok("RecordLoginFailure", errOnly(s.RecordLoginFailure(ctx, ip, "192.0.2.2", time.Hour)))
+	if n, err := s.DeleteAllLoginLockouts(ctx); c.ok("DeleteAllLoginLockouts", err) && n != 2 {
+		c.errorf("DeleteAllLoginLockouts = %d, want 2", n)
+	}
+}
+
+func testWidgets(ctx context.Context, c *checker, s database.Store) {
+	alice, err := s.GetUserByUsername(ctx, "alice")
+	if !c.ok("GetUserByUsername", err) {
+		return
+	}
+
+	_, err = s.GetWidgetData(ctx, alice.ID, "weather", "location")
+	c.notFound("GetWidgetData(missing)", err, database.ErrWidgetDataNotFound)
+
+	// Saving twice must upsert, not add a second row
+	c.ok("SaveWidgetData", s.SaveWidgetData(ctx, alice.ID, "weather", "location", `{"city": "Oslo"}`))
+	c.ok("SaveWidgetData(again)", s.SaveWidgetData(ctx, alice.ID, "weather", "location", `{"city": "Bergen", "zoom": 3}`))
+	value, err := s.GetWidgetData(ctx, alice.ID, "weather", "location")
+	if c.ok("GetWidgetData", err) && !sameJSON(value, `{"zoom": 3, "city": "Bergen"}`) {
+		c.errorf("GetWidgetData = %s", value)
+	}
+}
+
+func testSettings(ctx context.Context, c *checker, s database.Store) {
+	alice, err := s.GetUserByUsername(ctx, "alice")
+	if !c.ok("GetUserByUsername", err) {
+		return
+	}
+
+	_, err = s.GetSetting(ctx, alice.ID, "theme")
+	c.notFound("GetSetting(missing)", err, database.ErrSettingNotFound)
+
+	c.ok("SaveSetting", s.SaveSetting(ctx, alice.ID, "theme", "light"))
+	c.ok("SaveSetting(again)", s.SaveSetting(ctx, alice.ID, "theme", "dark"))
+	if value, err := s.GetSetting(ctx, alice.ID, "theme"); c.ok("GetSetting", err) && value != "dark" {
+		c.errorf("GetSetting = %q, want dark", value)
+	}
+}
+
+func testAudit(ctx context.Context, c *checker, s database.Store) {
+	alice, err := s.GetUserByUsername(ctx, "alice")
+	if !c.ok("GetUserByUsername", err) {
+		return
+	}
+
+	for _, e := range []models.AuditEvent{
+		{Action: "auth.login", Outcome: "success", ActorID: &alice.ID, ActorName: "alice", ClientIP: "192.0.2.1", Details: json.RawMessage(`{"method": "password"}`)},
+		{Action: "auth.login_failed", Outcome: "failure", ActorName: "mallory", ClientIP: "192.0.2.9"},
+		{Action: "user.role", Outcome: "success", ActorID: &alice.ID, ActorName: "Alice", TargetType: "user", TargetID: "2",
+			Before: json.RawMessage(`{"role": "viewer"}`), After: json.RawMessage(`{"role": "admin"}`)},
+		{Action: "auth.logout", Outcome: "success", ActorID: &alice.ID, ActorName: "alice", ClientIP: "192.0.2.1"},
+	} {
+		c.ok("InsertAuditEvent", s.InsertAuditEvent(ctx, &e))
+	}
+
+	all, err := s.ListAuditEvents(ctx, database.AuditFilter{Limit: 10})
+	if !c.ok("ListAuditEvents", err) {
+		return
+	}
+	if got, want := auditActions(all), []string{"auth.logout", "user.role", "auth.login_failed", "auth.login"}; !reflect.DeepEqual(got, want) {
+		c.errorf("ListAuditEvents = %v, want %v", got, want)
+		return
+	}
+	login, change := all[3], all[1]
+	if login.OccurredAt.IsZero() || login.ActorID == nil || *login.ActorID != alice.ID || !sameJSON(string(login.Details), `{"method": "password"}`) {
+		c.errorf("ListAuditEvents login = %+v", login)
+	}
+	if !sameJSON(string(change.Before), `{"role": "viewer"}`) || !sameJSON(string(change.After), `{"role": "admin"}`) || len(change.Details) != 0 {
+		c.errorf("ListAuditEvents role change = before %s, after %s, details %s", change.Before, change.After, change.Details)
+	}
+	if all[2].ActorID != nil {
+		c.errorf("ListAuditEvents: event without an actor has actor %d", *all[2].ActorID)
+	}
+
+	now := time.Now()
+	for _, tc := range []struct {
+		name   string
+		filter database.AuditFilter
+		want   []string
+	}{
+		{"action prefix", database.AuditFilter{Action: "auth."}, []string{"auth.logout", "auth.login_failed", "auth.login"}},
+		{"actor ignores case", database.AuditFilter{Actor: "ALICE"}, []string{"auth.logout", "user.role", "auth.login"}},
+		{"outcome", database.AuditFilter{Outcome: "failure"}, []string{"auth.login_failed"}},
+		{"target", database.AuditFilter{TargetType: "user", TargetID: "2"}, []string{"user.role"}},
+		{"client ip", database.AuditFilter{ClientIP: "192.0.2.1"}, []string{"auth.logout", "auth.login"}},
+		{"since", database.AuditFilter{Since: now.Add(-time.Hour)}, []string{"auth.logout", "user.role", "auth.login_failed", "auth.login"}},
+		{"until", database.AuditFilter{Until: now.Add(-time.Hour)}, []string{}},
+		{"before id", database.AuditFilter{BeforeID: all[1].ID}, []string{"auth.login_failed", "auth.login"}},
+		{"limit", database.AuditFilter{Limit: 2}, []string{"auth.logout", "user.role"}},
+	} {
+		if tc.filter.Limit == 0 {
+			tc.filter.Limit = 10
+		}
+		events, err := s.ListAuditEvents(ctx, tc.filter)
+		if c.ok("ListAuditEvents("+tc.name+")", err) && !reflect.DeepEqual(auditActions(events), tc.want) {
+			c.errorf("ListAuditEvents(%s) = %v, want %v", tc.name, auditActions(events), tc.want)
+		}
+	}
+
+	if n, err := s.PurgeAuditEvents(ctx, time.Hour); c.ok("PurgeAuditEvents", err) && n != 0 {
+		c.errorf("PurgeAuditEvents(1h) = %d, want 0", n)
+	}
+	if n, err := s.PurgeAuditEvents(ctx, -time.Minute); c.ok("PurgeAuditEvents(all)", err) && n != 4 {
+		c.errorf("PurgeAuditEvents(all) = %d, want 4", n)
+	}
+
+	// Left for testCascade
+	c.ok("InsertAuditEvent", s.InsertAuditEvent(ctx, &models.AuditEvent{Action: "auth.logout", Outcome: "success", ActorID: &alice.ID, ActorName: "alice"}))
+}
+
+func testMetrics(ctx context.Context, c *checker, s database.Store) {
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	point := func(metric string, at time.Time, avg, lo, hi float64, count int) models.MetricPoint {
+		return models.MetricPoint{Metric: metric, Time: at, Avg: avg, Min: lo, Max: hi, Count: count}
+	}
+
+	c.ok("InsertMetricSamples", s.InsertMetricSamples(ctx, base, map[string]float64{"cpu": 10, "mem": 40}))
+	// A second sample within the same second is dropped
+	c.ok("InsertMetricSamples(same second)", s.InsertMetricSamples(ctx, base.Add(500*time.Millisecond), map[string]float64{"cpu": 99}))
+	c.ok("InsertMetricSamples(later)", s.InsertMetricSamples(ctx, base.Add(10*time.Second).In(time.FixedZone("CET", 3600)), map[string]float64{"cpu": 20}))
+	c.ok("InsertMetricSamples(empty)", s.InsertMetricSamples(ctx, base, nil))
+
+	for _, tc := range []struct {
+		name     string
+		metric   string
+		from, to time.Time
+		want     []models.MetricPoint
+	}{
+		{"one metric", "cpu", base, base.Add(time.Minute), []models.MetricPoint{
+			point("cpu", base, 10, 10, 10, 1), point("cpu", base.Add(10*time.Second), 20, 20, 20, 1),
+		}},
+		{"every metric", "", base, base.Add(time.Second), []models.MetricPoint{
+			point("cpu", base, 10, 10, 10, 1), point("mem", base, 40, 40, 40, 1),
+		}},
+		{"half-open range", "cpu", base.Add(time.Second), base.Add(10 * time.Second), nil},
+	} {
+		points, err := s.ListMetricPoints(ctx, 0, tc.metric, tc.from, tc.to)
+		if c.ok("ListMetricPoints("+tc.name+")", err) && !samePoints(points, tc.want) {
+			c.errorf("ListMetricPoints(%s) = %+v, want %+v", tc.name, points, tc.want)
+		}
+	}
+
+	if latest, err := s.LatestMetricRollup(ctx, time.Minute); c.ok("LatestMetricRollup(none)", err) && !latest.IsZero() {
+		c.errorf("LatestMetricRollup with no rollups = %v", latest)
+	}
+
+	// Upserting a bucket again replaces it; tiers are kept apart
+	c.ok("UpsertMetricRollups", s.UpsertMetricRollups(ctx, time.Minute, []models.MetricPoint{point("cpu", base, 15, 10, 20, 2)}))
+	c.ok("UpsertMetricRollups(again)", s.UpsertMetricRollups(ctx, time.Minute, []models.MetricPoint{
+		point("cpu", base, 16, 10, 22, 3), point("cpu", base.Add(time.Minute), 30, 30, 30, 1),
+	}))
+	c.ok("UpsertMetricRollups(hour)", s.UpsertMetricRollups(ctx, time.Hour, []models.MetricPoint{point("load", base, 1, 1, 1, 60)}))
+
+	minutes, err := s.ListMetricPoints(ctx, time.Minute, "", base, base.Add(time.Hour))
+	if want := []models.MetricPoint{point("cpu", base, 16, 10, 22, 3), point("cpu", base.Add(time.Minute), 30, 30, 30, 1)}; c.ok("ListMetricPoints(minute)", err) && !samePoints(minutes, want) {
+		c.errorf("ListMetricPoints(minute) = %+v, want %+v", minutes, want)
+	}
+	if latest, err := s.LatestMetricRollup(ctx, time.Minute); c.ok("LatestMetricRollup", err) && !latest.Equal(base.Add(time.Minute)) {
+		c.errorf("LatestMetricRollup = %v, want %v", latest, base.Add(time.Minute))
+	}
+	if names, err := s.ListMetricNames(ctx); c.ok("ListMetricNames", err) && !reflect.DeepEqual(names, []string{"cpu", "load", "mem"}) {
+		c.errorf("ListMetricNames = %v", names)
+	}
+
+	if n, err := s.PurgeMetricPoints(ctx, 0, base.Add(time.Second)); c.ok("PurgeMetricPoints(samples)", err) && n != 2 {
+		c.errorf("PurgeMetricPoints(samples) = %d, want 2", n)
+	}
+	if n, err := s.PurgeMetricPoints(ctx, time.Minute, base.Add(time.Minute)); c.ok("PurgeMetricPoints(minute)", err) && n != 1 {
+		c.errorf("PurgeMetricPoints(minute) = %d, want 1", n)
+	}
+	if hours, err := s.ListMetricPoints(ctx, time.Hour, "load", base, base.Add(time.Hour)); c.ok("ListMetricPoints(hour)", err) && len(hours) != 1 {
+		c.errorf("PurgeMetricPoints(minute) touched the hour tier: %+v", hours)
+	}
+}
+
+// testCascade checks that deleting a user removes their rows everywhere
+func testCascade(ctx context.Context, c *checker, s database.Store) {
+	alice, err := s.GetUserByUsername(ctx, "alice")
+	if !c.ok("GetUserByUsername", err) {
+		return
+	}
+	c.ok("CreateSession", s.CreateSession(ctx, alice.ID, "tok-3", "", "", time.Hour, time.Hour))
+
+	if found, err := s.DeleteUser(ctx, alice.ID); c.ok("DeleteUser", err) && !found {
+		c.errorf("DeleteUser reported no user")
+	}
+	if found, err := s.DeleteUser(ctx, alice.ID); c.ok("DeleteUser(again)", err) && found {
+		c.errorf("DeleteUser removed the same user twice")
+	}
+
+	_, err = s.GetSessionByToken(ctx, "tok-3")
+	c.notFound("GetSessionByToken(after DeleteUser)", err, database.ErrSessionNotFound)
+	_, err = s.GetWidgetData(ctx, alice.ID, "weather", "location")
+	c.notFound("GetWidgetData(after DeleteUser)", err, database.ErrWidgetDataNotFound)
+	_, err = s.GetSetting(ctx, alice.ID, "theme")
+	c.notFound("GetSetting(after DeleteUser)", err, database.ErrSettingNotFound)
+	_, err = s.GetAPITokenByHash(ctx, "hash-ci")
+	c.notFound("GetAPITokenByHash(after DeleteUser)", err, database.ErrAPITokenNotFound)
+	_, err = s.GetLoginChallenge(ctx, "chal-2")
+	c.notFound("GetLoginChallenge(after DeleteUser)", err, database.ErrNotFound)
+	if passkeys, err := s.ListPasskeys(ctx, alice.ID); c.ok("ListPasskeys(after DeleteUser)", err) && len(passkeys) != 0 {
+		c.errorf("ListPasskeys after DeleteUser = %+v, want none", passkeys)
+	}
+	_, _, err = s.TakeWebAuthnCeremony(ctx, "cer-3")
+	c.notFound("TakeWebAuthnCeremony(after DeleteUser)", err, database.ErrNotFound)
+	_, err = s.TakeOIDCState(ctx, "st-3")
+	c.notFound("TakeOIDCState(after DeleteUser)", err, database.ErrNotFound)
+
+	// Audit events outlive their actor, keeping the name
+	events, err := s.ListAuditEvents(ctx, database.AuditFilter{Actor: "alice", Limit: 10})
+	if c.ok("ListAuditEvents(after DeleteUser)", err) && (len(events) != 1 || events[0].ActorID != nil) {
+		c.errorf("ListAuditEvents after DeleteUser = %+v, want one event without an actor ID", events)
+	}
+}
+
+// errOnly drops a result the check does not need
+func errOnly[T any](_ T, err error) error {
+	return err
+}
+
+func auditActions(events []models.AuditEvent) []string {
+	actions := []string{}
+	for _, e := range events {
+		actions = append(actions, e.Action)
+	}
+	return actions
+}
+
+// samePoints compares metric points, allowing for time zones
+func samePoints(got, want []models.MetricPoint) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		g, w := got[i], want[i]
+		if g.Metric != w.Metric || !g.Time.Equal(w.Time) || g.Avg != w.Avg || g.Min != w.Min || g.Max != w.Max || g.Count != w.Count {
+			return false
+		}
+	}
+	return true
+}
+
+// sameJSON compares documents semantically, since JSONB reorders keys
+func sameJSON(a, b string) bool {
+	var x, y any
+	if json.Unmarshal([]byte(a), &x) != nil || json.Unmarshal([]byte(b), &y) != nil {
+		return false
+	}
+	return reflect.DeepEqual(x, y)
+}
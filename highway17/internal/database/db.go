@@ -2,18 +2,37 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
-	"os"
+	"sort"
 	"strings"
+	"time"
+
+	"citadel/highway17/internal/models"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// DB runs the application's queries against Postgres or SQLite
 type DB struct {
-	pool *pgxpool.Pool
+	conn    conn
+	dialect Dialect
+	pool    *pgxpool.Pool // Postgres only; migrations lock one of its connections
+	sqlDB   *sql.DB       // SQLite only
 }
 
+// New connects to DATABASE_URL, choosing the backend by scheme (see
+// ParseDialect)
 func New(ctx context.Context, databaseURL string) (*DB, error) {
+	dialect, err := ParseDialect(databaseURL)
+	if err != nil {
+		return nil, err
+	}
+	if dialect == SQLite {
+		return openSQLite(ctx, databaseURL)
+	}
+
 	config, err := pgxpool.ParseConfig(databaseURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse database URL: %w", err)
@@ -29,93 +48,676 @@ func New(ctx context.Context, databaseURL string) (*DB, error) {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	return &DB{pool: pool}, nil
+	return &DB{conn: pgxConn{pool}, dialect: Postgres, pool: pool}, nil
+}
+
+// Dialect reports which backend the DB talks to
+func (d *DB) Dialect() Dialect {
+	return d.dialect
 }
 
 func (d *DB) Close() {
-	d.pool.Close()
+	if d.pool != nil {
+		d.pool.Close()
+	}
+	if d.sqlDB != nil {
+		d.sqlDB.Close()
+	}
 }
 
-func (d *DB) Migrate(ctx context.Context) error {
-	// Read migration file
-	migration, err := os.ReadFile("migrations/001_initial_schema.sql")
+// User queries
+
+// userColumns is the column list scanUser expects
+const userColumns = "id, username, role, password_hash, tailscale_ip, tailscale_login, oidc_issuer, oidc_subject, totp_enabled, totp_secret, totp_last_step, must_change_password, auth_backend, created_at, updated_at"
+
+func scanUser(row row) (*models.User, error) {
+	var user models.User
+	var phash, tip, tlogin, oissuer, osubject, tsecret *string
+	err := row.Scan(
+		&user.ID, &user.Username, &user.Role, &phash, &tip, &tlogin, &oissuer, &osubject,
+		&user.TOTPEnabled, &tsecret, &user.TOTPLastStep, &user.MustChangePassword,
+		&user.AuthBackend, &user.CreatedAt, &user.UpdatedAt,
+	)
 	if err != nil {
-		return fmt.Errorf("failed to read migration file: %w", err)
+		return nil, notFound(err, ErrUserNotFound)
 	}
 
-	// Split into individual statements
-	statements := strings.Split(string(migration), ";")
+	if phash != nil {
+		user.PasswordHash = *phash
+	}
+	if tip != nil {
+		user.TailscaleIP = *tip
+	}
+	if tlogin != nil {
+		user.TailscaleLogin = *tlogin
+	}
+	if oissuer != nil {
+		user.OIDCIssuer = *oissuer
+	}
+	if osubject != nil {
+		user.OIDCSubject = *osubject
+	}
+	if tsecret != nil {
+		user.TOTPSecret = *tsecret
+	}
 
-	for _, stmt := range statements {
-		stmt = strings.TrimSpace(stmt)
-		if stmt == "" {
-			continue
-		}
+	return &user, nil
+}
+
+func (d *DB) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	return scanUser(d.conn.QueryRow(ctx, "SELECT "+userColumns+" FROM users WHERE username = $1", username))
+}
+
+func (d *DB) GetUserByID(ctx context.Context, id int) (*models.User, error) {
+	return scanUser(d.conn.QueryRow(ctx, "SELECT "+userColumns+" FROM users WHERE id = $1", id))
+}
+
+func (d *DB) GetUserByTailscaleLogin(ctx context.Context, login string) (*models.User, error) {
+	return scanUser(d.conn.QueryRow(ctx, "SELECT "+userColumns+" FROM users WHERE LOWER(tailscale_login) = LOWER($1)", login))
+}
+
+func (d *DB) GetUserByTailscaleIP(ctx context.Context, ip string) (*models.User, error) {
+	return scanUser(d.conn.QueryRow(ctx, "SELECT "+userColumns+" FROM users WHERE tailscale_ip = $1", ip))
+}
+
+// CreateTailscaleUser creates a password-less user bound to a tailnet login
+func (d *DB) CreateTailscaleUser(ctx context.Context, username, login string) (int, error) {
+	var id int
+	err := d.conn.QueryRow(
+		ctx,
+		"INSERT INTO users (username, tailscale_login, auth_backend) VALUES ($1, $2, $3) RETURNING id",
+		username, login, models.AuthBackendTailscale,
+	).Scan(&id)
+	return id, err
+}
+
+func (d *DB) GetUserByOIDCSubject(ctx context.Context, issuer, subject string) (*models.User, error) {
+	return scanUser(d.conn.QueryRow(ctx, "SELECT "+userColumns+" FROM users WHERE oidc_issuer = $1 AND oidc_subject = $2", issuer, subject))
+}
+
+// CreateOIDCUser creates a password-less user bound to an OIDC identity
+func (d *DB) CreateOIDCUser(ctx context.Context, username, issuer, subject, role string) (int, error) {
+	var id int
+	err := d.conn.QueryRow(
+		ctx,
+		"INSERT INTO users (username, oidc_issuer, oidc_subject, role, auth_backend) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		username, issuer, subject, role, models.AuthBackendOIDC,
+	).Scan(&id)
+	return id, err
+}
+
+func (d *DB) CreateUser(ctx context.Context, username, passwordHash, role string, mustChangePassword bool) (int, error) {
+	var id int
+	err := d.conn.QueryRow(
+		ctx,
+		"INSERT INTO users (username, password_hash, role, must_change_password, auth_backend) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		username, passwordHash, role, mustChangePassword, models.AuthBackendLocal,
+	).Scan(&id)
+	return id, err
+}
+
+// SetPassword replaces a user's password hash and sets or clears the
+// forced-change flag
+func (d *DB) SetPassword(ctx context.Context, userID int, passwordHash string, mustChangePassword bool) error {
+	_, err := d.conn.Exec(
+		ctx,
+		"UPDATE users SET password_hash = $2, must_change_password = $3, updated_at = NOW() WHERE id = $1",
+		userID, passwordHash, mustChangePassword,
+	)
+	return err
+}
 
-		if _, err := d.pool.Exec(ctx, stmt); err != nil {
-			return fmt.Errorf("failed to execute migration: %w", err)
+func (d *DB) CountUsers(ctx context.Context) (int, error) {
+	var count int
+	err := d.conn.QueryRow(ctx, "SELECT COUNT(*) FROM users").Scan(&count)
+	return count, err
+}
+
+func (d *DB) ListUsers(ctx context.Context) ([]models.User, error) {
+	rows, err := d.conn.Query(ctx, "SELECT "+userColumns+" FROM users ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
 		}
+		users = append(users, *user)
 	}
+	return users, rows.Err()
+}
 
-	return nil
+// UpsertDirectoryUser creates or refreshes the local row for a user
+// authenticated by an external directory. The role is only overwritten when
+// syncRole is set, so manual role changes survive without group mapping.
+// A row created by any other backend is never adopted; the username is
+// reported as ErrUserConflict instead.
+func (d *DB) UpsertDirectoryUser(ctx context.Context, backend, username, role string, syncRole bool) (*models.User, error) {
+	user, err := scanUser(d.conn.QueryRow(
+		ctx,
+		`INSERT INTO users (username, role, auth_backend) VALUES ($1, $2, $4)
+		 ON CONFLICT (username) DO UPDATE SET
+		   role = CASE WHEN $3 THEN EXCLUDED.role ELSE users.role END,
+		   updated_at = NOW()
+		 WHERE users.auth_backend = EXCLUDED.auth_backend
+		 RETURNING `+userColumns,
+		username, role, syncRole, backend,
+	))
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, ErrUserConflict
+	}
+	return user, err
 }
 
-// User queries
-func (d *DB) GetUserByUsername(ctx context.Context, username string) (map[string]interface{}, error) {
-	row := d.pool.QueryRow(ctx, "SELECT id, username, password_hash, tailscale_ip FROM users WHERE username = $1", username)
+// DeleteUser removes an account; its sessions, tokens and widget data go with it
+func (d *DB) DeleteUser(ctx context.Context, userID int) (bool, error) {
+	tag, err := d.conn.Exec(ctx, "DELETE FROM users WHERE id = $1", userID)
+	return tag.RowsAffected() > 0, err
+}
 
-	var id int
-	var uname, phash, tip string
-	err := row.Scan(&id, &uname, &phash, &tip)
+func (d *DB) SetUserRole(ctx context.Context, userID int, role string) (bool, error) {
+	tag, err := d.conn.Exec(ctx, "UPDATE users SET role = $2, updated_at = NOW() WHERE id = $1", userID, role)
+	return tag.RowsAffected() > 0, err
+}
+
+// CreateSession stores a session that expires after idle unless touched,
+// and in any case after maxLifetime
+func (d *DB) CreateSession(ctx context.Context, userID int, token, ip, userAgent string, idle, maxLifetime time.Duration) error {
+	_, err := d.conn.Exec(
+		ctx,
+		`INSERT INTO sessions (user_id, token, ip_address, user_agent, expires_at, absolute_expires_at, last_seen_at)
+		 VALUES ($1, $2, $3, $4,
+		         LEAST(NOW() + make_interval(secs => $5), NOW() + make_interval(secs => $6)),
+		         NOW() + make_interval(secs => $6), NOW())`,
+		userID, token, ip, userAgent, idle.Seconds(), maxLifetime.Seconds(),
+	)
+	return err
+}
+
+// sessionColumns is the column list scanSession expects
+const sessionColumns = "id, user_id, token, expires_at, absolute_expires_at, created_at, last_seen_at, ip_address, user_agent"
+
+func scanSession(row row) (*models.Session, error) {
+	var s models.Session
+	var lastSeen *time.Time
+	var ip, ua *string
+	err := row.Scan(&s.ID, &s.UserID, &s.Token, &s.ExpiresAt, &s.AbsoluteExpiresAt, &s.CreatedAt, &lastSeen, &ip, &ua)
+	if err != nil {
+		return nil, notFound(err, ErrSessionNotFound)
+	}
+
+	s.LastSeenAt = s.CreatedAt
+	if lastSeen != nil {
+		s.LastSeenAt = *lastSeen
+	}
+	if ip != nil {
+		s.IPAddress = *ip
+	}
+	if ua != nil {
+		s.UserAgent = *ua
+	}
+
+	return &s, nil
+}
+
+// GetSessionByToken returns an unexpired session, or ErrSessionNotFound
+func (d *DB) GetSessionByToken(ctx context.Context, token string) (*models.Session, error) {
+	return scanSession(d.conn.QueryRow(
+		ctx,
+		"SELECT "+sessionColumns+" FROM sessions WHERE token = $1 AND expires_at > NOW()",
+		token,
+	))
+}
+
+// TouchSession records activity and slides expiry, capped at the absolute expiry
+func (d *DB) TouchSession(ctx context.Context, id int, ip string, idle time.Duration) error {
+	_, err := d.conn.Exec(
+		ctx,
+		`UPDATE sessions SET
+		   last_seen_at = NOW(),
+		   ip_address = $2,
+		   expires_at = LEAST(NOW() + make_interval(secs => $3), absolute_expires_at)
+		 WHERE id = $1`,
+		id, ip, idle.Seconds(),
+	)
+	return err
+}
+
+func (d *DB) ListSessions(ctx context.Context, userID int) ([]models.Session, error) {
+	rows, err := d.conn.Query(
+		ctx,
+		"SELECT "+sessionColumns+" FROM sessions WHERE user_id = $1 AND expires_at > NOW() ORDER BY last_seen_at DESC NULLS LAST",
+		userID,
+	)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	return map[string]interface{}{
-		"id":            id,
-		"username":      uname,
-		"password_hash": phash,
-		"tailscale_ip":  tip,
-	}, nil
+	sessions := []models.Session{}
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *s)
+	}
+	return sessions, rows.Err()
 }
 
-func (d *DB) CreateUser(ctx context.Context, username, passwordHash string) (int, error) {
-	var id int
-	err := d.pool.QueryRow(
+func (d *DB) DeleteSession(ctx context.Context, token string) error {
+	_, err := d.conn.Exec(ctx, "DELETE FROM sessions WHERE token = $1", token)
+	return err
+}
+
+// DeleteUserSession revokes one of a user's sessions by ID
+func (d *DB) DeleteUserSession(ctx context.Context, userID, id int) (bool, error) {
+	tag, err := d.conn.Exec(ctx, "DELETE FROM sessions WHERE id = $2 AND user_id = $1", userID, id)
+	return tag.RowsAffected() > 0, err
+}
+
+// DeleteUserSessions revokes every session of a user except keepToken (may be empty)
+func (d *DB) DeleteUserSessions(ctx context.Context, userID int, keepToken string) (int64, error) {
+	tag, err := d.conn.Exec(ctx, "DELETE FROM sessions WHERE user_id = $1 AND token <> $2", userID, keepToken)
+	return tag.RowsAffected(), err
+}
+
+// PurgeExpiredSessions deletes expired sessions, login challenges, WebAuthn
+// ceremonies and OIDC states, returning the number of sessions removed
+func (d *DB) PurgeExpiredSessions(ctx context.Context) (int64, error) {
+	tag, err := d.conn.Exec(ctx, "DELETE FROM sessions WHERE expires_at < NOW()")
+	if err != nil {
+		return 0, err
+	}
+	if _, err := d.conn.Exec(ctx, "DELETE FROM login_challenges WHERE expires_at < NOW()"); err != nil {
+		return tag.RowsAffected(), err
+	}
+	if _, err := d.conn.Exec(ctx, "DELETE FROM webauthn_ceremonies WHERE expires_at < NOW()"); err != nil {
+		return tag.RowsAffected(), err
+	}
+	if _, err := d.conn.Exec(ctx, "DELETE FROM oidc_states WHERE expires_at < NOW()"); err != nil {
+		return tag.RowsAffected(), err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// Two-factor queries
+
+// SetPendingTOTPSecret stores a secret for an enrollment that is not yet verified
+func (d *DB) SetPendingTOTPSecret(ctx context.Context, userID int, secret string) error {
+	_, err := d.conn.Exec(
 		ctx,
-		"INSERT INTO users (username, password_hash) VALUES ($1, $2) RETURNING id",
-		username, passwordHash,
-	).Scan(&id)
-	return id, err
+		"UPDATE users SET totp_secret = $2, totp_enabled = FALSE, totp_last_step = 0, updated_at = NOW() WHERE id = $1",
+		userID, secret,
+	)
+	return err
+}
+
+// EnableTOTP turns on two-factor and replaces the user's recovery codes
+func (d *DB) EnableTOTP(ctx context.Context, userID int, step int64, recoveryCodeHashes []string) error {
+	tx, err := d.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "UPDATE users SET totp_enabled = TRUE, totp_last_step = $2, updated_at = NOW() WHERE id = $1", userID, step); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	for _, hash := range recoveryCodeHashes {
+		if _, err := tx.Exec(ctx, "INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)", userID, hash); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (d *DB) DisableTOTP(ctx context.Context, userID int) error {
+	tx, err := d.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "UPDATE users SET totp_enabled = FALSE, totp_secret = NULL, totp_last_step = 0, updated_at = NOW() WHERE id = $1", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// AdvanceTOTPStep records the last accepted time step, refusing replays.
+// It reports false when the step was already used.
+func (d *DB) AdvanceTOTPStep(ctx context.Context, userID int, step int64) (bool, error) {
+	tag, err := d.conn.Exec(
+		ctx,
+		"UPDATE users SET totp_last_step = $2 WHERE id = $1 AND totp_last_step < $2",
+		userID, step,
+	)
+	return tag.RowsAffected() > 0, err
+}
+
+// UseRecoveryCode marks an unused recovery code as spent, reporting whether one matched
+func (d *DB) UseRecoveryCode(ctx context.Context, userID int, codeHash string) (bool, error) {
+	tag, err := d.conn.Exec(
+		ctx,
+		`UPDATE recovery_codes SET used_at = NOW()
+		 WHERE id = (SELECT id FROM recovery_codes WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL LIMIT 1)`,
+		userID, codeHash,
+	)
+	return tag.RowsAffected() > 0, err
 }
 
-func (d *DB) CreateSession(ctx context.Context, userID int, token string, expiresAt string) error {
-	_, err := d.pool.Exec(
+func (d *DB) CountRecoveryCodes(ctx context.Context, userID int) (int, error) {
+	var count int
+	err := d.conn.QueryRow(ctx, "SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL", userID).Scan(&count)
+	return count, err
+}
+
+func (d *DB) CreateLoginChallenge(ctx context.Context, userID int, token string, ttl time.Duration) error {
+	_, err := d.conn.Exec(
 		ctx,
-		"INSERT INTO sessions (user_id, token, expires_at) VALUES ($1, $2, $3)",
-		userID, token, expiresAt,
+		"INSERT INTO login_challenges (user_id, token, expires_at) VALUES ($1, $2, NOW() + make_interval(secs => $3))",
+		userID, token, ttl.Seconds(),
 	)
 	return err
 }
 
-func (d *DB) GetSessionByToken(ctx context.Context, token string) (int, error) {
+func (d *DB) GetLoginChallenge(ctx context.Context, token string) (int, error) {
 	var userID int
-	err := d.pool.QueryRow(
+	err := d.conn.QueryRow(
 		ctx,
-		"SELECT user_id FROM sessions WHERE token = $1 AND expires_at > NOW()",
+		"SELECT user_id FROM login_challenges WHERE token = $1 AND expires_at > NOW()",
 		token,
 	).Scan(&userID)
-	return userID, err
+	return userID, notFound(err, ErrNotFound)
 }
 
-func (d *DB) DeleteSession(ctx context.Context, token string) error {
-	_, err := d.pool.Exec(ctx, "DELETE FROM sessions WHERE token = $1", token)
+func (d *DB) DeleteLoginChallenge(ctx context.Context, token string) error {
+	_, err := d.conn.Exec(ctx, "DELETE FROM login_challenges WHERE token = $1 OR expires_at < NOW()", token)
+	return err
+}
+
+// Passkey queries
+
+func (d *DB) ListPasskeys(ctx context.Context, userID int) ([]models.Passkey, error) {
+	rows, err := d.conn.Query(
+		ctx,
+		`SELECT id, user_id, name, credential_id, credential_json::text, created_at, last_used_at
+		 FROM webauthn_credentials WHERE user_id = $1 ORDER BY created_at`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	passkeys := []models.Passkey{}
+	for rows.Next() {
+		var p models.Passkey
+		if err := rows.Scan(&p.ID, &p.UserID, &p.Name, &p.CredentialID, &p.CredentialJSON, &p.CreatedAt, &p.LastUsedAt); err != nil {
+			return nil, err
+		}
+		passkeys = append(passkeys, p)
+	}
+	return passkeys, rows.Err()
+}
+
+func (d *DB) CreatePasskey(ctx context.Context, userID int, name string, credentialID []byte, credentialJSON string) error {
+	_, err := d.conn.Exec(
+		ctx,
+		"INSERT INTO webauthn_credentials (user_id, name, credential_id, credential_json) VALUES ($1, $2, $3, $4)",
+		userID, name, credentialID, credentialJSON,
+	)
+	return err
+}
+
+// TouchPasskey stores the updated credential (sign count, flags) after a login
+func (d *DB) TouchPasskey(ctx context.Context, credentialID []byte, credentialJSON string) error {
+	_, err := d.conn.Exec(
+		ctx,
+		"UPDATE webauthn_credentials SET credential_json = $2, last_used_at = NOW() WHERE credential_id = $1",
+		credentialID, credentialJSON,
+	)
+	return err
+}
+
+func (d *DB) RenamePasskey(ctx context.Context, userID, id int, name string) (bool, error) {
+	tag, err := d.conn.Exec(ctx, "UPDATE webauthn_credentials SET name = $3 WHERE id = $2 AND user_id = $1", userID, id, name)
+	return tag.RowsAffected() > 0, err
+}
+
+func (d *DB) DeletePasskey(ctx context.Context, userID, id int) (bool, error) {
+	tag, err := d.conn.Exec(ctx, "DELETE FROM webauthn_credentials WHERE id = $2 AND user_id = $1", userID, id)
+	return tag.RowsAffected() > 0, err
+}
+
+// SaveWebAuthnCeremony stores ceremony state; userID is nil for discoverable logins
+func (d *DB) SaveWebAuthnCeremony(ctx context.Context, token string, userID *int, sessionJSON string, ttl time.Duration) error {
+	_, err := d.conn.Exec(
+		ctx,
+		"INSERT INTO webauthn_ceremonies (token, user_id, session_json, expires_at) VALUES ($1, $2, $3, NOW() + make_interval(secs => $4))",
+		token, userID, sessionJSON, ttl.Seconds(),
+	)
+	return err
+}
+
+// TakeWebAuthnCeremony returns and deletes an unexpired ceremony so it can only be finished once
+func (d *DB) TakeWebAuthnCeremony(ctx context.Context, token string) (*int, string, error) {
+	var userID *int
+	var sessionJSON string
+	err := d.conn.QueryRow(
+		ctx,
+		"DELETE FROM webauthn_ceremonies WHERE token = $1 AND expires_at > NOW() RETURNING user_id, session_json::text",
+		token,
+	).Scan(&userID, &sessionJSON)
+	return userID, sessionJSON, notFound(err, ErrNotFound)
+}
+
+// OIDC queries
+
+// SaveOIDCState stores a pending authorization-code flow; userID is set
+// when an existing account is being linked
+func (d *DB) SaveOIDCState(ctx context.Context, state, nonce, codeVerifier string, userID *int, returnTo string, ttl time.Duration) error {
+	_, err := d.conn.Exec(
+		ctx,
+		"INSERT INTO oidc_states (state, nonce, code_verifier, user_id, return_to, expires_at) VALUES ($1, $2, $3, $4, $5, NOW() + make_interval(secs => $6))",
+		state, nonce, codeVerifier, userID, returnTo, ttl.Seconds(),
+	)
+	return err
+}
+
+// TakeOIDCState returns and deletes an unexpired flow so a callback can only
+// be used once. Unknown and expired states return ErrNotFound.
+func (d *DB) TakeOIDCState(ctx context.Context, state string) (*models.OIDCState, error) {
+	var s models.OIDCState
+	err := d.conn.QueryRow(
+		ctx,
+		"DELETE FROM oidc_states WHERE state = $1 AND expires_at > NOW() RETURNING nonce, code_verifier, user_id, return_to",
+		state,
+	).Scan(&s.Nonce, &s.CodeVerifier, &s.UserID, &s.ReturnTo)
+	if err != nil {
+		return nil, notFound(err, ErrNotFound)
+	}
+	return &s, nil
+}
+
+// LinkOIDCIdentity binds an OIDC identity to an existing user
+func (d *DB) LinkOIDCIdentity(ctx context.Context, userID int, issuer, subject string) error {
+	_, err := d.conn.Exec(
+		ctx,
+		"UPDATE users SET oidc_issuer = $2, oidc_subject = $3, updated_at = NOW() WHERE id = $1",
+		userID, issuer, subject,
+	)
+	return err
+}
+
+// API token queries
+
+// apiTokenColumns is the column list scanAPIToken expects
+const apiTokenColumns = "id, user_id, name, token_prefix, scopes, expires_at, last_used_at, created_at"
+
+func scanAPIToken(row row) (*models.APIToken, error) {
+	var t models.APIToken
+	if err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.Prefix, &t.Scopes, &t.ExpiresAt, &t.LastUsedAt, &t.CreatedAt); err != nil {
+		return nil, notFound(err, ErrAPITokenNotFound)
+	}
+	return &t, nil
+}
+
+// CreateAPIToken stores a token hash; a zero ttl means the token never expires
+func (d *DB) CreateAPIToken(ctx context.Context, userID int, name, tokenHash, prefix string, scopes []string, ttl time.Duration) (*models.APIToken, error) {
+	return scanAPIToken(d.conn.QueryRow(
+		ctx,
+		`INSERT INTO api_tokens (user_id, name, token_hash, token_prefix, scopes, expires_at)
+		 VALUES ($1, $2, $3, $4, $5, CASE WHEN $6::float8 > 0 THEN NOW() + make_interval(secs => $6) END)
+		 RETURNING `+apiTokenColumns,
+		userID, name, tokenHash, prefix, scopes, ttl.Seconds(),
+	))
+}
+
+// GetAPITokenByHash returns an unexpired token
+func (d *DB) GetAPITokenByHash(ctx context.Context, tokenHash string) (*models.APIToken, error) {
+	return scanAPIToken(d.conn.QueryRow(
+		ctx,
+		"SELECT "+apiTokenColumns+" FROM api_tokens WHERE token_hash = $1 AND (expires_at IS NULL OR expires_at > NOW())",
+		tokenHash,
+	))
+}
+
+// TouchAPIToken records use, writing at most once a minute per token
+func (d *DB) TouchAPIToken(ctx context.Context, id int) error {
+	_, err := d.conn.Exec(
+		ctx,
+		`UPDATE api_tokens SET last_used_at = NOW()
+		 WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`,
+		id,
+	)
+	return err
+}
+
+func (d *DB) ListAPITokens(ctx context.Context, userID int) ([]models.APIToken, error) {
+	rows, err := d.conn.Query(ctx, "SELECT "+apiTokenColumns+" FROM api_tokens WHERE user_id = $1 ORDER BY created_at", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []models.APIToken{}
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *token)
+	}
+	return tokens, rows.Err()
+}
+
+// DeleteAPIToken revokes one of a user's tokens, reporting whether it existed
+func (d *DB) DeleteAPIToken(ctx context.Context, userID, id int) (bool, error) {
+	tag, err := d.conn.Exec(ctx, "DELETE FROM api_tokens WHERE id = $1 AND user_id = $2", id, userID)
+	return tag.RowsAffected() > 0, err
+}
+
+// Login lockout queries
+
+// RecordLoginFailure bumps the failure counter for a subject and returns the
+// new count. The counter restarts when the previous failure is older than window.
+func (d *DB) RecordLoginFailure(ctx context.Context, scope, subject string, window time.Duration) (int, error) {
+	var failures int
+	err := d.conn.QueryRow(
+		ctx,
+		`INSERT INTO login_lockouts (scope, subject, failures, last_failure_at)
+		 VALUES ($1, $2, 1, NOW())
+		 ON CONFLICT (scope, subject) DO UPDATE SET
+		   failures = CASE
+		     WHEN login_lockouts.last_failure_at < NOW() - make_interval(secs => $3) THEN 1
+		     ELSE login_lockouts.failures + 1
+		   END,
+		   last_failure_at = NOW()
+		 RETURNING failures`,
+		scope, subject, window.Seconds(),
+	).Scan(&failures)
+	return failures, err
+}
+
+func (d *DB) LockLogin(ctx context.Context, scope, subject string, duration time.Duration) error {
+	_, err := d.conn.Exec(
+		ctx,
+		"UPDATE login_lockouts SET locked_until = NOW() + make_interval(secs => $3) WHERE scope = $1 AND subject = $2",
+		scope, subject, duration.Seconds(),
+	)
+	return err
+}
+
+// LoginLockedFor returns how much longer a subject is locked out, or zero
+func (d *DB) LoginLockedFor(ctx context.Context, scope, subject string) (time.Duration, error) {
+	var seconds float64
+	err := d.conn.QueryRow(
+		ctx,
+		`SELECT COALESCE(EXTRACT(EPOCH FROM (MAX(locked_until) - NOW())), 0)::float8
+		 FROM login_lockouts WHERE scope = $1 AND subject = $2 AND locked_until > NOW()`,
+		scope, subject,
+	).Scan(&seconds)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+func (d *DB) ClearLoginFailures(ctx context.Context, scope, subject string) error {
+	_, err := d.conn.Exec(ctx, "DELETE FROM login_lockouts WHERE scope = $1 AND subject = $2", scope, subject)
 	return err
 }
 
+func (d *DB) ListLoginLockouts(ctx context.Context) ([]models.LoginLockout, error) {
+	rows, err := d.conn.Query(
+		ctx,
+		`SELECT id, scope, subject, failures, last_failure_at, locked_until, COALESCE(locked_until > NOW(), false)
+		 FROM login_lockouts ORDER BY last_failure_at DESC`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lockouts := []models.LoginLockout{}
+	for rows.Next() {
+		var l models.LoginLockout
+		if err := rows.Scan(&l.ID, &l.Scope, &l.Subject, &l.Failures, &l.LastFailureAt, &l.LockedUntil, &l.Locked); err != nil {
+			return nil, err
+		}
+		lockouts = append(lockouts, l)
+	}
+	return lockouts, rows.Err()
+}
+
+// DeleteLoginLockout clears one lockout row, reporting whether it existed
+func (d *DB) DeleteLoginLockout(ctx context.Context, id int) (bool, error) {
+	tag, err := d.conn.Exec(ctx, "DELETE FROM login_lockouts WHERE id = $1", id)
+	return tag.RowsAffected() > 0, err
+}
+
+func (d *DB) DeleteAllLoginLockouts(ctx context.Context) (int64, error) {
+	tag, err := d.conn.Exec(ctx, "DELETE FROM login_lockouts")
+	return tag.RowsAffected(), err
+}
+
 // Widget data queries
 func (d *DB) SaveWidgetData(ctx context.Context, userID int, widgetName, widgetKey, valueJSON string) error {
-	_, err := d.pool.Exec(
+	_, err := d.conn.Exec(
 		ctx,
 		`INSERT INTO widget_data (user_id, widget_name, widget_key, value_json)
 		 VALUES ($1, $2, $3, $4)
@@ -127,17 +729,75 @@ func (d *DB) SaveWidgetData(ctx context.Context, userID int, widgetName, widgetK
 
 func (d *DB) GetWidgetData(ctx context.Context, userID int, widgetName, widgetKey string) (string, error) {
 	var value string
-	err := d.pool.QueryRow(
+	err := d.conn.QueryRow(
 		ctx,
 		"SELECT value_json FROM widget_data WHERE user_id = $1 AND widget_name = $2 AND widget_key = $3",
 		userID, widgetName, widgetKey,
 	).Scan(&value)
-	return value, err
+	return value, notFound(err, ErrWidgetDataNotFound)
+}
+
+// Setup queries
+
+// IsSetupComplete reports whether the first admin has been created
+func (d *DB) IsSetupComplete(ctx context.Context) (bool, error) {
+	var done bool
+	err := d.conn.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM system_state WHERE key = 'setup_completed')").Scan(&done)
+	return done, err
+}
+
+// MarkSetupComplete closes the setup wizard without creating an account, for
+// installs whose first admin was created some other way
+func (d *DB) MarkSetupComplete(ctx context.Context) error {
+	_, err := d.conn.Exec(ctx, "INSERT INTO system_state (key, value) VALUES ('setup_completed', 'true') ON CONFLICT (key) DO NOTHING")
+	return err
+}
+
+// CompleteSetup marks setup as done and creates the first admin in one
+// transaction. It reports false, creating nothing, if setup already ran or
+// any user exists; setup is marked done either way.
+func (d *DB) CompleteSetup(ctx context.Context, username, passwordHash string) (int, bool, error) {
+	tx, err := d.conn.Begin(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, "INSERT INTO system_state (key, value) VALUES ('setup_completed', 'true') ON CONFLICT (key) DO NOTHING")
+	if err != nil {
+		return 0, false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return 0, false, nil
+	}
+
+	var hasUsers bool
+	if err := tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM users)").Scan(&hasUsers); err != nil {
+		return 0, false, err
+	}
+	if hasUsers {
+		return 0, false, tx.Commit(ctx)
+	}
+
+	var id int
+	err = tx.QueryRow(
+		ctx,
+		"INSERT INTO users (username, password_hash, role, auth_backend) VALUES ($1, $2, 'admin', $3) RETURNING id",
+		username, passwordHash, models.AuthBackendLocal,
+	).Scan(&id)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, false, err
+	}
+	return id, true, nil
 }
 
 // Settings queries
 func (d *DB) SaveSetting(ctx context.Context, userID int, key, value string) error {
-	_, err := d.pool.Exec(
+	_, err := d.conn.Exec(
 		ctx,
 		`INSERT INTO settings (user_id, setting_key, setting_value)
 		 VALUES ($1, $2, $3)
@@ -149,10 +809,272 @@ func (d *DB) SaveSetting(ctx context.Context, userID int, key, value string) err
 
 func (d *DB) GetSetting(ctx context.Context, userID int, key string) (string, error) {
 	var value string
-	err := d.pool.QueryRow(
+	err := d.conn.QueryRow(
 		ctx,
 		"SELECT setting_value FROM settings WHERE user_id = $1 AND setting_key = $2",
 		userID, key,
 	).Scan(&value)
-	return value, err
+	return value, notFound(err, ErrSettingNotFound)
+}
+
+// Audit queries
+
+// AuditFilter narrows ListAuditEvents; zero fields match everything
+type AuditFilter struct {
+	Action     string // prefix, so "auth." matches every auth event
+	Actor      string
+	Outcome    string
+	TargetType string
+	TargetID   string
+	ClientIP   string
+	Since      time.Time
+	Until      time.Time
+	BeforeID   int64 // page backwards from this event ID
+	Limit      int
+}
+
+// auditEventColumns is the column list scanAuditEvent expects
+const auditEventColumns = "id, occurred_at, action, outcome, actor_id, actor_name, client_ip, target_type, target_id, before_value, after_value, details"
+
+func scanAuditEvent(row row) (*models.AuditEvent, error) {
+	var e models.AuditEvent
+	var before, after, details []byte
+	err := row.Scan(
+		&e.ID, &e.OccurredAt, &e.Action, &e.Outcome, &e.ActorID, &e.ActorName, &e.ClientIP,
+		&e.TargetType, &e.TargetID, &before, &after, &details,
+	)
+	if err != nil {
+		return nil, err
+	}
+	e.Before, e.After, e.Details = before, after, details
+	return &e, nil
+}
+
+// InsertAuditEvent stores an event; JSON fields may be nil
+func (d *DB) InsertAuditEvent(ctx context.Context, e *models.AuditEvent) error {
+	_, err := d.conn.Exec(
+		ctx,
+		`INSERT INTO audit_events (action, outcome, actor_id, actor_name, client_ip, target_type, target_id, before_value, after_value, details)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		e.Action, e.Outcome, e.ActorID, e.ActorName, e.ClientIP, e.TargetType, e.TargetID,
+		nullJSON(e.Before), nullJSON(e.After), nullJSON(e.Details),
+	)
+	return err
+}
+
+// ListAuditEvents returns matching events, newest first
+func (d *DB) ListAuditEvents(ctx context.Context, f AuditFilter) ([]models.AuditEvent, error) {
+	var where []string
+	var args []any
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+
+	if f.Action != "" {
+		add("starts_with(action, $%d)", f.Action)
+	}
+	if f.Actor != "" {
+		add("LOWER(actor_name) = LOWER($%d)", f.Actor)
+	}
+	if f.Outcome != "" {
+		add("outcome = $%d", f.Outcome)
+	}
+	if f.TargetType != "" {
+		add("target_type = $%d", f.TargetType)
+	}
+	if f.TargetID != "" {
+		add("target_id = $%d", f.TargetID)
+	}
+	if f.ClientIP != "" {
+		add("client_ip = $%d", f.ClientIP)
+	}
+	if !f.Since.IsZero() {
+		add("occurred_at >= $%d", f.Since)
+	}
+	if !f.Until.IsZero() {
+		add("occurred_at < $%d", f.Until)
+	}
+	if f.BeforeID > 0 {
+		add("id < $%d", f.BeforeID)
+	}
+
+	query := "SELECT " + auditEventColumns + " FROM audit_events"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	args = append(args, f.Limit)
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT $%d", len(args))
+
+	rows, err := d.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.AuditEvent{}
+	for rows.Next() {
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, *e)
+	}
+	return events, rows.Err()
+}
+
+// PurgeAuditEvents deletes events older than maxAge
+func (d *DB) PurgeAuditEvents(ctx context.Context, maxAge time.Duration) (int64, error) {
+	tag, err := d.conn.Exec(ctx, "DELETE FROM audit_events WHERE occurred_at < NOW() - make_interval(secs => $1)", maxAge.Seconds())
+	return tag.RowsAffected(), err
+}
+
+// nullJSON stores an empty document as SQL NULL
+func nullJSON(b []byte) any {
+	if len(b) == 0 {
+		return nil
+	}
+	return string(b)
+}
+
+// InsertMetricSamples stores one poll's values. A second sample for the same
+// metric and second is ignored.
+func (d *DB) InsertMetricSamples(ctx context.Context, at time.Time, values map[string]float64) error {
+	if len(values) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	at = at.UTC().Truncate(time.Second)
+	rows := make([]string, 0, len(names))
+	args := []any{at}
+	for _, name := range names {
+		args = append(args, name, values[name])
+		rows = append(rows, fmt.Sprintf("($%d, $1, $%d)", len(args)-1, len(args)))
+	}
+
+	_, err := d.conn.Exec(
+		ctx,
+		"INSERT INTO metric_samples (metric, sampled_at, value) VALUES "+strings.Join(rows, ", ")+
+			" ON CONFLICT (metric, sampled_at) DO NOTHING",
+		args...,
+	)
+	return err
+}
+
+// UpsertMetricRollups writes rollups at resolution, replacing any bucket that
+// was already rolled up
+func (d *DB) UpsertMetricRollups(ctx context.Context, resolution time.Duration, points []models.MetricPoint) error {
+	tx, err := d.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	for _, p := range points {
+		if _, err := tx.Exec(
+			ctx,
+			`INSERT INTO metric_rollups (resolution, metric, bucket, avg_value, min_value, max_value, sample_count)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7)
+			 ON CONFLICT (resolution, metric, bucket)
+			 DO UPDATE SET avg_value = EXCLUDED.avg_value, min_value = EXCLUDED.min_value,
+			               max_value = EXCLUDED.max_value, sample_count = EXCLUDED.sample_count`,
+			int(resolution.Seconds()), p.Metric, p.Time.UTC(), p.Avg, p.Min, p.Max, p.Count,
+		); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// ListMetricPoints returns the samples (resolution 0) or rollups in
+// [from, to), oldest first. An empty metric lists every metric.
+func (d *DB) ListMetricPoints(ctx context.Context, resolution time.Duration, metric string, from, to time.Time) ([]models.MetricPoint, error) {
+	var query string
+	args := []any{from.UTC(), to.UTC()}
+	if resolution == 0 {
+		query = "SELECT metric, sampled_at, value, value, value, 1 FROM metric_samples WHERE sampled_at >= $1 AND sampled_at < $2"
+	} else {
+		args = append(args, int(resolution.Seconds()))
+		query = `SELECT metric, bucket, avg_value, min_value, max_value, sample_count FROM metric_rollups
+		 WHERE bucket >= $1 AND bucket < $2 AND resolution = $3`
+	}
+	if metric != "" {
+		args = append(args, metric)
+		query += fmt.Sprintf(" AND metric = $%d", len(args))
+	}
+	if resolution == 0 {
+		query += " ORDER BY sampled_at, metric"
+	} else {
+		query += " ORDER BY bucket, metric"
+	}
+
+	rows, err := d.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var points []models.MetricPoint
+	for rows.Next() {
+		var p models.MetricPoint
+		if err := rows.Scan(&p.Metric, &p.Time, &p.Avg, &p.Min, &p.Max, &p.Count); err != nil {
+			return nil, err
+		}
+		p.Time = p.Time.UTC()
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+// LatestMetricRollup returns the newest bucket rolled up at resolution, or
+// the zero time if there is none
+func (d *DB) LatestMetricRollup(ctx context.Context, resolution time.Duration) (time.Time, error) {
+	var latest *time.Time
+	err := d.conn.QueryRow(ctx, "SELECT MAX(bucket) FROM metric_rollups WHERE resolution = $1", int(resolution.Seconds())).Scan(&latest)
+	if err != nil || latest == nil {
+		return time.Time{}, err
+	}
+	return latest.UTC(), nil
+}
+
+// PurgeMetricPoints deletes samples (resolution 0) or rollups older than
+// before
+func (d *DB) PurgeMetricPoints(ctx context.Context, resolution time.Duration, before time.Time) (int64, error) {
+	var tag commandTag
+	var err error
+	if resolution == 0 {
+		tag, err = d.conn.Exec(ctx, "DELETE FROM metric_samples WHERE sampled_at < $1", before.UTC())
+	} else {
+		tag, err = d.conn.Exec(ctx, "DELETE FROM metric_rollups WHERE resolution = $1 AND bucket < $2", int(resolution.Seconds()), before.UTC())
+	}
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// ListMetricNames returns every metric with stored history, sorted
+func (d *DB) ListMetricNames(ctx context.Context) ([]string, error) {
+	rows, err := d.conn.Query(ctx, "SELECT metric FROM metric_samples UNION SELECT metric FROM metric_rollups ORDER BY metric")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
 }
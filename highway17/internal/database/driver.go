@@ -0,0 +1,109 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Dialect names the SQL database behind a DB
+type Dialect string
+
+const (
+	Postgres Dialect = "postgres"
+	SQLite   Dialect = "sqlite"
+)
+
+// ParseDialect picks the backend from the DATABASE_URL scheme:
+// postgres:// or postgresql:// for Postgres, sqlite: for an SQLite file.
+// Postgres keyword/value strings ("host=... dbname=...") are accepted too.
+func ParseDialect(databaseURL string) (Dialect, error) {
+	scheme, _, ok := strings.Cut(databaseURL, ":")
+	switch strings.ToLower(scheme) {
+	case "postgres", "postgresql":
+		return Postgres, nil
+	case "sqlite", "sqlite3":
+		return SQLite, nil
+	}
+	if ok && strings.Contains(databaseURL, "://") {
+		return "", fmt.Errorf("unsupported DATABASE_URL scheme %q: want postgres:// or sqlite:", scheme)
+	}
+	return Postgres, nil
+}
+
+// conn is the query interface every method in this package uses. Queries are
+// written for Postgres with $n placeholders; the SQLite driver translates the
+// few Postgres-only constructs they use (see sqliteQuery).
+type conn interface {
+	Exec(ctx context.Context, sql string, args ...any) (commandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) row
+	Begin(ctx context.Context) (tx, error)
+}
+
+type tx interface {
+	conn
+	Commit(ctx context.Context) error
+	Rollback(ctx context.Context) error
+}
+
+// commandTag, rows and row are the parts of pgconn.CommandTag, pgx.Rows and
+// pgx.Row the queries need
+type commandTag interface {
+	RowsAffected() int64
+}
+
+type rows interface {
+	Next() bool
+	Scan(dest ...any) error
+	Err() error
+	Close()
+}
+
+type row interface {
+	Scan(dest ...any) error
+}
+
+// pgxQuerier is implemented by *pgxpool.Pool, *pgxpool.Conn and pgx.Tx
+type pgxQuerier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// pgxConn adapts pgx to conn without changing any query
+type pgxConn struct {
+	q pgxQuerier
+}
+
+func (c pgxConn) Exec(ctx context.Context, sql string, args ...any) (commandTag, error) {
+	return c.q.Exec(ctx, sql, args...)
+}
+
+func (c pgxConn) Query(ctx context.Context, sql string, args ...any) (rows, error) {
+	return c.q.Query(ctx, sql, args...)
+}
+
+func (c pgxConn) QueryRow(ctx context.Context, sql string, args ...any) row {
+	return c.q.QueryRow(ctx, sql, args...)
+}
+
+func (c pgxConn) Begin(ctx context.Context) (tx, error) {
+	t, err := c.q.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return pgxTx{pgxConn{t}, t}, nil
+}
+
+type pgxTx struct {
+	pgxConn
+	tx pgx.Tx
+}
+
+func (t pgxTx) Commit(ctx context.Context) error   { return t.tx.Commit(ctx) }
+func (t pgxTx) Rollback(ctx context.Context) error { return t.tx.Rollback(ctx) }
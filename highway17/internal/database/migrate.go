@@ -0,0 +1,413 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"citadel/highway17/migrations"
+)
+
+const (
+	// migrationLockKey is the pg_advisory_lock key held while migrating, so
+	// two instances starting together never apply the same migration
+	migrationLockKey int64 = 1_717_170_017
+
+	upMarker   = "-- migrate:up"
+	downMarker = "-- migrate:down"
+)
+
+// Migration states reported by MigrationStatus
+const (
+	MigrationApplied  = "applied"
+	MigrationPending  = "pending"
+	MigrationModified = "modified" // applied, but the file changed since
+	MigrationMissing  = "missing"  // applied, but not known to this build
+)
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.sql$`)
+
+const createMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version BIGINT PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    checksum VARCHAR(64) NOT NULL,
+    applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    duration_ms INTEGER NOT NULL DEFAULT 0
+)`
+
+// Migration is one versioned schema change
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// MigrationStatus describes a migration known to the build, the database,
+// or both
+type MigrationStatus struct {
+	Version   int64      `json:"version"`
+	Name      string     `json:"name"`
+	State     string     `json:"state"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+type appliedMigration struct {
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+// LoadMigrations reads NNN_name.sql files from fsys in version order. Each
+// file must have a "-- migrate:up" section; the "-- migrate:down" section
+// may be empty for migrations that cannot be reversed.
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list migrations: %w", err)
+	}
+
+	var list []Migration
+	seen := make(map[int64]string)
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migration %s: name must look like 012_add_widgets.sql", entry.Name())
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %w", entry.Name(), err)
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", other, entry.Name(), version)
+		}
+		seen[version] = entry.Name()
+
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+		up, down, err := splitMigration(string(content))
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %w", entry.Name(), err)
+		}
+
+		sum := sha256.Sum256(content)
+		list = append(list, Migration{
+			Version:  version,
+			Name:     match[2],
+			Up:       up,
+			Down:     down,
+			Checksum: hex.EncodeToString(sum[:]),
+		})
+	}
+
+	if len(list) == 0 {
+		return nil, fmt.Errorf("no migrations found")
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// splitMigration separates the up and down sections at their marker lines
+func splitMigration(content string) (up, down string, err error) {
+	var section *strings.Builder
+	var upSQL, downSQL strings.Builder
+	var sawUp bool
+
+	for _, line := range strings.SplitAfter(content, "\n") {
+		switch strings.TrimSpace(line) {
+		case upMarker:
+			if sawUp {
+				return "", "", fmt.Errorf("%q must appear once, before %q", upMarker, downMarker)
+			}
+			sawUp = true
+			section = &upSQL
+			continue
+		case downMarker:
+			if section != &upSQL {
+				return "", "", fmt.Errorf("%q must follow %q", downMarker, upMarker)
+			}
+			section = &downSQL
+			continue
+		}
+		if section != nil {
+			section.WriteString(line)
+		}
+	}
+
+	if !sawUp || strings.TrimSpace(upSQL.String()) == "" {
+		return "", "", fmt.Errorf("missing %q section", upMarker)
+	}
+	return upSQL.String(), downSQL.String(), nil
+}
+
+// Migrate applies every pending migration in version order and returns the
+// ones it applied. It refuses to run if an applied migration was edited or
+// is unknown to this build.
+func (d *DB) Migrate(ctx context.Context) ([]Migration, error) {
+	list, err := d.loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	err = d.withMigrationLock(ctx, func(c conn) error {
+		history, err := migrationHistory(ctx, c)
+		if err != nil {
+			return err
+		}
+		if err := verifyMigrations(list, history); err != nil {
+			return err
+		}
+
+		for _, m := range list {
+			if _, ok := history[m.Version]; ok {
+				continue
+			}
+			if err := runMigration(ctx, c, m, true); err != nil {
+				return err
+			}
+			applied = append(applied, m)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// MigrateDown reverts the most recently applied migrations, newest first,
+// and returns the ones it reverted
+func (d *DB) MigrateDown(ctx context.Context, steps int) ([]Migration, error) {
+	list, err := d.loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int64]Migration, len(list))
+	for _, m := range list {
+		byVersion[m.Version] = m
+	}
+
+	var reverted []Migration
+	err = d.withMigrationLock(ctx, func(c conn) error {
+		history, err := migrationHistory(ctx, c)
+		if err != nil {
+			return err
+		}
+		if err := verifyMigrations(list, history); err != nil {
+			return err
+		}
+
+		versions := make([]int64, 0, len(history))
+		for version := range history {
+			versions = append(versions, version)
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+
+		for _, version := range versions[:min(steps, len(versions))] {
+			m := byVersion[version]
+			if strings.TrimSpace(m.Down) == "" {
+				return fmt.Errorf("migration %03d_%s has no %q section", m.Version, m.Name, downMarker)
+			}
+			if err := runMigration(ctx, c, m, false); err != nil {
+				return err
+			}
+			reverted = append(reverted, m)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// MigrationStatus lists every migration the build or the database knows
+// about, in version order
+func (d *DB) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	list, err := d.loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	// Status is read-only: a database that was never migrated has no history
+	history := make(map[int64]appliedMigration)
+	exists := "SELECT to_regclass('schema_migrations') IS NOT NULL"
+	if d.dialect == SQLite {
+		exists = "SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations')"
+	}
+	var migrated bool
+	if err := d.conn.QueryRow(ctx, exists).Scan(&migrated); err != nil {
+		return nil, fmt.Errorf("failed to check for schema_migrations: %w", err)
+	}
+	if migrated {
+		if history, err = migrationHistory(ctx, d.conn); err != nil {
+			return nil, err
+		}
+	}
+
+	var statuses []MigrationStatus
+	for _, m := range list {
+		status := MigrationStatus{Version: m.Version, Name: m.Name, State: MigrationPending}
+		if h, ok := history[m.Version]; ok {
+			status.State = MigrationApplied
+			if h.checksum != m.Checksum {
+				status.State = MigrationModified
+			}
+			status.AppliedAt = &h.appliedAt
+			delete(history, m.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for version, h := range history {
+		statuses = append(statuses, MigrationStatus{Version: version, Name: h.name, State: MigrationMissing, AppliedAt: &h.appliedAt})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+
+	return statuses, nil
+}
+
+// loadMigrations reads the embedded migrations for the DB's dialect. Both
+// dialects must define the same versions, so a schema change is never
+// shipped for one backend only.
+func (d *DB) loadMigrations() ([]Migration, error) {
+	postgres, err := LoadMigrations(migrations.FS)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := fs.Sub(migrations.FS, "sqlite")
+	if err != nil {
+		return nil, err
+	}
+	sqlite, err := LoadMigrations(sub)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: %w", err)
+	}
+
+	if len(postgres) != len(sqlite) {
+		return nil, fmt.Errorf("found %d postgres and %d sqlite migrations; every version needs both", len(postgres), len(sqlite))
+	}
+	for i := range postgres {
+		if postgres[i].Version != sqlite[i].Version || postgres[i].Name != sqlite[i].Name {
+			return nil, fmt.Errorf("migration %03d_%s has no sqlite/ counterpart (found %03d_%s)",
+				postgres[i].Version, postgres[i].Name, sqlite[i].Version, sqlite[i].Name)
+		}
+	}
+
+	if d.dialect == SQLite {
+		return sqlite, nil
+	}
+	return postgres, nil
+}
+
+// withMigrationLock runs fn on a dedicated connection holding the migration
+// advisory lock, waiting for any other instance to finish first. SQLite has
+// no advisory locks; its single connection and file lock serialise writers.
+func (d *DB) withMigrationLock(ctx context.Context, fn func(c conn) error) error {
+	if d.pool == nil {
+		if _, err := d.conn.Exec(ctx, createMigrationsTable); err != nil {
+			return fmt.Errorf("failed to create schema_migrations: %w", err)
+		}
+		return fn(d.conn)
+	}
+
+	pc, err := d.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer pc.Release()
+
+	if _, err := pc.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+		return fmt.Errorf("failed to take migration lock: %w", err)
+	}
+	defer pc.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockKey)
+
+	if _, err := pc.Exec(ctx, createMigrationsTable); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	return fn(pgxConn{pc})
+}
+
+// migrationHistory reads schema_migrations keyed by version
+func migrationHistory(ctx context.Context, c conn) (map[int64]appliedMigration, error) {
+	rows, err := c.Query(ctx, "SELECT version, name, checksum, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	history := make(map[int64]appliedMigration)
+	for rows.Next() {
+		var version int64
+		var h appliedMigration
+		if err := rows.Scan(&version, &h.name, &h.checksum, &h.appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan schema_migrations: %w", err)
+		}
+		history[version] = h
+	}
+	return history, rows.Err()
+}
+
+// verifyMigrations rejects histories that no longer match the embedded files
+func verifyMigrations(list []Migration, history map[int64]appliedMigration) error {
+	known := make(map[int64]bool, len(list))
+	for _, m := range list {
+		known[m.Version] = true
+		if h, ok := history[m.Version]; ok && h.checksum != m.Checksum {
+			return fmt.Errorf("migration %03d_%s was edited after it was applied (checksum %s, recorded %s); add a new migration instead",
+				m.Version, m.Name, m.Checksum[:12], h.checksum[:min(12, len(h.checksum))])
+		}
+	}
+	for version, h := range history {
+		if !known[version] {
+			return fmt.Errorf("database has migration %03d_%s, which this build does not know; run a newer build or migrate down with the build that added it", version, h.name)
+		}
+	}
+	return nil
+}
+
+// runMigration applies or reverts one migration and records it in a single
+// transaction. The SQL is sent without arguments, so pgx uses the simple
+// protocol (and SQLite runs each statement in turn): the file may hold any
+// number of statements.
+func runMigration(ctx context.Context, c conn, m Migration, up bool) error {
+	direction, sql := "up", m.Up
+	if !up {
+		direction, sql = "down", m.Down
+	}
+
+	tx, err := c.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin migration %03d_%s: %w", m.Version, m.Name, err)
+	}
+	defer tx.Rollback(ctx)
+
+	start := time.Now()
+	if _, err := tx.Exec(ctx, sql); err != nil {
+		return fmt.Errorf("migration %03d_%s %s failed: %w", m.Version, m.Name, direction, err)
+	}
+
+	if up {
+		_, err = tx.Exec(ctx,
+			"INSERT INTO schema_migrations (version, name, checksum, duration_ms) VALUES ($1, $2, $3, $4)",
+			m.Version, m.Name, m.Checksum, time.Since(start).Milliseconds())
+	} else {
+		_, err = tx.Exec(ctx, "DELETE FROM schema_migrations WHERE version = $1", m.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record migration %03d_%s: %w", m.Version, m.Name, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit migration %03d_%s: %w", m.Version, m.Name, err)
+	}
+	return nil
+}
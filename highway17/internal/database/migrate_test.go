@@ -0,0 +1,136 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"citadel/highway17/internal/models"
+)
+
+// migrateTo brings a fresh in-memory SQLite database up to version, so a
+// test can seed rows the way an older release wrote them
+func migrateTo(t *testing.T, version int64) *DB {
+	t.Helper()
+	ctx := context.Background()
+
+	d, err := New(ctx, "sqlite::memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(d.Close)
+
+	if _, err := d.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	list, err := d.loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := 0
+	for _, m := range list {
+		if m.Version > version {
+			steps++
+		}
+	}
+	if _, err := d.MigrateDown(ctx, steps); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// Before setup existed, any username signed in with the shared default
+// password, so upgraded password accounts must pick a new one
+func TestMigrateSetupForcesPasswordChange(t *testing.T) {
+	ctx := context.Background()
+	d := migrateTo(t, 9)
+
+	for _, stmt := range []string{
+		"INSERT INTO users (username, password_hash, role) VALUES ('alyx', 'hash-of-checkpoint', 'admin')",
+		"INSERT INTO users (username, tailscale_login) VALUES ('barney', 'barney@github')",
+	} {
+		if _, err := d.conn.Exec(ctx, stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := d.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		username   string
+		mustChange bool
+	}{
+		{"alyx", true},
+		{"barney", false},
+	}
+	for _, tt := range tests {
+		user, err := d.GetUserByUsername(ctx, tt.username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.MustChangePassword != tt.mustChange {
+			t.Errorf("%s must_change_password = %v, want %v", tt.username, user.MustChangePassword, tt.mustChange)
+		}
+	}
+
+	// Accounts created after the upgrade keep the flag they were given
+	id, err := d.CreateUser(ctx, "eli", "hash", "operator", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user, err := d.GetUserByID(ctx, id); err != nil || user.MustChangePassword {
+		t.Errorf("new user = %+v, %v; want no forced change", user, err)
+	}
+	if done, err := d.IsSetupComplete(ctx); err != nil || !done {
+		t.Errorf("IsSetupComplete = %v, %v; want an upgraded install to skip setup", done, err)
+	}
+}
+
+// Rows from before auth_backend existed are classified by the identity they
+// carry, so LDAP keeps working for the users it created
+func TestMigrateAuthBackendBackfill(t *testing.T) {
+	ctx := context.Background()
+	d := migrateTo(t, 13)
+
+	for _, stmt := range []string{
+		"INSERT INTO users (username, password_hash) VALUES ('alyx', 'hash')",
+		"INSERT INTO users (username, password_hash, oidc_issuer, oidc_subject) VALUES ('eli', 'hash', 'https://idp.example', 'sub-1')",
+		"INSERT INTO users (username, tailscale_login) VALUES ('barney', 'barney@github')",
+		"INSERT INTO users (username, oidc_issuer, oidc_subject) VALUES ('kleiner', 'https://idp.example', 'sub-2')",
+		"INSERT INTO users (username, role) VALUES ('gordon', 'operator')",
+	} {
+		if _, err := d.conn.Exec(ctx, stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := d.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		username, want string
+	}{
+		{"alyx", models.AuthBackendLocal},
+		{"eli", models.AuthBackendLocal},
+		{"barney", models.AuthBackendTailscale},
+		{"kleiner", models.AuthBackendOIDC},
+		{"gordon", models.AuthBackendLDAP},
+	}
+	for _, tt := range tests {
+		user, err := d.GetUserByUsername(ctx, tt.username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.AuthBackend != tt.want {
+			t.Errorf("%s auth_backend = %q, want %q", tt.username, user.AuthBackend, tt.want)
+		}
+	}
+
+	if user, err := d.UpsertDirectoryUser(ctx, models.AuthBackendLDAP, "gordon", "admin", true); err != nil || user.Role != "admin" {
+		t.Errorf("UpsertDirectoryUser(gordon) = %+v, %v; want the backfilled row synced", user, err)
+	}
+	if _, err := d.UpsertDirectoryUser(ctx, models.AuthBackendLDAP, "alyx", "admin", true); !errors.Is(err, ErrUserConflict) {
+		t.Errorf("UpsertDirectoryUser(alyx) = %v, want ErrUserConflict", err)
+	}
+}
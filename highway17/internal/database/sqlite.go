@@ -0,0 +1,302 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite" // pure-Go driver, registered as "sqlite"
+)
+
+// sqliteTimeFormat is what CURRENT_TIMESTAMP and datetime('now') produce.
+// Times are stored in UTC in this format so they compare correctly as text.
+const sqliteTimeFormat = "2006-01-02 15:04:05"
+
+// sqlitePragmas are applied to every connection
+var sqlitePragmas = []string{"foreign_keys(1)", "busy_timeout(5000)", "journal_mode(WAL)", "synchronous(NORMAL)"}
+
+// sqliteRewrites translate the Postgres-only constructs the queries use.
+// Order matters: the NOW() forms with intervals go before bare NOW().
+var sqliteRewrites = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`EXTRACT\(EPOCH FROM \((.+?) - NOW\(\)\)\)`), `((julianday($1) - julianday('now')) * 86400)`},
+	{regexp.MustCompile(`NOW\(\) ([+-]) make_interval\(secs => (\$\d+)\)`), `datetime('now', printf('%+f seconds', $1$2))`},
+	{regexp.MustCompile(`NOW\(\) ([+-]) INTERVAL '([^']+)'`), `datetime('now', '$1$2')`},
+	{regexp.MustCompile(`NOW\(\)`), `datetime('now')`},
+	{regexp.MustCompile(`\bLEAST\(`), `MIN(`},
+	{regexp.MustCompile(`starts_with\(([\w.]+), (\$\d+)\)`), `substr($1, 1, length($2)) = $2`},
+	{regexp.MustCompile(`::(text|float8|int|bigint)\b`), ``},
+}
+
+// sqliteQueries caches translated queries, keyed by the Postgres text
+var sqliteQueries sync.Map
+
+// sqliteQuery rewrites a Postgres query for SQLite. $n placeholders are
+// left alone: SQLite binds $1, $2, ... by position.
+func sqliteQuery(query string) string {
+	if translated, ok := sqliteQueries.Load(query); ok {
+		return translated.(string)
+	}
+	translated := query
+	for _, r := range sqliteRewrites {
+		translated = r.re.ReplaceAllString(translated, r.repl)
+	}
+	sqliteQueries.Store(query, translated)
+	return translated
+}
+
+// sqliteDSN turns sqlite:///var/lib/highway17/highway17.db (absolute),
+// sqlite://data/highway17.db or sqlite:highway17.db (relative) and
+// sqlite::memory: into a driver DSN. Query parameters are passed through.
+func sqliteDSN(databaseURL string) (dsn, path string, err error) {
+	_, rest, _ := strings.Cut(databaseURL, ":")
+	path, query, _ := strings.Cut(strings.TrimPrefix(rest, "//"), "?")
+	if path == "" {
+		return "", "", fmt.Errorf("sqlite DATABASE_URL has no file path, e.g. sqlite:///var/lib/highway17/highway17.db")
+	}
+
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid sqlite DATABASE_URL parameters: %w", err)
+	}
+	for _, pragma := range sqlitePragmas {
+		params.Add("_pragma", pragma)
+	}
+
+	return "file:" + path + "?" + params.Encode(), path, nil
+}
+
+func openSQLite(ctx context.Context, databaseURL string) (*DB, error) {
+	dsn, path, err := sqliteDSN(databaseURL)
+	if err != nil {
+		return nil, err
+	}
+	if path != ":memory:" {
+		if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+			return nil, fmt.Errorf("failed to create database directory: %w", err)
+		}
+	}
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	// SQLite has a single writer, and one connection keeps :memory: alive
+	db.SetMaxOpenConns(1)
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	return &DB{conn: sqliteConn{q: db, db: db}, dialect: SQLite, sqlDB: db}, nil
+}
+
+// sqlQuerier is implemented by *sql.DB and *sql.Tx
+type sqlQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// sqliteConn adapts database/sql to conn; db is nil inside a transaction
+type sqliteConn struct {
+	q  sqlQuerier
+	db *sql.DB
+}
+
+type sqliteResult int64
+
+func (r sqliteResult) RowsAffected() int64 { return int64(r) }
+
+func (c sqliteConn) Exec(ctx context.Context, query string, args ...any) (commandTag, error) {
+	res, err := c.q.ExecContext(ctx, sqliteQuery(query), sqliteArgs(args)...)
+	if err != nil {
+		return sqliteResult(0), err
+	}
+	n, err := res.RowsAffected()
+	return sqliteResult(n), err
+}
+
+func (c sqliteConn) Query(ctx context.Context, query string, args ...any) (rows, error) {
+	r, err := c.q.QueryContext(ctx, sqliteQuery(query), sqliteArgs(args)...)
+	if err != nil {
+		return nil, err
+	}
+	return sqliteRows{r}, nil
+}
+
+func (c sqliteConn) QueryRow(ctx context.Context, query string, args ...any) row {
+	return sqliteRow{c.q.QueryRowContext(ctx, sqliteQuery(query), sqliteArgs(args)...)}
+}
+
+func (c sqliteConn) Begin(ctx context.Context) (tx, error) {
+	if c.db == nil {
+		return nil, errors.New("sqlite: nested transactions are not supported")
+	}
+	t, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return sqliteTx{sqliteConn{q: t}, t}, nil
+}
+
+type sqliteTx struct {
+	sqliteConn
+	tx *sql.Tx
+}
+
+func (t sqliteTx) Commit(ctx context.Context) error   { return t.tx.Commit() }
+func (t sqliteTx) Rollback(ctx context.Context) error { return t.tx.Rollback() }
+
+type sqliteRows struct {
+	rows *sql.Rows
+}
+
+func (r sqliteRows) Next() bool             { return r.rows.Next() }
+func (r sqliteRows) Err() error             { return r.rows.Err() }
+func (r sqliteRows) Close()                 { r.rows.Close() }
+func (r sqliteRows) Scan(dest ...any) error { return r.rows.Scan(sqliteDest(dest)...) }
+
+type sqliteRow struct {
+	row *sql.Row
+}
+
+func (r sqliteRow) Scan(dest ...any) error { return r.row.Scan(sqliteDest(dest)...) }
+
+// sqliteArgs stores times in sqliteTimeFormat and string slices (TEXT[] in
+// Postgres) as JSON arrays
+func sqliteArgs(args []any) []any {
+	converted := make([]any, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case time.Time:
+			converted[i] = v.UTC().Format(sqliteTimeFormat)
+		case []string:
+			if v == nil {
+				v = []string{}
+			}
+			b, err := json.Marshal(v)
+			if err != nil {
+				converted[i] = arg
+				continue
+			}
+			converted[i] = string(b)
+		default:
+			converted[i] = arg
+		}
+	}
+	return converted
+}
+
+// sqliteDest wraps scan targets that database/sql cannot fill from SQLite
+// values on its own
+func sqliteDest(dest []any) []any {
+	converted := make([]any, len(dest))
+	for i, d := range dest {
+		switch d := d.(type) {
+		case *[]string:
+			converted[i] = sqliteStrings{d}
+		case *time.Time:
+			converted[i] = sqliteTime{d}
+		case **time.Time:
+			converted[i] = sqliteNullTime{d}
+		default:
+			converted[i] = d
+		}
+	}
+	return converted
+}
+
+// sqliteStrings scans a JSON array column into a []string
+type sqliteStrings struct {
+	dst *[]string
+}
+
+func (s sqliteStrings) Scan(src any) error {
+	var raw []byte
+	switch v := src.(type) {
+	case nil:
+		*s.dst = []string{}
+		return nil
+	case string:
+		raw = []byte(v)
+	case []byte:
+		raw = v
+	default:
+		return fmt.Errorf("cannot scan %T into []string", src)
+	}
+	list := []string{}
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return fmt.Errorf("cannot scan %q into []string: %w", raw, err)
+	}
+	*s.dst = list
+	return nil
+}
+
+type sqliteTime struct {
+	dst *time.Time
+}
+
+func (s sqliteTime) Scan(src any) error {
+	if src == nil {
+		return errors.New("cannot scan NULL into time.Time")
+	}
+	t, err := parseSQLiteTime(src)
+	if err != nil {
+		return err
+	}
+	*s.dst = t
+	return nil
+}
+
+type sqliteNullTime struct {
+	dst **time.Time
+}
+
+func (s sqliteNullTime) Scan(src any) error {
+	if src == nil {
+		*s.dst = nil
+		return nil
+	}
+	t, err := parseSQLiteTime(src)
+	if err != nil {
+		return err
+	}
+	*s.dst = &t
+	return nil
+}
+
+// parseSQLiteTime accepts the driver's own time values and the text forms
+// SQLite date functions produce
+func parseSQLiteTime(src any) (time.Time, error) {
+	var s string
+	switch v := src.(type) {
+	case time.Time:
+		return v.UTC(), nil
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return time.Time{}, fmt.Errorf("cannot scan %T into time.Time", src)
+	}
+
+	for _, layout := range []string{sqliteTimeFormat, "2006-01-02 15:04:05.999999999", time.RFC3339Nano, "2006-01-02 15:04:05.999999999-07:00"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q as a time", s)
+}
@@ -0,0 +1,197 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"citadel/highway17/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrNotFound is wrapped by every not-found error the stores return, so
+// callers never need to know about pgx.ErrNoRows
+var ErrNotFound = errors.New("not found")
+
+var (
+	ErrUserNotFound       = fmt.Errorf("user %w", ErrNotFound)
+	ErrSessionNotFound    = fmt.Errorf("session %w", ErrNotFound)
+	ErrAPITokenNotFound   = fmt.Errorf("api token %w", ErrNotFound)
+	ErrWidgetDataNotFound = fmt.Errorf("widget data %w", ErrNotFound)
+	ErrSettingNotFound    = fmt.Errorf("setting %w", ErrNotFound)
+)
+
+// ErrUserConflict means the username belongs to an account another backend
+// created
+var ErrUserConflict = errors.New("username belongs to an account from another backend")
+
+// UserStore reads and writes user accounts. Lookups return ErrUserNotFound
+// when no row matches; NULL columns come back as empty strings.
+type UserStore interface {
+	GetUserByUsername(ctx context.Context, username string) (*models.User, error)
+	GetUserByID(ctx context.Context, id int) (*models.User, error)
+	GetUserByTailscaleLogin(ctx context.Context, login string) (*models.User, error)
+	GetUserByTailscaleIP(ctx context.Context, ip string) (*models.User, error)
+	GetUserByOIDCSubject(ctx context.Context, issuer, subject string) (*models.User, error)
+	CreateUser(ctx context.Context, username, passwordHash, role string, mustChangePassword bool) (int, error)
+	CreateTailscaleUser(ctx context.Context, username, login string) (int, error)
+	CreateOIDCUser(ctx context.Context, username, issuer, subject, role string) (int, error)
+	UpsertDirectoryUser(ctx context.Context, backend, username, role string, syncRole bool) (*models.User, error)
+	LinkOIDCIdentity(ctx context.Context, userID int, issuer, subject string) error
+	SetPassword(ctx context.Context, userID int, passwordHash string, mustChangePassword bool) error
+	SetUserRole(ctx context.Context, userID int, role string) (bool, error)
+	DeleteUser(ctx context.Context, userID int) (bool, error)
+	CountUsers(ctx context.Context) (int, error)
+	ListUsers(ctx context.Context) ([]models.User, error)
+}
+
+// SessionStore keeps login sessions. GetSessionByToken returns
+// ErrSessionNotFound for unknown and expired tokens alike.
+type SessionStore interface {
+	CreateSession(ctx context.Context, userID int, token, ip, userAgent string, idle, maxLifetime time.Duration) error
+	GetSessionByToken(ctx context.Context, token string) (*models.Session, error)
+	TouchSession(ctx context.Context, id int, ip string, idle time.Duration) error
+	ListSessions(ctx context.Context, userID int) ([]models.Session, error)
+	DeleteSession(ctx context.Context, token string) error
+	DeleteUserSession(ctx context.Context, userID, id int) (bool, error)
+	DeleteUserSessions(ctx context.Context, userID int, keepToken string) (int64, error)
+	PurgeExpiredSessions(ctx context.Context) (int64, error)
+}
+
+// WidgetStore keeps per-user widget state as JSON documents
+type WidgetStore interface {
+	SaveWidgetData(ctx context.Context, userID int, widgetName, widgetKey, valueJSON string) error
+	GetWidgetData(ctx context.Context, userID int, widgetName, widgetKey string) (string, error)
+}
+
+// SettingsStore keeps per-user key/value settings
+type SettingsStore interface {
+	SaveSetting(ctx context.Context, userID int, key, value string) error
+	GetSetting(ctx context.Context, userID int, key string) (string, error)
+}
+
+// TOTPStore keeps two-factor secrets and recovery codes. Codes are stored
+// hashed; each can be used once.
+type TOTPStore interface {
+	SetPendingTOTPSecret(ctx context.Context, userID int, secret string) error
+	EnableTOTP(ctx context.Context, userID int, step int64, recoveryCodeHashes []string) error
+	DisableTOTP(ctx context.Context, userID int) error
+	AdvanceTOTPStep(ctx context.Context, userID int, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userID int, codeHash string) (bool, error)
+	CountRecoveryCodes(ctx context.Context, userID int) (int, error)
+}
+
+// LoginChallengeStore keeps the short-lived tokens that link a verified
+// password to the second-factor step. GetLoginChallenge returns ErrNotFound
+// for unknown and expired tokens alike.
+type LoginChallengeStore interface {
+	CreateLoginChallenge(ctx context.Context, userID int, token string, ttl time.Duration) error
+	GetLoginChallenge(ctx context.Context, token string) (int, error)
+	DeleteLoginChallenge(ctx context.Context, token string) error
+}
+
+// APITokenStore keeps personal API tokens by hash. GetAPITokenByHash returns
+// ErrAPITokenNotFound for unknown and expired tokens alike.
+type APITokenStore interface {
+	CreateAPIToken(ctx context.Context, userID int, name, tokenHash, prefix string, scopes []string, ttl time.Duration) (*models.APIToken, error)
+	GetAPITokenByHash(ctx context.Context, tokenHash string) (*models.APIToken, error)
+	TouchAPIToken(ctx context.Context, id int) error
+	ListAPITokens(ctx context.Context, userID int) ([]models.APIToken, error)
+	DeleteAPIToken(ctx context.Context, userID, id int) (bool, error)
+}
+
+// PasskeyStore keeps users' WebAuthn credentials. TouchPasskey finds the
+// credential by its ID, since a login does not know the user in advance.
+type PasskeyStore interface {
+	ListPasskeys(ctx context.Context, userID int) ([]models.Passkey, error)
+	CreatePasskey(ctx context.Context, userID int, name string, credentialID []byte, credentialJSON string) error
+	TouchPasskey(ctx context.Context, credentialID []byte, credentialJSON string) error
+	RenamePasskey(ctx context.Context, userID, id int, name string) (bool, error)
+	DeletePasskey(ctx context.Context, userID, id int) (bool, error)
+}
+
+// WebAuthnCeremonyStore keeps the state of unfinished passkey registrations
+// and logins. TakeWebAuthnCeremony returns ErrNotFound for unknown and
+// expired tokens alike.
+type WebAuthnCeremonyStore interface {
+	SaveWebAuthnCeremony(ctx context.Context, token string, userID *int, sessionJSON string, ttl time.Duration) error
+	TakeWebAuthnCeremony(ctx context.Context, token string) (*int, string, error)
+}
+
+// OIDCStateStore keeps pending authorization-code flows. TakeOIDCState
+// returns ErrNotFound for unknown and expired states alike.
+type OIDCStateStore interface {
+	SaveOIDCState(ctx context.Context, state, nonce, codeVerifier string, userID *int, returnTo string, ttl time.Duration) error
+	TakeOIDCState(ctx context.Context, state string) (*models.OIDCState, error)
+}
+
+// LockoutStore counts failed logins per scope and subject
+type LockoutStore interface {
+	RecordLoginFailure(ctx context.Context, scope, subject string, window time.Duration) (int, error)
+	LockLogin(ctx context.Context, scope, subject string, duration time.Duration) error
+	LoginLockedFor(ctx context.Context, scope, subject string) (time.Duration, error)
+	ClearLoginFailures(ctx context.Context, scope, subject string) error
+	ListLoginLockouts(ctx context.Context) ([]models.LoginLockout, error)
+	DeleteLoginLockout(ctx context.Context, id int) (bool, error)
+	DeleteAllLoginLockouts(ctx context.Context) (int64, error)
+}
+
+// SetupStore records whether the first-run setup wizard has completed
+type SetupStore interface {
+	IsSetupComplete(ctx context.Context) (bool, error)
+	MarkSetupComplete(ctx context.Context) error
+	CompleteSetup(ctx context.Context, username, passwordHash string) (int, bool, error)
+}
+
+// AuditStore keeps the audit log. ListAuditEvents returns events newest
+// first; see AuditFilter.
+type AuditStore interface {
+	InsertAuditEvent(ctx context.Context, e *models.AuditEvent) error
+	ListAuditEvents(ctx context.Context, f AuditFilter) ([]models.AuditEvent, error)
+	PurgeAuditEvents(ctx context.Context, maxAge time.Duration) (int64, error)
+}
+
+// MetricStore keeps system metric history. A resolution of zero addresses
+// the raw samples; any other resolution addresses the rollups of that
+// bucket width. Times are stored and returned in UTC.
+type MetricStore interface {
+	InsertMetricSamples(ctx context.Context, at time.Time, values map[string]float64) error
+	UpsertMetricRollups(ctx context.Context, resolution time.Duration, points []models.MetricPoint) error
+	ListMetricPoints(ctx context.Context, resolution time.Duration, metric string, from, to time.Time) ([]models.MetricPoint, error)
+	LatestMetricRollup(ctx context.Context, resolution time.Duration) (time.Time, error)
+	PurgeMetricPoints(ctx context.Context, resolution time.Duration, before time.Time) (int64, error)
+	ListMetricNames(ctx context.Context) ([]string, error)
+}
+
+// Store is every repository in one, as implemented by DB and by the
+// in-memory store in databasetest
+type Store interface {
+	UserStore
+	SessionStore
+	WidgetStore
+	SettingsStore
+	TOTPStore
+	LoginChallengeStore
+	APITokenStore
+	PasskeyStore
+	WebAuthnCeremonyStore
+	OIDCStateStore
+	LockoutStore
+	SetupStore
+	AuditStore
+	MetricStore
+}
+
+var _ Store = (*DB)(nil)
+
+// notFound replaces pgx.ErrNoRows and sql.ErrNoRows with the store's
+// domain error
+func notFound(err, domainErr error) error {
+	if errors.Is(err, pgx.ErrNoRows) || errors.Is(err, sql.ErrNoRows) {
+		return domainErr
+	}
+	return err
+}
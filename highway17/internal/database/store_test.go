@@ -0,0 +1,57 @@
+package database_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"citadel/highway17/internal/database"
+	"citadel/highway17/internal/database/databasetest"
+)
+
+// TestStore runs the shared store suite against every implementation.
+// Postgres runs only when TEST_DATABASE_URL points at an empty database.
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("memory", func(t *testing.T) {
+		if err := databasetest.TestStore(ctx, databasetest.NewStore()); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("sqlite memory", func(t *testing.T) {
+		testDatabase(t, "sqlite::memory:")
+	})
+
+	t.Run("sqlite file", func(t *testing.T) {
+		testDatabase(t, "sqlite://"+filepath.Join(t.TempDir(), "highway17.db"))
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		url := os.Getenv("TEST_DATABASE_URL")
+		if url == "" {
+			t.Skip("TEST_DATABASE_URL is not set")
+		}
+		testDatabase(t, url)
+	})
+}
+
+func testDatabase(t *testing.T, databaseURL string) {
+	t.Helper()
+	ctx := context.Background()
+
+	db, err := database.New(ctx, databaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+
+	if _, err := db.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := databasetest.TestStore(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+}
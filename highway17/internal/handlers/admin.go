@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"citadel/highway17/internal/audit"
+	"citadel/highway17/internal/auth"
+	"citadel/highway17/internal/config"
+	"citadel/highway17/internal/database"
+	"citadel/highway17/internal/models"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+type AdminHandler struct {
+	cfg   *config.Config
+	db    database.Store
+	log   *zap.Logger
+	audit *audit.Recorder
+}
+
+func NewAdminHandler(cfg *config.Config, db database.Store, log *zap.Logger, rec *audit.Recorder) *AdminHandler {
+	return &AdminHandler{
+		cfg:   cfg,
+		db:    db,
+		log:   log,
+		audit: rec,
+	}
+}
+
+// ListLockouts returns every tracked login failure record
+func (ah *AdminHandler) ListLockouts(c echo.Context) error {
+	ctx := context.Background()
+
+	lockouts, err := ah.db.ListLoginLockouts(ctx)
+	if err != nil {
+		ah.log.Sugar().Errorw("failed to list lockouts", "error", err)
+		return c.JSON(500, map[string]string{"error": "failed to list lockouts"})
+	}
+
+	return c.JSON(200, lockouts)
+}
+
+// ClearLockout removes a single lockout record
+func (ah *AdminHandler) ClearLockout(c echo.Context) error {
+	ctx := context.Background()
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(400, map[string]string{"error": "invalid lockout id"})
+	}
+
+	found, err := ah.db.DeleteLoginLockout(ctx, id)
+	if err != nil {
+		ah.log.Sugar().Errorw("failed to clear lockout", "id", id, "error", err)
+		return c.JSON(500, map[string]string{"error": "failed to clear lockout"})
+	}
+	if !found {
+		return c.JSON(404, map[string]string{"error": "lockout not found"})
+	}
+
+	ah.log.Sugar().Infow("login lockout cleared", "event", "login_lockout_cleared", "id", id, "client_ip", c.RealIP())
+	ah.audit.Request(c, audit.Event{Action: audit.ActionLockoutClear, TargetType: "lockout", TargetID: strconv.Itoa(id)})
+	return c.JSON(200, map[string]string{"message": "lockout cleared"})
+}
+
+// ClearAllLockouts removes every lockout record
+func (ah *AdminHandler) ClearAllLockouts(c echo.Context) error {
+	ctx := context.Background()
+
+	cleared, err := ah.db.DeleteAllLoginLockouts(ctx)
+	if err != nil {
+		ah.log.Sugar().Errorw("failed to clear lockouts", "error", err)
+		return c.JSON(500, map[string]string{"error": "failed to clear lockouts"})
+	}
+
+	ah.log.Sugar().Infow("login lockouts cleared", "event", "login_lockout_cleared", "count", cleared, "client_ip", c.RealIP())
+	ah.audit.Request(c, audit.Event{
+		Action:     audit.ActionLockoutClear,
+		TargetType: "lockout",
+		TargetID:   "all",
+		Details:    map[string]any{"count": cleared},
+	})
+	return c.JSON(200, map[string]interface{}{"message": "lockouts cleared", "count": cleared})
+}
+
+// ListUsers returns every account with its role
+func (ah *AdminHandler) ListUsers(c echo.Context) error {
+	ctx := context.Background()
+
+	users, err := ah.db.ListUsers(ctx)
+	if err != nil {
+		ah.log.Sugar().Errorw("failed to list users", "error", err)
+		return c.JSON(500, map[string]string{"error": "failed to list users"})
+	}
+
+	return c.JSON(200, users)
+}
+
+// SetUserRole changes a user's role. The last admin cannot be demoted.
+func (ah *AdminHandler) SetUserRole(c echo.Context) error {
+	ctx := context.Background()
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(400, map[string]string{"error": "invalid user id"})
+	}
+
+	var req struct {
+		Role string `json:"role" form:"role"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(400, map[string]string{"error": "invalid request"})
+	}
+
+	role, err := auth.ParseRole(req.Role)
+	if err != nil {
+		return c.JSON(400, map[string]string{"error": err.Error()})
+	}
+
+	users, err := ah.db.ListUsers(ctx)
+	if err != nil {
+		ah.log.Sugar().Errorw("failed to list users", "error", err)
+		return c.JSON(500, map[string]string{"error": "failed to update role"})
+	}
+
+	var target *models.User
+	admins := 0
+	for i := range users {
+		if users[i].Role == string(auth.RoleAdmin) {
+			admins++
+		}
+		if users[i].ID == id {
+			target = &users[i]
+		}
+	}
+	if target == nil {
+		return c.JSON(404, map[string]string{"error": "user not found"})
+	}
+	if target.Role == string(auth.RoleAdmin) && role != auth.RoleAdmin && admins <= 1 {
+		return c.JSON(409, map[string]string{"error": "cannot demote the last admin"})
+	}
+
+	if _, err := ah.db.SetUserRole(ctx, id, string(role)); err != nil {
+		ah.log.Sugar().Errorw("failed to set user role", "user_id", id, "error", err)
+		return c.JSON(500, map[string]string{"error": "failed to update role"})
+	}
+
+	actor, _ := GetCurrentUser(c)
+	ah.log.Sugar().Infow("user role changed", "event", "user_role_changed", "user_id", id, "role", role, "actor", actor.Username, "client_ip", c.RealIP())
+	ah.audit.Request(c, audit.Event{
+		Action:     audit.ActionUserRole,
+		TargetType: "user",
+		TargetID:   target.Username,
+		Before:     map[string]string{"role": target.Role},
+		After:      map[string]string{"role": string(role)},
+	})
+	return c.JSON(200, map[string]string{"message": "role updated", "role": string(role)})
+}
+
+// CreateUser adds a local account. New accounts must choose their own
+// password at first login unless must_change_password is false.
+func (ah *AdminHandler) CreateUser(c echo.Context) error {
+	ctx := context.Background()
+
+	var req struct {
+		Username           string `json:"username" form:"username"`
+		Password           string `json:"password" form:"password"`
+		Role               string `json:"role" form:"role"`
+		MustChangePassword *bool  `json:"must_change_password" form:"must_change_password"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(400, map[string]string{"error": "invalid request"})
+	}
+
+	if err := auth.ValidateUsername(req.Username); err != nil {
+		return c.JSON(400, map[string]string{"error": err.Error()})
+	}
+	if err := auth.ValidatePassword(req.Password); err != nil {
+		return c.JSON(400, map[string]string{"error": err.Error()})
+	}
+	if req.Role == "" {
+		req.Role = string(auth.RoleViewer)
+	}
+	role, err := auth.ParseRole(req.Role)
+	if err != nil {
+		return c.JSON(400, map[string]string{"error": err.Error()})
+	}
+	mustChange := req.MustChangePassword == nil || *req.MustChangePassword
+
+	if _, err := ah.db.GetUserByUsername(ctx, req.Username); err == nil {
+		return c.JSON(409, map[string]string{"error": "username already exists"})
+	} else if !errors.Is(err, database.ErrUserNotFound) {
+		ah.log.Sugar().Errorw("failed to look up user", "error", err)
+		return c.JSON(500, map[string]string{"error": "failed to create user"})
+	}
+
+	hash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		ah.log.Sugar().Errorw("failed to hash password", "error", err)
+		return c.JSON(500, map[string]string{"error": "failed to create user"})
+	}
+
+	id, err := ah.db.CreateUser(ctx, req.Username, hash, string(role), mustChange)
+	if err != nil {
+		ah.log.Sugar().Errorw("failed to create user", "username", req.Username, "error", err)
+		return c.JSON(500, map[string]string{"error": "failed to create user"})
+	}
+
+	actor, _ := GetCurrentUser(c)
+	ah.log.Sugar().Infow("user created", "event", "user_created", "user_id", id, "username", req.Username, "role", role, "must_change_password", mustChange, "actor", actor.Username, "client_ip", c.RealIP())
+	ah.audit.Request(c, audit.Event{
+		Action:     audit.ActionUserCreate,
+		TargetType: "user",
+		TargetID:   req.Username,
+		After:      map[string]any{"role": role, "must_change_password": mustChange},
+	})
+	return c.JSON(201, map[string]interface{}{"id": id, "username": req.Username, "role": role, "must_change_password": mustChange})
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"citadel/highway17/internal/audit"
+	"citadel/highway17/internal/config"
+	"citadel/highway17/internal/database"
+	"citadel/highway17/internal/models"
+	"citadel/highway17/web/components"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+const (
+	auditPageSize = 100
+	auditMaxLimit = 1000
+)
+
+type AuditHandler struct {
+	cfg *config.Config
+	db  database.AuditStore
+	log *zap.Logger
+}
+
+func NewAuditHandler(cfg *config.Config, db database.AuditStore, log *zap.Logger) *AuditHandler {
+	return &AuditHandler{
+		cfg: cfg,
+		db:  db,
+		log: log,
+	}
+}
+
+// Page shows the audit log with a filter form; "Older" pages by event ID
+func (ah *AuditHandler) Page(c echo.Context) error {
+	ctx := context.Background()
+
+	query := components.AuditQuery{
+		Action:     c.QueryParam("action"),
+		Actor:      c.QueryParam("actor"),
+		Outcome:    c.QueryParam("outcome"),
+		TargetType: c.QueryParam("target_type"),
+		TargetID:   c.QueryParam("target_id"),
+		ClientIP:   c.QueryParam("ip"),
+		Since:      c.QueryParam("since"),
+		Until:      c.QueryParam("until"),
+	}
+
+	filter, err := parseAuditFilter(c)
+	if err != nil {
+		query.Error = err.Error()
+		return render(c, http.StatusBadRequest, components.AuditPage(query, audit.Actions, nil, ""))
+	}
+
+	events, err := ah.db.ListAuditEvents(ctx, filter)
+	if err != nil {
+		ah.log.Sugar().Errorw("failed to list audit events", "error", err)
+		return c.String(500, "failed to list audit events")
+	}
+
+	return render(c, http.StatusOK, components.AuditPage(query, audit.Actions, events, olderURL(c, events, filter.Limit)))
+}
+
+// List returns audit events as JSON, newest first. Pass next_before_id back
+// as before_id to fetch the next page.
+func (ah *AuditHandler) List(c echo.Context) error {
+	ctx := context.Background()
+
+	filter, err := parseAuditFilter(c)
+	if err != nil {
+		return c.JSON(400, map[string]string{"error": err.Error()})
+	}
+
+	events, err := ah.db.ListAuditEvents(ctx, filter)
+	if err != nil {
+		ah.log.Sugar().Errorw("failed to list audit events", "error", err)
+		return c.JSON(500, map[string]string{"error": "failed to list audit events"})
+	}
+
+	resp := map[string]interface{}{"events": events}
+	if len(events) == filter.Limit {
+		resp["next_before_id"] = events[len(events)-1].ID
+	}
+	return c.JSON(200, resp)
+}
+
+// parseAuditFilter reads the filter from query parameters. since and until
+// take RFC 3339 timestamps or YYYY-MM-DD dates (UTC).
+func parseAuditFilter(c echo.Context) (database.AuditFilter, error) {
+	filter := database.AuditFilter{
+		Action:     c.QueryParam("action"),
+		Actor:      c.QueryParam("actor"),
+		Outcome:    c.QueryParam("outcome"),
+		TargetType: c.QueryParam("target_type"),
+		TargetID:   c.QueryParam("target_id"),
+		ClientIP:   c.QueryParam("ip"),
+		Limit:      auditPageSize,
+	}
+
+	var err error
+	if filter.Since, err = parseAuditTime(c.QueryParam("since")); err != nil {
+		return filter, fmt.Errorf("invalid since: %w", err)
+	}
+	if filter.Until, err = parseAuditTime(c.QueryParam("until")); err != nil {
+		return filter, fmt.Errorf("invalid until: %w", err)
+	}
+	// A bare date as until includes the whole day
+	if raw := c.QueryParam("until"); len(raw) == len("2006-01-02") {
+		filter.Until = filter.Until.AddDate(0, 0, 1)
+	}
+
+	if raw := c.QueryParam("before_id"); raw != "" {
+		if filter.BeforeID, err = strconv.ParseInt(raw, 10, 64); err != nil || filter.BeforeID < 1 {
+			return filter, fmt.Errorf("invalid before_id")
+		}
+	}
+	if raw := c.QueryParam("limit"); raw != "" {
+		if filter.Limit, err = strconv.Atoi(raw); err != nil || filter.Limit < 1 || filter.Limit > auditMaxLimit {
+			return filter, fmt.Errorf("limit must be between 1 and %d", auditMaxLimit)
+		}
+	}
+
+	return filter, nil
+}
+
+// parseAuditTime accepts an RFC 3339 timestamp or a date; occurred_at is
+// stored without a zone, so times are compared in UTC
+func parseAuditTime(raw string) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", raw); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected YYYY-MM-DD or RFC 3339")
+	}
+	return t.UTC(), nil
+}
+
+// olderURL links to the next page of a full result set
+func olderURL(c echo.Context, events []models.AuditEvent, limit int) string {
+	if len(events) < limit {
+		return ""
+	}
+	q := url.Values{}
+	for key, values := range c.QueryParams() {
+		if key != "before_id" {
+			q[key] = values
+		}
+	}
+	q.Set("before_id", strconv.FormatInt(events[len(events)-1].ID, 10))
+	return c.Request().URL.Path + "?" + q.Encode()
+}
@@ -2,32 +2,51 @@ package handlers
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/hex"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
+	"citadel/highway17/internal/audit"
+	"citadel/highway17/internal/auth"
 	"citadel/highway17/internal/config"
 	"citadel/highway17/internal/database"
 	"citadel/highway17/internal/models"
+	"citadel/highway17/web/components"
 
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type AuthHandler struct {
-	cfg *config.Config
-	db  *database.DB
-	log *zap.Logger
+	cfg       *config.Config
+	db        database.Store
+	log       *zap.Logger
+	audit     *audit.Recorder
+	sessions  *auth.Sessions
+	lockout   *auth.Lockout
+	twoFactor *auth.TwoFactor
+	backends  *auth.Backends
+	setup     *auth.Setup
+	forward   *auth.ForwardPolicy
 }
 
-func NewAuthHandler(cfg *config.Config, db *database.DB, log *zap.Logger) *AuthHandler {
+func NewAuthHandler(cfg *config.Config, db database.Store, log *zap.Logger, rec *audit.Recorder, sessions *auth.Sessions, lockout *auth.Lockout, tf *auth.TwoFactor, backends *auth.Backends, setup *auth.Setup, forward *auth.ForwardPolicy) *AuthHandler {
 	return &AuthHandler{
-		cfg: cfg,
-		db:  db,
-		log: log,
+		cfg:       cfg,
+		db:        db,
+		log:       log,
+		audit:     rec,
+		sessions:  sessions,
+		lockout:   lockout,
+		twoFactor: tf,
+		backends:  backends,
+		setup:     setup,
+		forward:   forward,
 	}
 }
 
@@ -37,9 +56,45 @@ type LoginRequest struct {
 }
 
 type LoginResponse struct {
-	Token    string `json:"token"`
-	Username string `json:"username"`
-	Message  string `json:"message"`
+	Token             string `json:"token,omitempty"`
+	Username          string `json:"username"`
+	Message           string `json:"message"`
+	TwoFactorRequired bool   `json:"two_factor_required,omitempty"`
+	Challenge         string `json:"challenge,omitempty"`
+	Redirect          string `json:"redirect,omitempty"`
+}
+
+type TwoFactorLoginRequest struct {
+	Challenge string `json:"challenge" form:"challenge"`
+	Code      string `json:"code" form:"code"`
+}
+
+// loginErrors are the messages /login?error=<code> may show
+var loginErrors = map[string]string{
+	"sso": "Single sign-on failed. Ask an administrator to link or create your account.",
+}
+
+// LoginPage serves the login form, or the setup wizard on a fresh install
+func (ah *AuthHandler) LoginPage(c echo.Context) error {
+	pending, err := ah.setup.Pending(context.Background())
+	if err != nil {
+		ah.log.Sugar().Errorw("failed to check setup state", "error", err)
+	}
+	if pending {
+		return c.Redirect(http.StatusSeeOther, "/setup")
+	}
+
+	// Sent here by forward auth: continue to the protected service afterwards
+	rememberReturnTo(c, ah.forward, c.QueryParam("rd"))
+
+	opts := components.LoginOptions{
+		PasswordEnabled: ah.cfg.PasswordLoginEnabled,
+		Error:           loginErrors[c.QueryParam("error")],
+	}
+	if ah.cfg.OIDCIssuerURL != "" {
+		opts.SSOName = ah.cfg.OIDCProviderName
+	}
+	return render(c, http.StatusOK, components.LoginPage(opts))
 }
 
 // Login handles user authentication
@@ -47,6 +102,10 @@ func (ah *AuthHandler) Login(c echo.Context) error {
 	ctx := context.Background()
 	req := new(LoginRequest)
 
+	if !ah.cfg.PasswordLoginEnabled {
+		return c.JSON(403, map[string]string{"error": "password login is disabled"})
+	}
+
 	if err := c.Bind(req); err != nil {
 		ah.log.Sugar().Errorw("failed to bind login request", "error", err)
 		return c.JSON(400, map[string]string{"error": "invalid request"})
@@ -57,58 +116,185 @@ func (ah *AuthHandler) Login(c echo.Context) error {
 		return c.JSON(400, map[string]string{"error": "username and password required"})
 	}
 
-	// Get user from database
-	user, err := ah.db.GetUserByUsername(ctx, req.Username)
-	if err != nil {
-		// User doesn't exist - check against default password for first-time setup
-		if req.Password == ah.cfg.LoginPassword {
-			// Create default user on first login
-			userID, err := ah.db.CreateUser(ctx, req.Username, hashPassword(ah.cfg.LoginPassword))
-			if err != nil {
-				ah.log.Sugar().Errorw("failed to create user", "error", err)
-				return c.JSON(500, map[string]string{"error": "failed to create user"})
-			}
-
-			token, err := ah.createSession(ctx, userID)
-			if err != nil {
-				ah.log.Sugar().Errorw("failed to create session", "error", err)
-				return c.JSON(500, map[string]string{"error": "failed to create session"})
-			}
-
-			ah.setSessionCookie(c, token)
-			return c.JSON(200, LoginResponse{
-				Token:    token,
-				Username: req.Username,
-				Message:  "User created and logged in",
+	clientIP := c.RealIP()
+
+	// Refuse early while the username or source IP is locked out
+	if err := ah.lockout.Check(ctx, req.Username, clientIP); err != nil {
+		var locked *auth.LockedOutError
+		if errors.As(err, &locked) {
+			ah.log.Sugar().Warnw("login rejected - locked out", "username", req.Username, "client_ip", clientIP, "scope", locked.Scope)
+			ah.audit.Request(c, audit.Event{
+				Action:    audit.ActionLoginLocked,
+				Outcome:   audit.OutcomeDenied,
+				ActorName: req.Username,
+				Details:   map[string]any{"method": "password", "scope": locked.Scope},
 			})
+			c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+			return c.JSON(429, map[string]string{"error": "too many failed login attempts, try again later"})
 		}
+		ah.log.Sugar().Errorw("failed to check login lockout", "error", err)
+		return c.JSON(500, map[string]string{"error": "failed to check login lockout"})
+	}
 
-		ah.log.Sugar().Warnw("login failed - user not found", "username", req.Username)
-		return c.JSON(401, map[string]string{"error": "invalid credentials"})
+	// Check the password against each configured backend in turn
+	user, backend, err := ah.backends.Authenticate(ctx, req.Username, req.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrUnknownUser), errors.Is(err, auth.ErrInvalidCredentials):
+			ah.log.Sugar().Warnw("login failed - invalid credentials", "username", req.Username, "client_ip", clientIP, "backend", backend)
+			ah.lockout.RecordFailure(ctx, req.Username, clientIP)
+			ah.audit.Request(c, audit.Event{
+				Action:    audit.ActionLoginFailed,
+				Outcome:   audit.OutcomeFailure,
+				ActorName: req.Username,
+				Details:   map[string]any{"method": "password", "reason": "invalid credentials"},
+			})
+			return c.JSON(401, map[string]string{"error": "invalid credentials"})
+		default:
+			ah.log.Sugar().Errorw("login failed - backend unavailable", "username", req.Username, "client_ip", clientIP, "error", err)
+			ah.audit.Request(c, audit.Event{
+				Action:    audit.ActionLoginFailed,
+				Outcome:   audit.OutcomeFailure,
+				ActorName: req.Username,
+				Details:   map[string]any{"method": "password", "reason": "backend unavailable"},
+			})
+			return c.JSON(503, map[string]string{"error": "authentication backend unavailable"})
+		}
 	}
 
-	// Verify password
-	passwordHash := user["password_hash"].(string)
-	if !verifyPassword(passwordHash, req.Password) {
-		ah.log.Sugar().Warnw("login failed - invalid password", "username", req.Username)
-		return c.JSON(401, map[string]string{"error": "invalid credentials"})
+	// Hand off to the second factor when the user has one enrolled
+	if user.TOTPEnabled {
+		return ah.startTwoFactor(c, user)
 	}
 
 	// Create session
-	userID := user["id"].(int)
-	token, err := ah.createSession(ctx, userID)
+	token, err := ah.sessions.Start(c, user.ID)
 	if err != nil {
 		ah.log.Sugar().Errorw("failed to create session", "error", err)
 		return c.JSON(500, map[string]string{"error": "failed to create session"})
 	}
 
-	ah.setSessionCookie(c, token)
-	ah.log.Sugar().Infow("user logged in", "username", req.Username)
+	ah.lockout.RecordSuccess(ctx, req.Username)
+	redirect := takeReturnTo(c, ah.forward, "/dashboard")
+	setLoginRedirect(c, redirect)
+	ah.log.Sugar().Infow("user logged in", "username", req.Username, "client_ip", clientIP, "backend", backend)
+	ah.audit.Request(c, audit.Event{
+		Action:    audit.ActionLogin,
+		ActorID:   &user.ID,
+		ActorName: user.Username,
+		Details:   map[string]any{"method": "password", "backend": backend},
+	})
 
 	return c.JSON(200, LoginResponse{
 		Token:    token,
 		Username: req.Username,
 		Message:  "logged in successfully",
+		Redirect: redirect,
+	})
+}
+
+// startTwoFactor parks a password-verified login until the second factor is supplied
+func (ah *AuthHandler) startTwoFactor(c echo.Context, user *models.User) error {
+	ctx := context.Background()
+
+	challenge := auth.GenerateToken()
+	if err := ah.db.CreateLoginChallenge(ctx, user.ID, challenge, auth.ChallengeTTL); err != nil {
+		ah.log.Sugar().Errorw("failed to create login challenge", "error", err)
+		return c.JSON(500, map[string]string{"error": "failed to start two-factor login"})
+	}
+
+	if isHTMX(c) {
+		return render(c, http.StatusOK, components.TwoFactorChallenge(challenge))
+	}
+	return c.JSON(200, LoginResponse{
+		Username:          user.Username,
+		Message:           "two-factor code required",
+		TwoFactorRequired: true,
+		Challenge:         challenge,
+	})
+}
+
+// LoginTwoFactor completes a login by checking a TOTP or recovery code
+func (ah *AuthHandler) LoginTwoFactor(c echo.Context) error {
+	ctx := context.Background()
+	req := new(TwoFactorLoginRequest)
+
+	if err := c.Bind(req); err != nil {
+		return c.JSON(400, map[string]string{"error": "invalid request"})
+	}
+	if req.Challenge == "" || req.Code == "" {
+		return c.JSON(400, map[string]string{"error": "challenge and code required"})
+	}
+
+	userID, err := ah.db.GetLoginChallenge(ctx, req.Challenge)
+	if err != nil {
+		return c.JSON(401, map[string]string{"error": "login expired, start again"})
+	}
+
+	user, err := ah.db.GetUserByID(ctx, userID)
+	if err != nil {
+		ah.log.Sugar().Errorw("failed to load user", "error", err)
+		return c.JSON(500, map[string]string{"error": "failed to load user"})
+	}
+
+	clientIP := c.RealIP()
+	if err := ah.lockout.Check(ctx, user.Username, clientIP); err != nil {
+		var locked *auth.LockedOutError
+		if errors.As(err, &locked) {
+			ah.audit.Request(c, audit.Event{
+				Action:    audit.ActionLoginLocked,
+				Outcome:   audit.OutcomeDenied,
+				ActorID:   &user.ID,
+				ActorName: user.Username,
+				Details:   map[string]any{"method": "totp", "scope": locked.Scope},
+			})
+			c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+			return c.JSON(429, map[string]string{"error": "too many failed login attempts, try again later"})
+		}
+		return c.JSON(500, map[string]string{"error": "failed to check login lockout"})
+	}
+
+	if err := ah.twoFactor.Verify(ctx, user, req.Code); err != nil {
+		if !errors.Is(err, auth.ErrInvalidCode) {
+			ah.log.Sugar().Errorw("failed to verify two-factor code", "error", err)
+			return c.JSON(500, map[string]string{"error": "failed to verify code"})
+		}
+		ah.log.Sugar().Warnw("login failed - invalid two-factor code", "username", user.Username, "client_ip", clientIP)
+		ah.lockout.RecordFailure(ctx, user.Username, clientIP)
+		ah.audit.Request(c, audit.Event{
+			Action:    audit.ActionLoginFailed,
+			Outcome:   audit.OutcomeFailure,
+			ActorID:   &user.ID,
+			ActorName: user.Username,
+			Details:   map[string]any{"method": "totp", "reason": "invalid code"},
+		})
+		return c.JSON(401, map[string]string{"error": "invalid code"})
+	}
+
+	ah.db.DeleteLoginChallenge(ctx, req.Challenge)
+
+	token, err := ah.sessions.Start(c, user.ID)
+	if err != nil {
+		ah.log.Sugar().Errorw("failed to create session", "error", err)
+		return c.JSON(500, map[string]string{"error": "failed to create session"})
+	}
+
+	ah.lockout.RecordSuccess(ctx, user.Username)
+	redirect := takeReturnTo(c, ah.forward, "/dashboard")
+	setLoginRedirect(c, redirect)
+	ah.log.Sugar().Infow("user logged in", "username", user.Username, "client_ip", clientIP, "two_factor", true)
+	ah.audit.Request(c, audit.Event{
+		Action:    audit.ActionLogin,
+		ActorID:   &user.ID,
+		ActorName: user.Username,
+		Details:   map[string]any{"method": "password", "two_factor": true},
+	})
+
+	return c.JSON(200, LoginResponse{
+		Token:    token,
+		Username: user.Username,
+		Message:  "logged in successfully",
+		Redirect: redirect,
 	})
 }
 
@@ -117,69 +303,82 @@ func (ah *AuthHandler) Logout(c echo.Context) error {
 	ctx := context.Background()
 
 	// Get token from cookie
-	cookie, err := c.Cookie("session_token")
+	cookie, err := c.Cookie(auth.SessionCookieName)
 	if err == nil && cookie.Value != "" {
 		ah.db.DeleteSession(ctx, cookie.Value)
 	}
 
 	// Clear cookie
-	http.SetCookie(c.Response(), &http.Cookie{
-		Name:     "session_token",
-		Value:    "",
-		MaxAge:   -1,
-		Path:     "/",
-		HttpOnly: true,
-		SameSite: http.SameSiteLaxMode,
-	})
+	ah.sessions.ClearCookie(c)
+	ah.audit.Request(c, audit.Event{Action: audit.ActionLogout})
 
 	ah.log.Sugar().Info("user logged out")
+	if isHTMX(c) {
+		c.Response().Header().Set("HX-Redirect", "/login")
+	}
 	return c.JSON(200, map[string]string{"message": "logged out successfully"})
 }
 
-// createSession generates a new session token and stores it
-func (ah *AuthHandler) createSession(ctx context.Context, userID int) (string, error) {
-	token := generateToken()
-	expiresAt := time.Now().Add(24 * time.Hour).Format(time.RFC3339)
-
-	if err := ah.db.CreateSession(ctx, userID, token, expiresAt); err != nil {
-		return "", fmt.Errorf("failed to save session: %w", err)
+// setLoginRedirect sends HTMX clients on to dest after a successful login
+func setLoginRedirect(c echo.Context, dest string) {
+	if isHTMX(c) {
+		c.Response().Header().Set("HX-Redirect", dest)
 	}
-
-	return token, nil
 }
 
-// setSessionCookie sets the session token cookie
-func (ah *AuthHandler) setSessionCookie(c echo.Context, token string) {
-	http.SetCookie(c.Response(), &http.Cookie{
-		Name:     "session_token",
-		Value:    token,
-		MaxAge:   86400, // 24 hours
+const (
+	// returnToCookie carries a forward-auth destination through the login
+	// flow, including the round trip to an SSO provider
+	returnToCookie = "h17_return_to"
+
+	// returnToTTL bounds how long a login may take before it is forgotten
+	returnToTTL = 15 * time.Minute
+)
+
+// rememberReturnTo stores raw for takeReturnTo if it is a protected host
+func rememberReturnTo(c echo.Context, forward *auth.ForwardPolicy, raw string) {
+	returnTo, ok := forward.ReturnURL(raw)
+	if !ok {
+		return
+	}
+	c.SetCookie(&http.Cookie{
+		Name:     returnToCookie,
+		Value:    url.QueryEscape(returnTo),
+		MaxAge:   int(returnToTTL.Seconds()),
 		Path:     "/",
 		HttpOnly: true,
 		SameSite: http.SameSiteLaxMode,
 	})
 }
 
-// Helper functions
+// takeReturnTo consumes the remembered destination, checking it again in
+// case the cookie was planted, and falls back to fallback
+func takeReturnTo(c echo.Context, forward *auth.ForwardPolicy, fallback string) string {
+	cookie, err := c.Cookie(returnToCookie)
+	if err != nil {
+		return fallback
+	}
+	c.SetCookie(&http.Cookie{Name: returnToCookie, Value: "", MaxAge: -1, Path: "/", HttpOnly: true})
 
-// generateToken creates a random 32-byte hex token
-func generateToken() string {
-	b := make([]byte, 32)
-	rand.Read(b)
-	return hex.EncodeToString(b)
+	raw, err := url.QueryUnescape(cookie.Value)
+	if err != nil {
+		return fallback
+	}
+	if returnTo, ok := forward.ReturnURL(raw); ok {
+		return returnTo
+	}
+	return fallback
 }
 
-// hashPassword hashes a password using bcrypt
-func hashPassword(password string) string {
-	hash, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	return string(hash)
+// safeReturnTo only allows local paths as post-login destinations
+func safeReturnTo(raw string) string {
+	if !strings.HasPrefix(raw, "/") || strings.HasPrefix(raw, "//") || strings.HasPrefix(raw, "/\\") {
+		return "/dashboard"
+	}
+	return raw
 }
 
-// verifyPassword checks if a password matches its hash
-func verifyPassword(hash, password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
-}
+// Helper functions
 
 // GetCurrentUser retrieves the current authenticated user from context
 func GetCurrentUser(c echo.Context) (*models.User, error) {
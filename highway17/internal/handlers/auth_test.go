@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"citadel/highway17/internal/audit"
+	"citadel/highway17/internal/auth"
+	"citadel/highway17/internal/config"
+	"citadel/highway17/internal/database/databasetest"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+func newTestAuthHandler(t *testing.T, store *databasetest.Store) *AuthHandler {
+	t.Helper()
+
+	cfg := &config.Config{
+		AuthBackends:         []string{"local"},
+		PasswordLoginEnabled: true,
+		SessionIdleTimeout:   3600,
+		SessionMaxLifetime:   86400,
+		LoginMaxAttempts:     2,
+		LoginLockoutBase:     60,
+		LoginLockoutMax:      600,
+		LoginFailureWindow:   900,
+	}
+	log := zap.NewNop()
+
+	backends, err := auth.NewBackends(cfg, store, log)
+	if err != nil {
+		t.Fatalf("NewBackends: %v", err)
+	}
+	forward, err := auth.ParseForwardRules("")
+	if err != nil {
+		t.Fatalf("ParseForwardRules: %v", err)
+	}
+
+	return NewAuthHandler(
+		cfg, store, log, audit.NewRecorder(store, log), auth.NewSessions(cfg, store),
+		auth.NewLockout(cfg, store, log), auth.NewTwoFactor(cfg, store), backends, auth.NewSetup(store), forward,
+	)
+}
+
+func createTestUser(t *testing.T, store *databasetest.Store, username, password string) int {
+	t.Helper()
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	id, err := store.CreateUser(context.Background(), username, hash, "viewer", false)
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	return id
+}
+
+func postLogin(ah *AuthHandler, username, password string) (*httptest.ResponseRecorder, LoginResponse) {
+	body := `{"username":"` + username + `","password":"` + password + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.RemoteAddr = "192.0.2.10:51234"
+	rec := httptest.NewRecorder()
+
+	c := echo.New().NewContext(req, rec)
+	if err := ah.Login(c); err != nil {
+		rec.Code = http.StatusInternalServerError
+	}
+
+	var resp LoginResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	return rec, resp
+}
+
+func TestLogin(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("valid credentials start a session", func(t *testing.T) {
+		store := databasetest.NewStore()
+		ah := newTestAuthHandler(t, store)
+		userID := createTestUser(t, store, "alyx", "correct horse")
+
+		rec, resp := postLogin(ah, "alyx", "correct horse")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+		}
+		if resp.Redirect != "/dashboard" {
+			t.Errorf("redirect = %q, want /dashboard", resp.Redirect)
+		}
+
+		session, err := store.GetSessionByToken(ctx, resp.Token)
+		if err != nil {
+			t.Fatalf("GetSessionByToken: %v", err)
+		}
+		if session.UserID != userID || session.IPAddress != "192.0.2.10" {
+			t.Errorf("session = user %d from %q, want user %d from 192.0.2.10", session.UserID, session.IPAddress, userID)
+		}
+
+		events := store.AuditEvents()
+		if len(events) != 1 || events[0].Action != audit.ActionLogin {
+			t.Errorf("audit events = %+v, want one %s", events, audit.ActionLogin)
+		}
+	})
+
+	t.Run("wrong password is counted and then locked out", func(t *testing.T) {
+		store := databasetest.NewStore()
+		ah := newTestAuthHandler(t, store)
+		createTestUser(t, store, "alyx", "correct horse")
+
+		for i := range 2 {
+			if rec, _ := postLogin(ah, "alyx", "wrong"); rec.Code != http.StatusUnauthorized {
+				t.Fatalf("attempt %d: status = %d, want 401", i+1, rec.Code)
+			}
+		}
+
+		rec, _ := postLogin(ah, "alyx", "correct horse")
+		if rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("status = %d, want 429", rec.Code)
+		}
+		if rec.Header().Get("Retry-After") == "" {
+			t.Error("missing Retry-After header")
+		}
+
+		lockouts, err := store.ListLoginLockouts(ctx)
+		if err != nil {
+			t.Fatalf("ListLoginLockouts: %v", err)
+		}
+		if len(lockouts) != 2 {
+			t.Fatalf("lockouts = %+v, want one per username and IP", lockouts)
+		}
+		for _, l := range lockouts {
+			if !l.Locked || l.Failures != 2 {
+				t.Errorf("lockout %s/%s = %d failures, locked %v; want 2, true", l.Scope, l.Subject, l.Failures, l.Locked)
+			}
+		}
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		store := databasetest.NewStore()
+		ah := newTestAuthHandler(t, store)
+
+		if rec, _ := postLogin(ah, "nobody", "secret"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("status = %d, want 401", rec.Code)
+		}
+	})
+
+	t.Run("two-factor users get a challenge instead of a session", func(t *testing.T) {
+		store := databasetest.NewStore()
+		ah := newTestAuthHandler(t, store)
+		userID := createTestUser(t, store, "alyx", "correct horse")
+		if err := store.SetPendingTOTPSecret(ctx, userID, "JBSWY3DPEHPK3PXP"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.EnableTOTP(ctx, userID, 1, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		rec, resp := postLogin(ah, "alyx", "correct horse")
+		if rec.Code != http.StatusOK || !resp.TwoFactorRequired || resp.Token != "" {
+			t.Fatalf("status = %d, response = %+v; want a challenge and no token", rec.Code, resp)
+		}
+
+		got, err := store.GetLoginChallenge(ctx, resp.Challenge)
+		if err != nil || got != userID {
+			t.Errorf("GetLoginChallenge = %d, %v; want %d", got, err, userID)
+		}
+		if sessions, _ := store.ListSessions(ctx, userID); len(sessions) != 0 {
+			t.Errorf("sessions = %d, want none before the second factor", len(sessions))
+		}
+	})
+}
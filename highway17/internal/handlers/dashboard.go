@@ -3,48 +3,70 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"strconv"
+	"time"
 
+	"citadel/highway17/internal/audit"
+	"citadel/highway17/internal/auth"
 	"citadel/highway17/internal/config"
 	"citadel/highway17/internal/database"
+	"citadel/highway17/internal/metrics"
 	"citadel/highway17/internal/services"
+	"citadel/highway17/web/components"
 
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
 )
 
+// Sparklines in the system widget cover sparklineRange in sparklineStep steps
+const (
+	sparklineRange = time.Hour
+	sparklineStep  = 2 * time.Minute
+)
+
 type DashboardHandler struct {
 	cfg                *config.Config
-	db                 *database.DB
+	db                 database.WidgetStore
 	log                *zap.Logger
+	audit              *audit.Recorder
 	weatherService     *services.WeatherService
 	systemStatsService *services.SystemStatsService
+	metrics            *metrics.Recorder
 }
 
 func NewDashboardHandler(
 	cfg *config.Config,
-	db *database.DB,
+	db database.WidgetStore,
 	log *zap.Logger,
+	rec *audit.Recorder,
 	ws *services.WeatherService,
 	ss *services.SystemStatsService,
+	mr *metrics.Recorder,
 ) *DashboardHandler {
 	return &DashboardHandler{
 		cfg:                cfg,
 		db:                 db,
 		log:                log,
+		audit:              rec,
 		weatherService:     ws,
 		systemStatsService: ss,
+		metrics:            mr,
 	}
 }
 
-// Dashboard serves the main dashboard page (HTML)
+// Dashboard serves the main dashboard page (HTML). Users without write
+// access get the read-only variant.
 func (dh *DashboardHandler) Dashboard(c echo.Context) error {
-	// TODO: Return templ component with proper authentication
-	// For now, return JSON indicating dashboard is loaded
-	return c.JSON(200, map[string]string{
-		"message": "dashboard loaded",
-		"status":  "ok",
-	})
+	user, err := GetCurrentUser(c)
+	if err != nil {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	readOnly := !auth.UserCan(user, auth.PermWidgetsWrite)
+	return render(c, http.StatusOK, components.Dashboard(readOnly))
 }
 
 // GetWeatherWidget returns current weather as JSON
@@ -57,32 +79,69 @@ func (dh *DashboardHandler) GetWeatherWidget(c echo.Context) error {
 		return c.JSON(500, map[string]string{"error": "failed to fetch weather"})
 	}
 
+	if isHTMX(c) {
+		return render(c, http.StatusOK, components.WeatherWidget(weather))
+	}
+
 	return c.JSON(200, weather)
 }
 
-// GetSystemStatsWidget returns system statistics as JSON
+// GetSystemStatsWidget returns system statistics as JSON, or as the widget
+// with sparklines of the last hour for HTMX
 func (dh *DashboardHandler) GetSystemStatsWidget(c echo.Context) error {
 	ctx := context.Background()
 
 	stats, err := dh.systemStatsService.GetStats(ctx)
+	if errors.Is(err, services.ErrNoStats) {
+		return c.JSON(503, map[string]string{"error": "system stats are not available"})
+	}
 	if err != nil {
 		dh.log.Sugar().Errorw("failed to get system stats", "error", err)
 		return c.JSON(500, map[string]string{"error": "failed to fetch system stats"})
 	}
 
+	if isHTMX(c) {
+		return render(c, http.StatusOK, components.SystemStatsWidget(stats, dh.sparklines(ctx)))
+	}
+
 	return c.JSON(200, stats)
 }
 
+// sparklines returns the recent history of each charted metric. Metrics
+// without history are left out rather than failing the widget.
+func (dh *DashboardHandler) sparklines(ctx context.Context) []components.Chart {
+	now := time.Now()
+	var charts []components.Chart
+	for _, spec := range components.SystemCharts {
+		series, err := dh.metrics.Series(ctx, spec.Metric, now.Add(-sparklineRange), now, sparklineStep)
+		if err != nil {
+			if !errors.Is(err, metrics.ErrUnknownMetric) {
+				dh.log.Sugar().Warnw("failed to load sparkline", "metric", spec.Metric, "error", err)
+			}
+			continue
+		}
+		charts = append(charts, components.Chart{ChartSpec: spec, Series: series})
+	}
+	return charts
+}
+
 // GetUptimeWidget returns uptime information
 func (dh *DashboardHandler) GetUptimeWidget(c echo.Context) error {
 	ctx := context.Background()
 
 	stats, err := dh.systemStatsService.GetStats(ctx)
+	if errors.Is(err, services.ErrNoStats) {
+		return c.JSON(503, map[string]string{"error": "system stats are not available"})
+	}
 	if err != nil {
 		dh.log.Sugar().Errorw("failed to get uptime", "error", err)
 		return c.JSON(500, map[string]string{"error": "failed to fetch uptime"})
 	}
 
+	if isHTMX(c) {
+		return render(c, http.StatusOK, components.UptimeWidget(stats.UptimeSeconds))
+	}
+
 	// Format uptime
 	days := stats.UptimeSeconds / 86400
 	hours := (stats.UptimeSeconds % 86400) / 3600
@@ -117,21 +176,40 @@ func (dh *DashboardHandler) SaveWidgetData(c echo.Context) error {
 	}
 
 	// Validate required fields
-	if req.UserID == 0 || req.WidgetName == "" || req.WidgetKey == "" {
+	if req.WidgetName == "" || req.WidgetKey == "" {
 		return c.JSON(400, map[string]string{"error": "missing required fields"})
 	}
 
+	userID, err := widgetDataOwner(c, req.UserID)
+	if err != nil {
+		return c.JSON(403, map[string]string{"error": err.Error()})
+	}
+
 	// Ensure ValueJSON is valid JSON
 	var jsonData interface{}
 	if err := json.Unmarshal([]byte(req.ValueJSON), &jsonData); err != nil {
 		return c.JSON(400, map[string]string{"error": "invalid JSON in value_json"})
 	}
 
-	if err := dh.db.SaveWidgetData(ctx, req.UserID, req.WidgetName, req.WidgetKey, req.ValueJSON); err != nil {
+	// Previous value for the audit diff
+	var before any
+	if old, err := dh.db.GetWidgetData(ctx, userID, req.WidgetName, req.WidgetKey); err == nil {
+		before = json.RawMessage(old)
+	}
+
+	if err := dh.db.SaveWidgetData(ctx, userID, req.WidgetName, req.WidgetKey, req.ValueJSON); err != nil {
 		dh.log.Sugar().Errorw("failed to save widget data", "error", err)
 		return c.JSON(500, map[string]string{"error": "failed to save widget data"})
 	}
 
+	dh.audit.Request(c, audit.Event{
+		Action:     audit.ActionWidgetSave,
+		TargetType: "widget_data",
+		TargetID:   fmt.Sprintf("%d/%s/%s", userID, req.WidgetName, req.WidgetKey),
+		Before:     before,
+		After:      json.RawMessage(req.ValueJSON),
+	})
+
 	return c.JSON(200, map[string]string{"message": "widget data saved"})
 }
 
@@ -139,24 +217,34 @@ func (dh *DashboardHandler) SaveWidgetData(c echo.Context) error {
 func (dh *DashboardHandler) GetWidgetData(c echo.Context) error {
 	ctx := context.Background()
 
-	userID := c.QueryParam("user_id")
 	widgetName := c.QueryParam("widget_name")
 	widgetKey := c.QueryParam("widget_key")
 
-	if userID == "" || widgetName == "" || widgetKey == "" {
+	if widgetName == "" || widgetKey == "" {
 		return c.JSON(400, map[string]string{"error": "missing query parameters"})
 	}
 
-	// Convert userID to int (simple parsing)
-	var uid int
-	if err := json.Unmarshal([]byte(userID), &uid); err != nil {
-		return c.JSON(400, map[string]string{"error": "invalid user_id"})
+	var requested int
+	if raw := c.QueryParam("user_id"); raw != "" {
+		id, err := strconv.Atoi(raw)
+		if err != nil {
+			return c.JSON(400, map[string]string{"error": "invalid user_id"})
+		}
+		requested = id
+	}
+
+	uid, err := widgetDataOwner(c, requested)
+	if err != nil {
+		return c.JSON(403, map[string]string{"error": err.Error()})
 	}
 
 	data, err := dh.db.GetWidgetData(ctx, uid, widgetName, widgetKey)
 	if err != nil {
-		dh.log.Sugar().Warnw("widget data not found", "user_id", uid, "widget_name", widgetName)
-		return c.JSON(404, map[string]string{"error": "widget data not found"})
+		if errors.Is(err, database.ErrWidgetDataNotFound) {
+			return c.JSON(404, map[string]string{"error": "widget data not found"})
+		}
+		dh.log.Sugar().Errorw("failed to get widget data", "user_id", uid, "widget_name", widgetName, "error", err)
+		return c.JSON(500, map[string]string{"error": "failed to get widget data"})
 	}
 
 	// Parse and return JSON
@@ -168,6 +256,22 @@ func (dh *DashboardHandler) GetWidgetData(c echo.Context) error {
 
 // Helper functions
 
+// widgetDataOwner resolves whose widget data a request addresses. Users
+// default to their own; only admins may name another user_id.
+func widgetDataOwner(c echo.Context, requested int) (int, error) {
+	user, err := GetCurrentUser(c)
+	if err != nil {
+		return 0, err
+	}
+	if requested == 0 || requested == user.ID {
+		return user.ID, nil
+	}
+	if !auth.UserCan(user, auth.PermAdmin) {
+		return 0, fmt.Errorf("cannot access another user's widget data")
+	}
+	return requested, nil
+}
+
 // formatUptime formats uptime into a readable string
 func formatUptime(days, hours, minutes uint64) string {
 	if days > 0 {
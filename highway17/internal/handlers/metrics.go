@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"citadel/highway17/internal/config"
+	"citadel/highway17/internal/metrics"
+	"citadel/highway17/web/components"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+const (
+	// metricsDefaultRange is the series range when neither range nor from is given
+	metricsDefaultRange = time.Hour
+	// metricsPageRange is the metrics page's range when none is chosen
+	metricsPageRange = "24h"
+	// metricsPagePoints is roughly how many points each page chart has
+	metricsPagePoints = 120
+)
+
+type MetricsHandler struct {
+	cfg      *config.Config
+	log      *zap.Logger
+	recorder *metrics.Recorder
+}
+
+func NewMetricsHandler(cfg *config.Config, log *zap.Logger, recorder *metrics.Recorder) *MetricsHandler {
+	return &MetricsHandler{
+		cfg:      cfg,
+		log:      log,
+		recorder: recorder,
+	}
+}
+
+// Page charts the system metrics over 1h, 24h, 7d or 30d, ending now or at
+// the RFC 3339 time in to
+func (mh *MetricsHandler) Page(c echo.Context) error {
+	ctx := context.Background()
+
+	view := components.MetricsView{Range: c.QueryParam("range"), Now: time.Now().UTC()}
+	if view.Range == "" {
+		view.Range = metricsPageRange
+	}
+	span, ok := components.MetricsRangeSpan(view.Range)
+	if !ok {
+		view.Error = "range must be 1h, 24h, 7d or 30d"
+		return render(c, http.StatusBadRequest, components.MetricsPage(view))
+	}
+	if raw := c.QueryParam("to"); raw != "" {
+		to, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			view.Error = "invalid to: expected RFC 3339"
+			return render(c, http.StatusBadRequest, components.MetricsPage(view))
+		}
+		if to.Before(view.Now) {
+			view.To = to.UTC()
+		}
+	}
+
+	end := view.Now
+	if !view.To.IsZero() {
+		end = view.To
+	}
+	for _, spec := range components.SystemCharts {
+		series, err := mh.recorder.Series(ctx, spec.Metric, end.Add(-span), end, span/metricsPagePoints)
+		if err != nil && !errors.Is(err, metrics.ErrUnknownMetric) {
+			mh.log.Sugar().Errorw("failed to query metric series", "metric", spec.Metric, "error", err)
+			return c.String(500, "failed to load metrics")
+		}
+		view.Charts = append(view.Charts, components.Chart{ChartSpec: spec, Series: series})
+	}
+
+	return render(c, http.StatusOK, components.MetricsPage(view))
+}
+
+// List returns the names of the metrics that have history
+func (mh *MetricsHandler) List(c echo.Context) error {
+	ctx := context.Background()
+
+	names, err := mh.recorder.Metrics(ctx)
+	if err != nil {
+		mh.log.Sugar().Errorw("failed to list metrics", "error", err)
+		return c.JSON(500, map[string]string{"error": "failed to list metrics"})
+	}
+	if names == nil {
+		names = []string{}
+	}
+
+	return c.JSON(200, map[string]interface{}{"metrics": names})
+}
+
+// Series returns one metric's history. The range is from and to (RFC 3339;
+// to defaults to now) or range, a duration back from now such as 24h or 7d.
+// step is a duration too; without it one is chosen to suit the range.
+func (mh *MetricsHandler) Series(c echo.Context) error {
+	ctx := context.Background()
+
+	metric := c.QueryParam("metric")
+	if metric == "" {
+		return c.JSON(400, map[string]string{"error": "metric is required"})
+	}
+
+	from, to, step, err := parseMetricsRange(c, time.Now())
+	if err != nil {
+		return c.JSON(400, map[string]string{"error": err.Error()})
+	}
+
+	series, err := mh.recorder.Series(ctx, metric, from, to, step)
+	if errors.Is(err, metrics.ErrUnknownMetric) {
+		return c.JSON(404, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		mh.log.Sugar().Errorw("failed to query metric series", "metric", metric, "error", err)
+		return c.JSON(500, map[string]string{"error": "failed to query metric series"})
+	}
+
+	return c.JSON(200, series)
+}
+
+// parseMetricsRange reads from/to or range, and step, from query parameters
+func parseMetricsRange(c echo.Context, now time.Time) (from, to time.Time, step time.Duration, err error) {
+	to = now
+	if raw := c.QueryParam("to"); raw != "" {
+		if to, err = time.Parse(time.RFC3339, raw); err != nil {
+			return from, to, 0, fmt.Errorf("invalid to: expected RFC 3339")
+		}
+	}
+
+	switch raw := c.QueryParam("from"); {
+	case raw != "":
+		if from, err = time.Parse(time.RFC3339, raw); err != nil {
+			return from, to, 0, fmt.Errorf("invalid from: expected RFC 3339")
+		}
+	case c.QueryParam("range") != "":
+		span, err := parseMetricsDuration(c.QueryParam("range"))
+		if err != nil || span <= 0 {
+			return from, to, 0, fmt.Errorf("invalid range: expected a duration such as 1h or 7d")
+		}
+		from = to.Add(-span)
+	default:
+		from = to.Add(-metricsDefaultRange)
+	}
+	if !from.Before(to) {
+		return from, to, 0, fmt.Errorf("from must be before to")
+	}
+
+	if raw := c.QueryParam("step"); raw != "" {
+		if step, err = parseMetricsDuration(raw); err != nil || step <= 0 {
+			return from, to, 0, fmt.Errorf("invalid step: expected a duration such as 30s or 5m")
+		}
+	}
+
+	return from, to, step, nil
+}
+
+// parseMetricsDuration is time.ParseDuration plus a whole-day "d" unit
+func parseMetricsDuration(raw string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(raw, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(raw)
+}
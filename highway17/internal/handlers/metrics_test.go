@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestParseMetricsRange(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	to := time.Date(2026, 2, 20, 6, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		query    string
+		wantFrom time.Time
+		wantTo   time.Time
+		wantStep time.Duration
+		wantErr  string
+	}{
+		{query: "", wantFrom: now.Add(-time.Hour), wantTo: now},
+		{query: "range=15m", wantFrom: now.Add(-15 * time.Minute), wantTo: now},
+		{query: "range=1h30m", wantFrom: now.Add(-90 * time.Minute), wantTo: now},
+		{query: "range=7d", wantFrom: now.Add(-7 * 24 * time.Hour), wantTo: now},
+		{query: "range=24h&to=2026-02-20T06:00:00Z", wantFrom: to.Add(-24 * time.Hour), wantTo: to},
+		{query: "from=2026-02-19T00:00:00Z&to=2026-02-20T06:00:00Z", wantFrom: time.Date(2026, 2, 19, 0, 0, 0, 0, time.UTC), wantTo: to},
+		// from wins over range
+		{query: "from=2026-03-01T11:00:00%2B01:00&range=7d", wantFrom: time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC), wantTo: now},
+		{query: "step=30s", wantFrom: now.Add(-time.Hour), wantTo: now, wantStep: 30 * time.Second},
+		{query: "range=30d&step=1d", wantFrom: now.Add(-30 * 24 * time.Hour), wantTo: now, wantStep: 24 * time.Hour},
+
+		{query: "to=yesterday", wantErr: "invalid to"},
+		{query: "from=2026-02-19", wantErr: "invalid from"},
+		{query: "range=week", wantErr: "invalid range"},
+		{query: "range=1.5d", wantErr: "invalid range"},
+		{query: "range=d", wantErr: "invalid range"},
+		{query: "range=0d", wantErr: "invalid range"},
+		{query: "range=-1h", wantErr: "invalid range"},
+		{query: "from=2026-03-01T12:00:00Z", wantErr: "from must be before to"},
+		{query: "from=2026-03-02T00:00:00Z", wantErr: "from must be before to"},
+		{query: "step=0s", wantErr: "invalid step"},
+		{query: "step=-5m", wantErr: "invalid step"},
+		{query: "step=fast", wantErr: "invalid step"},
+		{query: "step=2xd", wantErr: "invalid step"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/metrics?"+tt.query, nil)
+			c := echo.New().NewContext(req, httptest.NewRecorder())
+
+			from, to, step, err := parseMetricsRange(c, now)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !from.Equal(tt.wantFrom) || !to.Equal(tt.wantTo) || step != tt.wantStep {
+				t.Errorf("range = %s to %s step %s, want %s to %s step %s", from, to, step, tt.wantFrom, tt.wantTo, tt.wantStep)
+			}
+		})
+	}
+}
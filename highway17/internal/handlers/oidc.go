@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	"citadel/highway17/internal/audit"
+	"citadel/highway17/internal/auth"
+	"citadel/highway17/internal/config"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// oidcStateCookie binds a pending OIDC flow to the browser that started it
+const oidcStateCookie = "h17_oidc_state"
+
+type OIDCHandler struct {
+	cfg      *config.Config
+	log      *zap.Logger
+	audit    *audit.Recorder
+	sessions *auth.Sessions
+	oidc     *auth.OIDC
+	forward  *auth.ForwardPolicy
+}
+
+func NewOIDCHandler(cfg *config.Config, log *zap.Logger, rec *audit.Recorder, sessions *auth.Sessions, o *auth.OIDC, forward *auth.ForwardPolicy) *OIDCHandler {
+	return &OIDCHandler{
+		cfg:      cfg,
+		log:      log,
+		audit:    rec,
+		sessions: sessions,
+		oidc:     o,
+		forward:  forward,
+	}
+}
+
+// Login sends the browser to the identity provider
+func (oh *OIDCHandler) Login(c echo.Context) error {
+	return oh.begin(c, nil, safeReturnTo(c.QueryParam("return_to")))
+}
+
+// Link starts a flow that attaches an identity to the signed-in user
+func (oh *OIDCHandler) Link(c echo.Context) error {
+	user, err := GetCurrentUser(c)
+	if err != nil {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+	return oh.begin(c, &user.ID, "/account/2fa")
+}
+
+func (oh *OIDCHandler) begin(c echo.Context, linkUserID *int, returnTo string) error {
+	ctx := context.Background()
+
+	url, state, err := oh.oidc.Begin(ctx, linkUserID, returnTo)
+	if err != nil {
+		oh.log.Sugar().Errorw("failed to start oidc login", "error", err)
+		return c.Redirect(http.StatusSeeOther, "/login?error=sso")
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		MaxAge:   int(auth.OIDCStateTTL.Seconds()),
+		Path:     "/auth/oidc",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return c.Redirect(http.StatusFound, url)
+}
+
+// Callback completes the flow and starts a session
+func (oh *OIDCHandler) Callback(c echo.Context) error {
+	ctx := context.Background()
+	clientIP := c.RealIP()
+
+	state := c.QueryParam("state")
+	cookie, err := c.Cookie(oidcStateCookie)
+	c.SetCookie(&http.Cookie{Name: oidcStateCookie, Value: "", MaxAge: -1, Path: "/auth/oidc", HttpOnly: true})
+
+	if idpErr := c.QueryParam("error"); idpErr != "" {
+		oh.log.Sugar().Warnw("login failed - identity provider refused", "client_ip", clientIP, "error", idpErr, "description", c.QueryParam("error_description"))
+		oh.auditFailure(c, "identity provider refused: "+idpErr)
+		return c.Redirect(http.StatusSeeOther, "/login?error=sso")
+	}
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		oh.log.Sugar().Warnw("login failed - oidc state mismatch", "client_ip", clientIP)
+		oh.auditFailure(c, "state mismatch")
+		return c.Redirect(http.StatusSeeOther, "/login?error=sso")
+	}
+
+	result, err := oh.oidc.Finish(ctx, state, c.QueryParam("code"))
+	if err != nil {
+		if errors.Is(err, auth.ErrOIDCUnknownUser) || errors.Is(err, auth.ErrOIDCStateExpired) {
+			oh.log.Sugar().Warnw("login failed - oidc", "client_ip", clientIP, "error", err)
+		} else {
+			oh.log.Sugar().Errorw("login failed - oidc", "client_ip", clientIP, "error", err)
+		}
+		oh.auditFailure(c, err.Error())
+		return c.Redirect(http.StatusSeeOther, "/login?error=sso")
+	}
+
+	if result.Linked {
+		oh.log.Sugar().Infow("oidc identity linked", "username", result.User.Username, "client_ip", clientIP)
+		oh.audit.Request(c, audit.Event{
+			Action:     audit.ActionOIDCLink,
+			ActorID:    &result.User.ID,
+			ActorName:  result.User.Username,
+			TargetType: "user",
+			TargetID:   result.User.Username,
+			After:      map[string]string{"oidc_issuer": result.User.OIDCIssuer, "oidc_subject": result.User.OIDCSubject},
+		})
+		return c.Redirect(http.StatusSeeOther, result.ReturnTo)
+	}
+
+	if _, err := oh.sessions.Start(c, result.User.ID); err != nil {
+		oh.log.Sugar().Errorw("failed to create session", "error", err)
+		return c.String(500, "failed to create session")
+	}
+
+	oh.log.Sugar().Infow("user logged in", "username", result.User.Username, "client_ip", clientIP, "method", "oidc")
+	oh.audit.Request(c, audit.Event{
+		Action:    audit.ActionLogin,
+		ActorID:   &result.User.ID,
+		ActorName: result.User.Username,
+		Details:   map[string]any{"method": "oidc"},
+	})
+	return c.Redirect(http.StatusSeeOther, takeReturnTo(c, oh.forward, result.ReturnTo))
+}
+
+// auditFailure records a failed SSO login; the user is usually unknown
+func (oh *OIDCHandler) auditFailure(c echo.Context, reason string) {
+	oh.audit.Request(c, audit.Event{
+		Action:  audit.ActionLoginFailed,
+		Outcome: audit.OutcomeFailure,
+		Details: map[string]any{"method": "oidc", "reason": reason},
+	})
+}
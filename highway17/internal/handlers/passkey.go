@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"citadel/highway17/internal/audit"
+	"citadel/highway17/internal/auth"
+	"citadel/highway17/internal/config"
+	"citadel/highway17/internal/database"
+	"citadel/highway17/web/components"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+type PasskeyHandler struct {
+	cfg      *config.Config
+	db       database.PasskeyStore
+	log      *zap.Logger
+	audit    *audit.Recorder
+	sessions *auth.Sessions
+	passkeys *auth.Passkeys
+	forward  *auth.ForwardPolicy
+}
+
+func NewPasskeyHandler(cfg *config.Config, db database.PasskeyStore, log *zap.Logger, rec *audit.Recorder, sessions *auth.Sessions, pk *auth.Passkeys, forward *auth.ForwardPolicy) *PasskeyHandler {
+	return &PasskeyHandler{
+		cfg:      cfg,
+		db:       db,
+		log:      log,
+		audit:    rec,
+		sessions: sessions,
+		passkeys: pk,
+		forward:  forward,
+	}
+}
+
+// Page lists the current user's passkeys
+func (ph *PasskeyHandler) Page(c echo.Context) error {
+	ctx := context.Background()
+
+	user, err := GetCurrentUser(c)
+	if err != nil {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	passkeys, err := ph.db.ListPasskeys(ctx, user.ID)
+	if err != nil {
+		ph.log.Sugar().Errorw("failed to list passkeys", "error", err)
+		return c.String(500, "failed to list passkeys")
+	}
+
+	return render(c, http.StatusOK, components.PasskeysPage(passkeys))
+}
+
+// List returns the current user's passkeys as JSON
+func (ph *PasskeyHandler) List(c echo.Context) error {
+	ctx := context.Background()
+
+	user, err := GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(401, map[string]string{"error": "authentication required"})
+	}
+
+	passkeys, err := ph.db.ListPasskeys(ctx, user.ID)
+	if err != nil {
+		ph.log.Sugar().Errorw("failed to list passkeys", "error", err)
+		return c.JSON(500, map[string]string{"error": "failed to list passkeys"})
+	}
+
+	return c.JSON(200, passkeys)
+}
+
+// BeginRegistration returns credential creation options for the browser
+func (ph *PasskeyHandler) BeginRegistration(c echo.Context) error {
+	ctx := context.Background()
+
+	user, err := GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(401, map[string]string{"error": "authentication required"})
+	}
+
+	ceremony, options, err := ph.passkeys.BeginRegistration(ctx, user)
+	if err != nil {
+		ph.log.Sugar().Errorw("failed to begin passkey registration", "username", user.Username, "error", err)
+		return c.JSON(500, map[string]string{"error": "failed to begin registration"})
+	}
+
+	return c.JSON(200, map[string]interface{}{
+		"ceremony": ceremony,
+		"options":  options,
+	})
+}
+
+// FinishRegistration verifies the attestation and stores the new passkey
+func (ph *PasskeyHandler) FinishRegistration(c echo.Context) error {
+	ctx := context.Background()
+
+	user, err := GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(401, map[string]string{"error": "authentication required"})
+	}
+
+	name := c.QueryParam("name")
+	if len(name) > 100 {
+		return c.JSON(400, map[string]string{"error": "name too long"})
+	}
+
+	if err := ph.passkeys.FinishRegistration(ctx, user, c.QueryParam("ceremony"), name, c.Request()); err != nil {
+		if errors.Is(err, auth.ErrCeremonyExpired) {
+			return c.JSON(400, map[string]string{"error": "registration expired, try again"})
+		}
+		ph.log.Sugar().Warnw("passkey registration failed", "username", user.Username, "error", err)
+		return c.JSON(400, map[string]string{"error": "passkey registration failed"})
+	}
+
+	ph.log.Sugar().Infow("passkey registered", "username", user.Username, "name", name)
+	ph.audit.Request(c, audit.Event{Action: audit.ActionPasskeyAdd, TargetType: "passkey", After: map[string]string{"name": name}})
+	return c.JSON(200, map[string]string{"message": "passkey registered"})
+}
+
+// Rename changes the display name of one of the user's passkeys
+func (ph *PasskeyHandler) Rename(c echo.Context) error {
+	ctx := context.Background()
+
+	user, err := GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(401, map[string]string{"error": "authentication required"})
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(400, map[string]string{"error": "invalid passkey id"})
+	}
+
+	var req struct {
+		Name string `json:"name" form:"name"`
+	}
+	if err := c.Bind(&req); err != nil || req.Name == "" || len(req.Name) > 100 {
+		return c.JSON(400, map[string]string{"error": "name must be 1-100 characters"})
+	}
+
+	found, err := ph.db.RenamePasskey(ctx, user.ID, id, req.Name)
+	if err != nil {
+		ph.log.Sugar().Errorw("failed to rename passkey", "error", err)
+		return c.JSON(500, map[string]string{"error": "failed to rename passkey"})
+	}
+	if !found {
+		return c.JSON(404, map[string]string{"error": "passkey not found"})
+	}
+
+	return c.JSON(200, map[string]string{"message": "passkey renamed"})
+}
+
+// Delete removes one of the user's passkeys
+func (ph *PasskeyHandler) Delete(c echo.Context) error {
+	ctx := context.Background()
+
+	user, err := GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(401, map[string]string{"error": "authentication required"})
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(400, map[string]string{"error": "invalid passkey id"})
+	}
+
+	found, err := ph.db.DeletePasskey(ctx, user.ID, id)
+	if err != nil {
+		ph.log.Sugar().Errorw("failed to delete passkey", "error", err)
+		return c.JSON(500, map[string]string{"error": "failed to delete passkey"})
+	}
+	if !found {
+		return c.JSON(404, map[string]string{"error": "passkey not found"})
+	}
+
+	ph.log.Sugar().Infow("passkey removed", "username", user.Username, "id", id)
+	ph.audit.Request(c, audit.Event{Action: audit.ActionPasskeyDelete, TargetType: "passkey", TargetID: strconv.Itoa(id)})
+
+	if isHTMX(c) {
+		return c.NoContent(200)
+	}
+	return c.JSON(200, map[string]string{"message": "passkey removed"})
+}
+
+// BeginLogin returns assertion options for a username-less passkey login
+func (ph *PasskeyHandler) BeginLogin(c echo.Context) error {
+	ctx := context.Background()
+
+	ceremony, options, err := ph.passkeys.BeginLogin(ctx)
+	if err != nil {
+		ph.log.Sugar().Errorw("failed to begin passkey login", "error", err)
+		return c.JSON(500, map[string]string{"error": "failed to begin login"})
+	}
+
+	return c.JSON(200, map[string]interface{}{
+		"ceremony": ceremony,
+		"options":  options,
+	})
+}
+
+// FinishLogin verifies the assertion and issues a session
+func (ph *PasskeyHandler) FinishLogin(c echo.Context) error {
+	ctx := context.Background()
+	clientIP := c.RealIP()
+
+	user, err := ph.passkeys.FinishLogin(ctx, c.QueryParam("ceremony"), c.Request())
+	if err != nil {
+		ph.log.Sugar().Warnw("login failed - passkey rejected", "client_ip", clientIP, "error", err)
+		ph.audit.Request(c, audit.Event{
+			Action:  audit.ActionLoginFailed,
+			Outcome: audit.OutcomeFailure,
+			Details: map[string]any{"method": "passkey", "reason": "assertion rejected"},
+		})
+		return c.JSON(401, map[string]string{"error": "passkey login failed"})
+	}
+
+	token, err := ph.sessions.Start(c, user.ID)
+	if err != nil {
+		ph.log.Sugar().Errorw("failed to create session", "error", err)
+		return c.JSON(500, map[string]string{"error": "failed to create session"})
+	}
+
+	ph.log.Sugar().Infow("user logged in", "username", user.Username, "client_ip", clientIP, "method", "passkey")
+	ph.audit.Request(c, audit.Event{
+		Action:    audit.ActionLogin,
+		ActorID:   &user.ID,
+		ActorName: user.Username,
+		Details:   map[string]any{"method": "passkey"},
+	})
+
+	return c.JSON(200, LoginResponse{
+		Token:    token,
+		Username: user.Username,
+		Message:  "logged in successfully",
+		Redirect: takeReturnTo(c, ph.forward, "/dashboard"),
+	})
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"citadel/highway17/internal/audit"
+	"citadel/highway17/internal/auth"
+	"citadel/highway17/internal/config"
+	"citadel/highway17/internal/database"
+	"citadel/highway17/web/components"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+type PasswordHandler struct {
+	cfg   *config.Config
+	db    database.Store
+	log   *zap.Logger
+	audit *audit.Recorder
+}
+
+func NewPasswordHandler(cfg *config.Config, db database.Store, log *zap.Logger, rec *audit.Recorder) *PasswordHandler {
+	return &PasswordHandler{
+		cfg:   cfg,
+		db:    db,
+		log:   log,
+		audit: rec,
+	}
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" form:"current_password"`
+	NewPassword     string `json:"new_password" form:"new_password"`
+}
+
+// Page serves the change-password form, which is also where users with a
+// pending forced change are sent
+func (ph *PasswordHandler) Page(c echo.Context) error {
+	user, err := GetCurrentUser(c)
+	if err != nil {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	return render(c, http.StatusOK, components.ChangePasswordPage(user.MustChangePassword, auth.MinPasswordLength))
+}
+
+// Change replaces the current user's local password and ends their other sessions
+func (ph *PasswordHandler) Change(c echo.Context) error {
+	ctx := context.Background()
+	req := new(ChangePasswordRequest)
+
+	user, err := GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(401, map[string]string{"error": "authentication required"})
+	}
+	if err := c.Bind(req); err != nil {
+		return c.JSON(400, map[string]string{"error": "invalid request"})
+	}
+
+	if user.PasswordHash == "" {
+		return c.JSON(409, map[string]string{"error": "this account has no local password"})
+	}
+	if !auth.VerifyPassword(user.PasswordHash, req.CurrentPassword) {
+		ph.log.Sugar().Warnw("password change failed - wrong current password", "username", user.Username, "client_ip", c.RealIP())
+		ph.audit.Request(c, audit.Event{
+			Action:     audit.ActionPasswordChange,
+			Outcome:    audit.OutcomeFailure,
+			TargetType: "user",
+			TargetID:   user.Username,
+			Details:    map[string]any{"reason": "wrong current password"},
+		})
+		return c.JSON(403, map[string]string{"error": "current password is incorrect"})
+	}
+	if err := auth.ValidatePassword(req.NewPassword); err != nil {
+		return c.JSON(400, map[string]string{"error": err.Error()})
+	}
+	if req.NewPassword == req.CurrentPassword {
+		return c.JSON(400, map[string]string{"error": "new password must differ from the current one"})
+	}
+
+	hash, err := auth.HashPassword(req.NewPassword)
+	if err != nil {
+		ph.log.Sugar().Errorw("failed to hash password", "error", err)
+		return c.JSON(500, map[string]string{"error": "failed to change password"})
+	}
+	if err := ph.db.SetPassword(ctx, user.ID, hash, false); err != nil {
+		ph.log.Sugar().Errorw("failed to save password", "error", err)
+		return c.JSON(500, map[string]string{"error": "failed to change password"})
+	}
+
+	if _, err := ph.db.DeleteUserSessions(ctx, user.ID, currentSessionToken(c)); err != nil {
+		ph.log.Sugar().Warnw("failed to revoke other sessions", "username", user.Username, "error", err)
+	}
+
+	ph.log.Sugar().Infow("password changed", "username", user.Username, "client_ip", c.RealIP(), "forced", user.MustChangePassword)
+	ph.audit.Request(c, audit.Event{
+		Action:     audit.ActionPasswordChange,
+		TargetType: "user",
+		TargetID:   user.Username,
+		Details:    map[string]any{"forced": user.MustChangePassword},
+	})
+	if isHTMX(c) {
+		c.Response().Header().Set("HX-Redirect", "/dashboard")
+	}
+	return c.JSON(200, map[string]string{"message": "password changed"})
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"citadel/highway17/internal/middleware"
+	"citadel/highway17/web/components"
+
+	"github.com/a-h/templ"
+	"github.com/labstack/echo/v4"
+)
+
+// render writes a templ component as an HTML response, passing along the
+// CSRF token so pages can embed it and the user so Layout can tailor its nav
+func render(c echo.Context, status int, component templ.Component) error {
+	ctx := c.Request().Context()
+	if token, ok := c.Get(middleware.CSRFContextKey).(string); ok {
+		ctx = components.WithCSRFToken(ctx, token)
+	}
+	if user, err := GetCurrentUser(c); err == nil {
+		ctx = components.WithViewer(ctx, user)
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
+	c.Response().WriteHeader(status)
+	return component.Render(ctx, c.Response())
+}
+
+// isHTMX reports whether the request was issued by htmx
+func isHTMX(c echo.Context) bool {
+	return c.Request().Header.Get("HX-Request") == "true"
+}
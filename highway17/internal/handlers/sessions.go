@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"citadel/highway17/internal/audit"
+	"citadel/highway17/internal/auth"
+	"citadel/highway17/internal/config"
+	"citadel/highway17/internal/database"
+	"citadel/highway17/internal/models"
+	"citadel/highway17/web/components"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+type SessionHandler struct {
+	cfg      *config.Config
+	db       database.SessionStore
+	log      *zap.Logger
+	audit    *audit.Recorder
+	sessions *auth.Sessions
+}
+
+func NewSessionHandler(cfg *config.Config, db database.SessionStore, log *zap.Logger, rec *audit.Recorder, sessions *auth.Sessions) *SessionHandler {
+	return &SessionHandler{
+		cfg:      cfg,
+		db:       db,
+		log:      log,
+		audit:    rec,
+		sessions: sessions,
+	}
+}
+
+// Page shows the current user's active sessions
+func (sh *SessionHandler) Page(c echo.Context) error {
+	user, err := GetCurrentUser(c)
+	if err != nil {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	sessions, err := sh.listSessions(c, user.ID)
+	if err != nil {
+		sh.log.Sugar().Errorw("failed to list sessions", "error", err)
+		return c.String(500, "failed to list sessions")
+	}
+
+	return render(c, http.StatusOK, components.SessionsPage(sessions))
+}
+
+// List returns the current user's active sessions as JSON
+func (sh *SessionHandler) List(c echo.Context) error {
+	user, err := GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(401, map[string]string{"error": "authentication required"})
+	}
+
+	sessions, err := sh.listSessions(c, user.ID)
+	if err != nil {
+		sh.log.Sugar().Errorw("failed to list sessions", "error", err)
+		return c.JSON(500, map[string]string{"error": "failed to list sessions"})
+	}
+
+	return c.JSON(200, sessions)
+}
+
+// Revoke ends one of the current user's sessions
+func (sh *SessionHandler) Revoke(c echo.Context) error {
+	ctx := context.Background()
+
+	user, err := GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(401, map[string]string{"error": "authentication required"})
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(400, map[string]string{"error": "invalid session id"})
+	}
+
+	found, err := sh.db.DeleteUserSession(ctx, user.ID, id)
+	if err != nil {
+		sh.log.Sugar().Errorw("failed to revoke session", "error", err)
+		return c.JSON(500, map[string]string{"error": "failed to revoke session"})
+	}
+	if !found {
+		return c.JSON(404, map[string]string{"error": "session not found"})
+	}
+
+	sh.log.Sugar().Infow("session revoked", "username", user.Username, "session_id", id)
+	sh.audit.Request(c, audit.Event{Action: audit.ActionSessionRevoke, TargetType: "session", TargetID: strconv.Itoa(id)})
+
+	if isHTMX(c) {
+		return c.NoContent(200)
+	}
+	return c.JSON(200, map[string]string{"message": "session revoked"})
+}
+
+// RevokeAll ends every other session of the current user, or all of them
+// including this one with ?include_current=true
+func (sh *SessionHandler) RevokeAll(c echo.Context) error {
+	ctx := context.Background()
+
+	user, err := GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(401, map[string]string{"error": "authentication required"})
+	}
+
+	includeCurrent := c.QueryParam("include_current") == "true"
+	keepToken := currentSessionToken(c)
+	if includeCurrent {
+		keepToken = ""
+	}
+
+	revoked, err := sh.db.DeleteUserSessions(ctx, user.ID, keepToken)
+	if err != nil {
+		sh.log.Sugar().Errorw("failed to revoke sessions", "error", err)
+		return c.JSON(500, map[string]string{"error": "failed to revoke sessions"})
+	}
+
+	sh.log.Sugar().Infow("sessions revoked", "username", user.Username, "count", revoked, "include_current", includeCurrent)
+	sh.audit.Request(c, audit.Event{
+		Action:     audit.ActionSessionRevoke,
+		TargetType: "session",
+		TargetID:   user.Username + "/all",
+		Details:    map[string]any{"count": revoked, "include_current": includeCurrent},
+	})
+
+	if includeCurrent {
+		sh.sessions.ClearCookie(c)
+		if isHTMX(c) {
+			c.Response().Header().Set("HX-Redirect", "/login")
+		}
+	} else if isHTMX(c) {
+		c.Response().Header().Set("HX-Refresh", "true")
+	}
+	return c.JSON(200, map[string]interface{}{"message": "sessions revoked", "count": revoked})
+}
+
+func (sh *SessionHandler) listSessions(c echo.Context, userID int) ([]models.Session, error) {
+	sessions, err := sh.db.ListSessions(context.Background(), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	current := currentSessionToken(c)
+	for i := range sessions {
+		sessions[i].Current = sessions[i].Token == current
+	}
+	return sessions, nil
+}
+
+// currentSessionToken returns the token the auth middleware authenticated with
+func currentSessionToken(c echo.Context) string {
+	token, _ := c.Get("session_token").(string)
+	return token
+}
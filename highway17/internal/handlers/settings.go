@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"citadel/highway17/internal/audit"
+	"citadel/highway17/internal/config"
+	"citadel/highway17/internal/database"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// maxSettingLength matches settings.setting_value
+const maxSettingLength = 1000
+
+type SettingsHandler struct {
+	cfg   *config.Config
+	db    database.SettingsStore
+	log   *zap.Logger
+	audit *audit.Recorder
+}
+
+func NewSettingsHandler(cfg *config.Config, db database.SettingsStore, log *zap.Logger, rec *audit.Recorder) *SettingsHandler {
+	return &SettingsHandler{
+		cfg:   cfg,
+		db:    db,
+		log:   log,
+		audit: rec,
+	}
+}
+
+// Get returns one of the current user's settings
+func (sh *SettingsHandler) Get(c echo.Context) error {
+	ctx := context.Background()
+
+	user, err := GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "authentication required"})
+	}
+
+	key := c.Param("key")
+	value, err := sh.db.GetSetting(ctx, user.ID, key)
+	if err != nil {
+		if errors.Is(err, database.ErrSettingNotFound) {
+			return c.JSON(404, map[string]string{"error": "setting not found"})
+		}
+		sh.log.Sugar().Errorw("failed to get setting", "key", key, "error", err)
+		return c.JSON(500, map[string]string{"error": "failed to get setting"})
+	}
+
+	return c.JSON(200, map[string]string{"key": key, "value": value})
+}
+
+// Save creates or replaces one of the current user's settings
+func (sh *SettingsHandler) Save(c echo.Context) error {
+	ctx := context.Background()
+
+	user, err := GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "authentication required"})
+	}
+
+	var req struct {
+		Value string `json:"value" form:"value"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(400, map[string]string{"error": "invalid request"})
+	}
+
+	key := c.Param("key")
+	if key == "" || len(key) > 255 || len(req.Value) > maxSettingLength {
+		return c.JSON(400, map[string]string{"error": "invalid setting"})
+	}
+
+	// Previous value for the audit diff
+	var before any
+	if old, err := sh.db.GetSetting(ctx, user.ID, key); err == nil {
+		before = map[string]string{"value": old}
+	}
+
+	if err := sh.db.SaveSetting(ctx, user.ID, key, req.Value); err != nil {
+		sh.log.Sugar().Errorw("failed to save setting", "key", key, "error", err)
+		return c.JSON(500, map[string]string{"error": "failed to save setting"})
+	}
+
+	sh.audit.Request(c, audit.Event{
+		Action:     audit.ActionSettingSave,
+		TargetType: "setting",
+		TargetID:   key,
+		Before:     before,
+		After:      map[string]string{"value": req.Value},
+	})
+
+	return c.JSON(200, map[string]string{"message": "setting saved"})
+}
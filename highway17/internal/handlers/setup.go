@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"citadel/highway17/internal/audit"
+	"citadel/highway17/internal/auth"
+	"citadel/highway17/internal/config"
+	"citadel/highway17/internal/database"
+	"citadel/highway17/web/components"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+type SetupHandler struct {
+	cfg      *config.Config
+	db       database.Store
+	log      *zap.Logger
+	audit    *audit.Recorder
+	sessions *auth.Sessions
+	setup    *auth.Setup
+}
+
+func NewSetupHandler(cfg *config.Config, db database.Store, log *zap.Logger, rec *audit.Recorder, sessions *auth.Sessions, setup *auth.Setup) *SetupHandler {
+	return &SetupHandler{
+		cfg:      cfg,
+		db:       db,
+		log:      log,
+		audit:    rec,
+		sessions: sessions,
+		setup:    setup,
+	}
+}
+
+type SetupRequest struct {
+	Username string `json:"username" form:"username"`
+	Password string `json:"password" form:"password"`
+}
+
+// Page serves the first-run form until the first admin exists
+func (sh *SetupHandler) Page(c echo.Context) error {
+	ctx := context.Background()
+
+	pending, err := sh.setup.Pending(ctx)
+	if err != nil {
+		sh.log.Sugar().Errorw("failed to check setup state", "error", err)
+		return c.String(500, "failed to check setup state")
+	}
+	if !pending {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	return render(c, http.StatusOK, components.SetupPage(auth.MinPasswordLength))
+}
+
+// Complete creates the first admin and signs them in
+func (sh *SetupHandler) Complete(c echo.Context) error {
+	ctx := context.Background()
+	req := new(SetupRequest)
+
+	if err := c.Bind(req); err != nil {
+		return c.JSON(400, map[string]string{"error": "invalid request"})
+	}
+
+	if err := auth.ValidateUsername(req.Username); err != nil {
+		return c.JSON(400, map[string]string{"error": err.Error()})
+	}
+	if err := auth.ValidatePassword(req.Password); err != nil {
+		return c.JSON(400, map[string]string{"error": err.Error()})
+	}
+
+	id, err := sh.setup.Complete(ctx, req.Username, req.Password)
+	if err != nil {
+		if errors.Is(err, auth.ErrSetupComplete) {
+			return c.JSON(410, map[string]string{"error": err.Error()})
+		}
+		sh.log.Sugar().Errorw("failed to complete setup", "error", err)
+		return c.JSON(500, map[string]string{"error": "failed to create admin account"})
+	}
+
+	sh.log.Sugar().Infow("setup completed", "username", req.Username, "client_ip", c.RealIP())
+	sh.audit.Request(c, audit.Event{
+		Action:     audit.ActionSetup,
+		ActorID:    &id,
+		ActorName:  req.Username,
+		TargetType: "user",
+		TargetID:   req.Username,
+		After:      map[string]string{"role": string(auth.RoleAdmin)},
+	})
+
+	if _, err := sh.sessions.Start(c, id); err != nil {
+		sh.log.Sugar().Errorw("failed to create session", "error", err)
+		return c.JSON(500, map[string]string{"error": "failed to create session"})
+	}
+
+	setLoginRedirect(c, "/dashboard")
+	return c.JSON(201, map[string]string{"message": "admin account created", "username": req.Username})
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"citadel/highway17/internal/audit"
+	"citadel/highway17/internal/auth"
+	"citadel/highway17/internal/config"
+	"citadel/highway17/internal/database"
+	"citadel/highway17/web/components"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+type TokenHandler struct {
+	cfg   *config.Config
+	db    database.Store
+	log   *zap.Logger
+	audit *audit.Recorder
+}
+
+func NewTokenHandler(cfg *config.Config, db database.Store, log *zap.Logger, rec *audit.Recorder) *TokenHandler {
+	return &TokenHandler{
+		cfg:   cfg,
+		db:    db,
+		log:   log,
+		audit: rec,
+	}
+}
+
+type CreateTokenRequest struct {
+	Name          string   `json:"name" form:"name"`
+	Scopes        []string `json:"scopes" form:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days" form:"expires_in_days"`
+}
+
+// CreateTokenResponse carries the plaintext token, which is never shown again
+type CreateTokenResponse struct {
+	Token   string `json:"token"`
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Prefix  string `json:"prefix"`
+	Message string `json:"message"`
+}
+
+// Page lists the current user's API tokens with a form to create one
+func (th *TokenHandler) Page(c echo.Context) error {
+	ctx := context.Background()
+
+	user, err := GetCurrentUser(c)
+	if err != nil {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	tokens, err := th.db.ListAPITokens(ctx, user.ID)
+	if err != nil {
+		th.log.Sugar().Errorw("failed to list api tokens", "error", err)
+		return c.String(500, "failed to list api tokens")
+	}
+
+	var scopes []string
+	for _, scope := range auth.TokenScopes {
+		if auth.UserCan(user, scope) {
+			scopes = append(scopes, string(scope))
+		}
+	}
+
+	return render(c, http.StatusOK, components.APITokensPage(tokens, scopes))
+}
+
+// List returns the current user's API tokens as JSON (without secrets)
+func (th *TokenHandler) List(c echo.Context) error {
+	ctx := context.Background()
+
+	user, err := GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(401, map[string]string{"error": "authentication required"})
+	}
+
+	tokens, err := th.db.ListAPITokens(ctx, user.ID)
+	if err != nil {
+		th.log.Sugar().Errorw("failed to list api tokens", "error", err)
+		return c.JSON(500, map[string]string{"error": "failed to list api tokens"})
+	}
+
+	return c.JSON(200, tokens)
+}
+
+// Create issues a new API token and returns it once
+func (th *TokenHandler) Create(c echo.Context) error {
+	ctx := context.Background()
+
+	user, err := GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(401, map[string]string{"error": "authentication required"})
+	}
+
+	req := new(CreateTokenRequest)
+	if err := c.Bind(req); err != nil {
+		return c.JSON(400, map[string]string{"error": "invalid request"})
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > 100 {
+		return c.JSON(400, map[string]string{"error": "name must be 1-100 characters"})
+	}
+	if req.ExpiresInDays < 0 {
+		return c.JSON(400, map[string]string{"error": "expires_in_days must not be negative"})
+	}
+
+	scopes, err := auth.ParseScopes(user, req.Scopes)
+	if err != nil {
+		return c.JSON(400, map[string]string{"error": err.Error()})
+	}
+
+	secret, hash, prefix := auth.GenerateAPIToken()
+	ttl := time.Duration(req.ExpiresInDays) * 24 * time.Hour
+
+	token, err := th.db.CreateAPIToken(ctx, user.ID, name, hash, prefix, scopes, ttl)
+	if err != nil {
+		th.log.Sugar().Errorw("failed to create api token", "error", err)
+		return c.JSON(500, map[string]string{"error": "failed to create api token"})
+	}
+
+	th.log.Sugar().Infow("api token created", "username", user.Username, "token_id", token.ID, "scopes", scopes)
+	th.audit.Request(c, audit.Event{
+		Action:     audit.ActionTokenCreate,
+		TargetType: "api_token",
+		TargetID:   strconv.Itoa(token.ID),
+		After:      map[string]any{"name": token.Name, "prefix": token.Prefix, "scopes": token.Scopes, "expires_at": token.ExpiresAt},
+	})
+
+	if isHTMX(c) {
+		return render(c, http.StatusOK, components.APITokenCreated(*token, secret))
+	}
+	return c.JSON(201, CreateTokenResponse{
+		Token:   secret,
+		ID:      token.ID,
+		Name:    token.Name,
+		Prefix:  token.Prefix,
+		Message: "store this token now, it will not be shown again",
+	})
+}
+
+// Revoke deletes one of the current user's API tokens
+func (th *TokenHandler) Revoke(c echo.Context) error {
+	ctx := context.Background()
+
+	user, err := GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(401, map[string]string{"error": "authentication required"})
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(400, map[string]string{"error": "invalid token id"})
+	}
+
+	found, err := th.db.DeleteAPIToken(ctx, user.ID, id)
+	if err != nil {
+		th.log.Sugar().Errorw("failed to revoke api token", "error", err)
+		return c.JSON(500, map[string]string{"error": "failed to revoke api token"})
+	}
+	if !found {
+		return c.JSON(404, map[string]string{"error": "api token not found"})
+	}
+
+	th.log.Sugar().Infow("api token revoked", "username", user.Username, "token_id", id)
+	th.audit.Request(c, audit.Event{Action: audit.ActionTokenRevoke, TargetType: "api_token", TargetID: strconv.Itoa(id)})
+
+	if isHTMX(c) {
+		return c.NoContent(200)
+	}
+	return c.JSON(200, map[string]string{"message": "api token revoked"})
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"citadel/highway17/internal/audit"
+	"citadel/highway17/internal/auth"
+	"citadel/highway17/internal/config"
+	"citadel/highway17/internal/database"
+	"citadel/highway17/web/components"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+type TwoFactorHandler struct {
+	cfg       *config.Config
+	db        database.Store
+	log       *zap.Logger
+	audit     *audit.Recorder
+	twoFactor *auth.TwoFactor
+}
+
+func NewTwoFactorHandler(cfg *config.Config, db database.Store, log *zap.Logger, rec *audit.Recorder, tf *auth.TwoFactor) *TwoFactorHandler {
+	return &TwoFactorHandler{
+		cfg:       cfg,
+		db:        db,
+		log:       log,
+		audit:     rec,
+		twoFactor: tf,
+	}
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" form:"code"`
+}
+
+// Page shows the current two-factor status for the logged-in user
+func (th *TwoFactorHandler) Page(c echo.Context) error {
+	ctx := context.Background()
+
+	user, err := GetCurrentUser(c)
+	if err != nil {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	remaining, err := th.db.CountRecoveryCodes(ctx, user.ID)
+	if err != nil {
+		th.log.Sugar().Errorw("failed to count recovery codes", "error", err)
+	}
+
+	return render(c, http.StatusOK, components.TwoFactorPage(user, remaining, th.cfg.TOTPRequired))
+}
+
+// Enroll starts enrollment and returns the otpauth URI and QR code
+func (th *TwoFactorHandler) Enroll(c echo.Context) error {
+	ctx := context.Background()
+
+	user, err := GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(401, map[string]string{"error": "authentication required"})
+	}
+	if user.TOTPEnabled {
+		return c.JSON(409, map[string]string{"error": "two-factor is already enabled"})
+	}
+
+	enrollment, err := th.twoFactor.Begin(ctx, user)
+	if err != nil {
+		th.log.Sugar().Errorw("failed to start two-factor enrollment", "username", user.Username, "error", err)
+		return c.JSON(500, map[string]string{"error": "failed to start enrollment"})
+	}
+
+	if isHTMX(c) {
+		return render(c, http.StatusOK, components.TwoFactorEnroll(enrollment.Secret, enrollment.URI, enrollment.QRCode))
+	}
+	return c.JSON(200, enrollment)
+}
+
+// Verify confirms enrollment with a first code and returns recovery codes
+func (th *TwoFactorHandler) Verify(c echo.Context) error {
+	ctx := context.Background()
+
+	user, err := GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(401, map[string]string{"error": "authentication required"})
+	}
+
+	req := new(TwoFactorCodeRequest)
+	if err := c.Bind(req); err != nil || req.Code == "" {
+		return c.JSON(400, map[string]string{"error": "code required"})
+	}
+
+	codes, err := th.twoFactor.Confirm(ctx, user, req.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidCode):
+			return c.JSON(401, map[string]string{"error": "invalid code"})
+		case errors.Is(err, auth.ErrTwoFactorNotPending):
+			return c.JSON(409, map[string]string{"error": "no enrollment in progress"})
+		}
+		th.log.Sugar().Errorw("failed to confirm two-factor enrollment", "username", user.Username, "error", err)
+		return c.JSON(500, map[string]string{"error": "failed to enable two-factor"})
+	}
+
+	th.log.Sugar().Infow("two-factor enabled", "username", user.Username)
+	th.audit.Request(c, audit.Event{Action: audit.ActionTwoFactorOn, TargetType: "user", TargetID: user.Username})
+
+	if isHTMX(c) {
+		return render(c, http.StatusOK, components.RecoveryCodes(codes))
+	}
+	return c.JSON(200, map[string]interface{}{
+		"message":        "two-factor enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// Disable turns two-factor off unless it is required by config
+func (th *TwoFactorHandler) Disable(c echo.Context) error {
+	ctx := context.Background()
+
+	user, err := GetCurrentUser(c)
+	if err != nil {
+		return c.JSON(401, map[string]string{"error": "authentication required"})
+	}
+	if th.cfg.TOTPRequired {
+		return c.JSON(403, map[string]string{"error": "two-factor is required for all users"})
+	}
+
+	req := new(TwoFactorCodeRequest)
+	if err := c.Bind(req); err != nil || req.Code == "" {
+		return c.JSON(400, map[string]string{"error": "code required"})
+	}
+
+	if err := th.twoFactor.Disable(ctx, user, req.Code); err != nil {
+		if errors.Is(err, auth.ErrInvalidCode) || errors.Is(err, auth.ErrTwoFactorNotPending) {
+			return c.JSON(401, map[string]string{"error": "invalid code"})
+		}
+		th.log.Sugar().Errorw("failed to disable two-factor", "username", user.Username, "error", err)
+		return c.JSON(500, map[string]string{"error": "failed to disable two-factor"})
+	}
+
+	th.log.Sugar().Infow("two-factor disabled", "username", user.Username)
+	th.audit.Request(c, audit.Event{Action: audit.ActionTwoFactorOff, TargetType: "user", TargetID: user.Username})
+
+	if isHTMX(c) {
+		c.Response().Header().Set("HX-Refresh", "true")
+	}
+	return c.JSON(200, map[string]string{"message": "two-factor disabled"})
+}
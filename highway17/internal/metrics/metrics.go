@@ -0,0 +1,360 @@
+// Package metrics keeps a history of the system statistics. Samples are
+// stored raw for a day, then rolled up into 1-minute and hourly buckets
+// (average, minimum and maximum) that are kept for 30 days and a year.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"citadel/highway17/internal/database"
+	"citadel/highway17/internal/models"
+
+	"go.uber.org/zap"
+)
+
+// Recorded metric names, as accepted by Series
+const (
+	CPUPercent    = "cpu_percent"
+	MemoryPercent = "memory_percent"
+	MemoryUsedGB  = "memory_used_gb"
+	DiskPercent   = "disk_percent"
+	DiskUsedGB    = "disk_used_gb"
+	Load1         = "load1"
+	Load5         = "load5"
+	Load15        = "load15"
+	ProcessCount  = "process_count"
+)
+
+// MaxPoints caps the points in one series; longer ranges get a wider step
+const MaxPoints = 1000
+
+// Values flattens a stats snapshot into the metrics that are recorded
+func Values(stats *models.SystemStats) map[string]float64 {
+	return map[string]float64{
+		CPUPercent:    stats.CPUPercent,
+		MemoryPercent: stats.MemoryPercent,
+		MemoryUsedGB:  stats.MemoryUsedGB,
+		DiskPercent:   stats.DiskPercent,
+		DiskUsedGB:    stats.DiskUsedGB,
+		Load1:         stats.LoadAverage[0],
+		Load5:         stats.LoadAverage[1],
+		Load15:        stats.LoadAverage[2],
+		ProcessCount:  float64(stats.ProcessCount),
+	}
+}
+
+// Tier is one level of stored history. Raw samples have no resolution.
+type Tier struct {
+	Name       string
+	Resolution time.Duration
+	Retention  time.Duration // zero keeps the tier forever
+}
+
+// covers reports whether the tier still holds data from at
+func (t Tier) covers(at, now time.Time) bool {
+	return t.Retention <= 0 || !at.Before(now.Add(-t.Retention))
+}
+
+// Retention is how long each tier is kept
+type Retention struct {
+	Raw    time.Duration
+	Minute time.Duration
+	Hour   time.Duration
+}
+
+// DefaultRetention keeps raw samples for a day, 1-minute rollups for 30 days
+// and hourly rollups for a year
+var DefaultRetention = Retention{Raw: 24 * time.Hour, Minute: 30 * 24 * time.Hour, Hour: 365 * 24 * time.Hour}
+
+// Tiers lists the tiers finest first
+func (r Retention) Tiers() []Tier {
+	return []Tier{
+		{Name: "raw", Retention: r.Raw},
+		{Name: "1m", Resolution: time.Minute, Retention: r.Minute},
+		{Name: "1h", Resolution: time.Hour, Retention: r.Hour},
+	}
+}
+
+// Source produces the statistics that are recorded
+type Source interface {
+	GetStats(ctx context.Context) (*models.SystemStats, error)
+}
+
+// Recorder stores samples, maintains the rollups and answers range queries
+type Recorder struct {
+	store database.MetricStore
+	log   *zap.Logger
+	tiers []Tier
+
+	// next[i] is where the rollup into tiers[i] resumes; only Run touches it
+	next      []time.Time
+	lastPurge time.Time
+}
+
+func NewRecorder(store database.MetricStore, log *zap.Logger, retention Retention) *Recorder {
+	tiers := retention.Tiers()
+	return &Recorder{
+		store: store,
+		log:   log,
+		tiers: tiers,
+		next:  make([]time.Time, len(tiers)),
+	}
+}
+
+// Record stores one sample of every metric in stats
+func (r *Recorder) Record(ctx context.Context, stats *models.SystemStats) error {
+	at := stats.LastUpdated
+	if at.IsZero() {
+		at = time.Now()
+	}
+	return r.store.InsertMetricSamples(ctx, at, Values(stats))
+}
+
+// Run records a sample from source every interval, and rolls up and purges
+// history every minute, until ctx is cancelled
+func (r *Recorder) Run(ctx context.Context, source Source, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	sample := time.NewTicker(interval)
+	defer sample.Stop()
+	maintain := time.NewTicker(time.Minute)
+	defer maintain.Stop()
+
+	r.sample(ctx, source)
+	r.Maintain(ctx, time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sample.C:
+			r.sample(ctx, source)
+		case now := <-maintain.C:
+			r.Maintain(ctx, now)
+		}
+	}
+}
+
+func (r *Recorder) sample(ctx context.Context, source Source) {
+	stats, err := source.GetStats(ctx)
+	if err != nil {
+		r.log.Sugar().Warnw("failed to sample system stats", "error", err)
+		return
+	}
+	if err := r.Record(ctx, stats); err != nil && ctx.Err() == nil {
+		r.log.Sugar().Errorw("failed to record metrics", "error", err)
+	}
+}
+
+// Maintain rolls up every complete bucket and, at most hourly, deletes
+// history past each tier's retention
+func (r *Recorder) Maintain(ctx context.Context, now time.Time) {
+	for i := 1; i < len(r.tiers); i++ {
+		if err := r.rollup(ctx, i, now); err != nil && ctx.Err() == nil {
+			r.log.Sugar().Errorw("failed to roll up metrics", "tier", r.tiers[i].Name, "error", err)
+		}
+	}
+
+	if now.Sub(r.lastPurge) < time.Hour {
+		return
+	}
+	r.lastPurge = now
+	for _, tier := range r.tiers {
+		if tier.Retention <= 0 {
+			continue
+		}
+		purged, err := r.store.PurgeMetricPoints(ctx, tier.Resolution, now.Add(-tier.Retention))
+		if err != nil {
+			r.log.Sugar().Errorw("failed to purge metrics", "tier", tier.Name, "error", err)
+		} else if purged > 0 {
+			r.log.Sugar().Infow("purged old metrics", "tier", tier.Name, "count", purged)
+		}
+	}
+}
+
+// rollupChunk is how many buckets one rollup query covers
+const rollupChunk = 60
+
+// rollup aggregates tiers[i-1] into every complete bucket of tiers[i]. The
+// newest bucket is always rolled up again on the next run, in case samples
+// landed in it after it was first aggregated.
+func (r *Recorder) rollup(ctx context.Context, i int, now time.Time) error {
+	src, dst := r.tiers[i-1], r.tiers[i]
+	end := now.UTC().Truncate(dst.Resolution)
+
+	start := r.next[i]
+	if start.IsZero() {
+		latest, err := r.store.LatestMetricRollup(ctx, dst.Resolution)
+		if err != nil {
+			return err
+		}
+		start = latest
+	}
+	if start.IsZero() {
+		// Nothing rolled up yet: begin at the oldest data the source keeps
+		lookback := src.Retention
+		if lookback <= 0 {
+			lookback = rollupChunk * dst.Resolution
+		}
+		start = end.Add(-lookback).Truncate(dst.Resolution)
+	}
+
+	for start.Before(end) {
+		chunkEnd := start.Add(rollupChunk * dst.Resolution)
+		if chunkEnd.After(end) {
+			chunkEnd = end
+		}
+		points, err := r.store.ListMetricPoints(ctx, src.Resolution, "", start, chunkEnd)
+		if err != nil {
+			return err
+		}
+		if len(points) > 0 {
+			if err := r.store.UpsertMetricRollups(ctx, dst.Resolution, aggregate(points, dst.Resolution)); err != nil {
+				return err
+			}
+		}
+		start = chunkEnd
+	}
+
+	r.next[i] = end.Add(-dst.Resolution)
+	return nil
+}
+
+// ErrUnknownMetric is returned by Series for a metric with no history
+var ErrUnknownMetric = errors.New("unknown metric")
+
+// Series returns metric over [from, to) in steps of step, read from the
+// coarsest tier that still covers from and is no coarser than step. The
+// step is rounded up to a round duration; a zero step picks one that gives
+// about MaxPoints/4 points. Steps with no data are left out.
+func (r *Recorder) Series(ctx context.Context, metric string, from, to time.Time, step time.Duration) (*models.MetricSeries, error) {
+	from, to = from.UTC(), to.UTC()
+	if !from.Before(to) {
+		return nil, fmt.Errorf("from must be before to")
+	}
+	if step <= 0 {
+		step = to.Sub(from) / (MaxPoints / 4)
+	}
+	step = roundStep(max(step, to.Sub(from)/MaxPoints))
+
+	tier := r.tierFor(from, step, time.Now())
+	if step < tier.Resolution {
+		step = tier.Resolution
+	}
+
+	points, err := r.store.ListMetricPoints(ctx, tier.Resolution, metric, from.Truncate(step), to)
+	if err != nil {
+		return nil, err
+	}
+	if len(points) == 0 {
+		names, err := r.store.ListMetricNames(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if i := sort.SearchStrings(names, metric); i == len(names) || names[i] != metric {
+			return nil, fmt.Errorf("%w %q", ErrUnknownMetric, metric)
+		}
+	}
+
+	series := &models.MetricSeries{
+		Metric:      metric,
+		From:        from,
+		To:          to,
+		StepSeconds: int64(step / time.Second),
+		Resolution:  tier.Name,
+		Points:      aggregate(points, step),
+	}
+	for i := range series.Points {
+		series.Points[i].Metric = ""
+	}
+	return series, nil
+}
+
+// Metrics lists every metric with stored history
+func (r *Recorder) Metrics(ctx context.Context) ([]string, error) {
+	return r.store.ListMetricNames(ctx)
+}
+
+// roundSteps are the steps roundStep picks from
+var roundSteps = []time.Duration{
+	time.Second, 5 * time.Second, 10 * time.Second, 15 * time.Second, 30 * time.Second,
+	time.Minute, 2 * time.Minute, 5 * time.Minute, 10 * time.Minute, 15 * time.Minute, 30 * time.Minute,
+	time.Hour, 2 * time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour, 24 * time.Hour,
+}
+
+// roundStep returns the smallest round step of at least d
+func roundStep(d time.Duration) time.Duration {
+	for _, step := range roundSteps {
+		if step >= d {
+			return step
+		}
+	}
+	day := 24 * time.Hour
+	return (d + day - 1) / day * day
+}
+
+// tierFor picks the coarsest tier that still holds from and is no coarser
+// than step, falling back to the finest tier that holds from
+func (r *Recorder) tierFor(from time.Time, step time.Duration, now time.Time) Tier {
+	var chosen *Tier
+	for i, tier := range r.tiers {
+		if !tier.covers(from, now) {
+			continue
+		}
+		if chosen == nil || tier.Resolution <= step {
+			chosen = &r.tiers[i]
+		}
+	}
+	if chosen == nil {
+		return r.tiers[len(r.tiers)-1]
+	}
+	return *chosen
+}
+
+// aggregate merges points into buckets of width step, per metric, oldest
+// first. Averages are weighted by each point's sample count.
+func aggregate(points []models.MetricPoint, step time.Duration) []models.MetricPoint {
+	type key struct {
+		metric string
+		at     time.Time
+	}
+	buckets := make(map[key]*models.MetricPoint)
+	sums := make(map[key]float64)
+	var order []key
+
+	for _, p := range points {
+		k := key{p.Metric, p.Time.Truncate(step)}
+		b, ok := buckets[k]
+		if !ok {
+			b = &models.MetricPoint{Metric: p.Metric, Time: k.at, Min: p.Min, Max: p.Max}
+			buckets[k] = b
+			order = append(order, k)
+		}
+		b.Min = min(b.Min, p.Min)
+		b.Max = max(b.Max, p.Max)
+		b.Count += p.Count
+		sums[k] += p.Avg * float64(p.Count)
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		if !order[i].at.Equal(order[j].at) {
+			return order[i].at.Before(order[j].at)
+		}
+		return order[i].metric < order[j].metric
+	})
+
+	result := make([]models.MetricPoint, 0, len(order))
+	for _, k := range order {
+		b := buckets[k]
+		if b.Count > 0 {
+			b.Avg = sums[k] / float64(b.Count)
+		}
+		result = append(result, *b)
+	}
+	return result
+}
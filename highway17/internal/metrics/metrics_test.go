@@ -0,0 +1,205 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"citadel/highway17/internal/database/databasetest"
+	"citadel/highway17/internal/models"
+
+	"go.uber.org/zap"
+)
+
+var t0 = time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+
+func record(t *testing.T, store *databasetest.Store, at time.Time, cpu float64) {
+	t.Helper()
+	if err := store.InsertMetricSamples(context.Background(), at, map[string]float64{CPUPercent: cpu}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// rollupAt returns the stored rollup of cpu_percent at resolution and time at
+func rollupAt(t *testing.T, store *databasetest.Store, resolution time.Duration, at time.Time) models.MetricPoint {
+	t.Helper()
+	points, err := store.ListMetricPoints(context.Background(), resolution, CPUPercent, at, at.Add(resolution))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 1 {
+		t.Fatalf("%s rollups at %s = %v, want one", resolution, at.Format(time.TimeOnly), points)
+	}
+	return points[0]
+}
+
+func checkPoint(t *testing.T, got models.MetricPoint, avg, lo, hi float64, count int) {
+	t.Helper()
+	if got.Avg != avg || got.Min != lo || got.Max != hi || got.Count != count {
+		t.Errorf("point at %s = avg %v min %v max %v count %d, want %v %v %v %d",
+			got.Time.Format(time.TimeOnly), got.Avg, got.Min, got.Max, got.Count, avg, lo, hi, count)
+	}
+}
+
+func TestRollup(t *testing.T) {
+	ctx := context.Background()
+	store := databasetest.NewStore()
+	r := NewRecorder(store, zap.NewNop(), DefaultRetention)
+
+	// Two samples in the first minute, one in every other minute of the
+	// hour, and one in the next hour's first minute
+	record(t, store, t0.Add(10*time.Second), 10)
+	record(t, store, t0.Add(40*time.Second), 20)
+	for m := 1; m < 60; m++ {
+		record(t, store, t0.Add(time.Duration(m)*time.Minute+5*time.Second), 30)
+	}
+	record(t, store, t0.Add(time.Hour+30*time.Second), 90)
+	// Not complete yet at the first run
+	record(t, store, t0.Add(time.Hour+time.Minute+time.Second), 99)
+
+	r.Maintain(ctx, t0.Add(time.Hour+time.Minute+5*time.Second))
+
+	checkPoint(t, rollupAt(t, store, time.Minute, t0), 15, 10, 20, 2)
+	checkPoint(t, rollupAt(t, store, time.Minute, t0.Add(30*time.Minute)), 30, 30, 30, 1)
+	checkPoint(t, rollupAt(t, store, time.Minute, t0.Add(time.Hour)), 90, 90, 90, 1)
+	if points, _ := store.ListMetricPoints(ctx, time.Minute, CPUPercent, t0.Add(time.Hour+time.Minute), t0.Add(2*time.Hour)); len(points) != 0 {
+		t.Errorf("incomplete minute rolled up: %v", points)
+	}
+
+	// The hour average weighs each minute by its sample count
+	checkPoint(t, rollupAt(t, store, time.Hour, t0), (10+20+59*30)/61.0, 10, 30, 61)
+	if points, _ := store.ListMetricPoints(ctx, time.Hour, CPUPercent, t0.Add(time.Hour), t0.Add(2*time.Hour)); len(points) != 0 {
+		t.Errorf("incomplete hour rolled up: %v", points)
+	}
+}
+
+func TestRollupLateSample(t *testing.T) {
+	ctx := context.Background()
+	store := databasetest.NewStore()
+	r := NewRecorder(store, zap.NewNop(), DefaultRetention)
+
+	record(t, store, t0.Add(59*time.Minute+10*time.Second), 40)
+	r.Maintain(ctx, t0.Add(time.Hour+5*time.Second))
+	checkPoint(t, rollupAt(t, store, time.Minute, t0.Add(59*time.Minute)), 40, 40, 40, 1)
+	checkPoint(t, rollupAt(t, store, time.Hour, t0), 40, 40, 40, 1)
+
+	// A sample for the newest bucket arrives after it was rolled up
+	record(t, store, t0.Add(59*time.Minute+50*time.Second), 60)
+	r.Maintain(ctx, t0.Add(time.Hour+30*time.Second))
+	checkPoint(t, rollupAt(t, store, time.Minute, t0.Add(59*time.Minute)), 50, 40, 60, 2)
+
+	// The hour above it picks the change up on its next run
+	r.Maintain(ctx, t0.Add(time.Hour+time.Minute+5*time.Second))
+	checkPoint(t, rollupAt(t, store, time.Hour, t0), 50, 40, 60, 2)
+
+	// A restarted recorder resumes at the newest stored rollup, so it also
+	// re-aggregates that bucket
+	record(t, store, t0.Add(time.Hour+10*time.Second), 10)
+	r = NewRecorder(store, zap.NewNop(), DefaultRetention)
+	r.Maintain(ctx, t0.Add(time.Hour+time.Minute+30*time.Second))
+	checkPoint(t, rollupAt(t, store, time.Minute, t0.Add(time.Hour)), 10, 10, 10, 1)
+
+	record(t, store, t0.Add(time.Hour+40*time.Second), 30)
+	r = NewRecorder(store, zap.NewNop(), DefaultRetention)
+	r.Maintain(ctx, t0.Add(time.Hour+time.Minute+45*time.Second))
+	checkPoint(t, rollupAt(t, store, time.Minute, t0.Add(time.Hour)), 20, 10, 30, 2)
+}
+
+func TestMaintainPurge(t *testing.T) {
+	ctx := context.Background()
+	store := databasetest.NewStore()
+	r := NewRecorder(store, zap.NewNop(), DefaultRetention)
+	now := t0.Add(48 * time.Hour)
+
+	record(t, store, now.Add(-DefaultRetention.Raw-time.Second), 1)
+	record(t, store, now.Add(-DefaultRetention.Raw), 2)
+	if err := store.UpsertMetricRollups(ctx, time.Minute, []models.MetricPoint{
+		{Metric: CPUPercent, Time: now.Add(-DefaultRetention.Minute - time.Minute), Avg: 1, Min: 1, Max: 1, Count: 1},
+		{Metric: CPUPercent, Time: now.Add(-DefaultRetention.Minute), Avg: 2, Min: 2, Max: 2, Count: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	r.Maintain(ctx, now)
+
+	raw, _ := store.ListMetricPoints(ctx, 0, CPUPercent, time.Time{}, now)
+	if len(raw) != 1 || raw[0].Avg != 2 {
+		t.Errorf("raw samples = %v, want the one at the retention edge", raw)
+	}
+	minutes, _ := store.ListMetricPoints(ctx, time.Minute, CPUPercent, time.Time{}, now.Add(-DefaultRetention.Minute+time.Hour))
+	if len(minutes) != 1 || minutes[0].Avg != 2 {
+		t.Errorf("minute rollups = %v, want the one at the retention edge", minutes)
+	}
+}
+
+func TestTierFor(t *testing.T) {
+	now := t0.Add(400 * 24 * time.Hour)
+	forever := Retention{Raw: DefaultRetention.Raw, Minute: DefaultRetention.Minute}
+
+	tests := []struct {
+		name      string
+		retention Retention
+		from      time.Time
+		step      time.Duration
+		want      string
+	}{
+		{"raw at its edge", DefaultRetention, now.Add(-DefaultRetention.Raw), time.Second, "raw"},
+		{"raw expired", DefaultRetention, now.Add(-DefaultRetention.Raw - time.Second), time.Second, "1m"},
+		{"coarser step within raw", DefaultRetention, now.Add(-time.Hour), time.Minute, "1m"},
+		{"step just below an hour", DefaultRetention, now.Add(-time.Hour), 30 * time.Minute, "1m"},
+		{"hourly step", DefaultRetention, now.Add(-time.Hour), time.Hour, "1h"},
+		{"minutes at their edge", DefaultRetention, now.Add(-DefaultRetention.Minute), time.Second, "1m"},
+		{"minutes expired", DefaultRetention, now.Add(-DefaultRetention.Minute - time.Second), time.Second, "1h"},
+		{"hours at their edge", DefaultRetention, now.Add(-DefaultRetention.Hour), time.Minute, "1h"},
+		{"older than every tier", DefaultRetention, now.Add(-DefaultRetention.Hour - time.Second), time.Minute, "1h"},
+		{"hours kept forever", forever, now.Add(-10 * DefaultRetention.Hour), time.Minute, "1h"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRecorder(databasetest.NewStore(), zap.NewNop(), tt.retention)
+			if got := r.tierFor(tt.from, tt.step, now); got.Name != tt.want {
+				t.Errorf("tierFor = %s, want %s", got.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestSeries(t *testing.T) {
+	ctx := context.Background()
+	store := databasetest.NewStore()
+	r := NewRecorder(store, zap.NewNop(), DefaultRetention)
+	now := time.Now().UTC()
+
+	record(t, store, now.Add(-10*time.Minute), 10)
+	record(t, store, now.Add(-5*time.Minute), 20)
+	if err := store.UpsertMetricRollups(ctx, time.Minute, []models.MetricPoint{
+		{Metric: CPUPercent, Time: now.Add(-36 * time.Hour).Truncate(time.Hour), Avg: 50, Min: 40, Max: 60, Count: 6},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	series, err := r.Series(ctx, CPUPercent, now.Add(-time.Hour), now, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if series.Resolution != "raw" || series.StepSeconds != 15 || len(series.Points) != 2 || series.Points[0].Metric != "" {
+		t.Errorf("last hour = %+v", series)
+	}
+
+	series, err = r.Series(ctx, CPUPercent, now.Add(-48*time.Hour), now, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if series.Resolution != "1m" || series.StepSeconds != 15*60 || len(series.Points) != 1 || series.Points[0].Avg != 50 {
+		t.Errorf("last two days = %+v", series)
+	}
+
+	if _, err := r.Series(ctx, "fan_rpm", now.Add(-time.Hour), now, 0); !errors.Is(err, ErrUnknownMetric) {
+		t.Errorf("unknown metric: error = %v, want ErrUnknownMetric", err)
+	}
+	if _, err := r.Series(ctx, CPUPercent, now, now, 0); err == nil {
+		t.Error("empty range accepted")
+	}
+}
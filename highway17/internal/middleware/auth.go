@@ -1,40 +1,363 @@
 package middleware
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"citadel/highway17/internal/audit"
+	"citadel/highway17/internal/auth"
 	"citadel/highway17/internal/config"
 	"citadel/highway17/internal/database"
+	"citadel/highway17/internal/models"
+	"citadel/highway17/internal/tailscale"
 
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
 )
 
+const (
+	// LoginPath is where unauthenticated browser requests are sent
+	LoginPath = "/login"
+
+	// TwoFactorSetupPath is where users without required 2FA are sent
+	TwoFactorSetupPath = "/account/2fa"
+
+	// PasswordChangePath is where users with a forced password change are sent
+	PasswordChangePath = "/account/password"
+)
+
+// twoFactorSetupPaths stay reachable while a required enrollment is pending
+var twoFactorSetupPaths = []string{TwoFactorSetupPath, "/api/2fa/*", "/api/logout"}
+
+// passwordChangePaths stay reachable while a forced password change is pending
+var passwordChangePaths = []string{PasswordChangePath, "/api/account/password", "/api/logout"}
+
+var (
+	errNotTailnet    = errors.New("remote address is not on the tailnet")
+	errTailnetProxy  = errors.New("remote address is a trusted proxy")
+	errTailnetDenied = errors.New("tailnet identity denied by policy")
+)
+
 type AuthMiddleware struct {
-	cfg *config.Config
-	db  *database.DB
-	log *zap.Logger
+	cfg      *config.Config
+	db       database.Store
+	log      *zap.Logger
+	audit    *audit.Recorder
+	sessions *auth.Sessions
+	network  *NetworkPolicy
+	tsClient *tailscale.Client
+	tsPolicy tailscale.Policy
 }
 
-func NewAuthMiddleware(cfg *config.Config, db *database.DB, log *zap.Logger) *AuthMiddleware {
-	return &AuthMiddleware{
-		cfg: cfg,
-		db:  db,
-		log: log,
+// NewAuthMiddleware builds the auth middleware. The network policy tells it
+// which peers are trusted proxies, whose tailnet identity is never used.
+func NewAuthMiddleware(cfg *config.Config, db database.Store, log *zap.Logger, rec *audit.Recorder, sessions *auth.Sessions, network *NetworkPolicy) *AuthMiddleware {
+	am := &AuthMiddleware{
+		cfg:      cfg,
+		db:       db,
+		log:      log,
+		audit:    rec,
+		sessions: sessions,
+		network:  network,
 	}
+
+	if cfg.TailscaleEnabled {
+		am.tsClient = tailscale.NewClient(cfg.TailscaleSocket)
+		am.tsPolicy = tailscale.Policy{
+			AllowedUsers: cfg.TailscaleAllowedUsers,
+			AllowedTags:  cfg.TailscaleAllowedTags,
+		}
+	}
+
+	return am
 }
 
-// CheckAuth middleware validates session tokens and Tailscale IPs
+// CheckAuth middleware validates session tokens and stores the user in the context
 func (am *AuthMiddleware) CheckAuth(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		// Skip auth for health check and login routes
-		path := c.Request().URL.Path
-		if path == "/health" || path == "/api/login" {
+		// Skip auth for allow-listed routes (health check, login, static
+		// assets) and forward auth, which checks credentials itself
+		if am.isPublicPath(c.Request().URL.Path) || c.Request().URL.Path == ForwardAuthPath {
 			return next(c)
 		}
 
-		// TODO: Check session token from cookie
-		// TODO: Verify against database
-		// TODO: Check Tailscale IP if enabled
+		// API tokens never fall back to cookies or tailnet identity
+		if bearer := bearerToken(c); auth.IsAPIToken(bearer) {
+			user := am.apiTokenUser(c, bearer)
+			if user == nil {
+				return am.unauthorized(c)
+			}
+			c.Set("user", user)
+			return next(c)
+		}
+
+		user, token := am.sessionUser(c)
+		if user == nil && am.tsClient != nil {
+			user, token = am.tailscaleUser(c)
+		}
+		if user == nil {
+			return am.unauthorized(c)
+		}
+
+		c.Set("user", user)
+		c.Set("session_token", token)
+
+		if user.MustChangePassword && !matchAny(passwordChangePaths, c.Request().URL.Path) {
+			return am.redirectPending(c, PasswordChangePath, "password change required")
+		}
+
+		if am.cfg.TOTPRequired && !user.TOTPEnabled && !matchAny(twoFactorSetupPaths, c.Request().URL.Path) {
+			return am.redirectPending(c, TwoFactorSetupPath, "two-factor enrollment required")
+		}
 
 		return next(c)
 	}
 }
+
+// sessionUser resolves the session token on the request to its user
+func (am *AuthMiddleware) sessionUser(c echo.Context) (*models.User, string) {
+	token := sessionToken(c)
+	if token == "" {
+		return nil, ""
+	}
+
+	ctx := c.Request().Context()
+
+	session, err := am.db.GetSessionByToken(ctx, token)
+	if err != nil {
+		if !errors.Is(err, database.ErrSessionNotFound) {
+			am.log.Sugar().Errorw("failed to look up session", "error", err)
+		}
+		return nil, ""
+	}
+
+	user, err := am.db.GetUserByID(ctx, session.UserID)
+	if err != nil {
+		am.log.Sugar().Warnw("session references missing user", "user_id", session.UserID, "error", err)
+		return nil, ""
+	}
+
+	// Slide the expiry forward on activity
+	if err := am.sessions.Touch(ctx, session, c.RealIP()); err != nil {
+		am.log.Sugar().Warnw("failed to touch session", "session_id", session.ID, "error", err)
+	}
+
+	return user, token
+}
+
+// apiTokenUser resolves a personal API token to its owner and stores the
+// token so Require can check its scopes
+func (am *AuthMiddleware) apiTokenUser(c echo.Context, bearer string) *models.User {
+	ctx := c.Request().Context()
+
+	token, err := am.db.GetAPITokenByHash(ctx, auth.HashAPIToken(bearer))
+	if err != nil {
+		if !errors.Is(err, database.ErrAPITokenNotFound) {
+			am.log.Sugar().Errorw("failed to look up api token", "error", err)
+		}
+		return nil
+	}
+
+	user, err := am.db.GetUserByID(ctx, token.UserID)
+	if err != nil {
+		am.log.Sugar().Warnw("api token references missing user", "user_id", token.UserID, "error", err)
+		return nil
+	}
+
+	if err := am.db.TouchAPIToken(ctx, token.ID); err != nil {
+		am.log.Sugar().Warnw("failed to touch api token", "token_id", token.ID, "error", err)
+	}
+
+	c.Set("api_token", token)
+	return user
+}
+
+// tailscaleUser logs in a tailnet peer without a password by asking the local
+// tailscaled who owns the connection. Page loads get a regular session
+// cookie and a login audit event; other requests, such as scripts that
+// never send the cookie back, are authenticated for that request only.
+func (am *AuthMiddleware) tailscaleUser(c echo.Context) (*models.User, string) {
+	req := c.Request()
+	ctx := req.Context()
+	remoteAddr := req.RemoteAddr
+
+	user, err := am.resolveTailnetUser(ctx, remoteAddr)
+	if err != nil {
+		switch {
+		case errors.Is(err, errNotTailnet), errors.Is(err, errTailnetProxy), errors.Is(err, tailscale.ErrNotTailnetPeer):
+			// Not a tailnet peer, fall back to the normal login flow
+		case errors.Is(err, errTailnetDenied), errors.Is(err, database.ErrUserNotFound):
+			am.log.Sugar().Warnw("tailscale login refused", "remote_addr", remoteAddr, "error", err)
+		default:
+			am.log.Sugar().Errorw("tailscale login failed", "remote_addr", remoteAddr, "error", err)
+		}
+		return nil, ""
+	}
+
+	if !isNavigation(req) {
+		return user, ""
+	}
+
+	token, err := am.sessions.Start(c, user.ID)
+	if err != nil {
+		am.log.Sugar().Errorw("failed to create session", "error", err)
+		return nil, ""
+	}
+
+	am.log.Sugar().Infow("user logged in via tailscale", "username", user.Username, "remote_addr", remoteAddr)
+	am.audit.Request(c, audit.Event{
+		Action:    audit.ActionLogin,
+		ActorID:   &user.ID,
+		ActorName: user.Username,
+		Details:   map[string]any{"method": "tailscale"},
+	})
+	return user, token
+}
+
+// resolveTailnetUser maps a remote address to a users row via WhoIs. Users
+// are matched by tailnet login first, then by the node's tailscale IP. A
+// trusted proxy on the tailnet is refused: WhoIs would name the proxy's
+// owner, not the client it forwards for.
+func (am *AuthMiddleware) resolveTailnetUser(ctx context.Context, remoteAddr string) (*models.User, error) {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return nil, errNotTailnet
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil || !tailscale.IsTailnetIP(ip) {
+		return nil, errNotTailnet
+	}
+	if am.network.isTrustedProxy(ip.Unmap()) {
+		return nil, errTailnetProxy
+	}
+
+	whois, err := am.tsClient.WhoIs(ctx, remoteAddr)
+	if err != nil {
+		return nil, err
+	}
+	if !am.tsPolicy.Allows(whois) {
+		return nil, fmt.Errorf("%w: login=%q tags=%v", errTailnetDenied, whois.LoginName(), whois.Node.Tags)
+	}
+
+	login := whois.LoginName()
+	if login != "" {
+		user, err := am.db.GetUserByTailscaleLogin(ctx, login)
+		if err == nil || !errors.Is(err, database.ErrUserNotFound) {
+			return user, err
+		}
+	}
+
+	user, err := am.db.GetUserByTailscaleIP(ctx, ip.Unmap().String())
+	if err == nil || !errors.Is(err, database.ErrUserNotFound) || login == "" || !am.cfg.TailscaleAutoProvision {
+		return user, err
+	}
+
+	userID, err := am.db.CreateTailscaleUser(ctx, login, login)
+	if err != nil {
+		return nil, fmt.Errorf("failed to provision tailnet user %q: %w", login, err)
+	}
+	am.log.Sugar().Infow("provisioned user from tailnet identity", "username", login, "user_id", userID)
+
+	return am.db.GetUserByID(ctx, userID)
+}
+
+// isPublicPath reports whether a path is on the configured allow-list
+func (am *AuthMiddleware) isPublicPath(path string) bool {
+	for _, pattern := range am.cfg.AuthPublicPaths {
+		if matchPath(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAny reports whether a path matches any of the patterns
+func matchAny(patterns []string, path string) bool {
+	for _, pattern := range patterns {
+		if matchPath(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// redirectPending sends users to a page they must complete before anything
+// else, such as required 2FA enrollment or a forced password change
+func (am *AuthMiddleware) redirectPending(c echo.Context, path, reason string) error {
+	req := c.Request()
+
+	if req.Header.Get("HX-Request") == "true" {
+		c.Response().Header().Set("HX-Redirect", path)
+		return c.NoContent(http.StatusForbidden)
+	}
+
+	if isAPIRequest(req) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": reason})
+	}
+
+	return c.Redirect(http.StatusSeeOther, path)
+}
+
+// unauthorized rejects a request in the form the caller expects:
+// HTMX gets an HX-Redirect, API clients get JSON, browsers get redirected
+func (am *AuthMiddleware) unauthorized(c echo.Context) error {
+	req := c.Request()
+
+	if req.Header.Get("HX-Request") == "true" {
+		c.Response().Header().Set("HX-Redirect", LoginPath)
+		return c.NoContent(http.StatusUnauthorized)
+	}
+
+	if isAPIRequest(req) {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "authentication required"})
+	}
+
+	return c.Redirect(http.StatusSeeOther, LoginPath)
+}
+
+// sessionToken reads the session token from the cookie or a bearer header
+func sessionToken(c echo.Context) string {
+	if cookie, err := c.Cookie(auth.SessionCookieName); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+	return bearerToken(c)
+}
+
+// bearerToken reads the credential from an Authorization: Bearer header
+func bearerToken(c echo.Context) string {
+	if token, ok := strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// matchPath matches a route pattern against a path. Patterns ending in "*"
+// match by prefix, everything else must match exactly.
+func matchPath(pattern, path string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(path, prefix)
+	}
+	return path == pattern
+}
+
+// isNavigation reports whether a request is a browser loading a page, as
+// opposed to HTMX, fetch or a script
+func isNavigation(req *http.Request) bool {
+	if req.Method != http.MethodGet || req.Header.Get("HX-Request") == "true" || isAPIRequest(req) {
+		return false
+	}
+	return strings.Contains(req.Header.Get(echo.HeaderAccept), echo.MIMETextHTML)
+}
+
+// isAPIRequest reports whether a request came from a non-browser client
+func isAPIRequest(req *http.Request) bool {
+	if strings.HasPrefix(req.URL.Path, "/api/") {
+		return true
+	}
+	return strings.Contains(req.Header.Get(echo.HeaderAccept), echo.MIMEApplicationJSON)
+}
@@ -0,0 +1,130 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"citadel/highway17/internal/audit"
+	"citadel/highway17/internal/auth"
+	"citadel/highway17/internal/config"
+	"citadel/highway17/internal/database/databasetest"
+	"citadel/highway17/internal/models"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+func newTestAuthMiddleware(t *testing.T, store *databasetest.Store) *AuthMiddleware {
+	t.Helper()
+	cfg := &config.Config{
+		AuthPublicPaths:    []string{"/health", "/static/*"},
+		SessionIdleTimeout: 3600,
+		SessionMaxLifetime: 86400,
+	}
+	log := zap.NewNop()
+	return NewAuthMiddleware(cfg, store, log, audit.NewRecorder(store, log), auth.NewSessions(cfg, store), newTestNetworkPolicy(t, "", "allow"))
+}
+
+// checkAuth runs CheckAuth for req and returns the response and the user
+// the next handler saw, if it was reached
+func checkAuth(am *AuthMiddleware, req *http.Request) (*httptest.ResponseRecorder, *models.User) {
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+
+	var user *models.User
+	next := func(c echo.Context) error {
+		user, _ = c.Get("user").(*models.User)
+		return c.NoContent(http.StatusNoContent)
+	}
+	if err := am.CheckAuth(next)(c); err != nil {
+		rec.Code = http.StatusInternalServerError
+	}
+	return rec, user
+}
+
+func TestCheckAuth(t *testing.T) {
+	ctx := context.Background()
+	store := databasetest.NewStore()
+	am := newTestAuthMiddleware(t, store)
+
+	// Created without a tailnet identity, so tailscale_ip is NULL
+	userID, err := store.CreateUser(ctx, "alyx", "hash", "viewer", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateSession(ctx, userID, "session-token", "192.0.2.10", "test", time.Hour, 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	forcedID, err := store.CreateUser(ctx, "barney", "hash", "viewer", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateSession(ctx, forcedID, "forced-token", "192.0.2.11", "test", time.Hour, 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	apiToken, hash, prefix := auth.GenerateAPIToken()
+	if _, err := store.CreateAPIToken(ctx, userID, "ci", hash, prefix, []string{"read"}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		cookie   string
+		bearer   string
+		wantCode int
+		wantUser string
+	}{
+		{name: "public path", path: "/health", wantCode: http.StatusNoContent},
+		{name: "no credentials", path: "/api/widgets", wantCode: http.StatusUnauthorized},
+		{name: "no credentials from a browser", path: "/dashboard", wantCode: http.StatusSeeOther},
+		{name: "session cookie", path: "/api/widgets", cookie: "session-token", wantCode: http.StatusNoContent, wantUser: "alyx"},
+		{name: "session bearer", path: "/api/widgets", bearer: "session-token", wantCode: http.StatusNoContent, wantUser: "alyx"},
+		{name: "unknown session", path: "/api/widgets", cookie: "stale-token", wantCode: http.StatusUnauthorized},
+		{name: "api token", path: "/api/widgets", bearer: apiToken, wantCode: http.StatusNoContent, wantUser: "alyx"},
+		{name: "api token ignores the cookie", path: "/api/widgets", cookie: "session-token", bearer: apiToken + "x", wantCode: http.StatusUnauthorized},
+		{name: "forced password change", path: "/api/widgets", cookie: "forced-token", wantCode: http.StatusForbidden},
+		{name: "forced password change may change it", path: "/api/account/password", cookie: "forced-token", wantCode: http.StatusNoContent, wantUser: "barney"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.RemoteAddr = "192.0.2.10:51234"
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: auth.SessionCookieName, Value: tt.cookie})
+			}
+			if tt.bearer != "" {
+				req.Header.Set(echo.HeaderAuthorization, "Bearer "+tt.bearer)
+			}
+
+			rec, user := checkAuth(am, req)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			var got string
+			if user != nil {
+				got = user.Username
+			}
+			if got != tt.wantUser {
+				t.Errorf("user = %q, want %q", got, tt.wantUser)
+			}
+			if user != nil && user.TailscaleIP != "" {
+				t.Errorf("tailscale IP = %q, want empty for a NULL column", user.TailscaleIP)
+			}
+		})
+	}
+
+	tokens, err := store.ListAPITokens(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1 || tokens[0].LastUsedAt == nil {
+		t.Errorf("api tokens = %+v, want the token marked as used", tokens)
+	}
+}
@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"citadel/highway17/internal/auth"
+	"citadel/highway17/internal/config"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+func TestCSRFProtect(t *testing.T) {
+	cs := NewCSRF(&config.Config{SessionMaxLifetime: 86400}, zap.NewNop())
+	token := strings.Repeat("ab", 32)
+	apiToken, _, _ := auth.GenerateAPIToken()
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		cookie   string
+		header   string
+		form     string
+		bearer   string
+		htmx     bool
+		wantCode int
+	}{
+		{name: "safe method without a cookie", method: http.MethodGet, path: "/dashboard", wantCode: http.StatusNoContent},
+		{name: "safe method ignores the header", method: http.MethodHead, path: "/dashboard", cookie: token, header: "wrong", wantCode: http.StatusNoContent},
+		{name: "post with the header", method: http.MethodPost, path: "/api/widgets/save", cookie: token, header: token, wantCode: http.StatusNoContent},
+		{name: "post with the form field", method: http.MethodPost, path: "/account/password", cookie: token, form: token, wantCode: http.StatusNoContent},
+		{name: "post without a token", method: http.MethodPost, path: "/api/widgets/save", cookie: token, wantCode: http.StatusForbidden},
+		{name: "post without a cookie", method: http.MethodPost, path: "/api/widgets/save", header: token, wantCode: http.StatusForbidden},
+		{name: "post with a mismatched token", method: http.MethodPost, path: "/api/widgets/save", cookie: token, header: strings.Repeat("cd", 32), wantCode: http.StatusForbidden},
+		{name: "htmx post with a mismatched token", method: http.MethodPost, path: "/dashboard", cookie: token, header: "x", htmx: true, wantCode: http.StatusForbidden},
+		{name: "malformed cookie", method: http.MethodPost, path: "/api/widgets/save", cookie: "short", header: "short", wantCode: http.StatusForbidden},
+		{name: "put without a token", method: http.MethodPut, path: "/api/widgets/save", cookie: token, wantCode: http.StatusForbidden},
+		{name: "delete without a token", method: http.MethodDelete, path: "/api/tokens/1", cookie: token, wantCode: http.StatusForbidden},
+		{name: "api token is exempt", method: http.MethodPost, path: "/api/widgets/save", bearer: apiToken, wantCode: http.StatusNoContent},
+		// Session tokens double as cookies, so only API tokens skip the check
+		{name: "session bearer is not exempt", method: http.MethodPost, path: "/api/widgets/save", bearer: "session-token", wantCode: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body *strings.Reader
+			if tt.form != "" {
+				body = strings.NewReader(url.Values{CSRFFormField: {tt.form}}.Encode())
+			} else {
+				body = strings.NewReader("")
+			}
+			req := httptest.NewRequest(tt.method, tt.path, body)
+			if tt.form != "" {
+				req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: CSRFCookieName, Value: tt.cookie})
+			}
+			if tt.header != "" {
+				req.Header.Set(CSRFHeader, tt.header)
+			}
+			if tt.bearer != "" {
+				req.Header.Set(echo.HeaderAuthorization, "Bearer "+tt.bearer)
+			}
+			if tt.htmx {
+				req.Header.Set("HX-Request", "true")
+			}
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+
+			var seen string
+			next := func(c echo.Context) error {
+				seen, _ = c.Get(CSRFContextKey).(string)
+				return c.NoContent(http.StatusNoContent)
+			}
+			if err := cs.Protect(next)(c); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if tt.wantCode == http.StatusForbidden {
+				wantJSON := strings.HasPrefix(tt.path, "/api/") || tt.htmx
+				if isJSON := strings.HasPrefix(rec.Header().Get(echo.HeaderContentType), echo.MIMEApplicationJSON); isJSON != wantJSON {
+					t.Errorf("content type = %q, want JSON %v", rec.Header().Get(echo.HeaderContentType), wantJSON)
+				}
+				return
+			}
+
+			// Templates see the browser's token; a browser without a
+			// valid one is issued a fresh cookie
+			issued := rec.Result().Cookies()
+			switch {
+			case tt.bearer != "":
+				if seen != "" || len(issued) != 0 {
+					t.Errorf("api token request got csrf token %q and cookies %v", seen, issued)
+				}
+			case tt.cookie == token:
+				if seen != token || len(issued) != 0 {
+					t.Errorf("csrf token = %q, cookies %v; want the existing token", seen, issued)
+				}
+			default:
+				if len(issued) != 1 || issued[0].Name != CSRFCookieName || issued[0].Value != seen || len(seen) != 64 || !issued[0].HttpOnly {
+					t.Errorf("csrf token = %q, cookies %v; want a new HttpOnly token", seen, issued)
+				}
+			}
+		})
+	}
+}
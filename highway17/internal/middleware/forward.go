@@ -0,0 +1,168 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"citadel/highway17/internal/auth"
+	"citadel/highway17/internal/config"
+	"citadel/highway17/internal/models"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// ForwardAuthPath is the endpoint reverse proxies ask before serving a
+// protected service (nginx auth_request, Traefik forwardAuth, Caddy forward_auth)
+const ForwardAuthPath = "/auth/forward"
+
+// Identity headers returned to the proxy, named as Authelia does so services
+// with header SSO support work unchanged
+const (
+	headerRemoteUser   = "Remote-User"
+	headerRemoteGroups = "Remote-Groups"
+)
+
+// ForwardAuth answers forward-auth requests with the Highway 17 session
+// cookie or an API token carried on the original request
+type ForwardAuth struct {
+	am       *AuthMiddleware
+	policy   *auth.ForwardPolicy
+	loginURL *url.URL
+	log      *zap.Logger
+}
+
+func NewForwardAuth(cfg *config.Config, log *zap.Logger, am *AuthMiddleware, policy *auth.ForwardPolicy) (*ForwardAuth, error) {
+	fa := &ForwardAuth{am: am, policy: policy, log: log}
+
+	if cfg.ForwardAuthLoginURL != "" {
+		u, err := url.Parse(cfg.ForwardAuthLoginURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, fmt.Errorf("invalid FORWARD_AUTH_LOGIN_URL %q: want an absolute http(s) URL", cfg.ForwardAuthLoginURL)
+		}
+		fa.loginURL = u
+	}
+
+	return fa, nil
+}
+
+// Check returns 200 with identity headers when the caller may reach the
+// original host. Unauthenticated callers get 401 with a Location header
+// pointing at the login page, or a 302 there with ?redirect=true for
+// proxies that pass responses straight to the browser. Signed-in users
+// without access get 403.
+func (fa *ForwardAuth) Check(c echo.Context) error {
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+
+	target := forwardedURL(c.Request())
+	if target == nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "missing X-Original-URL or X-Forwarded-Host"})
+	}
+
+	rule := fa.policy.Rule(target.Host)
+	if rule == nil {
+		fa.log.Sugar().Warnw("forward auth denied", "host", target.Host, "client_ip", c.RealIP(), "reason", "no rule for host")
+		return c.NoContent(http.StatusForbidden)
+	}
+
+	user := fa.user(c)
+	if user == nil {
+		return fa.login(c, target)
+	}
+
+	if reason := fa.denyReason(c, user, rule); reason != "" {
+		fa.log.Sugar().Warnw("forward auth denied",
+			"username", user.Username,
+			"role", user.Role,
+			"host", target.Host,
+			"rule", rule.String(),
+			"client_ip", c.RealIP(),
+			"reason", reason,
+		)
+		return c.NoContent(http.StatusForbidden)
+	}
+
+	c.Response().Header().Set(headerRemoteUser, user.Username)
+	c.Response().Header().Set(headerRemoteGroups, user.Role)
+	return c.NoContent(http.StatusOK)
+}
+
+// user resolves the credential on the original request. Tailnet identity is
+// not used: the connection comes from the proxy, not the browser.
+func (fa *ForwardAuth) user(c echo.Context) *models.User {
+	if bearer := bearerToken(c); auth.IsAPIToken(bearer) {
+		return fa.am.apiTokenUser(c, bearer)
+	}
+	user, _ := fa.am.sessionUser(c)
+	return user
+}
+
+// denyReason explains why a signed-in user may not pass, or returns ""
+func (fa *ForwardAuth) denyReason(c echo.Context, user *models.User, rule *auth.ForwardRule) string {
+	token, _ := c.Get("api_token").(*models.APIToken)
+	switch {
+	case !auth.UserCan(user, auth.PermProxyAccess):
+		return "role has no proxy access"
+	case token != nil && !auth.TokenAllows(token, auth.PermProxyAccess):
+		return "api token lacks proxy:access scope"
+	case user.MustChangePassword:
+		return "password change required"
+	case fa.am.cfg.TOTPRequired && !user.TOTPEnabled:
+		return "two-factor enrollment required"
+	case !rule.Allows(user):
+		return "not allowed by rule"
+	}
+	return ""
+}
+
+// login sends an unauthenticated caller to the login page, which returns
+// them to target afterwards
+func (fa *ForwardAuth) login(c echo.Context, target *url.URL) error {
+	if fa.loginURL == nil {
+		return c.NoContent(http.StatusUnauthorized)
+	}
+
+	login := *fa.loginURL
+	q := login.Query()
+	q.Set("rd", target.String())
+	login.RawQuery = q.Encode()
+	location := login.String()
+
+	if c.QueryParam("redirect") == "true" && !isAPIRequest(c.Request()) {
+		return c.Redirect(http.StatusFound, location)
+	}
+	c.Response().Header().Set(echo.HeaderLocation, location)
+	return c.NoContent(http.StatusUnauthorized)
+}
+
+// forwardedURL rebuilds the URL the browser asked the proxy for, from
+// X-Original-URL (nginx) or X-Forwarded-Proto/Host/Uri (Traefik, Caddy)
+func forwardedURL(req *http.Request) *url.URL {
+	if raw := req.Header.Get("X-Original-URL"); raw != "" {
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" {
+			return nil
+		}
+		return u
+	}
+
+	host := req.Header.Get("X-Forwarded-Host")
+	if host == "" {
+		return nil
+	}
+	scheme := "https"
+	if req.Header.Get(echo.HeaderXForwardedProto) == "http" {
+		scheme = "http"
+	}
+	uri := req.Header.Get("X-Forwarded-Uri")
+	if uri == "" {
+		uri = "/"
+	}
+
+	u, err := url.Parse(scheme + "://" + host + uri)
+	if err != nil {
+		return nil
+	}
+	return u
+}
@@ -0,0 +1,146 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"citadel/highway17/internal/auth"
+	"citadel/highway17/internal/config"
+	"citadel/highway17/internal/database/databasetest"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+func TestForwardAuthCheck(t *testing.T) {
+	ctx := context.Background()
+	store := databasetest.NewStore()
+	am := newTestAuthMiddleware(t, store)
+
+	policy, err := auth.ParseForwardRules("jellyfin.city17.lan viewer,operator,admin; portainer.city17.lan admin; *.lab.lan user:barney")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fa, err := NewForwardAuth(&config.Config{ForwardAuthLoginURL: "https://h17.city17.lan/login"}, zap.NewNop(), am, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session := func(username, role string, mustChange bool) string {
+		id, err := store.CreateUser(ctx, username, "hash", role, mustChange)
+		if err != nil {
+			t.Fatal(err)
+		}
+		token := username + "-token"
+		if err := store.CreateSession(ctx, id, token, "192.0.2.10", "test", time.Hour, 24*time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	alyx := session("alyx", "admin", false)
+	barney := session("barney", "viewer", false)
+	gordon := session("gordon", "admin", true)
+
+	alyxUser, _ := store.GetUserByUsername(ctx, "alyx")
+	readToken, hash, prefix := auth.GenerateAPIToken()
+	if _, err := store.CreateAPIToken(ctx, alyxUser.ID, "ci", hash, prefix, []string{"widgets:read"}, 0); err != nil {
+		t.Fatal(err)
+	}
+	proxyToken, hash, prefix := auth.GenerateAPIToken()
+	if _, err := store.CreateAPIToken(ctx, alyxUser.ID, "ha", hash, prefix, []string{"proxy:access"}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	const jellyfin = "https://jellyfin.city17.lan/web/index.html"
+	tests := []struct {
+		name         string
+		originalURL  string
+		forwarded    map[string]string
+		query        string
+		cookie       string
+		bearer       string
+		wantCode     int
+		wantLocation string
+		wantUser     string
+		wantGroups   string
+	}{
+		{name: "no original url", wantCode: http.StatusBadRequest},
+		{name: "host without a rule", originalURL: "https://grafana.city17.lan/", cookie: alyx, wantCode: http.StatusForbidden},
+		{name: "anonymous", originalURL: jellyfin, wantCode: http.StatusUnauthorized,
+			wantLocation: "https://h17.city17.lan/login?rd=https%3A%2F%2Fjellyfin.city17.lan%2Fweb%2Findex.html"},
+		{name: "anonymous redirected", originalURL: jellyfin, query: "?redirect=true", wantCode: http.StatusFound,
+			wantLocation: "https://h17.city17.lan/login?rd=https%3A%2F%2Fjellyfin.city17.lan%2Fweb%2Findex.html"},
+		{name: "unknown session", originalURL: jellyfin, cookie: "stale", wantCode: http.StatusUnauthorized,
+			wantLocation: "https://h17.city17.lan/login?rd=https%3A%2F%2Fjellyfin.city17.lan%2Fweb%2Findex.html"},
+		{name: "password change pending", originalURL: jellyfin, cookie: gordon, wantCode: http.StatusForbidden},
+		{name: "role not in the rule", originalURL: "https://portainer.city17.lan/", cookie: barney, wantCode: http.StatusForbidden},
+		{name: "role in the rule", originalURL: jellyfin, cookie: alyx, wantCode: http.StatusOK, wantUser: "alyx", wantGroups: "admin"},
+		{name: "named user on a wildcard", originalURL: "https://pihole.lab.lan/admin", cookie: barney, wantCode: http.StatusOK, wantUser: "barney", wantGroups: "viewer"},
+		{name: "other user on a wildcard", originalURL: "https://pihole.lab.lan/admin", cookie: alyx, wantCode: http.StatusForbidden},
+		{name: "forwarded headers", forwarded: map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "Jellyfin.City17.lan:443", "X-Forwarded-Uri": "/web/"},
+			cookie: barney, wantCode: http.StatusOK, wantUser: "barney", wantGroups: "viewer"},
+		{name: "api token without proxy scope", originalURL: jellyfin, bearer: readToken, wantCode: http.StatusForbidden},
+		{name: "api token with proxy scope", originalURL: jellyfin, bearer: proxyToken, wantCode: http.StatusOK, wantUser: "alyx", wantGroups: "admin"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, ForwardAuthPath+tt.query, nil)
+			req.RemoteAddr = "172.18.0.2:50000"
+			if tt.originalURL != "" {
+				req.Header.Set("X-Original-URL", tt.originalURL)
+			}
+			for k, v := range tt.forwarded {
+				req.Header.Set(k, v)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: auth.SessionCookieName, Value: tt.cookie})
+			}
+			if tt.bearer != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			rec := httptest.NewRecorder()
+
+			if err := fa.Check(echo.New().NewContext(req, rec)); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if got := rec.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", got, tt.wantLocation)
+			}
+			if got := rec.Header().Get(headerRemoteUser); got != tt.wantUser {
+				t.Errorf("%s = %q, want %q", headerRemoteUser, got, tt.wantUser)
+			}
+			if got := rec.Header().Get(headerRemoteGroups); got != tt.wantGroups {
+				t.Errorf("%s = %q, want %q", headerRemoteGroups, got, tt.wantGroups)
+			}
+			if got := rec.Header().Get("Cache-Control"); got != "no-store" {
+				t.Errorf("Cache-Control = %q, want no-store", got)
+			}
+		})
+	}
+
+	// Without a login URL the proxy gets a bare 401
+	bare, err := NewForwardAuth(&config.Config{}, zap.NewNop(), am, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, ForwardAuthPath, nil)
+	req.Header.Set("X-Original-URL", jellyfin)
+	rec := httptest.NewRecorder()
+	if err := bare.Check(echo.New().NewContext(req, rec)); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("Location") != "" {
+		t.Errorf("without a login URL: status %d, Location %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	if _, err := NewForwardAuth(&config.Config{ForwardAuthLoginURL: "/login"}, zap.NewNop(), am, policy); err == nil {
+		t.Error("relative FORWARD_AUTH_LOGIN_URL accepted")
+	}
+}
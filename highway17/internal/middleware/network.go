@@ -0,0 +1,244 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"citadel/highway17/internal/config"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// NetworkRule allows or denies a set of networks for a route pattern.
+// Rules are evaluated in order and the first one that matches both the
+// path and the client address decides the request.
+type NetworkRule struct {
+	Allow    bool
+	Path     string
+	Prefixes []netip.Prefix
+	raw      string
+}
+
+func (r NetworkRule) String() string {
+	return r.raw
+}
+
+func (r NetworkRule) matches(path string, ip netip.Addr) bool {
+	if !matchPath(r.Path, path) {
+		return false
+	}
+	for _, prefix := range r.Prefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// NetworkPolicy enforces CIDR rules ahead of authentication and resolves
+// the real client IP behind trusted reverse proxies
+type NetworkPolicy struct {
+	rules          []NetworkRule
+	trustedProxies []netip.Prefix
+	defaultAllow   bool
+	log            *zap.Logger
+}
+
+func NewNetworkPolicy(cfg *config.Config, log *zap.Logger) (*NetworkPolicy, error) {
+	rules, err := ParseNetworkRules(cfg.NetworkPolicy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid NETWORK_POLICY: %w", err)
+	}
+
+	trusted, err := parsePrefixes(cfg.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TRUSTED_PROXIES: %w", err)
+	}
+
+	var defaultAllow bool
+	switch cfg.NetworkDefaultAction {
+	case "allow":
+		defaultAllow = true
+	case "deny":
+		defaultAllow = false
+	default:
+		return nil, fmt.Errorf("invalid NETWORK_DEFAULT_ACTION %q (want allow or deny)", cfg.NetworkDefaultAction)
+	}
+
+	return &NetworkPolicy{
+		rules:          rules,
+		trustedProxies: trusted,
+		defaultAllow:   defaultAllow,
+		log:            log,
+	}, nil
+}
+
+// ParseNetworkRules parses rules of the form "allow /api/* 192.168.68.0/24,100.64.0.0/10"
+// separated by ";". The network "any" matches every IPv4 and IPv6 address.
+func ParseNetworkRules(spec string) ([]NetworkRule, error) {
+	var rules []NetworkRule
+
+	for _, raw := range strings.Split(spec, ";") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+
+		fields := strings.Fields(raw)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("rule %q: want \"allow|deny <path> <cidr>[,<cidr>...]\"", raw)
+		}
+
+		rule := NetworkRule{Path: fields[1], raw: raw}
+		switch fields[0] {
+		case "allow":
+			rule.Allow = true
+		case "deny":
+			rule.Allow = false
+		default:
+			return nil, fmt.Errorf("rule %q: unknown action %q", raw, fields[0])
+		}
+
+		prefixes, err := parsePrefixes(strings.Split(fields[2], ","))
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", raw, err)
+		}
+		rule.Prefixes = prefixes
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// Enforce rejects requests whose client IP is denied for the requested path
+func (np *NetworkPolicy) Enforce(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		path := c.Request().URL.Path
+
+		ip, err := netip.ParseAddr(c.RealIP())
+		if err != nil {
+			np.log.Sugar().Warnw("request denied by network policy", "client_ip", c.RealIP(), "path", path, "rule", "unparseable client address")
+			return forbidden(c)
+		}
+		ip = ip.Unmap()
+
+		for _, rule := range np.rules {
+			if !rule.matches(path, ip) {
+				continue
+			}
+			if !rule.Allow {
+				np.log.Sugar().Warnw("request denied by network policy", "client_ip", ip.String(), "path", path, "rule", rule.String())
+				return forbidden(c)
+			}
+			return next(c)
+		}
+
+		if !np.defaultAllow {
+			np.log.Sugar().Warnw("request denied by network policy", "client_ip", ip.String(), "path", path, "rule", "default deny")
+			return forbidden(c)
+		}
+
+		return next(c)
+	}
+}
+
+// ClientIP resolves the originating client address. Forwarding headers are
+// only honoured when the direct peer is a trusted proxy; X-Forwarded-For is
+// walked right to left, skipping further trusted hops. Use it as echo's
+// IPExtractor so c.RealIP() agrees with the policy everywhere.
+func (np *NetworkPolicy) ClientIP(req *http.Request) string {
+	peer := remoteIP(req.RemoteAddr)
+	if !peer.IsValid() || !np.isTrustedProxy(peer) {
+		return addrString(peer, req.RemoteAddr)
+	}
+
+	if xff := req.Header.Get(echo.HeaderXForwardedFor); xff != "" {
+		hops := strings.Split(xff, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				break
+			}
+			hop = hop.Unmap()
+			if i == 0 || !np.isTrustedProxy(hop) {
+				return hop.String()
+			}
+		}
+	}
+
+	if realIP, err := netip.ParseAddr(strings.TrimSpace(req.Header.Get(echo.HeaderXRealIP))); err == nil {
+		return realIP.Unmap().String()
+	}
+
+	return peer.String()
+}
+
+func (np *NetworkPolicy) isTrustedProxy(ip netip.Addr) bool {
+	for _, prefix := range np.trustedProxies {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forbidden rejects a request in the form the caller expects
+func forbidden(c echo.Context) error {
+	if isAPIRequest(c.Request()) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "forbidden"})
+	}
+	return c.String(http.StatusForbidden, "Forbidden")
+}
+
+// parsePrefixes parses CIDRs or bare addresses; "any" expands to all addresses
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		switch {
+		case value == "":
+			continue
+		case value == "any":
+			prefixes = append(prefixes, netip.MustParsePrefix("0.0.0.0/0"), netip.MustParsePrefix("::/0"))
+		case strings.Contains(value, "/"):
+			prefix, err := netip.ParsePrefix(value)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, prefix.Masked())
+		default:
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+		}
+	}
+
+	return prefixes, nil
+}
+
+func remoteIP(remoteAddr string) netip.Addr {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return ip.Unmap()
+}
+
+func addrString(ip netip.Addr, fallback string) string {
+	if ip.IsValid() {
+		return ip.String()
+	}
+	return fallback
+}
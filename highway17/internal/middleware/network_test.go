@@ -0,0 +1,155 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"citadel/highway17/internal/config"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// examplePolicy is the NETWORK_POLICY example from AGENT.md
+const examplePolicy = "allow /health any; allow /api/* 192.168.68.0/24,100.64.0.0/10,127.0.0.1; deny /api/* any; allow * 192.168.68.0/24,100.64.0.0/10,127.0.0.1"
+
+func newTestNetworkPolicy(t *testing.T, policy, defaultAction string) *NetworkPolicy {
+	t.Helper()
+
+	np, err := NewNetworkPolicy(&config.Config{
+		NetworkPolicy:        policy,
+		NetworkDefaultAction: defaultAction,
+		TrustedProxies:       []string{"172.16.0.0/12", "10.0.0.1"},
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return np
+}
+
+func TestClientIP(t *testing.T) {
+	np := newTestNetworkPolicy(t, "", "allow")
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        string
+		realIP     string
+		want       string
+	}{
+		{name: "direct peer", remoteAddr: "192.168.68.20:50000", want: "192.168.68.20"},
+		{name: "untrusted peer spoofing XFF", remoteAddr: "203.0.113.7:50000", xff: "127.0.0.1", want: "203.0.113.7"},
+		{name: "untrusted peer spoofing X-Real-IP", remoteAddr: "203.0.113.7:50000", realIP: "127.0.0.1", want: "203.0.113.7"},
+		{name: "trusted proxy", remoteAddr: "172.18.0.2:50000", xff: "192.168.68.20", want: "192.168.68.20"},
+		{name: "client-supplied hops are ignored", remoteAddr: "172.18.0.2:50000", xff: "127.0.0.1, 203.0.113.7", want: "203.0.113.7"},
+		{name: "trusted hops are skipped", remoteAddr: "172.18.0.2:50000", xff: "127.0.0.1, 198.51.100.4, 10.0.0.1, 172.20.0.5", want: "198.51.100.4"},
+		{name: "every hop trusted", remoteAddr: "172.18.0.2:50000", xff: "172.20.0.9, 10.0.0.1", want: "172.20.0.9"},
+		{name: "unparsable hop falls back to X-Real-IP", remoteAddr: "172.18.0.2:50000", xff: "198.51.100.4, unknown", realIP: "192.168.68.30", want: "192.168.68.30"},
+		{name: "unparsable hop without X-Real-IP", remoteAddr: "172.18.0.2:50000", xff: "garbage", want: "172.18.0.2"},
+		{name: "X-Real-IP alone", remoteAddr: "172.18.0.2:50000", realIP: " 192.168.68.30 ", want: "192.168.68.30"},
+		{name: "IPv4-mapped peer", remoteAddr: "[::ffff:192.168.68.20]:50000", want: "192.168.68.20"},
+		{name: "IPv4-mapped trusted proxy", remoteAddr: "[::ffff:172.18.0.2]:50000", xff: "192.168.68.20", want: "192.168.68.20"},
+		{name: "IPv4-mapped hop", remoteAddr: "172.18.0.2:50000", xff: "::ffff:198.51.100.4", want: "198.51.100.4"},
+		{name: "IPv6 peer", remoteAddr: "[2001:db8::1]:50000", xff: "127.0.0.1", want: "2001:db8::1"},
+		{name: "unparsable peer", remoteAddr: "@", want: "@"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.xff != "" {
+				req.Header.Set(echo.HeaderXForwardedFor, tt.xff)
+			}
+			if tt.realIP != "" {
+				req.Header.Set(echo.HeaderXRealIP, tt.realIP)
+			}
+
+			if got := np.ClientIP(req); got != tt.want {
+				t.Errorf("ClientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseNetworkRules(t *testing.T) {
+	rules, err := ParseNetworkRules(" allow /health any ;; deny /api/* 10.1.2.3,192.168.68.7/24 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("rules = %v, want 2", rules)
+	}
+
+	if r := rules[0]; !r.Allow || r.Path != "/health" || len(r.Prefixes) != 2 || r.String() != "allow /health any" {
+		t.Errorf("rule 0 = %+v", r)
+	}
+	want := []netip.Prefix{netip.MustParsePrefix("10.1.2.3/32"), netip.MustParsePrefix("192.168.68.0/24")}
+	if r := rules[1]; r.Allow || r.Path != "/api/*" || len(r.Prefixes) != 2 || r.Prefixes[0] != want[0] || r.Prefixes[1] != want[1] {
+		t.Errorf("rule 1 = %+v, want deny /api/* %v", r, want)
+	}
+
+	for _, spec := range []string{
+		"allow /api/*",
+		"permit /api/* any",
+		"allow /api/* 192.168.68.0/33",
+		"allow /api/* lan",
+		"allow /health any; deny /api/*",
+	} {
+		if _, err := ParseNetworkRules(spec); err == nil {
+			t.Errorf("ParseNetworkRules(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestNetworkPolicyEnforce(t *testing.T) {
+	tests := []struct {
+		name          string
+		policy        string
+		defaultAction string
+		path          string
+		remoteAddr    string
+		want          int
+	}{
+		{name: "health from anywhere", path: "/health", remoteAddr: "203.0.113.7:50000", want: http.StatusOK},
+		{name: "api from the LAN", path: "/api/widgets", remoteAddr: "192.168.68.20:50000", want: http.StatusOK},
+		{name: "api from the tailnet", path: "/api/widgets", remoteAddr: "100.64.0.1:50000", want: http.StatusOK},
+		{name: "api from outside", path: "/api/widgets", remoteAddr: "203.0.113.7:50000", want: http.StatusForbidden},
+		{name: "pages from the LAN", path: "/dashboard", remoteAddr: "192.168.68.20:50000", want: http.StatusOK},
+		{name: "pages from outside fall to the default", path: "/dashboard", remoteAddr: "203.0.113.7:50000", want: http.StatusForbidden},
+		{name: "IPv4-mapped client", path: "/api/widgets", remoteAddr: "[::ffff:127.0.0.1]:50000", want: http.StatusOK},
+		{name: "forwarded client behind a trusted proxy", path: "/api/widgets", remoteAddr: "172.18.0.2:50000", want: http.StatusForbidden},
+		{name: "first match wins over a later allow", policy: "deny /api/* any; allow /api/* 192.168.68.0/24", path: "/api/widgets", remoteAddr: "192.168.68.20:50000", want: http.StatusForbidden},
+		{name: "unmatched request with default allow", policy: "deny /api/* 203.0.113.0/24", defaultAction: "allow", path: "/dashboard", remoteAddr: "203.0.113.7:50000", want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, defaultAction := tt.policy, tt.defaultAction
+			if policy == "" {
+				policy = examplePolicy
+			}
+			if defaultAction == "" {
+				defaultAction = "deny"
+			}
+			np := newTestNetworkPolicy(t, policy, defaultAction)
+
+			e := echo.New()
+			e.IPExtractor = np.ClientIP
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set(echo.HeaderXForwardedFor, "203.0.113.7")
+			rec := httptest.NewRecorder()
+
+			handler := np.Enforce(func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+			if err := handler(e.NewContext(req, rec)); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
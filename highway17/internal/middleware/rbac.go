@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"citadel/highway17/internal/auth"
+	"citadel/highway17/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Require returns route middleware that only lets through users whose role
+// grants perm, and for API tokens whose scopes do too. It must run after
+// CheckAuth has stored the user.
+func (am *AuthMiddleware) Require(perm auth.Permission) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			user, _ := c.Get("user").(*models.User)
+			if user == nil {
+				return am.unauthorized(c)
+			}
+
+			// API tokens are limited to their scopes on top of the owner's role
+			token, _ := c.Get("api_token").(*models.APIToken)
+			if !auth.UserCan(user, perm) || (token != nil && !auth.TokenAllows(token, perm)) {
+				am.log.Sugar().Warnw("request denied by role",
+					"username", user.Username,
+					"role", user.Role,
+					"permission", perm,
+					"path", c.Request().URL.Path,
+					"client_ip", c.RealIP(),
+				)
+				return forbidden(c)
+			}
+
+			return next(c)
+		}
+	}
+}
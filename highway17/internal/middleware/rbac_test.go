@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"citadel/highway17/internal/auth"
+	"citadel/highway17/internal/database/databasetest"
+	"citadel/highway17/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+// require runs Require(perm) for a request made by user, who may be nil,
+// and returns the response status
+func require(am *AuthMiddleware, perm auth.Permission, user *models.User, token *models.APIToken, path string) int {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	if user != nil {
+		c.Set("user", user)
+	}
+	if token != nil {
+		c.Set("api_token", token)
+	}
+
+	next := func(c echo.Context) error { return c.NoContent(http.StatusNoContent) }
+	if err := am.Require(perm)(next)(c); err != nil {
+		return http.StatusInternalServerError
+	}
+	return rec.Code
+}
+
+func TestRequireRole(t *testing.T) {
+	am := newTestAuthMiddleware(t, databasetest.NewStore())
+
+	const allowed, denied = http.StatusNoContent, http.StatusForbidden
+	tests := []struct {
+		perm                    auth.Permission
+		admin, operator, viewer int
+	}{
+		{auth.PermDashboardView, allowed, allowed, allowed},
+		{auth.PermWidgetsRead, allowed, allowed, allowed},
+		{auth.PermAccount, allowed, allowed, allowed},
+		{auth.PermProxyAccess, allowed, allowed, allowed},
+		{auth.PermWidgetsWrite, allowed, allowed, denied},
+		{auth.PermSettingsWrite, allowed, allowed, denied},
+		{auth.PermContainersManage, allowed, allowed, denied},
+		{auth.PermAdmin, allowed, denied, denied},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.perm), func(t *testing.T) {
+			for role, want := range map[auth.Role]int{auth.RoleAdmin: tt.admin, auth.RoleOperator: tt.operator, auth.RoleViewer: tt.viewer} {
+				user := &models.User{ID: 1, Username: "alyx", Role: string(role)}
+				if got := require(am, tt.perm, user, nil, "/api/widgets"); got != want {
+					t.Errorf("%s: status = %d, want %d", role, got, want)
+				}
+			}
+
+			// A row with an unknown role gets nothing
+			if got := require(am, tt.perm, &models.User{ID: 1, Username: "alyx", Role: "superuser"}, nil, "/api/widgets"); got != denied {
+				t.Errorf("unknown role: status = %d, want %d", got, denied)
+			}
+		})
+	}
+
+	if got := require(am, auth.PermDashboardView, nil, nil, "/api/widgets"); got != http.StatusUnauthorized {
+		t.Errorf("no user: status = %d, want 401", got)
+	}
+	if got := require(am, auth.PermAdmin, &models.User{ID: 1, Role: "viewer"}, nil, "/admin"); got != denied {
+		t.Errorf("browser request: status = %d, want 403", got)
+	}
+}
+
+func TestRequireTokenScopes(t *testing.T) {
+	am := newTestAuthMiddleware(t, databasetest.NewStore())
+	admin := &models.User{ID: 1, Username: "alyx", Role: string(auth.RoleAdmin)}
+	viewer := &models.User{ID: 2, Username: "barney", Role: string(auth.RoleViewer)}
+
+	tests := []struct {
+		name   string
+		user   *models.User
+		scopes []string
+		perm   auth.Permission
+		want   int
+	}{
+		{"read token reads", admin, []string{"widgets:read"}, auth.PermWidgetsRead, http.StatusNoContent},
+		{"read token views the dashboard", admin, []string{"widgets:read"}, auth.PermDashboardView, http.StatusNoContent},
+		{"read token cannot write", admin, []string{"widgets:read"}, auth.PermWidgetsWrite, http.StatusForbidden},
+		{"read token cannot administer", admin, []string{"widgets:read"}, auth.PermAdmin, http.StatusForbidden},
+		{"admin token administers", admin, []string{"admin"}, auth.PermAdmin, http.StatusNoContent},
+		{"admin token cannot manage the account", admin, []string{"admin"}, auth.PermAccount, http.StatusForbidden},
+		// Scopes narrow the role; a stale admin token of a demoted user grants nothing extra
+		{"admin token of a viewer", viewer, []string{"admin"}, auth.PermAdmin, http.StatusForbidden},
+		{"admin token of a viewer writing", viewer, []string{"admin"}, auth.PermWidgetsWrite, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := &models.APIToken{ID: 1, UserID: tt.user.ID, Scopes: tt.scopes}
+			if got := require(am, tt.perm, tt.user, token, "/api/widgets"); got != tt.want {
+				t.Errorf("status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"citadel/highway17/internal/audit"
+	"citadel/highway17/internal/auth"
+	"citadel/highway17/internal/config"
+	"citadel/highway17/internal/database"
+	"citadel/highway17/internal/database/databasetest"
+	"citadel/highway17/internal/models"
+	"citadel/highway17/internal/tailscale"
+	"citadel/highway17/internal/tailscale/tailscaletest"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// newTailnet starts a fake tailscaled with a few peers. 100.64.0.7 is the
+// reverse proxy, a node owned by alyx that forwards for other clients.
+func newTailnet(t *testing.T) *tailscaletest.Server {
+	t.Helper()
+
+	srv, err := tailscaletest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+
+	srv.AddUserPeer("100.64.0.1", "laptop", "alyx@github")
+	srv.AddUserPeer("100.64.0.2", "desktop", "barney@github")
+	srv.AddUserPeer("100.64.0.3", "phone", "eli@github")
+	srv.AddTaggedPeer("100.64.0.4", "nas", "tag:server")
+	srv.AddTaggedPeer("100.64.0.5", "printer", "tag:server")
+	srv.AddTaggedPeer("100.64.0.6", "camera", "tag:iot")
+	srv.AddUserPeer("100.64.0.7", "proxy", "alyx@github")
+	return srv
+}
+
+func newTailnetMiddleware(t *testing.T, srv *tailscaletest.Server, store *databasetest.Store, autoProvision bool) *AuthMiddleware {
+	t.Helper()
+
+	cfg := &config.Config{
+		TailscaleEnabled:       true,
+		TailscaleSocket:        srv.SocketPath,
+		TailscaleAllowedUsers:  []string{"alyx@github", "barney@github", "eli@github"},
+		TailscaleAllowedTags:   []string{"tag:server"},
+		TailscaleAutoProvision: autoProvision,
+		TrustedProxies:         []string{"100.64.0.7"},
+		NetworkDefaultAction:   "allow",
+		SessionIdleTimeout:     3600,
+		SessionMaxLifetime:     86400,
+	}
+	log := zap.NewNop()
+	network, err := NewNetworkPolicy(cfg, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewAuthMiddleware(cfg, store, log, audit.NewRecorder(store, log), auth.NewSessions(cfg, store), network)
+}
+
+func TestResolveTailnetUser(t *testing.T) {
+	ctx := context.Background()
+	srv := newTailnet(t)
+
+	tests := []struct {
+		name          string
+		remoteAddr    string
+		autoProvision bool
+		wantUser      string
+		wantErr       error
+	}{
+		{name: "user node by login ahead of ip", remoteAddr: "100.64.0.1:50000", wantUser: "alyx"},
+		{name: "user node falls back to ip", remoteAddr: "100.64.0.2:50000", wantUser: "barney"},
+		{name: "tagged node by ip", remoteAddr: "100.64.0.4:50000", wantUser: "nas"},
+		{name: "tagged node never provisioned", remoteAddr: "100.64.0.5:50000", autoProvision: true, wantErr: database.ErrUserNotFound},
+		{name: "unknown user without provisioning", remoteAddr: "100.64.0.3:50000", wantErr: database.ErrUserNotFound},
+		{name: "unknown user with provisioning", remoteAddr: "100.64.0.3:50000", autoProvision: true, wantUser: "eli@github"},
+		{name: "tag outside the policy", remoteAddr: "100.64.0.6:50000", wantErr: errTailnetDenied},
+		{name: "trusted proxy on the tailnet", remoteAddr: "100.64.0.7:50000", wantErr: errTailnetProxy},
+		{name: "trusted proxy on the tailnet, mapped", remoteAddr: "[::ffff:100.64.0.7]:50000", wantErr: errTailnetProxy},
+		{name: "unknown peer", remoteAddr: "100.64.0.99:50000", wantErr: tailscale.ErrNotTailnetPeer},
+		{name: "not a tailnet address", remoteAddr: "192.0.2.1:50000", wantErr: errNotTailnet},
+		{name: "no port", remoteAddr: "100.64.0.1", wantErr: errNotTailnet},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := databasetest.NewStore()
+
+			alyxID, _ := store.CreateTailscaleUser(ctx, "alyx", "Alyx@GitHub")
+			// Barney's row carries another login, so only the IP matches
+			barneyID, _ := store.CreateTailscaleUser(ctx, "barney", "barney@gitlab")
+			nasID, _ := store.CreateUser(ctx, "nas", "", "viewer", false)
+			// Kleiner holds the IP of Alyx's laptop; the login match outranks it
+			kleinerID, _ := store.CreateUser(ctx, "kleiner", "", "viewer", false)
+			store.SetTailscaleIP(alyxID, "100.64.0.9")
+			store.SetTailscaleIP(kleinerID, "100.64.0.1")
+			store.SetTailscaleIP(barneyID, "100.64.0.2")
+			store.SetTailscaleIP(nasID, "100.64.0.4")
+
+			am := newTailnetMiddleware(t, srv, store, tt.autoProvision)
+
+			user, err := am.resolveTailnetUser(ctx, tt.remoteAddr)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.Username != tt.wantUser {
+				t.Errorf("user = %q, want %q", user.Username, tt.wantUser)
+			}
+
+			count, _ := store.CountUsers(ctx)
+			if provisioned := count == 5; provisioned != (tt.wantUser == "eli@github") {
+				t.Errorf("users = %d after resolving %s", count, tt.remoteAddr)
+			}
+		})
+	}
+}
+
+func TestTailnetSessions(t *testing.T) {
+	ctx := context.Background()
+	srv := newTailnet(t)
+	store := databasetest.NewStore()
+	am := newTailnetMiddleware(t, srv, store, false)
+
+	alyxID, err := store.CreateTailscaleUser(ctx, "alyx", "alyx@github")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		remoteAddr  string
+		path        string
+		header      map[string]string
+		wantCode    int
+		wantSession bool
+	}{
+		{name: "script polling the api", remoteAddr: "100.64.0.1:50000", path: "/api/widgets/system", header: map[string]string{"Accept": "*/*"}, wantCode: http.StatusNoContent},
+		{name: "script polling again", remoteAddr: "100.64.0.1:50000", path: "/api/widgets/system", header: map[string]string{"Accept": "*/*"}, wantCode: http.StatusNoContent},
+		{name: "htmx request", remoteAddr: "100.64.0.1:50000", path: "/dashboard", header: map[string]string{"Accept": "text/html", "HX-Request": "true"}, wantCode: http.StatusNoContent},
+		{name: "forwarded by the tailnet proxy", remoteAddr: "100.64.0.7:50000", path: "/api/widgets/system", header: map[string]string{"X-Forwarded-For": "203.0.113.7"}, wantCode: http.StatusUnauthorized},
+		{name: "browser page load", remoteAddr: "100.64.0.1:50000", path: "/dashboard", header: map[string]string{"Accept": "text/html,application/xhtml+xml"}, wantCode: http.StatusNoContent, wantSession: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, _ := store.ListSessions(ctx, alyxID)
+			events := len(store.AuditEvents())
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			rec, user := checkAuth(am, req)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if tt.wantCode == http.StatusNoContent && (user == nil || user.ID != alyxID) {
+				t.Errorf("user = %+v, want alyx", user)
+			}
+
+			after, _ := store.ListSessions(ctx, alyxID)
+			cookie := strings.Contains(rec.Header().Get(echo.HeaderSetCookie), auth.SessionCookieName+"=")
+			newEvents := store.AuditEvents()[events:]
+			if tt.wantSession {
+				if len(after) != len(before)+1 || !cookie || len(newEvents) != 1 || newEvents[0].Action != audit.ActionLogin {
+					t.Errorf("sessions %d -> %d, cookie %v, events %v; want one session and one login event", len(before), len(after), cookie, auditActions(newEvents))
+				}
+				return
+			}
+			if len(after) != len(before) || cookie || len(newEvents) != 0 {
+				t.Errorf("sessions %d -> %d, cookie %v, events %v; want the identity scoped to the request", len(before), len(after), cookie, auditActions(newEvents))
+			}
+		})
+	}
+}
+
+func auditActions(events []models.AuditEvent) []string {
+	actions := make([]string, len(events))
+	for i, e := range events {
+		actions[i] = e.Action
+	}
+	return actions
+}
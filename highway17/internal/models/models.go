@@ -1,24 +1,109 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // User represents a dashboard user
 type User struct {
-	ID           int       `json:"id"`
-	Username     string    `json:"username"`
-	PasswordHash string    `json:"-"`
-	TailscaleIP  string    `json:"tailscale_ip,omitempty"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID                 int       `json:"id"`
+	Username           string    `json:"username"`
+	PasswordHash       string    `json:"-"`
+	Role               string    `json:"role"`
+	TailscaleIP        string    `json:"tailscale_ip,omitempty"`
+	TailscaleLogin     string    `json:"tailscale_login,omitempty"`
+	OIDCIssuer         string    `json:"oidc_issuer,omitempty"`
+	OIDCSubject        string    `json:"oidc_subject,omitempty"`
+	TOTPEnabled        bool      `json:"totp_enabled"`
+	TOTPSecret         string    `json:"-"`
+	TOTPLastStep       int64     `json:"-"`
+	MustChangePassword bool      `json:"must_change_password"`
+	AuthBackend        string    `json:"auth_backend"`
+	CreatedAt          time.Time `json:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at"`
 }
 
+// Account origins recorded in users.auth_backend
+const (
+	AuthBackendLocal     = "local"
+	AuthBackendTailscale = "tailscale"
+	AuthBackendOIDC      = "oidc"
+	AuthBackendLDAP      = "ldap"
+)
+
 // Session represents a user session
 type Session struct {
-	ID        int       `json:"id"`
-	UserID    int       `json:"user_id"`
-	Token     string    `json:"token"`
-	ExpiresAt time.Time `json:"expires_at"`
-	CreatedAt time.Time `json:"created_at"`
+	ID                int        `json:"id"`
+	UserID            int        `json:"user_id"`
+	Token             string     `json:"-"`
+	ExpiresAt         time.Time  `json:"expires_at"`
+	AbsoluteExpiresAt *time.Time `json:"absolute_expires_at,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+	LastSeenAt        time.Time  `json:"last_seen_at"`
+	IPAddress         string     `json:"ip_address"`
+	UserAgent         string     `json:"user_agent"`
+	Current           bool       `json:"current"`
+}
+
+// Passkey is a registered WebAuthn credential
+type Passkey struct {
+	ID             int        `json:"id"`
+	UserID         int        `json:"user_id"`
+	Name           string     `json:"name"`
+	CredentialID   []byte     `json:"-"`
+	CredentialJSON string     `json:"-"`
+	CreatedAt      time.Time  `json:"created_at"`
+	LastUsedAt     *time.Time `json:"last_used_at,omitempty"`
+}
+
+// APIToken is a long-lived personal token for scripts. Only a hash of the
+// secret is stored; Prefix identifies the token in listings.
+type APIToken struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// OIDCState is a pending OpenID Connect authorization-code flow
+type OIDCState struct {
+	Nonce        string
+	CodeVerifier string
+	UserID       *int // set when linking an existing account
+	ReturnTo     string
+}
+
+// AuditEvent records who did what, from where, to which object. Before and
+// After hold the changed state as JSON where an action modifies something.
+type AuditEvent struct {
+	ID         int64           `json:"id"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Action     string          `json:"action"`
+	Outcome    string          `json:"outcome"`
+	ActorID    *int            `json:"actor_id,omitempty"`
+	ActorName  string          `json:"actor_name"`
+	ClientIP   string          `json:"client_ip"`
+	TargetType string          `json:"target_type,omitempty"`
+	TargetID   string          `json:"target_id,omitempty"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	Details    json.RawMessage `json:"details,omitempty"`
+}
+
+// LoginLockout tracks failed logins for a username or source IP
+type LoginLockout struct {
+	ID            int        `json:"id"`
+	Scope         string     `json:"scope"` // username or ip
+	Subject       string     `json:"subject"`
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until,omitempty"`
+	Locked        bool       `json:"locked"`
 }
 
 // WeatherData represents current weather conditions
@@ -48,6 +133,96 @@ type SystemStats struct {
 	ProcessCount  int        `json:"process_count"`
 	LoadAverage   [3]float64 `json:"load_average"`
 	LastUpdated   time.Time  `json:"last_updated"`
+
+	// CPU is the aggregate CPU time breakdown and CPUCores the same per
+	// core, over the interval since the previous sample
+	CPU      CPUTimes   `json:"cpu"`
+	CPUCores []CPUTimes `json:"cpu_cores"`
+
+	// Pressure is Linux pressure stall information; nil when the kernel
+	// does not provide it
+	Pressure *Pressure `json:"pressure,omitempty"`
+
+	// Mounts are the monitored storage filesystems
+	Mounts []MountStats `json:"mounts"`
+}
+
+// MountStats is the usage of one mounted filesystem. Error is set, and the
+// usage left zero, when a configured mount could not be read, such as a
+// drive that is not mounted.
+type MountStats struct {
+	Path          string  `json:"path"`
+	Device        string  `json:"device"`
+	FSType        string  `json:"fstype"`
+	UUID          string  `json:"uuid,omitempty"`
+	TotalGB       float64 `json:"total_gb"`
+	UsedGB        float64 `json:"used_gb"`
+	FreeGB        float64 `json:"free_gb"`
+	UsedPercent   float64 `json:"used_percent"`
+	InodesTotal   uint64  `json:"inodes_total"`
+	InodesUsed    uint64  `json:"inodes_used"`
+	InodesFree    uint64  `json:"inodes_free"`
+	InodesPercent float64 `json:"inodes_percent"`
+	WarnPercent   float64 `json:"warn_percent"`
+	Warning       bool    `json:"warning"`
+	Error         string  `json:"error,omitempty"`
+}
+
+// CPUTimes splits a CPU's time into percentages that add up to 100
+type CPUTimes struct {
+	Name    string  `json:"name"`
+	Busy    float64 `json:"busy"`
+	User    float64 `json:"user"`
+	Nice    float64 `json:"nice"`
+	System  float64 `json:"system"`
+	Idle    float64 `json:"idle"`
+	IOWait  float64 `json:"iowait"`
+	IRQ     float64 `json:"irq"`
+	SoftIRQ float64 `json:"softirq"`
+	Steal   float64 `json:"steal"`
+}
+
+// Pressure is the share of time tasks stalled waiting on each resource
+type Pressure struct {
+	CPU    PressureStall `json:"cpu"`
+	Memory PressureStall `json:"memory"`
+	IO     PressureStall `json:"io"`
+}
+
+// PressureStall holds the "some" (at least one task stalled) and "full"
+// (every non-idle task stalled) averages of one resource
+type PressureStall struct {
+	Some PressureAverages `json:"some"`
+	Full PressureAverages `json:"full"`
+}
+
+// PressureAverages are stall percentages averaged over 10s, 60s and 300s
+type PressureAverages struct {
+	Avg10  float64 `json:"avg10"`
+	Avg60  float64 `json:"avg60"`
+	Avg300 float64 `json:"avg300"`
+}
+
+// MetricPoint is one step of a metric's history. A raw sample has Count 1
+// and Avg, Min and Max equal; rollups aggregate Count samples.
+type MetricPoint struct {
+	Metric string    `json:"metric,omitempty"`
+	Time   time.Time `json:"time"`
+	Avg    float64   `json:"avg"`
+	Min    float64   `json:"min"`
+	Max    float64   `json:"max"`
+	Count  int       `json:"count"`
+}
+
+// MetricSeries is a metric over a time range at a fixed step. Resolution
+// names the stored tier the points were built from: raw, 1m or 1h.
+type MetricSeries struct {
+	Metric      string        `json:"metric"`
+	From        time.Time     `json:"from"`
+	To          time.Time     `json:"to"`
+	StepSeconds int64         `json:"step_seconds"`
+	Resolution  string        `json:"resolution"`
+	Points      []MetricPoint `json:"points"`
 }
 
 // Widget represents a dashboard widget
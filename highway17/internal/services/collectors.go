@@ -0,0 +1,149 @@
+package services
+
+import (
+	"context"
+
+	"citadel/highway17/internal/models"
+
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/host"
+	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+const gib = 1024 * 1024 * 1024
+
+// DefaultCollectors are the collectors every sampler starts with
+func DefaultCollectors() []Collector {
+	return []Collector{
+		NewCPUCollector(DefaultProcRoot),
+		NewLoadCollector(DefaultProcRoot),
+		NewPressureCollector(DefaultProcRoot),
+		NewCollector("memory", collectMemory),
+		NewCollector("disk", collectDisk),
+		NewCollector("uptime", collectUptime),
+		NewCollector("processes", collectProcesses),
+	}
+}
+
+// NewCollector wraps a stateless collect function as a Collector
+func NewCollector(name string, collect func(ctx context.Context, stats *models.SystemStats) error) Collector {
+	return collectorFunc{name: name, collect: collect}
+}
+
+type collectorFunc struct {
+	name    string
+	collect func(ctx context.Context, stats *models.SystemStats) error
+}
+
+func (c collectorFunc) Name() string {
+	return c.name
+}
+
+func (c collectorFunc) Collect(ctx context.Context, stats *models.SystemStats) error {
+	return c.collect(ctx, stats)
+}
+
+// CPUCollector reports CPU usage, overall and per core, over the time since
+// its previous sample, from the /proc/stat counters under procRoot. The
+// first sample covers the time since boot.
+type CPUCollector struct {
+	procRoot string
+	prev     map[string]cpuTimes
+}
+
+func NewCPUCollector(procRoot string) *CPUCollector {
+	return &CPUCollector{procRoot: procRoot, prev: make(map[string]cpuTimes)}
+}
+
+func (cc *CPUCollector) Name() string {
+	return "cpu"
+}
+
+func (cc *CPUCollector) Collect(ctx context.Context, stats *models.SystemStats) error {
+	cpus, err := readProcStat(cc.procRoot)
+	if err != nil {
+		return err
+	}
+
+	// Cores are matched by name, since offline cores drop out of the list
+	prev := make(map[string]cpuTimes, len(cpus))
+	for _, t := range cpus {
+		usage := t.usage(cc.prev[t.Name])
+		if t.Name == "cpu" {
+			stats.CPU = usage
+		} else {
+			stats.CPUCores = append(stats.CPUCores, usage)
+		}
+		prev[t.Name] = t
+	}
+	cc.prev = prev
+	stats.CPUPercent = stats.CPU.Busy
+	return nil
+}
+
+// NewLoadCollector reports the load averages from loadavg under procRoot
+func NewLoadCollector(procRoot string) Collector {
+	return NewCollector("load", func(ctx context.Context, stats *models.SystemStats) error {
+		load, err := readLoadAvg(procRoot)
+		if err != nil {
+			return err
+		}
+		stats.LoadAverage = load
+		return nil
+	})
+}
+
+// NewPressureCollector reports pressure stall information from pressure/
+// under procRoot, leaving it nil on kernels without PSI
+func NewPressureCollector(procRoot string) Collector {
+	return NewCollector("pressure", func(ctx context.Context, stats *models.SystemStats) error {
+		pressure, err := readPressure(procRoot)
+		if err != nil {
+			return err
+		}
+		stats.Pressure = pressure
+		return nil
+	})
+}
+
+func collectMemory(ctx context.Context, stats *models.SystemStats) error {
+	vmem, err := mem.VirtualMemoryWithContext(ctx)
+	if err != nil {
+		return err
+	}
+	stats.MemoryPercent = vmem.UsedPercent
+	stats.MemoryUsedGB = float64(vmem.Used) / gib
+	stats.MemoryTotalGB = float64(vmem.Total) / gib
+	return nil
+}
+
+// collectDisk reports the root filesystem
+func collectDisk(ctx context.Context, stats *models.SystemStats) error {
+	usage, err := disk.UsageWithContext(ctx, "/")
+	if err != nil {
+		return err
+	}
+	stats.DiskPercent = usage.UsedPercent
+	stats.DiskUsedGB = float64(usage.Used) / gib
+	stats.DiskTotalGB = float64(usage.Total) / gib
+	return nil
+}
+
+func collectUptime(ctx context.Context, stats *models.SystemStats) error {
+	uptime, err := host.UptimeWithContext(ctx)
+	if err != nil {
+		return err
+	}
+	stats.UptimeSeconds = uptime
+	return nil
+}
+
+func collectProcesses(ctx context.Context, stats *models.SystemStats) error {
+	pids, err := process.PidsWithContext(ctx)
+	if err != nil {
+		return err
+	}
+	stats.ProcessCount = len(pids)
+	return nil
+}
@@ -0,0 +1,234 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"citadel/highway17/internal/models"
+)
+
+// DefaultProcRoot is where procfs is mounted. The parsers below read from
+// any directory laid out like it, so they can be pointed at a copy.
+const DefaultProcRoot = "/proc"
+
+// cpuTimes are the counters of one cpu line of /proc/stat, in clock ticks
+// since boot. Guest time is already counted in user and nice.
+type cpuTimes struct {
+	Name    string
+	User    uint64
+	Nice    uint64
+	System  uint64
+	Idle    uint64
+	IOWait  uint64
+	IRQ     uint64
+	SoftIRQ uint64
+	Steal   uint64
+}
+
+func (t cpuTimes) counters() [8]uint64 {
+	return [8]uint64{t.User, t.Nice, t.System, t.Idle, t.IOWait, t.IRQ, t.SoftIRQ, t.Steal}
+}
+
+// usage splits the ticks between prev and t into percentages. Busy is
+// everything but idle and iowait. Counters that went backwards, as after a
+// CPU comes back online, give an all-zero breakdown.
+func (t cpuTimes) usage(prev cpuTimes) models.CPUTimes {
+	cur, old := t.counters(), prev.counters()
+	var delta [8]float64
+	var total float64
+	for i := range cur {
+		if cur[i] < old[i] {
+			return models.CPUTimes{Name: t.Name}
+		}
+		delta[i] = float64(cur[i] - old[i])
+		total += delta[i]
+	}
+	if total == 0 {
+		return models.CPUTimes{Name: t.Name}
+	}
+
+	pct := func(v float64) float64 { return v / total * 100 }
+	return models.CPUTimes{
+		Name:    t.Name,
+		Busy:    pct(total - delta[3] - delta[4]),
+		User:    pct(delta[0]),
+		Nice:    pct(delta[1]),
+		System:  pct(delta[2]),
+		Idle:    pct(delta[3]),
+		IOWait:  pct(delta[4]),
+		IRQ:     pct(delta[5]),
+		SoftIRQ: pct(delta[6]),
+		Steal:   pct(delta[7]),
+	}
+}
+
+// readProcStat reads the cpu lines of stat under procRoot
+func readProcStat(procRoot string) ([]cpuTimes, error) {
+	f, err := os.Open(filepath.Join(procRoot, "stat"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseProcStat(f)
+}
+
+// parseProcStat parses the cpu lines of /proc/stat: the aggregate "cpu"
+// line first, then one "cpuN" line per online core
+func parseProcStat(r io.Reader) ([]cpuTimes, error) {
+	var cpus []cpuTimes
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], "cpu") {
+			continue
+		}
+		// Kernels before 2.6.11 stop at irq and softirq; steal is optional
+		if len(fields) < 8 {
+			return nil, fmt.Errorf("parse /proc/stat: short %s line", fields[0])
+		}
+		var counters [8]uint64
+		for i := range counters {
+			if i+1 >= len(fields) {
+				break
+			}
+			n, err := strconv.ParseUint(fields[i+1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("parse /proc/stat: %s: %w", fields[0], err)
+			}
+			counters[i] = n
+		}
+		cpus = append(cpus, cpuTimes{
+			Name:    fields[0],
+			User:    counters[0],
+			Nice:    counters[1],
+			System:  counters[2],
+			Idle:    counters[3],
+			IOWait:  counters[4],
+			IRQ:     counters[5],
+			SoftIRQ: counters[6],
+			Steal:   counters[7],
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(cpus) == 0 || cpus[0].Name != "cpu" {
+		return nil, fmt.Errorf("parse /proc/stat: no aggregate cpu line")
+	}
+	return cpus, nil
+}
+
+// readLoadAvg reads loadavg under procRoot
+func readLoadAvg(procRoot string) ([3]float64, error) {
+	f, err := os.Open(filepath.Join(procRoot, "loadavg"))
+	if err != nil {
+		return [3]float64{}, err
+	}
+	defer f.Close()
+	return parseLoadAvg(f)
+}
+
+// parseLoadAvg parses the 1, 5 and 15 minute load averages at the start of
+// /proc/loadavg ("0.52 0.58 0.59 1/389 12345")
+func parseLoadAvg(r io.Reader) ([3]float64, error) {
+	var load [3]float64
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return load, err
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return load, fmt.Errorf("parse /proc/loadavg: expected 3 averages, got %q", line)
+	}
+	for i := range load {
+		if load[i], err = strconv.ParseFloat(fields[i], 64); err != nil {
+			return load, fmt.Errorf("parse /proc/loadavg: %w", err)
+		}
+	}
+	return load, nil
+}
+
+// readPressure reads pressure/cpu, memory and io under procRoot. It
+// returns nil without an error when the kernel has no PSI, either built
+// without it or booted with psi=0.
+func readPressure(procRoot string) (*models.Pressure, error) {
+	pressure := &models.Pressure{}
+	for _, resource := range []struct {
+		name  string
+		stall *models.PressureStall
+	}{
+		{"cpu", &pressure.CPU},
+		{"memory", &pressure.Memory},
+		{"io", &pressure.IO},
+	} {
+		data, err := os.ReadFile(filepath.Join(procRoot, "pressure", resource.name))
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.EOPNOTSUPP) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if *resource.stall, err = parsePressure(bytes.NewReader(data)); err != nil {
+			return nil, fmt.Errorf("parse /proc/pressure/%s: %w", resource.name, err)
+		}
+	}
+	return pressure, nil
+}
+
+// parsePressure parses one /proc/pressure file:
+//
+//	some avg10=0.00 avg60=0.00 avg300=0.00 total=0
+//	full avg10=0.00 avg60=0.00 avg300=0.00 total=0
+//
+// Kernels before 5.13 have no full line for cpu; it is left zero.
+func parsePressure(r io.Reader) (models.PressureStall, error) {
+	var stall models.PressureStall
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		var avgs *models.PressureAverages
+		switch fields[0] {
+		case "some":
+			avgs = &stall.Some
+		case "full":
+			avgs = &stall.Full
+		default:
+			return stall, fmt.Errorf("unexpected line %q", fields[0])
+		}
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				return stall, fmt.Errorf("malformed field %q", field)
+			}
+			var dst *float64
+			switch key {
+			case "avg10":
+				dst = &avgs.Avg10
+			case "avg60":
+				dst = &avgs.Avg60
+			case "avg300":
+				dst = &avgs.Avg300
+			default:
+				continue
+			}
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return stall, fmt.Errorf("%s: %w", key, err)
+			}
+			*dst = v
+		}
+	}
+	return stall, scanner.Err()
+}
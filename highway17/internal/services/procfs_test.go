@@ -0,0 +1,234 @@
+package services
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"citadel/highway17/internal/models"
+)
+
+// The fixtures under testdata/proc are laid out like /proc: modern is a
+// current kernel, legacy has 7-field cpu lines and no full line in
+// pressure/cpu, and nopsi has no pressure directory at all.
+func procRoot(name string) string {
+	return filepath.Join("testdata", "proc", name)
+}
+
+func TestReadProcStat(t *testing.T) {
+	tests := []struct {
+		root  string
+		cores int
+		cpu   cpuTimes
+	}{
+		// Guest and guest_nice are counted in user already and are ignored
+		{"modern", 2, cpuTimes{Name: "cpu", User: 10132153, Nice: 290696, System: 3084719, Idle: 46828483, IOWait: 16683, IRQ: 0, SoftIRQ: 25195, Steal: 1200}},
+		// Pre-steal kernels stop after softirq
+		{"legacy", 2, cpuTimes{Name: "cpu", User: 2255, Nice: 34, System: 2290, Idle: 22625563, IOWait: 6290, IRQ: 127, SoftIRQ: 456}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.root, func(t *testing.T) {
+			cpus, err := readProcStat(procRoot(tt.root))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(cpus) != tt.cores+1 {
+				t.Fatalf("cpu lines = %d, want %d", len(cpus), tt.cores+1)
+			}
+			if cpus[0] != tt.cpu {
+				t.Errorf("cpu = %+v, want %+v", cpus[0], tt.cpu)
+			}
+			for i, c := range cpus[1:] {
+				if want := "cpu" + string(rune('0'+i)); c.Name != want {
+					t.Errorf("core %d = %s, want %s", i, c.Name, want)
+				}
+			}
+		})
+	}
+
+	for name, stat := range map[string]string{
+		"short line":       "cpu  1 2 3 4 5 6\n",
+		"not a number":     "cpu  1 2 3 4 5 6 x\n",
+		"no aggregate":     "cpu0 1 2 3 4 5 6 7\n",
+		"no cpu lines":     "ctxt 115315\n",
+		"aggregate second": "cpu0 1 2 3 4 5 6 7\ncpu  1 2 3 4 5 6 7\n",
+	} {
+		if _, err := parseProcStat(strings.NewReader(stat)); err == nil {
+			t.Errorf("parseProcStat(%s) succeeded", name)
+		}
+	}
+
+	if _, err := readProcStat(t.TempDir()); err == nil {
+		t.Error("readProcStat without a stat file succeeded")
+	}
+}
+
+func TestCPUTimesUsage(t *testing.T) {
+	prev := cpuTimes{Name: "cpu", User: 100, Nice: 10, System: 50, Idle: 1000, IOWait: 20, IRQ: 5, SoftIRQ: 5, Steal: 10}
+
+	tests := []struct {
+		name string
+		cur  cpuTimes
+		want models.CPUTimes
+	}{
+		{
+			name: "every counter moves",
+			cur:  cpuTimes{Name: "cpu", User: 125, Nice: 15, System: 60, Idle: 1040, IOWait: 25, IRQ: 10, SoftIRQ: 10, Steal: 15},
+			want: models.CPUTimes{Name: "cpu", Busy: 55, User: 25, Nice: 5, System: 10, Idle: 40, IOWait: 5, IRQ: 5, SoftIRQ: 5, Steal: 5},
+		},
+		{
+			name: "idle only",
+			cur:  cpuTimes{Name: "cpu", User: 100, Nice: 10, System: 50, Idle: 1100, IOWait: 20, IRQ: 5, SoftIRQ: 5, Steal: 10},
+			want: models.CPUTimes{Name: "cpu", Idle: 100},
+		},
+		{
+			name: "no ticks",
+			cur:  prev,
+			want: models.CPUTimes{Name: "cpu"},
+		},
+		{
+			name: "counters went backwards",
+			cur:  cpuTimes{Name: "cpu", User: 90, Nice: 15, System: 60, Idle: 1040, IOWait: 25, IRQ: 10, SoftIRQ: 10, Steal: 15},
+			want: models.CPUTimes{Name: "cpu"},
+		},
+		{
+			name: "steal went backwards",
+			cur:  cpuTimes{Name: "cpu", User: 130, Nice: 15, System: 60, Idle: 1040, IOWait: 25, IRQ: 10, SoftIRQ: 10, Steal: 0},
+			want: models.CPUTimes{Name: "cpu"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cur.usage(prev); !sameUsage(got, tt.want) {
+				t.Errorf("usage = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// sameUsage compares two breakdowns up to float rounding
+func sameUsage(a, b models.CPUTimes) bool {
+	near := func(x, y float64) bool { return math.Abs(x-y) < 1e-9 }
+	return a.Name == b.Name && near(a.Busy, b.Busy) && near(a.User, b.User) && near(a.Nice, b.Nice) &&
+		near(a.System, b.System) && near(a.Idle, b.Idle) && near(a.IOWait, b.IOWait) &&
+		near(a.IRQ, b.IRQ) && near(a.SoftIRQ, b.SoftIRQ) && near(a.Steal, b.Steal)
+}
+
+func TestCPUCollector(t *testing.T) {
+	root := t.TempDir()
+	writeStat := func(stat string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(root, "stat"), []byte(stat), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cc := NewCPUCollector(root)
+
+	// The first sample covers the time since boot
+	writeStat("cpu  50 0 50 900 0 0 0 0\ncpu0 25 0 25 450 0 0 0 0\ncpu1 25 0 25 450 0 0 0 0\n")
+	stats := &models.SystemStats{}
+	if err := cc.Collect(context.Background(), stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.CPUPercent != 10 || len(stats.CPUCores) != 2 {
+		t.Fatalf("first sample = %v%% over %d cores, want 10%% over 2", stats.CPUPercent, len(stats.CPUCores))
+	}
+
+	// cpu1 went offline and came back with its counters reset
+	writeStat("cpu  150 0 50 1000 0 0 0 0\ncpu0 75 0 25 500 0 0 0 0\ncpu1 5 0 0 10 0 0 0 0\n")
+	stats = &models.SystemStats{}
+	if err := cc.Collect(context.Background(), stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.CPUPercent != 50 {
+		t.Errorf("cpu = %v%%, want 50%%", stats.CPUPercent)
+	}
+	if got := stats.CPUCores[0]; got.Name != "cpu0" || got.Busy != 50 {
+		t.Errorf("cpu0 = %+v, want 50%% busy", got)
+	}
+	if got := stats.CPUCores[1]; got != (models.CPUTimes{Name: "cpu1"}) {
+		t.Errorf("cpu1 = %+v, want an all-zero breakdown", got)
+	}
+
+	// The reset counters are the baseline for the next sample
+	writeStat("cpu  250 0 50 1100 0 0 0 0\ncpu0 125 0 25 550 0 0 0 0\ncpu1 55 0 0 60 0 0 0 0\n")
+	stats = &models.SystemStats{}
+	if err := cc.Collect(context.Background(), stats); err != nil {
+		t.Fatal(err)
+	}
+	if got := stats.CPUCores[1]; got.Busy != 50 {
+		t.Errorf("cpu1 after reset = %+v, want 50%% busy", got)
+	}
+}
+
+func TestReadLoadAvg(t *testing.T) {
+	load, err := readLoadAvg(procRoot("modern"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if load != [3]float64{0.52, 0.58, 0.59} {
+		t.Errorf("load = %v", load)
+	}
+
+	for _, loadavg := range []string{"", "0.52 0.58\n", "0.52 high 0.59 2/389 12345\n"} {
+		if _, err := parseLoadAvg(strings.NewReader(loadavg)); err == nil {
+			t.Errorf("parseLoadAvg(%q) succeeded", loadavg)
+		}
+	}
+}
+
+func TestReadPressure(t *testing.T) {
+	memory := models.PressureStall{
+		Some: models.PressureAverages{Avg10: 0.25, Avg60: 0.10, Avg300: 0.02},
+		Full: models.PressureAverages{Avg10: 0.12, Avg60: 0.05, Avg300: 0.01},
+	}
+	io := models.PressureStall{
+		Some: models.PressureAverages{Avg10: 4.20, Avg60: 2.75, Avg300: 1.10},
+		Full: models.PressureAverages{Avg10: 3.10, Avg60: 2.00, Avg300: 0.80},
+	}
+
+	tests := []struct {
+		root string
+		want *models.Pressure
+	}{
+		{"modern", &models.Pressure{
+			CPU:    models.PressureStall{Some: models.PressureAverages{Avg10: 1.53, Avg60: 0.87, Avg300: 0.35}},
+			Memory: memory,
+			IO:     io,
+		}},
+		// No full line in pressure/cpu before 5.13
+		{"legacy", &models.Pressure{
+			CPU:    models.PressureStall{Some: models.PressureAverages{Avg10: 2.04, Avg60: 0.75, Avg300: 0.40}},
+			Memory: memory,
+			IO:     io,
+		}},
+		{"nopsi", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.root, func(t *testing.T) {
+			got, err := readPressure(procRoot(tt.root))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+				t.Errorf("pressure = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	for _, psi := range []string{
+		"none avg10=0.00 avg60=0.00 avg300=0.00 total=0\n",
+		"some avg10\n",
+		"some avg10=high avg60=0.00 avg300=0.00 total=0\n",
+	} {
+		if _, err := parsePressure(strings.NewReader(psi)); err == nil {
+			t.Errorf("parsePressure(%q) succeeded", psi)
+		}
+	}
+}
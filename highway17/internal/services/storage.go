@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"citadel/highway17/internal/models"
+
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// DefaultUUIDDir holds a symlink per filesystem UUID to its device
+const DefaultUUIDDir = "/dev/disk/by-uuid"
+
+// MountSpec is a mount point to report and the usage percentage, of space
+// or inodes, at which it is flagged
+type MountSpec struct {
+	Path        string
+	WarnPercent float64
+}
+
+// ParseMountSpecs parses "path" and "path=percent" entries; paths without a
+// percentage warn at warnPercent
+func ParseMountSpecs(entries []string, warnPercent float64) ([]MountSpec, error) {
+	specs := make([]MountSpec, 0, len(entries))
+	for _, entry := range entries {
+		path, pct, hasPct := strings.Cut(entry, "=")
+		path = filepath.Clean(strings.TrimSpace(path))
+		if !filepath.IsAbs(path) {
+			return nil, fmt.Errorf("entry %q: mount path must be absolute", entry)
+		}
+		spec := MountSpec{Path: path, WarnPercent: warnPercent}
+		if hasPct {
+			v, err := strconv.ParseFloat(strings.TrimSpace(pct), 64)
+			if err != nil || !(v >= 1 && v <= 100) {
+				return nil, fmt.Errorf("entry %q: want path=percent with a percent from 1 to 100", entry)
+			}
+			spec.WarnPercent = v
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// warns reports whether space or inode usage has reached the threshold
+func (spec MountSpec) warns(usedPercent, inodesPercent float64) bool {
+	return usedPercent >= spec.WarnPercent || inodesPercent >= spec.WarnPercent
+}
+
+// StorageCollector reports the usage of each mount in its list or, with an
+// empty list, of every mounted block device
+type StorageCollector struct {
+	mounts      []MountSpec
+	warnPercent float64
+	uuidDir     string
+}
+
+func NewStorageCollector(mounts []MountSpec, warnPercent float64) *StorageCollector {
+	return &StorageCollector{mounts: mounts, warnPercent: warnPercent, uuidDir: DefaultUUIDDir}
+}
+
+func (sc *StorageCollector) Name() string {
+	return "storage"
+}
+
+func (sc *StorageCollector) Collect(ctx context.Context, stats *models.SystemStats) error {
+	partitions, err := disk.PartitionsWithContext(ctx, false)
+	if err != nil {
+		return err
+	}
+	byPath := make(map[string]disk.PartitionStat, len(partitions))
+	for _, p := range partitions {
+		byPath[p.Mountpoint] = p
+	}
+
+	mounts := sc.mounts
+	if len(mounts) == 0 {
+		mounts = sc.discover(partitions)
+	}
+
+	uuids := readUUIDs(sc.uuidDir)
+	for _, spec := range mounts {
+		mount := models.MountStats{Path: spec.Path, WarnPercent: spec.WarnPercent}
+
+		// A path that is not a mount point would report whatever filesystem
+		// it sits on, which for an unmounted drive is the OS disk
+		p, ok := byPath[spec.Path]
+		if !ok {
+			mount.Error = "not mounted"
+			mount.Warning = true
+			stats.Mounts = append(stats.Mounts, mount)
+			continue
+		}
+		mount.Device = p.Device
+		mount.FSType = p.Fstype
+		mount.UUID = uuids[resolveDevice(p.Device)]
+
+		usage, err := disk.UsageWithContext(ctx, spec.Path)
+		if err != nil {
+			mount.Error = err.Error()
+			mount.Warning = true
+			stats.Mounts = append(stats.Mounts, mount)
+			continue
+		}
+		mount.TotalGB = float64(usage.Total) / gib
+		mount.UsedGB = float64(usage.Used) / gib
+		mount.FreeGB = float64(usage.Free) / gib
+		mount.UsedPercent = usage.UsedPercent
+		mount.InodesTotal = usage.InodesTotal
+		mount.InodesUsed = usage.InodesUsed
+		mount.InodesFree = usage.InodesFree
+		mount.InodesPercent = usage.InodesUsedPercent
+		mount.Warning = spec.warns(mount.UsedPercent, mount.InodesPercent)
+		stats.Mounts = append(stats.Mounts, mount)
+	}
+	return nil
+}
+
+// discover lists each mounted device once, at its shortest mount point:
+// bind mounts, such as the files Docker mounts into a container, show the
+// same device again further down the tree. The root filesystem's device is
+// left out; the disk gauge already covers it.
+func (sc *StorageCollector) discover(partitions []disk.PartitionStat) []MountSpec {
+	var rootDevice string
+	for _, p := range partitions {
+		if p.Mountpoint == "/" {
+			rootDevice = p.Device
+		}
+	}
+
+	shortest := make(map[string]string)
+	for _, p := range partitions {
+		if p.Device == rootDevice {
+			continue
+		}
+		if cur, ok := shortest[p.Device]; !ok || len(p.Mountpoint) < len(cur) {
+			shortest[p.Device] = p.Mountpoint
+		}
+	}
+
+	specs := make([]MountSpec, 0, len(shortest))
+	for _, path := range shortest {
+		specs = append(specs, MountSpec{Path: path, WarnPercent: sc.warnPercent})
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Path < specs[j].Path })
+	return specs
+}
+
+// readUUIDs maps each device under dir's symlinks to its filesystem UUID.
+// The directory is missing inside most containers; no UUIDs are reported
+// then.
+func readUUIDs(dir string) map[string]string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	uuids := make(map[string]string, len(entries))
+	for _, e := range entries {
+		device, err := filepath.EvalSymlinks(filepath.Join(dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		uuids[device] = e.Name()
+	}
+	return uuids
+}
+
+// resolveDevice follows symlinks such as /dev/mapper/* to the device node
+func resolveDevice(device string) string {
+	if resolved, err := filepath.EvalSymlinks(device); err == nil {
+		return resolved
+	}
+	return device
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMountSpecs(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		want    []MountSpec
+		wantErr bool
+	}{
+		{name: "none", entries: nil, want: []MountSpec{}},
+		{name: "default applied", entries: []string{"/mnt/media"}, want: []MountSpec{{"/mnt/media", 90}}},
+		{name: "own percent", entries: []string{"/mnt/media=85"}, want: []MountSpec{{"/mnt/media", 85}}},
+		{name: "mixed", entries: []string{"/srv/backup=97.5", "/mnt/media"}, want: []MountSpec{{"/srv/backup", 97.5}, {"/mnt/media", 90}}},
+		{name: "spaces and trailing slash", entries: []string{" /mnt/media/ = 80 "}, want: []MountSpec{{"/mnt/media", 80}}},
+		{name: "bounds", entries: []string{"/a=1", "/b=100"}, want: []MountSpec{{"/a", 1}, {"/b", 100}}},
+
+		{name: "relative path", entries: []string{"mnt/media"}, wantErr: true},
+		{name: "dot path", entries: []string{"./media=80"}, wantErr: true},
+		{name: "empty path", entries: []string{"=80"}, wantErr: true},
+		{name: "percent above 100", entries: []string{"/mnt/media=101"}, wantErr: true},
+		{name: "percent below 1", entries: []string{"/mnt/media=0.5"}, wantErr: true},
+		{name: "negative percent", entries: []string{"/mnt/media=-10"}, wantErr: true},
+		{name: "not a number", entries: []string{"/mnt/media=high"}, wantErr: true},
+		{name: "NaN", entries: []string{"/mnt/media=NaN"}, wantErr: true},
+		{name: "empty percent", entries: []string{"/mnt/media="}, wantErr: true},
+		{name: "percent sign", entries: []string{"/mnt/media=85%"}, wantErr: true},
+		{name: "one bad entry", entries: []string{"/mnt/media", "backup=80"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMountSpecs(tt.entries, 90)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseMountSpecs(%q) = %v, want an error", tt.entries, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseMountSpecs(%q) = %v, want %v", tt.entries, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMountSpecWarns(t *testing.T) {
+	spec := MountSpec{Path: "/mnt/media", WarnPercent: 90}
+
+	tests := []struct {
+		name         string
+		used, inodes float64
+		wantWarning  bool
+	}{
+		{"both below", 89.9, 50, false},
+		{"space at the threshold", 90, 50, true},
+		{"space above", 99, 0, true},
+		{"inodes at the threshold", 40, 90, true},
+		{"inodes above", 40, 100, true},
+		{"both above", 95, 95, true},
+		// Filesystems without inodes (vfat, some FUSE mounts) report zero
+		{"no inodes", 10, 0, false},
+		{"empty", 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		if got := spec.warns(tt.used, tt.inodes); got != tt.wantWarning {
+			t.Errorf("%s: warns(%v, %v) = %v, want %v", tt.name, tt.used, tt.inodes, got, tt.wantWarning)
+		}
+	}
+
+	// A mount's own threshold replaces the default
+	strict := MountSpec{Path: "/srv/backup", WarnPercent: 70}
+	if !strict.warns(75, 0) || strict.warns(69.9, 69.9) {
+		t.Error("warns ignored a 70% threshold")
+	}
+}
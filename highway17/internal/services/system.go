@@ -2,109 +2,114 @@ package services
 
 import (
 	"context"
+	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"citadel/highway17/internal/models"
 
-	"github.com/shirou/gopsutil/v3/cpu"
-	"github.com/shirou/gopsutil/v3/disk"
-	"github.com/shirou/gopsutil/v3/host"
-	"github.com/shirou/gopsutil/v3/mem"
-	"github.com/shirou/gopsutil/v3/process"
 	"go.uber.org/zap"
 )
 
-type SystemStatsService struct {
-	log        *zap.Logger
-	mu         sync.RWMutex
-	cached     *models.SystemStats
-	cacheTTL   time.Duration
-	lastUpdate time.Time
-}
+// defaultStatsInterval is used when the configured poll interval is not positive
+const defaultStatsInterval = 5 * time.Second
 
-func NewSystemStatsService(log *zap.Logger, cacheTTL time.Duration) *SystemStatsService {
-	return &SystemStatsService{
-		log:      log,
-		cacheTTL: cacheTTL,
-	}
+// ErrNoStats is returned by GetStats before the first sample is taken
+var ErrNoStats = errors.New("system stats have not been sampled yet")
+
+// Collector fills in part of a stats snapshot. Collect is only ever called
+// from the sampler goroutine, one collector at a time, so a collector may
+// keep state between samples (the CPU collector keeps the previous
+// /proc/stat counters to compute deltas).
+type Collector interface {
+	Name() string
+	Collect(ctx context.Context, stats *models.SystemStats) error
 }
 
-// GetStats fetches current system statistics
-func (ss *SystemStatsService) GetStats(ctx context.Context) (*models.SystemStats, error) {
-	ss.mu.RLock()
-	if ss.cached != nil && time.Since(ss.lastUpdate) < ss.cacheTTL {
-		defer ss.mu.RUnlock()
-		return ss.cached, nil
-	}
-	ss.mu.RUnlock()
+// SystemStatsService samples the system in the background and publishes
+// each sample as an immutable snapshot
+type SystemStatsService struct {
+	log      *zap.Logger
+	interval time.Duration
 
-	stats := &models.SystemStats{
-		LastUpdated: time.Now(),
-	}
+	mu         sync.Mutex
+	collectors []Collector
 
-	// CPU usage (per-core average)
-	cpuPercents, err := cpu.PercentWithContext(ctx, 1*time.Second, false)
-	if err != nil {
-		ss.log.Sugar().Warnw("failed to get CPU percentage", "error", err)
-	} else if len(cpuPercents) > 0 {
-		stats.CPUPercent = cpuPercents[0]
-	}
+	latest atomic.Pointer[models.SystemStats]
+}
 
-	// Memory usage
-	vmem, err := mem.VirtualMemoryWithContext(ctx)
-	if err != nil {
-		ss.log.Sugar().Warnw("failed to get memory info", "error", err)
-	} else {
-		stats.MemoryPercent = vmem.UsedPercent
-		stats.MemoryUsedGB = float64(vmem.Used) / (1024 * 1024 * 1024)
-		stats.MemoryTotalGB = float64(vmem.Total) / (1024 * 1024 * 1024)
+// NewSystemStatsService creates a sampler that runs the default collectors
+// every interval once started
+func NewSystemStatsService(log *zap.Logger, interval time.Duration) *SystemStatsService {
+	if interval <= 0 {
+		interval = defaultStatsInterval
 	}
-
-	// Disk usage (root partition)
-	diskUsage, err := disk.UsageWithContext(ctx, "/")
-	if err != nil {
-		ss.log.Sugar().Warnw("failed to get disk info", "error", err)
-	} else {
-		stats.DiskPercent = diskUsage.UsedPercent
-		stats.DiskUsedGB = float64(diskUsage.Used) / (1024 * 1024 * 1024)
-		stats.DiskTotalGB = float64(diskUsage.Total) / (1024 * 1024 * 1024)
+	ss := &SystemStatsService{
+		log:      log,
+		interval: interval,
 	}
-
-	// Uptime
-	uptime, err := host.UptimeWithContext(ctx)
-	if err != nil {
-		ss.log.Sugar().Warnw("failed to get uptime", "error", err)
-	} else {
-		stats.UptimeSeconds = uptime
+	for _, c := range DefaultCollectors() {
+		ss.Register(c)
 	}
+	return ss
+}
 
-	// Process count
-	processes, err := process.ProcessesWithContext(ctx)
-	if err != nil {
-		ss.log.Sugar().Warnw("failed to get process count", "error", err)
-	} else {
-		stats.ProcessCount = len(processes)
-	}
+// Register adds a collector; it runs after those already registered, from
+// the next sample on
+func (ss *SystemStatsService) Register(c Collector) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.collectors = append(ss.collectors, c)
+}
+
+// Start takes the first sample, so GetStats has a snapshot as soon as it
+// returns, then samples every interval in the background until ctx is
+// cancelled
+func (ss *SystemStatsService) Start(ctx context.Context) {
+	ss.sample(ctx)
+	go ss.run(ctx)
+}
 
-	// Load average (platform specific - only works on Unix-like systems)
-	// This is handled by host.SensorsTemperaturesWithContext but we'll skip for now
-	stats.LoadAverage[0] = 0
-	stats.LoadAverage[1] = 0
-	stats.LoadAverage[2] = 0
+func (ss *SystemStatsService) run(ctx context.Context) {
+	ticker := time.NewTicker(ss.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ss.sample(ctx)
+		}
+	}
+}
 
-	// Cache the result
+// sample runs every collector into a new snapshot and publishes it. A
+// collector that fails leaves its fields zero without holding up the rest.
+func (ss *SystemStatsService) sample(ctx context.Context) *models.SystemStats {
 	ss.mu.Lock()
-	ss.cached = stats
-	ss.lastUpdate = time.Now()
+	collectors := ss.collectors
 	ss.mu.Unlock()
 
-	return stats, nil
+	stats := &models.SystemStats{}
+	for _, c := range collectors {
+		if err := c.Collect(ctx, stats); err != nil {
+			ss.log.Sugar().Warnw("failed to collect system stats", "collector", c.Name(), "error", err)
+		}
+	}
+	stats.LastUpdated = time.Now()
+
+	ss.latest.Store(stats)
+	return stats
 }
 
-// ClearCache clears the system stats cache
-func (ss *SystemStatsService) ClearCache() {
-	ss.mu.Lock()
-	ss.cached = nil
-	ss.mu.Unlock()
+// GetStats returns the latest snapshot without blocking. The snapshot is
+// shared between callers and must not be modified.
+func (ss *SystemStatsService) GetStats(ctx context.Context) (*models.SystemStats, error) {
+	stats := ss.latest.Load()
+	if stats == nil {
+		return nil, ErrNoStats
+	}
+	return stats, nil
 }
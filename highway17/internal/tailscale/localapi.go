@@ -0,0 +1,130 @@
+package tailscale
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"time"
+)
+
+// DefaultSocketPath is where tailscaled listens for LocalAPI requests on Linux
+const DefaultSocketPath = "/var/run/tailscale/tailscaled.sock"
+
+// ErrNotTailnetPeer is returned when tailscaled does not know the address
+var ErrNotTailnetPeer = errors.New("address is not a tailnet peer")
+
+var tailnetPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("fd7a:115c:a1e0::/48"),
+}
+
+// WhoIsResponse is the subset of the LocalAPI whois reply we care about
+type WhoIsResponse struct {
+	Node        *Node        `json:"Node"`
+	UserProfile *UserProfile `json:"UserProfile"`
+}
+
+// Node describes the tailnet machine a connection came from
+type Node struct {
+	ID           int64    `json:"ID"`
+	StableID     string   `json:"StableID"`
+	Name         string   `json:"Name"`
+	ComputedName string   `json:"ComputedName"`
+	Addresses    []string `json:"Addresses"`
+	Tags         []string `json:"Tags"`
+}
+
+// UserProfile describes the tailnet user that owns a node
+type UserProfile struct {
+	ID          int64  `json:"ID"`
+	LoginName   string `json:"LoginName"`
+	DisplayName string `json:"DisplayName"`
+}
+
+// IsTagged reports whether the node is owned by tags rather than a user
+func (w *WhoIsResponse) IsTagged() bool {
+	return w.Node != nil && len(w.Node.Tags) > 0
+}
+
+// LoginName returns the owning user's login, or "" for tagged nodes
+func (w *WhoIsResponse) LoginName() string {
+	if w.IsTagged() || w.UserProfile == nil {
+		return ""
+	}
+	return w.UserProfile.LoginName
+}
+
+// Client talks to the local tailscaled over its unix socket
+type Client struct {
+	socketPath string
+	httpClient *http.Client
+}
+
+func NewClient(socketPath string) *Client {
+	if socketPath == "" {
+		socketPath = DefaultSocketPath
+	}
+
+	return &Client{
+		socketPath: socketPath,
+		httpClient: &http.Client{
+			Timeout: 5 * time.Second,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socketPath)
+				},
+			},
+		},
+	}
+}
+
+// WhoIs asks tailscaled which node and user own a remote address (ip or ip:port)
+func (c *Client) WhoIs(ctx context.Context, addr string) (*WhoIsResponse, error) {
+	// The host is ignored by the unix dialer but tailscaled checks it
+	reqURL := "http://local-tailscaled.sock/localapi/v0/whois?addr=" + url.QueryEscape(addr)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Sec-Tailscale", "localapi")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tailscaled: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotTailnetPeer
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
+	}
+
+	var whois WhoIsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&whois); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &whois, nil
+}
+
+// IsTailnetIP reports whether an address falls inside the Tailscale CGNAT or ULA ranges
+func IsTailnetIP(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, prefix := range tailnetPrefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package tailscale
+
+import (
+	"slices"
+	"strings"
+)
+
+// Policy decides which tailnet identities may log in automatically.
+// With both lists empty every tailnet peer that maps to a user is allowed.
+type Policy struct {
+	AllowedUsers []string
+	AllowedTags  []string
+}
+
+// Allows reports whether a WhoIs identity passes the policy
+func (p Policy) Allows(w *WhoIsResponse) bool {
+	if w == nil || w.Node == nil {
+		return false
+	}
+
+	if len(p.AllowedUsers) == 0 && len(p.AllowedTags) == 0 {
+		return true
+	}
+
+	if w.IsTagged() {
+		for _, tag := range w.Node.Tags {
+			if slices.Contains(p.AllowedTags, tag) {
+				return true
+			}
+		}
+		return false
+	}
+
+	login := w.LoginName()
+	for _, allowed := range p.AllowedUsers {
+		if strings.EqualFold(allowed, login) {
+			return true
+		}
+	}
+	return false
+}
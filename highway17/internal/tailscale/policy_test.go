@@ -0,0 +1,82 @@
+package tailscale_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"citadel/highway17/internal/tailscale"
+	"citadel/highway17/internal/tailscale/tailscaletest"
+)
+
+func newTestServer(t *testing.T) *tailscaletest.Server {
+	t.Helper()
+
+	srv, err := tailscaletest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+
+	srv.AddUserPeer("100.64.0.1", "laptop", "Alyx@github")
+	srv.AddTaggedPeer("100.64.0.2", "nas", "tag:server", "tag:storage")
+	srv.AddTaggedPeer("100.64.0.3", "printer", "tag:iot")
+	return srv
+}
+
+func TestPolicyAllows(t *testing.T) {
+	ctx := context.Background()
+	client := tailscale.NewClient(newTestServer(t).SocketPath)
+
+	open := tailscale.Policy{}
+	users := tailscale.Policy{AllowedUsers: []string{"alyx@github"}}
+	tags := tailscale.Policy{AllowedTags: []string{"tag:storage"}}
+	both := tailscale.Policy{AllowedUsers: []string{"barney@github"}, AllowedTags: []string{"tag:server"}}
+
+	tests := []struct {
+		name   string
+		addr   string
+		policy tailscale.Policy
+		login  string
+		want   bool
+	}{
+		{"user node, open policy", "100.64.0.1:443", open, "Alyx@github", true},
+		{"user node, login ignores case", "100.64.0.1:443", users, "Alyx@github", true},
+		{"user node, tags only", "100.64.0.1:443", tags, "Alyx@github", false},
+		{"user node, other user", "100.64.0.1:443", both, "Alyx@github", false},
+		{"tagged node, open policy", "100.64.0.2:443", open, "", true},
+		{"tagged node, any tag matches", "100.64.0.2:443", tags, "", true},
+		{"tagged node, users only", "100.64.0.2:443", users, "", false},
+		{"tagged node, other tag", "100.64.0.3:443", both, "", false},
+		{"tagged node, tag matches", "100.64.0.2:443", both, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			whois, err := client.WhoIs(ctx, tt.addr)
+			if err != nil {
+				t.Fatalf("WhoIs: %v", err)
+			}
+			// Tagged nodes never carry the owner's login, even though
+			// tailscaled reports the "tagged-devices" profile
+			if got := whois.LoginName(); got != tt.login {
+				t.Errorf("LoginName = %q, want %q", got, tt.login)
+			}
+			if got := tt.policy.Allows(whois); got != tt.want {
+				t.Errorf("Allows = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if (tailscale.Policy{}).Allows(nil) || (tailscale.Policy{}).Allows(&tailscale.WhoIsResponse{}) {
+		t.Error("Allows accepted an identity without a node")
+	}
+}
+
+func TestWhoIsUnknownPeer(t *testing.T) {
+	client := tailscale.NewClient(newTestServer(t).SocketPath)
+
+	if _, err := client.WhoIs(context.Background(), "100.64.0.99:443"); !errors.Is(err, tailscale.ErrNotTailnetPeer) {
+		t.Fatalf("WhoIs(unknown) error = %v, want ErrNotTailnetPeer", err)
+	}
+}
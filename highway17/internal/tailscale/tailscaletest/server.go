@@ -0,0 +1,109 @@
+// Package tailscaletest provides a fake tailscaled LocalAPI listening on a
+// unix socket, so Tailscale login can be exercised without a real tailnet.
+package tailscaletest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"citadel/highway17/internal/tailscale"
+)
+
+type Server struct {
+	// SocketPath is the unix socket to pass to tailscale.NewClient
+	SocketPath string
+
+	dir      string
+	listener net.Listener
+	srv      *http.Server
+
+	mu    sync.RWMutex
+	peers map[string]*tailscale.WhoIsResponse
+}
+
+// NewServer starts a fake LocalAPI on a socket in a fresh temp directory
+func NewServer() (*Server, error) {
+	dir, err := os.MkdirTemp("", "tailscaletest")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create socket dir: %w", err)
+	}
+
+	socketPath := filepath.Join(dir, "tailscaled.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to listen on %s: %w", socketPath, err)
+	}
+
+	s := &Server{
+		SocketPath: socketPath,
+		dir:        dir,
+		listener:   listener,
+		peers:      make(map[string]*tailscale.WhoIsResponse),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/localapi/v0/whois", s.handleWhoIs)
+	s.srv = &http.Server{Handler: mux}
+
+	go s.srv.Serve(listener)
+
+	return s, nil
+}
+
+// AddPeer registers the identity returned for an IP address
+func (s *Server) AddPeer(ip string, whois *tailscale.WhoIsResponse) {
+	s.mu.Lock()
+	s.peers[ip] = whois
+	s.mu.Unlock()
+}
+
+// AddUserPeer registers a user-owned node for an IP address
+func (s *Server) AddUserPeer(ip, nodeName, loginName string) {
+	s.AddPeer(ip, &tailscale.WhoIsResponse{
+		Node:        &tailscale.Node{Name: nodeName, ComputedName: nodeName, Addresses: []string{ip + "/32"}},
+		UserProfile: &tailscale.UserProfile{LoginName: loginName, DisplayName: loginName},
+	})
+}
+
+// AddTaggedPeer registers a tag-owned node for an IP address
+func (s *Server) AddTaggedPeer(ip, nodeName string, tags ...string) {
+	s.AddPeer(ip, &tailscale.WhoIsResponse{
+		Node:        &tailscale.Node{Name: nodeName, ComputedName: nodeName, Addresses: []string{ip + "/32"}, Tags: tags},
+		UserProfile: &tailscale.UserProfile{LoginName: "tagged-devices", DisplayName: "Tagged Devices"},
+	})
+}
+
+// Close stops the server and removes the socket directory
+func (s *Server) Close() error {
+	err := s.srv.Close()
+	os.RemoveAll(s.dir)
+	return err
+}
+
+func (s *Server) handleWhoIs(w http.ResponseWriter, r *http.Request) {
+	addr := r.URL.Query().Get("addr")
+	ip := addr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		ip = host
+	}
+	ip = strings.Trim(ip, "[]")
+
+	s.mu.RLock()
+	whois, ok := s.peers[ip]
+	s.mu.RUnlock()
+
+	if !ok {
+		http.Error(w, "no match for IP:port", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(whois)
+}
@@ -1,5 +1,7 @@
 -- Migration 001: Initial Schema (Run on application startup)
 
+-- migrate:up
+
 -- Users table
 CREATE TABLE IF NOT EXISTS users (
     id SERIAL PRIMARY KEY,
@@ -45,3 +47,10 @@ CREATE INDEX IF NOT EXISTS idx_sessions_token ON sessions(token);
 CREATE INDEX IF NOT EXISTS idx_widget_data_user_id ON widget_data(user_id);
 CREATE INDEX IF NOT EXISTS idx_widget_data_name ON widget_data(widget_name);
 CREATE INDEX IF NOT EXISTS idx_settings_user_id ON settings(user_id);
+
+-- migrate:down
+
+DROP TABLE IF EXISTS settings;
+DROP TABLE IF EXISTS widget_data;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS users;
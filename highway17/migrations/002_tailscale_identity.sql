@@ -0,0 +1,15 @@
+-- Migration 002: Tailscale identity login
+
+-- migrate:up
+
+-- Tailnet login name (e.g. user@github) bound to a dashboard user
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tailscale_login VARCHAR(255);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_tailscale_login ON users(LOWER(tailscale_login));
+CREATE INDEX IF NOT EXISTS idx_users_tailscale_ip ON users(tailscale_ip);
+
+-- migrate:down
+
+DROP INDEX IF EXISTS idx_users_tailscale_ip;
+DROP INDEX IF EXISTS idx_users_tailscale_login;
+ALTER TABLE users DROP COLUMN IF EXISTS tailscale_login;
@@ -0,0 +1,20 @@
+-- Migration 003: Login brute-force protection
+
+-- migrate:up
+
+-- Failed login tracking, one row per username and per source IP
+CREATE TABLE IF NOT EXISTS login_lockouts (
+    id SERIAL PRIMARY KEY,
+    scope VARCHAR(20) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    locked_until TIMESTAMP,
+    UNIQUE(scope, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_lockouts_locked_until ON login_lockouts(locked_until);
+
+-- migrate:down
+
+DROP TABLE IF EXISTS login_lockouts;
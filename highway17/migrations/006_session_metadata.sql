@@ -0,0 +1,18 @@
+-- Migration 006: Session metadata and sliding expiry
+
+-- migrate:up
+
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS absolute_expires_at TIMESTAMP;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS ip_address VARCHAR(64);
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS user_agent VARCHAR(512);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions(expires_at);
+
+-- migrate:down
+
+DROP INDEX IF EXISTS idx_sessions_expires_at;
+ALTER TABLE sessions DROP COLUMN IF EXISTS user_agent;
+ALTER TABLE sessions DROP COLUMN IF EXISTS ip_address;
+ALTER TABLE sessions DROP COLUMN IF EXISTS absolute_expires_at;
+ALTER TABLE sessions DROP COLUMN IF EXISTS last_seen_at;
@@ -0,0 +1,14 @@
+-- Migration 007: Role-based access control
+
+-- migrate:up
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'viewer';
+
+-- Existing installs: promote the oldest account so someone can manage roles
+UPDATE users SET role = 'admin'
+WHERE id = (SELECT MIN(id) FROM users)
+  AND NOT EXISTS (SELECT 1 FROM users WHERE role = 'admin');
+
+-- migrate:down
+
+ALTER TABLE users DROP COLUMN IF EXISTS role;
@@ -0,0 +1,27 @@
+-- Migration 010: First-run setup and forced password changes
+
+-- migrate:up
+
+-- Install-wide flags that are not tied to a user
+CREATE TABLE IF NOT EXISTS system_state (
+    key VARCHAR(100) PRIMARY KEY,
+    value TEXT NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Installs that already have accounts never see the setup wizard
+INSERT INTO system_state (key, value)
+SELECT 'setup_completed', 'true' WHERE EXISTS (SELECT 1 FROM users)
+ON CONFLICT (key) DO NOTHING;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS must_change_password BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Before setup existed any username signed in with the shared LOGIN_PASSWORD
+-- and got a row hashed from it, so every password account an upgrade finds
+-- may still hold that public password
+UPDATE users SET must_change_password = TRUE WHERE password_hash IS NOT NULL;
+
+-- migrate:down
+
+ALTER TABLE users DROP COLUMN IF EXISTS must_change_password;
+DROP TABLE IF EXISTS system_state;
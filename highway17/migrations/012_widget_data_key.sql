@@ -0,0 +1,17 @@
+-- Migration 012: Unique widget data keys
+
+-- migrate:up
+
+-- SaveWidgetData upserts on (user_id, widget_name, widget_key), which needs
+-- a unique index; keep the newest row of any duplicates
+DELETE FROM widget_data a USING widget_data b
+WHERE a.user_id = b.user_id
+  AND a.widget_name = b.widget_name
+  AND a.widget_key = b.widget_key
+  AND a.id < b.id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_widget_data_key ON widget_data(user_id, widget_name, widget_key);
+
+-- migrate:down
+
+DROP INDEX IF EXISTS idx_widget_data_key;
@@ -0,0 +1,33 @@
+-- Migration 013: System metrics history
+
+-- migrate:up
+
+-- Raw samples, one row per metric per poll, kept for a day
+CREATE TABLE IF NOT EXISTS metric_samples (
+    metric VARCHAR(100) NOT NULL,
+    sampled_at TIMESTAMP NOT NULL,
+    value DOUBLE PRECISION NOT NULL,
+    PRIMARY KEY (metric, sampled_at)
+);
+
+CREATE INDEX IF NOT EXISTS idx_metric_samples_sampled_at ON metric_samples(sampled_at);
+
+-- Downsampled history: resolution is the bucket width in seconds (60 or
+-- 3600) and bucket its start, in UTC
+CREATE TABLE IF NOT EXISTS metric_rollups (
+    resolution INTEGER NOT NULL,
+    metric VARCHAR(100) NOT NULL,
+    bucket TIMESTAMP NOT NULL,
+    avg_value DOUBLE PRECISION NOT NULL,
+    min_value DOUBLE PRECISION NOT NULL,
+    max_value DOUBLE PRECISION NOT NULL,
+    sample_count INTEGER NOT NULL,
+    PRIMARY KEY (resolution, metric, bucket)
+);
+
+CREATE INDEX IF NOT EXISTS idx_metric_rollups_bucket ON metric_rollups(resolution, bucket);
+
+-- migrate:down
+
+DROP TABLE IF EXISTS metric_rollups;
+DROP TABLE IF EXISTS metric_samples;
@@ -0,0 +1,20 @@
+-- Migration 014: Record which backend created each account
+
+-- migrate:up
+
+-- Directory logins only adopt rows their own backend created, so an LDAP
+-- entry cannot take over a local, Tailscale or OIDC account of the same name
+ALTER TABLE users ADD COLUMN IF NOT EXISTS auth_backend VARCHAR(20) NOT NULL DEFAULT 'local';
+
+-- Existing rows are classified by the identity they carry. Password-less
+-- rows with no tailnet or OIDC identity can only have come from LDAP.
+UPDATE users SET auth_backend = 'tailscale'
+ WHERE password_hash IS NULL AND tailscale_login IS NOT NULL;
+UPDATE users SET auth_backend = 'oidc'
+ WHERE password_hash IS NULL AND tailscale_login IS NULL AND oidc_subject IS NOT NULL;
+UPDATE users SET auth_backend = 'ldap'
+ WHERE password_hash IS NULL AND tailscale_login IS NULL AND oidc_subject IS NULL;
+
+-- migrate:down
+
+ALTER TABLE users DROP COLUMN IF EXISTS auth_backend;
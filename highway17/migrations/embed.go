@@ -0,0 +1,16 @@
+// Package migrations embeds the SQL schema migrations so the binaries do not
+// depend on the working directory.
+//
+// Files are named NNN_description.sql and applied in version order. Each
+// holds a "-- migrate:up" section and a "-- migrate:down" section that
+// reverses it; both run inside a transaction.
+//
+// The top-level files are for Postgres. sqlite/ holds the same versions
+// rewritten for SQLite (INTEGER PRIMARY KEY for SERIAL, json_valid TEXT for
+// JSONB, JSON arrays for TEXT[]); every version must exist in both.
+package migrations
+
+import "embed"
+
+//go:embed *.sql sqlite/*.sql
+var FS embed.FS
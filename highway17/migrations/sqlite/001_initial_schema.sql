@@ -0,0 +1,56 @@
+-- Migration 001: Initial Schema (SQLite)
+
+-- migrate:up
+
+-- Users table
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username VARCHAR(255) UNIQUE NOT NULL,
+    password_hash VARCHAR(255),
+    tailscale_ip VARCHAR(50),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Sessions table
+CREATE TABLE IF NOT EXISTS sessions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token VARCHAR(255) UNIQUE NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Widget data storage; JSONB becomes TEXT checked with json_valid
+CREATE TABLE IF NOT EXISTS widget_data (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    widget_name VARCHAR(255) NOT NULL,
+    widget_key VARCHAR(255) NOT NULL,
+    value_json TEXT CHECK (json_valid(value_json)),
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Settings table
+CREATE TABLE IF NOT EXISTS settings (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    setting_key VARCHAR(255) NOT NULL,
+    setting_value VARCHAR(1000),
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(user_id, setting_key)
+);
+
+-- Create indexes
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_token ON sessions(token);
+CREATE INDEX IF NOT EXISTS idx_widget_data_user_id ON widget_data(user_id);
+CREATE INDEX IF NOT EXISTS idx_widget_data_name ON widget_data(widget_name);
+CREATE INDEX IF NOT EXISTS idx_settings_user_id ON settings(user_id);
+
+-- migrate:down
+
+DROP TABLE IF EXISTS settings;
+DROP TABLE IF EXISTS widget_data;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,32 @@
+-- Migration 005: Passkey (WebAuthn) credentials (SQLite)
+
+-- migrate:up
+
+-- Registered passkeys; the full credential is kept as JSON so sign counts
+-- and authenticator flags round-trip exactly
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    credential_id BLOB UNIQUE NOT NULL,
+    credential_json TEXT NOT NULL CHECK (json_valid(credential_json)),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP
+);
+
+-- In-flight registration and login ceremonies
+CREATE TABLE IF NOT EXISTS webauthn_ceremonies (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    token VARCHAR(255) UNIQUE NOT NULL,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    session_json TEXT NOT NULL CHECK (json_valid(session_json)),
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_webauthn_credentials_user_id ON webauthn_credentials(user_id);
+
+-- migrate:down
+
+DROP TABLE IF EXISTS webauthn_ceremonies;
+DROP TABLE IF EXISTS webauthn_credentials;
@@ -0,0 +1,22 @@
+-- Migration 006: Session metadata and sliding expiry (SQLite)
+
+-- migrate:up
+
+-- SQLite cannot add a column with a CURRENT_TIMESTAMP default, so existing
+-- rows are backfilled and new sessions always set last_seen_at
+ALTER TABLE sessions ADD COLUMN last_seen_at TIMESTAMP;
+ALTER TABLE sessions ADD COLUMN absolute_expires_at TIMESTAMP;
+ALTER TABLE sessions ADD COLUMN ip_address VARCHAR(64);
+ALTER TABLE sessions ADD COLUMN user_agent VARCHAR(512);
+
+UPDATE sessions SET last_seen_at = created_at WHERE last_seen_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions(expires_at);
+
+-- migrate:down
+
+DROP INDEX IF EXISTS idx_sessions_expires_at;
+ALTER TABLE sessions DROP COLUMN user_agent;
+ALTER TABLE sessions DROP COLUMN ip_address;
+ALTER TABLE sessions DROP COLUMN absolute_expires_at;
+ALTER TABLE sessions DROP COLUMN last_seen_at;
@@ -0,0 +1,22 @@
+-- Migration 008: Personal API tokens (SQLite)
+
+-- migrate:up
+
+-- scopes is a JSON array where Postgres uses TEXT[]
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    token_hash VARCHAR(64) UNIQUE NOT NULL,
+    token_prefix VARCHAR(16) NOT NULL,
+    scopes TEXT NOT NULL DEFAULT '[]' CHECK (json_valid(scopes)),
+    expires_at TIMESTAMP,
+    last_used_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens(user_id);
+
+-- migrate:down
+
+DROP TABLE IF EXISTS api_tokens;
@@ -0,0 +1,28 @@
+-- Migration 009: OpenID Connect identities and login state (SQLite)
+
+-- migrate:up
+
+ALTER TABLE users ADD COLUMN oidc_issuer VARCHAR(255);
+ALTER TABLE users ADD COLUMN oidc_subject VARCHAR(255);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_oidc_identity ON users(oidc_issuer, oidc_subject);
+
+-- Pending authorization-code flows, keyed by the state parameter. user_id
+-- is set when a signed-in user is linking their account.
+CREATE TABLE IF NOT EXISTS oidc_states (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    state VARCHAR(255) UNIQUE NOT NULL,
+    nonce VARCHAR(255) NOT NULL,
+    code_verifier VARCHAR(255) NOT NULL,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    return_to VARCHAR(1024) NOT NULL DEFAULT '/',
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- migrate:down
+
+DROP TABLE IF EXISTS oidc_states;
+DROP INDEX IF EXISTS idx_users_oidc_identity;
+ALTER TABLE users DROP COLUMN oidc_subject;
+ALTER TABLE users DROP COLUMN oidc_issuer;
@@ -0,0 +1,28 @@
+-- Migration 011: Audit log (SQLite)
+
+-- migrate:up
+
+-- actor_name is kept alongside actor_id so events survive the user being
+-- deleted, and so failed logins can name the username that was tried
+CREATE TABLE IF NOT EXISTS audit_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    occurred_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    action VARCHAR(100) NOT NULL,
+    outcome VARCHAR(20) NOT NULL DEFAULT 'success',
+    actor_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    actor_name VARCHAR(255) NOT NULL DEFAULT '',
+    client_ip VARCHAR(45) NOT NULL DEFAULT '',
+    target_type VARCHAR(50) NOT NULL DEFAULT '',
+    target_id VARCHAR(255) NOT NULL DEFAULT '',
+    before_value TEXT CHECK (json_valid(before_value)),
+    after_value TEXT CHECK (json_valid(after_value)),
+    details TEXT CHECK (json_valid(details))
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_occurred_at ON audit_events(occurred_at);
+CREATE INDEX IF NOT EXISTS idx_audit_events_action ON audit_events(action);
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor_id ON audit_events(actor_id);
+
+-- migrate:down
+
+DROP TABLE IF EXISTS audit_events;
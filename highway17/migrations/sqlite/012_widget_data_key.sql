@@ -0,0 +1,14 @@
+-- Migration 012: Unique widget data keys (SQLite)
+
+-- migrate:up
+
+-- SaveWidgetData upserts on (user_id, widget_name, widget_key), which needs
+-- a unique index; keep the newest row of any duplicates
+DELETE FROM widget_data
+WHERE id NOT IN (SELECT MAX(id) FROM widget_data GROUP BY user_id, widget_name, widget_key);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_widget_data_key ON widget_data(user_id, widget_name, widget_key);
+
+-- migrate:down
+
+DROP INDEX IF EXISTS idx_widget_data_key;
@@ -0,0 +1,102 @@
+package components
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"citadel/highway17/internal/models"
+)
+
+// AuditQuery holds the audit page filters as typed, to refill the form
+type AuditQuery struct {
+	Action     string
+	Actor      string
+	Outcome    string
+	TargetType string
+	TargetID   string
+	ClientIP   string
+	Since      string
+	Until      string
+	Error      string
+}
+
+// auditChange is one line of an event's before/after diff
+type auditChange struct {
+	Field  string
+	Before string
+	After  string
+}
+
+// auditChanges lists the fields that differ between Before and After. When
+// either side is not a JSON object the whole values are compared.
+func auditChanges(ev models.AuditEvent) []auditChange {
+	if len(ev.Before) == 0 && len(ev.After) == 0 {
+		return nil
+	}
+
+	var before, after map[string]json.RawMessage
+	if json.Unmarshal(ev.Before, &before) != nil || json.Unmarshal(ev.After, &after) != nil {
+		return []auditChange{{Before: auditValue(ev.Before), After: auditValue(ev.After)}}
+	}
+
+	fields := make([]string, 0, len(before)+len(after))
+	for field := range before {
+		fields = append(fields, field)
+	}
+	for field := range after {
+		if _, ok := before[field]; !ok {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	var changes []auditChange
+	for _, field := range fields {
+		b, a := auditValue(before[field]), auditValue(after[field])
+		if b != a {
+			changes = append(changes, auditChange{Field: field, Before: b, After: a})
+		}
+	}
+	return changes
+}
+
+// auditValue renders a JSON value compactly; strings lose their quotes
+func auditValue(raw json.RawMessage) string {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "—"
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	return string(raw)
+}
+
+func auditActor(ev models.AuditEvent) string {
+	if ev.ActorName != "" {
+		return ev.ActorName
+	}
+	if ev.ActorID != nil {
+		return fmt.Sprintf("user %d", *ev.ActorID)
+	}
+	return "—"
+}
+
+func auditTarget(ev models.AuditEvent) string {
+	switch {
+	case ev.TargetType == "":
+		return "—"
+	case ev.TargetID == "":
+		return ev.TargetType
+	default:
+		return ev.TargetType + " " + ev.TargetID
+	}
+}
+
+func auditOutcomeClass(outcome string) string {
+	if outcome == "success" {
+		return "text-valve-green"
+	}
+	return "text-valve-orange"
+}
@@ -0,0 +1,329 @@
+package components
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"citadel/highway17/internal/metrics"
+	"citadel/highway17/internal/models"
+)
+
+// Charts are drawn in a chartWidth x chartHeight viewBox that is stretched
+// to fit its box; hover columns and labels are HTML positioned in percent,
+// so text never stretches with the SVG.
+const (
+	chartWidth  = 1000.0
+	chartHeight = 100.0
+)
+
+// ChartSpec describes how a metric is charted
+type ChartSpec struct {
+	Metric string
+	Title  string
+	Unit   string  // "%" for percentages, empty for plain numbers
+	Max    float64 // fixed top of the y axis; zero scales to the data
+}
+
+// SystemCharts are the metrics the system widget and the metrics page chart
+var SystemCharts = []ChartSpec{
+	{Metric: metrics.CPUPercent, Title: "CPU", Unit: "%", Max: 100},
+	{Metric: metrics.MemoryPercent, Title: "Memory", Unit: "%", Max: 100},
+	{Metric: metrics.DiskPercent, Title: "Disk", Unit: "%", Max: 100},
+	{Metric: metrics.Load1, Title: "Load (1m)"},
+}
+
+// Chart is a metric's series ready to render; Series is nil when the
+// metric has no history yet
+type Chart struct {
+	ChartSpec
+	Series *models.MetricSeries
+}
+
+// chartLayout is a Chart resolved to SVG paths and hover columns
+type chartLayout struct {
+	Chart
+	Line    string
+	Band    string
+	Hits    []chartHit
+	Top     string
+	Start   string
+	End     string
+	Latest  string
+	Summary string
+}
+
+// chartHit is the hover column over one point, in percent of the width
+type chartHit struct {
+	Left    float64
+	Width   float64
+	Label   string
+	Value   string
+	Flip    bool // tooltip opens leftwards, for points in the right half
+	ZoomURL string
+}
+
+// layoutChart places c's points. zoomURL, when set, links each point to a
+// closer view around it.
+func layoutChart(c Chart, zoomURL func(at time.Time) string) chartLayout {
+	l := chartLayout{Chart: c}
+	s := c.Series
+	if s == nil || len(s.Points) == 0 {
+		return l
+	}
+
+	span := s.To.Sub(s.From).Seconds()
+	step := time.Duration(s.StepSeconds) * time.Second
+	top := c.Max
+	if top <= 0 {
+		for _, p := range s.Points {
+			top = math.Max(top, p.Max)
+		}
+		top = niceCeil(top * 1.1)
+	}
+
+	x := func(t time.Time) float64 {
+		return clamp(t.Sub(s.From).Seconds()/span, 0, 1) * chartWidth
+	}
+	y := func(v float64) float64 {
+		return chartHeight - clamp(v/top, 0, 1)*chartHeight
+	}
+
+	// Points more than two steps apart are a gap in the data, not a slope
+	var line, band strings.Builder
+	var segment []models.MetricPoint
+	flush := func() {
+		if len(segment) == 0 {
+			return
+		}
+		xs := make([]float64, len(segment))
+		for i, p := range segment {
+			xs[i] = x(p.Time.Add(step / 2))
+		}
+		if len(segment) == 1 {
+			// A lone point is drawn across its bucket
+			segment = append(segment, segment[0])
+			xs = []float64{x(segment[0].Time), x(segment[0].Time.Add(step))}
+		}
+		for i, p := range segment {
+			line.WriteString(pathCmd(i, xs[i], y(p.Avg)))
+			band.WriteString(pathCmd(i, xs[i], y(p.Max)))
+		}
+		for i := len(segment) - 1; i >= 0; i-- {
+			band.WriteString(pathCmd(1, xs[i], y(segment[i].Min)))
+		}
+		band.WriteString("Z")
+		segment = segment[:0]
+	}
+
+	var total float64
+	var count int
+	peak := math.Inf(-1)
+	for i, p := range s.Points {
+		if i > 0 && p.Time.Sub(s.Points[i-1].Time) > 2*step {
+			flush()
+		}
+		segment = append(segment, p)
+
+		total += p.Avg * float64(p.Count)
+		count += p.Count
+		peak = math.Max(peak, p.Max)
+
+		left := x(p.Time) / chartWidth * 100
+		hit := chartHit{
+			Left:  left,
+			Width: math.Min(step.Seconds()/span*100, 100-left),
+			Label: p.Time.UTC().Format(chartTimeFormat(step)) + " UTC",
+			Value: c.format(p.Avg),
+			Flip:  left > 50,
+		}
+		if p.Min != p.Max {
+			hit.Value += fmt.Sprintf(" (%s – %s)", c.format(p.Min), c.format(p.Max))
+		}
+		if zoomURL != nil {
+			hit.ZoomURL = zoomURL(p.Time.Add(step / 2))
+		}
+		l.Hits = append(l.Hits, hit)
+	}
+	flush()
+
+	axis := chartAxisFormat(s.To.Sub(s.From))
+	l.Line = line.String()
+	l.Band = band.String()
+	l.Top = c.format(top)
+	l.Start = s.From.UTC().Format(axis)
+	l.End = s.To.UTC().Format(axis)
+	l.Latest = c.format(s.Points[len(s.Points)-1].Avg)
+	if count > 0 {
+		l.Summary = fmt.Sprintf("avg %s · peak %s", c.format(total/float64(count)), c.format(peak))
+	}
+	return l
+}
+
+// format renders a value in the chart's unit
+func (c Chart) format(v float64) string {
+	if c.Unit == "%" {
+		return fmt.Sprintf("%.1f%%", v)
+	}
+	return strconv.FormatFloat(v, 'f', 2, 64) + c.Unit
+}
+
+// chartTimeFormat labels a point precisely enough to tell steps apart
+func chartTimeFormat(step time.Duration) string {
+	if step < time.Minute {
+		return "Jan 2 15:04:05"
+	}
+	return "Jan 2 15:04"
+}
+
+// chartAxisFormat labels the ends of a range of length span
+func chartAxisFormat(span time.Duration) string {
+	if span <= 24*time.Hour {
+		return "15:04"
+	}
+	return "Jan 2 15:04"
+}
+
+func pathCmd(i int, x, y float64) string {
+	cmd := "L"
+	if i == 0 {
+		cmd = "M"
+	}
+	return cmd + strconv.FormatFloat(x, 'f', 1, 64) + "," + strconv.FormatFloat(y, 'f', 1, 64)
+}
+
+// niceCeil rounds v up to 1, 2 or 5 times a power of ten
+func niceCeil(v float64) float64 {
+	if v <= 0 {
+		return 1
+	}
+	pow := math.Pow(10, math.Floor(math.Log10(v)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if m*pow >= v {
+			return m * pow
+		}
+	}
+	return 10 * pow
+}
+
+func clamp(v, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(hi, v))
+}
+
+func percent(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64) + "%"
+}
+
+// MetricsRange is a window the metrics page offers
+type MetricsRange struct {
+	Name string
+	Span time.Duration
+}
+
+// MetricsRanges are the metrics page's ranges, narrowest first
+var MetricsRanges = []MetricsRange{
+	{"1h", time.Hour},
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+}
+
+// MetricsRangeSpan returns the length of the named range
+func MetricsRangeSpan(name string) (time.Duration, bool) {
+	for _, r := range MetricsRanges {
+		if r.Name == name {
+			return r.Span, true
+		}
+	}
+	return 0, false
+}
+
+// MetricsView is what the metrics page shows: the charts for Range ending
+// at To, or ending now when To is zero
+type MetricsView struct {
+	Range  string
+	To     time.Time
+	Now    time.Time
+	Charts []Chart
+	Error  string
+}
+
+// metricsURL links to the metrics page; a zero to follows the present
+func metricsURL(rangeName string, to time.Time) string {
+	q := url.Values{"range": {rangeName}}
+	if !to.IsZero() {
+		q.Set("to", to.UTC().Format(time.RFC3339))
+	}
+	return "/metrics?" + q.Encode()
+}
+
+func (v MetricsView) url() string {
+	return metricsURL(v.Range, v.To)
+}
+
+func (v MetricsView) end() time.Time {
+	if v.To.IsZero() {
+		return v.Now
+	}
+	return v.To
+}
+
+// at returns the view of rangeName ending at to, or following the present
+// when to is not in the past
+func (v MetricsView) at(rangeName string, to time.Time) string {
+	if !to.Before(v.Now) {
+		to = time.Time{}
+	}
+	return metricsURL(rangeName, to)
+}
+
+// liveURL is the view's range ending now
+func (v MetricsView) liveURL() string {
+	return metricsURL(v.Range, time.Time{})
+}
+
+// earlierURL and laterURL pan by a whole range; laterURL is empty when the
+// view already ends now
+func (v MetricsView) earlierURL() string {
+	span, _ := MetricsRangeSpan(v.Range)
+	return v.at(v.Range, v.end().Add(-span))
+}
+
+func (v MetricsView) laterURL() string {
+	if v.To.IsZero() {
+		return ""
+	}
+	span, _ := MetricsRangeSpan(v.Range)
+	return v.at(v.Range, v.To.Add(span))
+}
+
+// zoomURL links a point to the next narrower range centred on it; the
+// narrowest range does not zoom
+func (v MetricsView) zoomURL() func(at time.Time) string {
+	for i, r := range MetricsRanges {
+		if r.Name == v.Range && i > 0 {
+			narrower := MetricsRanges[i-1]
+			return func(at time.Time) string {
+				return v.at(narrower.Name, at.Add(narrower.Span/2))
+			}
+		}
+	}
+	return nil
+}
+
+// chartFor lays out the chart for metric as a sparkline, without zooming
+func chartFor(charts []Chart, metric string) chartLayout {
+	for _, c := range charts {
+		if c.Metric == metric {
+			return layoutChart(c, nil)
+		}
+	}
+	return chartLayout{}
+}
+
+func hitStyle(hit chartHit) string {
+	return "left: " + percent(hit.Left) + "; width: " + percent(hit.Width)
+}
@@ -0,0 +1,183 @@
+package components
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"citadel/highway17/internal/models"
+)
+
+var chartStart = time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+
+// testSeries spans 1000s at a 10s step, so x in the viewBox is the point's
+// offset in seconds plus half a step
+func testSeries(points ...models.MetricPoint) *models.MetricSeries {
+	return &models.MetricSeries{
+		From:        chartStart,
+		To:          chartStart.Add(1000 * time.Second),
+		StepSeconds: 10,
+		Points:      points,
+	}
+}
+
+// point is a bucket starting sec seconds in, its min and max around avg
+func point(sec int, lo, avg, hi float64) models.MetricPoint {
+	return models.MetricPoint{Time: chartStart.Add(time.Duration(sec) * time.Second), Min: lo, Avg: avg, Max: hi, Count: 1}
+}
+
+func TestLayoutChartPaths(t *testing.T) {
+	percentChart := ChartSpec{Metric: "cpu", Unit: "%", Max: 100}
+
+	tests := []struct {
+		name     string
+		points   []models.MetricPoint
+		wantLine string
+		wantBand string
+	}{
+		{
+			name:     "one segment",
+			points:   []models.MetricPoint{point(0, 10, 10, 10), point(10, 20, 20, 20), point(20, 30, 30, 30)},
+			wantLine: "M5.0,90.0L15.0,80.0L25.0,70.0",
+			wantBand: "M5.0,90.0L15.0,80.0L25.0,70.0L25.0,70.0L15.0,80.0L5.0,90.0Z",
+		},
+		{
+			name:     "band between min and max",
+			points:   []models.MetricPoint{point(0, 10, 20, 30), point(10, 40, 50, 60)},
+			wantLine: "M5.0,80.0L15.0,50.0",
+			wantBand: "M5.0,70.0L15.0,40.0L15.0,60.0L5.0,90.0Z",
+		},
+		// Two steps apart is still a slope
+		{
+			name:     "missed bucket",
+			points:   []models.MetricPoint{point(0, 10, 10, 10), point(20, 30, 30, 30)},
+			wantLine: "M5.0,90.0L25.0,70.0",
+			wantBand: "M5.0,90.0L25.0,70.0L25.0,70.0L5.0,90.0Z",
+		},
+		{
+			name:     "gap splits the line",
+			points:   []models.MetricPoint{point(0, 10, 10, 10), point(10, 20, 20, 20), point(50, 30, 30, 30), point(60, 40, 40, 40)},
+			wantLine: "M5.0,90.0L15.0,80.0M55.0,70.0L65.0,60.0",
+			wantBand: "M5.0,90.0L15.0,80.0L15.0,80.0L5.0,90.0ZM55.0,70.0L65.0,60.0L65.0,60.0L55.0,70.0Z",
+		},
+		// A lone point is drawn across its whole bucket
+		{
+			name:     "lone point",
+			points:   []models.MetricPoint{point(100, 40, 50, 60)},
+			wantLine: "M100.0,50.0L110.0,50.0",
+			wantBand: "M100.0,40.0L110.0,40.0L110.0,60.0L100.0,60.0Z",
+		},
+		{
+			name:     "lone point after a gap",
+			points:   []models.MetricPoint{point(0, 10, 10, 10), point(10, 20, 20, 20), point(500, 50, 50, 50)},
+			wantLine: "M5.0,90.0L15.0,80.0M500.0,50.0L510.0,50.0",
+			wantBand: "M5.0,90.0L15.0,80.0L15.0,80.0L5.0,90.0ZM500.0,50.0L510.0,50.0L510.0,50.0L500.0,50.0Z",
+		},
+		// Values past a fixed top stay inside the box
+		{
+			name:     "clamped to the axis",
+			points:   []models.MetricPoint{point(0, -5, 0, 150), point(10, 100, 120, 150)},
+			wantLine: "M5.0,100.0L15.0,0.0",
+			wantBand: "M5.0,0.0L15.0,0.0L15.0,0.0L5.0,100.0Z",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := layoutChart(Chart{ChartSpec: percentChart, Series: testSeries(tt.points...)}, nil)
+			if l.Line != tt.wantLine {
+				t.Errorf("line = %s, want %s", l.Line, tt.wantLine)
+			}
+			if l.Band != tt.wantBand {
+				t.Errorf("band = %s, want %s", l.Band, tt.wantBand)
+			}
+			if len(l.Hits) != len(tt.points) {
+				t.Errorf("hits = %d, want one per point", len(l.Hits))
+			}
+		})
+	}
+
+	for name, series := range map[string]*models.MetricSeries{"no series": nil, "no points": testSeries()} {
+		if l := layoutChart(Chart{ChartSpec: percentChart, Series: series}, nil); l.Line != "" || l.Band != "" || l.Hits != nil || l.Top != "" {
+			t.Errorf("%s: layout = %+v, want empty", name, l)
+		}
+	}
+}
+
+func TestLayoutChartScale(t *testing.T) {
+	tests := []struct {
+		name    string
+		max     float64
+		peak    float64
+		wantTop string
+		wantY   string // the peak's y in the line
+	}{
+		{name: "fixed top", max: 100, peak: 25, wantTop: "100.00", wantY: "75.0"},
+		// Unfixed axes leave 10% headroom and round up to 1, 2 or 5
+		{name: "rounds to five", peak: 3.2, wantTop: "5.00", wantY: "36.0"},
+		{name: "rounds to two", peak: 1.5, wantTop: "2.00", wantY: "25.0"},
+		{name: "headroom crosses a step", peak: 0.95, wantTop: "2.00", wantY: "52.5"},
+		{name: "rounds to one", peak: 0.8, wantTop: "1.00", wantY: "20.0"},
+		{name: "large values", peak: 420, wantTop: "500.00", wantY: "16.0"},
+		{name: "all zero", peak: 0, wantTop: "1.00", wantY: "100.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Chart{ChartSpec: ChartSpec{Metric: "load_1", Max: tt.max}, Series: testSeries(point(0, 0, tt.peak, tt.peak))}
+			l := layoutChart(c, nil)
+			if l.Top != tt.wantTop {
+				t.Errorf("top = %s, want %s", l.Top, tt.wantTop)
+			}
+			if want := "M0.0," + tt.wantY + "L10.0," + tt.wantY; l.Line != want {
+				t.Errorf("line = %s, want %s", l.Line, want)
+			}
+		})
+	}
+}
+
+func TestLayoutChartHits(t *testing.T) {
+	c := Chart{
+		ChartSpec: ChartSpec{Metric: "cpu", Unit: "%", Max: 100},
+		Series: testSeries(
+			models.MetricPoint{Time: chartStart, Min: 10, Avg: 20, Max: 40, Count: 3},
+			models.MetricPoint{Time: chartStart.Add(600 * time.Second), Min: 60, Avg: 60, Max: 60, Count: 1},
+			models.MetricPoint{Time: chartStart.Add(995 * time.Second), Min: 30, Avg: 30, Max: 30, Count: 1},
+		),
+	}
+	var zoomed []time.Time
+	l := layoutChart(c, func(at time.Time) string {
+		zoomed = append(zoomed, at)
+		return "/metrics?zoom"
+	})
+
+	want := []chartHit{
+		{Left: 0, Width: 1, Label: "Mar 1 10:00:00 UTC", Value: "20.0% (10.0% – 40.0%)", ZoomURL: "/metrics?zoom"},
+		{Left: 60, Width: 1, Label: "Mar 1 10:10:00 UTC", Value: "60.0%", Flip: true, ZoomURL: "/metrics?zoom"},
+		// The last column stops at the right edge
+		{Left: 99.5, Width: 0.5, Label: "Mar 1 10:16:35 UTC", Value: "30.0%", Flip: true, ZoomURL: "/metrics?zoom"},
+	}
+	if len(l.Hits) != len(want) {
+		t.Fatalf("hits = %+v", l.Hits)
+	}
+	near := func(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+	for i, hit := range l.Hits {
+		w := want[i]
+		if !near(hit.Left, w.Left) || !near(hit.Width, w.Width) || hit.Label != w.Label || hit.Value != w.Value || hit.Flip != w.Flip || hit.ZoomURL != w.ZoomURL {
+			t.Errorf("hit %d = %+v, want %+v", i, hit, want[i])
+		}
+	}
+
+	// Zooming centres on the middle of the bucket
+	if len(zoomed) != 3 || !zoomed[0].Equal(chartStart.Add(5*time.Second)) {
+		t.Errorf("zoom times = %v", zoomed)
+	}
+
+	// The average is weighted by sample count
+	if l.Summary != "avg 30.0% · peak 60.0%" || l.Latest != "30.0%" {
+		t.Errorf("summary = %q, latest = %q", l.Summary, l.Latest)
+	}
+	if l.Start != "10:00" || l.End != "10:16" {
+		t.Errorf("axis = %s to %s", l.Start, l.End)
+	}
+}
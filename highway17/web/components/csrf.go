@@ -0,0 +1,25 @@
+package components
+
+import (
+	"context"
+	"encoding/json"
+)
+
+type csrfTokenKey struct{}
+
+// WithCSRFToken makes the request's CSRF token available to Layout
+func WithCSRFToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, csrfTokenKey{}, token)
+}
+
+func csrfToken(ctx context.Context) string {
+	token, _ := ctx.Value(csrfTokenKey{}).(string)
+	return token
+}
+
+// csrfHeaders renders the hx-headers value that attaches the token to every
+// htmx request on the page
+func csrfHeaders(ctx context.Context) string {
+	b, _ := json.Marshal(map[string]string{"X-CSRF-Token": csrfToken(ctx)})
+	return string(b)
+}
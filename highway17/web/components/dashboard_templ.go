@@ -8,7 +8,7 @@ package components
 import "github.com/a-h/templ"
 import templruntime "github.com/a-h/templ/runtime"
 
-func Dashboard() templ.Component {
+func Dashboard(readOnly bool) templ.Component {
 	return templruntime.GeneratedTemplate(func(templ_7745c5c3_Input templruntime.GeneratedComponentInput) (templ_7745c5c3_Err error) {
 		templ_7745c5c3_W, ctx := templ_7745c5c3_Input.Writer, templ_7745c5c3_Input.Context
 		if templ_7745c5c3_CtxErr := ctx.Err(); templ_7745c5c3_CtxErr != nil {
@@ -41,7 +41,13 @@ func Dashboard() templ.Component {
 				}()
 			}
 			ctx = templ.InitializeContext(ctx)
-			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 1, "<div class=\"grid grid-cols-1 md:grid-cols-2 gap-6\"><!-- Weather Widget --><div id=\"weather-widget\" hx-get=\"/api/widgets/weather\" hx-trigger=\"load, every 10m\" hx-swap=\"innerHTML\" class=\"border-2 border-valve-orange bg-dark p-6\"><div class=\"text-valve-cyan\">Loading weather...</div></div><!-- System Stats Widget --><div id=\"system-widget\" hx-get=\"/api/widgets/system\" hx-trigger=\"load, every 5s\" hx-swap=\"innerHTML\" class=\"border-2 border-valve-orange bg-dark p-6\"><div class=\"text-valve-cyan\">Loading system stats...</div></div><!-- Uptime Widget --><div id=\"uptime-widget\" hx-get=\"/api/widgets/uptime\" hx-trigger=\"load, every 1m\" hx-swap=\"innerHTML\" class=\"border-2 border-valve-orange bg-dark p-6\"><div class=\"text-valve-cyan\">Loading uptime...</div></div></div>")
+			if readOnly {
+				templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 1, "<div class=\"mb-6 border border-valve-cyan p-3 text-valve-cyan text-sm\">READ-ONLY ACCESS - ask an administrator for operator access to change widgets or settings.</div>")
+				if templ_7745c5c3_Err != nil {
+					return templ_7745c5c3_Err
+				}
+			}
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 2, " <div class=\"grid grid-cols-1 md:grid-cols-2 gap-6\"><!-- Weather Widget --><div id=\"weather-widget\" hx-get=\"/api/widgets/weather\" hx-trigger=\"load, every 10m\" hx-swap=\"innerHTML\" class=\"border-2 border-valve-orange bg-dark p-6\"><div class=\"text-valve-cyan\">Loading weather...</div></div><!-- System Stats Widget --><div id=\"system-widget\" hx-get=\"/api/widgets/system\" hx-trigger=\"load, every 5s\" hx-swap=\"innerHTML\" class=\"border-2 border-valve-orange bg-dark p-6\"><div class=\"text-valve-cyan\">Loading system stats...</div></div><!-- Uptime Widget --><div id=\"uptime-widget\" hx-get=\"/api/widgets/uptime\" hx-trigger=\"load, every 1m\" hx-swap=\"innerHTML\" class=\"border-2 border-valve-orange bg-dark p-6\"><div class=\"text-valve-cyan\">Loading uptime...</div></div></div>")
 			if templ_7745c5c3_Err != nil {
 				return templ_7745c5c3_Err
 			}
@@ -8,7 +8,16 @@ package components
 import "github.com/a-h/templ"
 import templruntime "github.com/a-h/templ/runtime"
 
-func LoginPage() templ.Component {
+import "citadel/highway17/internal/assets"
+
+// LoginOptions selects which sign-in methods the login page offers
+type LoginOptions struct {
+	PasswordEnabled bool
+	SSOName         string // empty when single sign-on is not configured
+	Error           string
+}
+
+func LoginPage(opts LoginOptions) templ.Component {
 	return templruntime.GeneratedTemplate(func(templ_7745c5c3_Input templruntime.GeneratedComponentInput) (templ_7745c5c3_Err error) {
 		templ_7745c5c3_W, ctx := templ_7745c5c3_Input.Writer, templ_7745c5c3_Input.Context
 		if templ_7745c5c3_CtxErr := ctx.Err(); templ_7745c5c3_CtxErr != nil {
@@ -29,7 +38,115 @@ func LoginPage() templ.Component {
 			templ_7745c5c3_Var1 = templ.NopComponent
 		}
 		ctx = templ.ClearChildren(ctx)
-		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 1, "<!doctype html><html lang=\"en\"><head><meta charset=\"UTF-8\"><meta name=\"viewport\" content=\"width=device-width, initial-scale=1.0\"><title>Login - Highway 17 Dashboard</title><link rel=\"stylesheet\" href=\"/static/css/style.css\"></head><body class=\"bg-dark text-valve-green font-mono flex items-center justify-center min-h-screen\"><div class=\"w-full max-w-md\"><div class=\"border-2 border-valve-orange bg-dark p-8\"><h1 class=\"text-3xl font-bold text-valve-orange mb-8 text-center\">HIGHWAY 17</h1><p class=\"text-valve-green text-center mb-6\">Administrative Access Required</p><form hx-post=\"/api/login\" hx-on::response-error=\"alert('Login failed')\" class=\"space-y-4\"><div><label for=\"username\" class=\"block text-valve-green text-sm mb-2\">Username:</label> <input type=\"text\" id=\"username\" name=\"username\" required class=\"w-full bg-dark border border-valve-cyan text-valve-green p-2 focus:outline-none focus:border-valve-orange\" placeholder=\"Enter username\"></div><div><label for=\"password\" class=\"block text-valve-green text-sm mb-2\">Password:</label> <input type=\"password\" id=\"password\" name=\"password\" required class=\"w-full bg-dark border border-valve-cyan text-valve-green p-2 focus:outline-none focus:border-valve-orange\" placeholder=\"Enter password\"></div><button type=\"submit\" class=\"w-full bg-valve-orange text-dark font-bold py-2 px-4 border-2 border-valve-orange hover:bg-dark hover:text-valve-orange transition\">LOGIN</button></form><p class=\"text-valve-cyan text-center text-xs mt-6\">Default password: checkpoint</p></div></div></body></html>")
+		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 1, "<!doctype html><html lang=\"en\"><head><meta charset=\"UTF-8\"><meta name=\"viewport\" content=\"width=device-width, initial-scale=1.0\"><meta name=\"csrf-token\" content=\"")
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		var templ_7745c5c3_Var2 string
+		templ_7745c5c3_Var2, templ_7745c5c3_Err = templ.JoinStringErrs(csrfToken(ctx))
+		if templ_7745c5c3_Err != nil {
+			return templ.Error{Err: templ_7745c5c3_Err, FileName: `web/components/login.templ`, Line: 18, Col: 51}
+		}
+		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var2))
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 2, "\"><title>Login - Highway 17 Dashboard</title><script src=\"")
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		var templ_7745c5c3_Var3 string
+		templ_7745c5c3_Var3, templ_7745c5c3_Err = templ.JoinStringErrs(assets.Path("js/htmx.min.js"))
+		if templ_7745c5c3_Err != nil {
+			return templ.Error{Err: templ_7745c5c3_Err, FileName: `web/components/login.templ`, Line: 20, Col: 46}
+		}
+		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var3))
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 3, "\"></script><link rel=\"stylesheet\" href=\"")
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		var templ_7745c5c3_Var4 templ.SafeURL
+		templ_7745c5c3_Var4, templ_7745c5c3_Err = templ.JoinURLErrs(assets.Path("css/style.css"))
+		if templ_7745c5c3_Err != nil {
+			return templ.Error{Err: templ_7745c5c3_Err, FileName: `web/components/login.templ`, Line: 21, Col: 61}
+		}
+		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var4))
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 4, "\"></head><body hx-headers=\"")
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		var templ_7745c5c3_Var5 string
+		templ_7745c5c3_Var5, templ_7745c5c3_Err = templ.JoinStringErrs(csrfHeaders(ctx))
+		if templ_7745c5c3_Err != nil {
+			return templ.Error{Err: templ_7745c5c3_Err, FileName: `web/components/login.templ`, Line: 23, Col: 37}
+		}
+		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var5))
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 5, "\" class=\"bg-dark text-valve-green font-mono flex items-center justify-center min-h-screen\"><div class=\"w-full max-w-md\"><div class=\"border-2 border-valve-orange bg-dark p-8\"><h1 class=\"text-3xl font-bold text-valve-orange mb-8 text-center\">HIGHWAY 17</h1><p class=\"text-valve-green text-center mb-6\">Administrative Access Required</p>")
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		if opts.Error != "" {
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 6, "<p class=\"border border-valve-orange text-valve-orange text-sm p-2 mb-6\">")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			var templ_7745c5c3_Var6 string
+			templ_7745c5c3_Var6, templ_7745c5c3_Err = templ.JoinStringErrs(opts.Error)
+			if templ_7745c5c3_Err != nil {
+				return templ.Error{Err: templ_7745c5c3_Err, FileName: `web/components/login.templ`, Line: 29, Col: 91}
+			}
+			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var6))
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 7, "</p>")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+		}
+		if opts.SSOName != "" {
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 8, "<a href=\"/auth/oidc/login\" class=\"block w-full text-center bg-dark text-valve-cyan font-bold py-2 px-4 border-2 border-valve-cyan hover:bg-valve-cyan hover:text-dark transition mb-4\">SIGN IN WITH ")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			var templ_7745c5c3_Var7 string
+			templ_7745c5c3_Var7, templ_7745c5c3_Err = templ.JoinStringErrs(opts.SSOName)
+			if templ_7745c5c3_Err != nil {
+				return templ.Error{Err: templ_7745c5c3_Err, FileName: `web/components/login.templ`, Line: 36, Col: 34}
+			}
+			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var7))
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 9, "</a>")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+		}
+		templ_7745c5c3_Err = PasskeyScript().Render(ctx, templ_7745c5c3_Buffer)
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 10, "<button type=\"button\" onclick=\"h17PasskeyLogin()\" class=\"w-full bg-dark text-valve-cyan font-bold py-2 px-4 border-2 border-valve-cyan hover:bg-valve-cyan hover:text-dark transition mb-6\">SIGN IN WITH PASSKEY</button> ")
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		if opts.PasswordEnabled {
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 11, "<form hx-post=\"/api/login\" hx-target=\"this\" hx-swap=\"outerHTML\" hx-on::response-error=\"alert('Login failed')\" class=\"space-y-4\"><div><label for=\"username\" class=\"block text-valve-green text-sm mb-2\">Username:</label> <input type=\"text\" id=\"username\" name=\"username\" required class=\"w-full bg-dark border border-valve-cyan text-valve-green p-2 focus:outline-none focus:border-valve-orange\" placeholder=\"Enter username\"></div><div><label for=\"password\" class=\"block text-valve-green text-sm mb-2\">Password:</label> <input type=\"password\" id=\"password\" name=\"password\" required class=\"w-full bg-dark border border-valve-cyan text-valve-green p-2 focus:outline-none focus:border-valve-orange\" placeholder=\"Enter password\"></div><button type=\"submit\" class=\"w-full bg-valve-orange text-dark font-bold py-2 px-4 border-2 border-valve-orange hover:bg-dark hover:text-valve-orange transition\">LOGIN</button></form>")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+		}
+		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 12, "</div></div></body></html>")
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
@@ -0,0 +1,203 @@
+// Code generated by templ - DO NOT EDIT.
+
+// templ: version: v0.3.977
+package components
+
+//lint:file-ignore SA4006 This context is only used if a nested component is present.
+
+import "github.com/a-h/templ"
+import templruntime "github.com/a-h/templ/runtime"
+
+import (
+	"citadel/highway17/internal/models"
+	"fmt"
+	"time"
+)
+
+// PasskeyScript wires the WebAuthn browser API to the /api/passkeys endpoints
+func PasskeyScript() templ.Component {
+	return templruntime.GeneratedTemplate(func(templ_7745c5c3_Input templruntime.GeneratedComponentInput) (templ_7745c5c3_Err error) {
+		templ_7745c5c3_W, ctx := templ_7745c5c3_Input.Writer, templ_7745c5c3_Input.Context
+		if templ_7745c5c3_CtxErr := ctx.Err(); templ_7745c5c3_CtxErr != nil {
+			return templ_7745c5c3_CtxErr
+		}
+		templ_7745c5c3_Buffer, templ_7745c5c3_IsBuffer := templruntime.GetBuffer(templ_7745c5c3_W)
+		if !templ_7745c5c3_IsBuffer {
+			defer func() {
+				templ_7745c5c3_BufErr := templruntime.ReleaseBuffer(templ_7745c5c3_Buffer)
+				if templ_7745c5c3_Err == nil {
+					templ_7745c5c3_Err = templ_7745c5c3_BufErr
+				}
+			}()
+		}
+		ctx = templ.InitializeContext(ctx)
+		templ_7745c5c3_Var1 := templ.GetChildren(ctx)
+		if templ_7745c5c3_Var1 == nil {
+			templ_7745c5c3_Var1 = templ.NopComponent
+		}
+		ctx = templ.ClearChildren(ctx)
+		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 1, "<script>\n\t\tfunction h17b64urlToBuf(value) {\n\t\t\tconst b64 = value.replace(/-/g, \"+\").replace(/_/g, \"/\");\n\t\t\tconst bin = atob(b64 + \"===\".slice((b64.length + 3) % 4));\n\t\t\treturn Uint8Array.from(bin, (c) => c.charCodeAt(0)).buffer;\n\t\t}\n\n\t\tfunction h17bufToB64url(buf) {\n\t\t\tconst bin = String.fromCharCode(...new Uint8Array(buf));\n\t\t\treturn btoa(bin).replace(/\\+/g, \"-\").replace(/\\//g, \"_\").replace(/=+$/, \"\");\n\t\t}\n\n\t\tasync function h17PasskeyFetch(url, body) {\n\t\t\tconst resp = await fetch(url, {\n\t\t\t\tmethod: \"POST\",\n\t\t\t\theaders: {\n\t\t\t\t\t\"Content-Type\": \"application/json\",\n\t\t\t\t\t\"X-CSRF-Token\": document.querySelector('meta[name=\"csrf-token\"]').content,\n\t\t\t\t},\n\t\t\t\tbody: body === undefined ? undefined : JSON.stringify(body),\n\t\t\t});\n\t\t\tconst data = await resp.json().catch(() => ({}));\n\t\t\tif (!resp.ok) {\n\t\t\t\tthrow new Error(data.error || \"request failed\");\n\t\t\t}\n\t\t\treturn data;\n\t\t}\n\n\t\tasync function h17PasskeyLogin() {\n\t\t\ttry {\n\t\t\t\tconst begin = await h17PasskeyFetch(\"/api/passkeys/login/begin\");\n\t\t\t\tconst options = begin.options.publicKey;\n\t\t\t\toptions.challenge = h17b64urlToBuf(options.challenge);\n\t\t\t\t(options.allowCredentials || []).forEach((c) => (c.id = h17b64urlToBuf(c.id)));\n\n\t\t\t\tconst cred = await navigator.credentials.get({ publicKey: options });\n\t\t\t\tconst result = await h17PasskeyFetch(\"/api/passkeys/login/finish?ceremony=\" + encodeURIComponent(begin.ceremony), {\n\t\t\t\t\tid: cred.id,\n\t\t\t\t\trawId: h17bufToB64url(cred.rawId),\n\t\t\t\t\ttype: cred.type,\n\t\t\t\t\tresponse: {\n\t\t\t\t\t\tauthenticatorData: h17bufToB64url(cred.response.authenticatorData),\n\t\t\t\t\t\tclientDataJSON: h17bufToB64url(cred.response.clientDataJSON),\n\t\t\t\t\t\tsignature: h17bufToB64url(cred.response.signature),\n\t\t\t\t\t\tuserHandle: cred.response.userHandle ? h17bufToB64url(cred.response.userHandle) : null,\n\t\t\t\t\t},\n\t\t\t\t});\n\t\t\t\twindow.location.href = result.redirect || \"/dashboard\";\n\t\t\t} catch (err) {\n\t\t\t\talert(\"Passkey login failed: \" + err.message);\n\t\t\t}\n\t\t}\n\n\t\tasync function h17PasskeyRegister() {\n\t\t\tconst nameInput = document.getElementById(\"passkey-name\");\n\t\t\ttry {\n\t\t\t\tconst begin = await h17PasskeyFetch(\"/api/passkeys/register/begin\");\n\t\t\t\tconst options = begin.options.publicKey;\n\t\t\t\toptions.challenge = h17b64urlToBuf(options.challenge);\n\t\t\t\toptions.user.id = h17b64urlToBuf(options.user.id);\n\t\t\t\t(options.excludeCredentials || []).forEach((c) => (c.id = h17b64urlToBuf(c.id)));\n\n\t\t\t\tconst cred = await navigator.credentials.create({ publicKey: options });\n\t\t\t\tconst params = new URLSearchParams({ ceremony: begin.ceremony, name: nameInput ? nameInput.value : \"\" });\n\t\t\t\tawait h17PasskeyFetch(\"/api/passkeys/register/finish?\" + params.toString(), {\n\t\t\t\t\tid: cred.id,\n\t\t\t\t\trawId: h17bufToB64url(cred.rawId),\n\t\t\t\t\ttype: cred.type,\n\t\t\t\t\tresponse: {\n\t\t\t\t\t\tattestationObject: h17bufToB64url(cred.response.attestationObject),\n\t\t\t\t\t\tclientDataJSON: h17bufToB64url(cred.response.clientDataJSON),\n\t\t\t\t\t\ttransports: cred.response.getTransports ? cred.response.getTransports() : [],\n\t\t\t\t\t},\n\t\t\t\t});\n\t\t\t\twindow.location.reload();\n\t\t\t} catch (err) {\n\t\t\t\talert(\"Passkey registration failed: \" + err.message);\n\t\t\t}\n\t\t}\n\t</script>")
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		return nil
+	})
+}
+
+func PasskeysPage(passkeys []models.Passkey) templ.Component {
+	return templruntime.GeneratedTemplate(func(templ_7745c5c3_Input templruntime.GeneratedComponentInput) (templ_7745c5c3_Err error) {
+		templ_7745c5c3_W, ctx := templ_7745c5c3_Input.Writer, templ_7745c5c3_Input.Context
+		if templ_7745c5c3_CtxErr := ctx.Err(); templ_7745c5c3_CtxErr != nil {
+			return templ_7745c5c3_CtxErr
+		}
+		templ_7745c5c3_Buffer, templ_7745c5c3_IsBuffer := templruntime.GetBuffer(templ_7745c5c3_W)
+		if !templ_7745c5c3_IsBuffer {
+			defer func() {
+				templ_7745c5c3_BufErr := templruntime.ReleaseBuffer(templ_7745c5c3_Buffer)
+				if templ_7745c5c3_Err == nil {
+					templ_7745c5c3_Err = templ_7745c5c3_BufErr
+				}
+			}()
+		}
+		ctx = templ.InitializeContext(ctx)
+		templ_7745c5c3_Var2 := templ.GetChildren(ctx)
+		if templ_7745c5c3_Var2 == nil {
+			templ_7745c5c3_Var2 = templ.NopComponent
+		}
+		ctx = templ.ClearChildren(ctx)
+		templ_7745c5c3_Var3 := templruntime.GeneratedTemplate(func(templ_7745c5c3_Input templruntime.GeneratedComponentInput) (templ_7745c5c3_Err error) {
+			templ_7745c5c3_W, ctx := templ_7745c5c3_Input.Writer, templ_7745c5c3_Input.Context
+			templ_7745c5c3_Buffer, templ_7745c5c3_IsBuffer := templruntime.GetBuffer(templ_7745c5c3_W)
+			if !templ_7745c5c3_IsBuffer {
+				defer func() {
+					templ_7745c5c3_BufErr := templruntime.ReleaseBuffer(templ_7745c5c3_Buffer)
+					if templ_7745c5c3_Err == nil {
+						templ_7745c5c3_Err = templ_7745c5c3_BufErr
+					}
+				}()
+			}
+			ctx = templ.InitializeContext(ctx)
+			templ_7745c5c3_Err = PasskeyScript().Render(ctx, templ_7745c5c3_Buffer)
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 2, " <div class=\"max-w-xl border-2 border-valve-orange bg-dark p-6\"><h2 class=\"text-2xl font-bold text-valve-orange mb-4\">PASSKEYS</h2>")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			if len(passkeys) == 0 {
+				templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 3, "<p class=\"text-valve-green mb-4\">No passkeys registered yet.</p>")
+				if templ_7745c5c3_Err != nil {
+					return templ_7745c5c3_Err
+				}
+			}
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 4, "<ul class=\"space-y-3 mb-6\">")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			for _, pk := range passkeys {
+				templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 5, "<li id=\"")
+				if templ_7745c5c3_Err != nil {
+					return templ_7745c5c3_Err
+				}
+				var templ_7745c5c3_Var4 string
+				templ_7745c5c3_Var4, templ_7745c5c3_Err = templ.JoinStringErrs(fmt.Sprintf("passkey-%d", pk.ID))
+				if templ_7745c5c3_Err != nil {
+					return templ.Error{Err: templ_7745c5c3_Err, FileName: `web/components/passkeys.templ`, Line: 103, Col: 46}
+				}
+				_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var4))
+				if templ_7745c5c3_Err != nil {
+					return templ_7745c5c3_Err
+				}
+				templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 6, "\" class=\"flex justify-between items-center border border-valve-cyan p-2\"><div><div class=\"text-valve-cyan\">")
+				if templ_7745c5c3_Err != nil {
+					return templ_7745c5c3_Err
+				}
+				var templ_7745c5c3_Var5 string
+				templ_7745c5c3_Var5, templ_7745c5c3_Err = templ.JoinStringErrs(pk.Name)
+				if templ_7745c5c3_Err != nil {
+					return templ.Error{Err: templ_7745c5c3_Err, FileName: `web/components/passkeys.templ`, Line: 105, Col: 45}
+				}
+				_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var5))
+				if templ_7745c5c3_Err != nil {
+					return templ_7745c5c3_Err
+				}
+				templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 7, "</div><div class=\"text-valve-green text-xs\">Added ")
+				if templ_7745c5c3_Err != nil {
+					return templ_7745c5c3_Err
+				}
+				var templ_7745c5c3_Var6 string
+				templ_7745c5c3_Var6, templ_7745c5c3_Err = templ.JoinStringErrs(pk.CreatedAt.Format(time.DateOnly))
+				if templ_7745c5c3_Err != nil {
+					return templ.Error{Err: templ_7745c5c3_Err, FileName: `web/components/passkeys.templ`, Line: 107, Col: 50}
+				}
+				_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var6))
+				if templ_7745c5c3_Err != nil {
+					return templ_7745c5c3_Err
+				}
+				templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 8, " | Last used: ")
+				if templ_7745c5c3_Err != nil {
+					return templ_7745c5c3_Err
+				}
+				var templ_7745c5c3_Var7 string
+				templ_7745c5c3_Var7, templ_7745c5c3_Err = templ.JoinStringErrs(formatLastUsed(pk.LastUsedAt))
+				if templ_7745c5c3_Err != nil {
+					return templ.Error{Err: templ_7745c5c3_Err, FileName: `web/components/passkeys.templ`, Line: 107, Col: 97}
+				}
+				_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var7))
+				if templ_7745c5c3_Err != nil {
+					return templ_7745c5c3_Err
+				}
+				templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 9, "</div></div><button hx-delete=\"")
+				if templ_7745c5c3_Err != nil {
+					return templ_7745c5c3_Err
+				}
+				var templ_7745c5c3_Var8 string
+				templ_7745c5c3_Var8, templ_7745c5c3_Err = templ.JoinStringErrs(fmt.Sprintf("/api/passkeys/%d", pk.ID))
+				if templ_7745c5c3_Err != nil {
+					return templ.Error{Err: templ_7745c5c3_Err, FileName: `web/components/passkeys.templ`, Line: 111, Col: 57}
+				}
+				_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var8))
+				if templ_7745c5c3_Err != nil {
+					return templ_7745c5c3_Err
+				}
+				templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 10, "\" hx-target=\"")
+				if templ_7745c5c3_Err != nil {
+					return templ_7745c5c3_Err
+				}
+				var templ_7745c5c3_Var9 string
+				templ_7745c5c3_Var9, templ_7745c5c3_Err = templ.JoinStringErrs(fmt.Sprintf("#passkey-%d", pk.ID))
+				if templ_7745c5c3_Err != nil {
+					return templ.Error{Err: templ_7745c5c3_Err, FileName: `web/components/passkeys.templ`, Line: 112, Col: 52}
+				}
+				_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var9))
+				if templ_7745c5c3_Err != nil {
+					return templ_7745c5c3_Err
+				}
+				templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 11, "\" hx-swap=\"outerHTML\" hx-confirm=\"Remove this passkey?\" class=\"text-valve-orange hover:text-valve-cyan transition\">Remove</button></li>")
+				if templ_7745c5c3_Err != nil {
+					return templ_7745c5c3_Err
+				}
+			}
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 12, "</ul><div class=\"space-y-2\"><label for=\"passkey-name\" class=\"block text-valve-green text-sm\">Name for a new passkey:</label> <input type=\"text\" id=\"passkey-name\" maxlength=\"100\" placeholder=\"aperture, phone, ...\" class=\"w-full bg-dark border border-valve-cyan text-valve-green p-2\"> <button onclick=\"h17PasskeyRegister()\" class=\"bg-valve-orange text-dark font-bold py-2 px-4 border-2 border-valve-orange hover:bg-dark hover:text-valve-orange transition\">ADD PASSKEY</button></div></div>")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			return nil
+		})
+		templ_7745c5c3_Err = Layout("Passkeys").Render(templ.WithChildren(ctx, templ_7745c5c3_Var3), templ_7745c5c3_Buffer)
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		return nil
+	})
+}
+
+func formatLastUsed(t *time.Time) string {
+	if t == nil {
+		return "never"
+	}
+	return t.Format(time.DateTime)
+}
+
+var _ = templruntime.GeneratedTemplate
@@ -0,0 +1,22 @@
+package components
+
+import (
+	"context"
+
+	"citadel/highway17/internal/auth"
+	"citadel/highway17/internal/models"
+)
+
+type viewerKey struct{}
+
+// WithViewer makes the signed-in user available to Layout, which only links
+// to pages the user may open
+func WithViewer(ctx context.Context, user *models.User) context.Context {
+	return context.WithValue(ctx, viewerKey{}, user)
+}
+
+// viewerCan reports whether the signed-in user holds perm
+func viewerCan(ctx context.Context, perm auth.Permission) bool {
+	user, _ := ctx.Value(viewerKey{}).(*models.User)
+	return user != nil && auth.UserCan(user, perm)
+}
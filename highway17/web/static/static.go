@@ -0,0 +1,11 @@
+// Package static embeds the browser assets so the dashboard ships as a
+// single binary and works without internet access.
+//
+// css/style.css is generated from css/input.css by Tailwind (npm run css);
+// js/htmx.min.js is htmx 1.9.10 from the upstream release, unmodified.
+package static
+
+import "embed"
+
+//go:embed css/style.css js
+var FS embed.FS